@@ -0,0 +1,27 @@
+// File: cmd_config.go
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"todolist-api-v2/internal/config"
+)
+
+const configUsage = `uso: config <comando>
+
+comandi:
+  print         mostra la configurazione effettiva e da dove arriva ogni valore`
+
+// runConfig gestisce il sottocomando "config".
+func runConfig(out io.Writer, cfg config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", configUsage)
+	}
+	switch args[0] {
+	case "print":
+		return cfg.Fprint(out)
+	default:
+		return fmt.Errorf("comando config sconosciuto %q\n%s", args[0], configUsage)
+	}
+}
@@ -0,0 +1,105 @@
+// File: cmd_legacy.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"todolist-api-v2/internal/config"
+	"todolist-api-v2/internal/store"
+)
+
+const importLegacyUsage = `uso: import-legacy [file.json]
+
+copia nel database SQLite (db_path) i dati di un file dello store JSON,
+compreso il vecchio todos.json con il solo elenco dei todo (default
+todos.json). Gli ID restano quelli del file e il database deve essere
+nuovo. Se il file è proprio db_path, viene prima rinominato in .bak.`
+
+// legacyJSONFile è il file dei todo della prima versione dell'app.
+const legacyJSONFile = "todos.json"
+
+// runImportLegacy gestisce il sottocomando "import-legacy".
+func runImportLegacy(out io.Writer, cfg config.Config, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("%s", importLegacyUsage)
+	}
+	if cfg.Store != store.DriverSQLite {
+		return fmt.Errorf("import-legacy scrive nel database SQLite, ma lo store configurato è %q", cfg.Store)
+	}
+	src := legacyJSONFile
+	if len(args) == 1 {
+		src = args[0]
+	}
+	isJSON, err := store.IsJSONFile(src)
+	if err != nil {
+		return err
+	}
+	if !isJSON {
+		return fmt.Errorf("%s non esiste o non è un file JSON dello store", src)
+	}
+
+	// Se db_path punta al file JSON (capita a chi prima usava lo store
+	// json), il database prende il suo posto e il JSON resta come backup.
+	renamed := sameFile(src, cfg.DBPath)
+	if renamed {
+		backup := src + ".bak"
+		if _, err := os.Stat(backup); err == nil {
+			return fmt.Errorf("%s esiste già: spostalo prima di ripetere l'import", backup)
+		}
+		if err := os.Rename(src, backup); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s rinominato in %s\n", src, backup)
+		src = backup
+	}
+
+	stats, err := importLegacy(src, cfg.DBPath)
+	if err != nil {
+		if renamed {
+			// rimettiamo il file com'era, al posto del database appena creato
+			os.Remove(cfg.DBPath)
+			if renameErr := os.Rename(src, cfg.DBPath); renameErr != nil {
+				err = errors.Join(err, renameErr)
+			}
+		}
+		return err
+	}
+	fmt.Fprintf(out, "importati in %s: %d utenti, %d API key, %d liste, %d todo, %d eventi\n",
+		cfg.DBPath, stats.Users, stats.APIKeys, stats.Lists, stats.Todos, stats.Events)
+	return nil
+}
+
+func importLegacy(src, dbPath string) (store.LegacyImport, error) {
+	db, err := store.New(dbPath)
+	if err != nil {
+		return store.LegacyImport{}, err
+	}
+	defer db.Close()
+	return db.ImportJSON(context.Background(), src)
+}
+
+// sameFile dice se due percorsi indicano lo stesso file, anche scritti in
+// modo diverso (es. "todos.json" e "./todos.json").
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// warnLegacyJSON avvisa all'avvio chi passa allo store SQLite con ancora
+// i todo nel vecchio todos.json: senza import partirebbe da zero.
+func warnLegacyJSON(dbPath string) {
+	if _, err := os.Stat(dbPath); !errors.Is(err, os.ErrNotExist) {
+		return // il database c'è già (o non lo vediamo): nulla da dire
+	}
+	legacy := filepath.Join(filepath.Dir(dbPath), legacyJSONFile)
+	if isJSON, _ := store.IsJSONFile(legacy); isJSON {
+		log.Printf("ATTENZIONE: %s non esiste ma c'è %s dello store JSON; per portare i todo nel database: import-legacy %s", dbPath, legacy, legacy)
+	}
+}
@@ -0,0 +1,109 @@
+// File: cmd_migrate.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"todolist-api-v2/internal/store"
+)
+
+const migrateUsage = `uso: migrate <comando>
+
+comandi:
+  status        mostra le migrazioni applicate e quelle mancanti
+  up            applica tutte le migrazioni mancanti
+  down [n]      annulla le ultime n migrazioni (default 1)
+  to <versione> porta lo schema alla versione indicata (0 = schema vuoto)`
+
+// runMigrate gestisce il sottocomando "migrate" sul database SQLite dbPath.
+func runMigrate(out io.Writer, dbPath string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", migrateUsage)
+	}
+
+	db, err := store.OpenDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := store.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	var done []store.Migration
+	switch args[0] {
+	case "status":
+		return printMigrationStatus(ctx, out, migrator)
+	case "up":
+		done, err = migrator.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil {
+				return fmt.Errorf("numero di passi non valido: %q", args[1])
+			}
+		}
+		done, err = migrator.Down(ctx, steps)
+	case "to":
+		if len(args) < 2 {
+			return fmt.Errorf("%s", migrateUsage)
+		}
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			return fmt.Errorf("versione non valida: %q", args[1])
+		}
+		done, err = migrator.To(ctx, version)
+	default:
+		return fmt.Errorf("comando migrate sconosciuto %q\n%s", args[0], migrateUsage)
+	}
+
+	version, versionErr := migrator.Version(ctx)
+	if versionErr != nil {
+		return versionErr
+	}
+
+	// stampiamo anche i passi riusciti prima di un eventuale errore: quelli
+	// sopra la versione finale sono stati annullati, gli altri applicati.
+	for _, mig := range done {
+		verb := "applicata"
+		if mig.Version > version {
+			verb = "annullata"
+		}
+		fmt.Fprintf(out, "%s %04d_%s\n", verb, mig.Version, mig.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(done) == 0 {
+		fmt.Fprintln(out, "nessuna migrazione da eseguire")
+	}
+	fmt.Fprintf(out, "versione attuale dello schema: %d\n", version)
+	return nil
+}
+
+func printMigrationStatus(ctx context.Context, out io.Writer, migrator *store.Migrator) error {
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, st := range status {
+		state := "da applicare"
+		if st.Applied {
+			state = "applicata il " + st.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		switch {
+		case st.Unknown:
+			state += " [SCONOSCIUTA: non presente in questo binario]"
+		case st.Modified:
+			state += " [CHECKSUM DIVERSO: script modificato]"
+		}
+		fmt.Fprintf(out, "%04d_%-30s %s\n", st.Version, st.Name, state)
+	}
+	return nil
+}
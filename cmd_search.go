@@ -0,0 +1,43 @@
+// File: cmd_search.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"todolist-api-v2/internal/config"
+	"todolist-api-v2/internal/store"
+)
+
+const searchUsage = `uso: search <comando>
+
+comandi:
+  rebuild   ricostruisce da zero l'indice della ricerca full-text (solo
+            store sqlite, con un binario compilato con make build)`
+
+// runSearch gestisce il sottocomando "search". L'indice si aggiorna da
+// solo con i trigger: rebuild serve se lo si sospetta non allineato.
+func runSearch(out io.Writer, cfg config.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%s", searchUsage)
+	}
+	if args[0] != "rebuild" {
+		return fmt.Errorf("comando search sconosciuto %q\n%s", args[0], searchUsage)
+	}
+	if cfg.Store != store.DriverSQLite {
+		return fmt.Errorf("l'indice di ricerca c'è solo con lo store sqlite, quello configurato è %q", cfg.Store)
+	}
+
+	s, err := store.New(cfg.DBPath)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	n, err := s.RebuildSearchIndex(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "indice di ricerca ricostruito: %d todo\n", n)
+	return nil
+}
@@ -0,0 +1,75 @@
+// File: cmd_user.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"todolist-api-v2/internal/auth"
+	"todolist-api-v2/internal/config"
+	"todolist-api-v2/internal/store"
+)
+
+const userUsage = `uso: user <comando>
+
+comandi:
+  add <username>     crea un utente; la password viene letta dalla prima riga di stdin
+  passwd <username>  cambia la password (letta da stdin), ad esempio per
+                     poter accedere con l'utente "default" ai todo creati
+                     prima degli account`
+
+// runUser gestisce il sottocomando "user". La password arriva da stdin e
+// non come argomento, così non finisce nella cronologia della shell.
+func runUser(in io.Reader, out io.Writer, cfg config.Config, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("%s", userUsage)
+	}
+	if args[0] != "add" && args[0] != "passwd" {
+		return fmt.Errorf("comando user sconosciuto %q\n%s", args[0], userUsage)
+	}
+	username := args[1]
+
+	fmt.Fprintf(out, "password per %s: ", username)
+	password, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	fmt.Fprintln(out)
+	password = strings.TrimRight(password, "\r\n")
+	if err := auth.ValidatePassword(password); err != nil {
+		return fmt.Errorf("password non valida: %w", err)
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	repo, err := store.Open(cfg.Store, cfg.DBPath)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+	ctx := context.Background()
+
+	switch args[0] {
+	case "add":
+		user, err := repo.CreateUser(ctx, username, hash)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "utente %s creato (id %d)\n", user.Username, user.ID)
+	case "passwd":
+		user, err := repo.GetUserByUsername(ctx, username)
+		if err != nil {
+			return err
+		}
+		if err := repo.SetPassword(ctx, user.ID, hash); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "password di %s aggiornata\n", user.Username)
+	}
+	return nil
+}
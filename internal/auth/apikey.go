@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// APIKeyPrefix apre ogni API key: la rende riconoscibile (anche dai
+// programmi che cercano segreti finiti per sbaglio in un repository).
+const APIKeyPrefix = "tdk_"
+
+// lunghezza del prefisso mostrato negli elenchi, APIKeyPrefix compreso.
+const displayPrefixLength = len(APIKeyPrefix) + 8
+
+// GenerateAPIKey crea una nuova chiave casuale. Restituisce la chiave da
+// consegnare al client (una volta sola), il prefisso da mostrare negli
+// elenchi e l'hash da salvare.
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", "", err
+	}
+	key = APIKeyPrefix + base64.RawURLEncoding.EncodeToString(random)
+	return key, key[:displayPrefixLength], HashAPIKey(key), nil
+}
+
+// HashAPIKey calcola l'hash con cui la chiave è salvata. Basta SHA-256:
+// a differenza delle password la chiave ha 256 bit casuali, quindi non
+// serve un hash lento, e così la si può cercare direttamente per hash.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// LooksLikeAPIKey fa un controllo veloce del formato, prima di cercare
+// la chiave nel database.
+func LooksLikeAPIKey(key string) bool {
+	return strings.HasPrefix(key, APIKeyPrefix) && len(key) > displayPrefixLength
+}
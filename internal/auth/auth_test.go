@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testSecret = []byte("una-chiave-di-test-lunga-almeno-32-byte")
+
+func TestPasswordHash(t *testing.T) {
+	// meno iterazioni, altrimenti il test è lento; il valore finisce nell'hash
+	defer func(n int) { hashIterations = n }(hashIterations)
+	hashIterations = 1000
+
+	hash, err := HashPassword("correct horse")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "pbkdf2-sha256$1000$"))
+
+	other, err := HashPassword("correct horse")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other, "il salt deve essere diverso ogni volta")
+
+	ok, err := CheckPassword(hash, "correct horse")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = CheckPassword(hash, "correct horsE")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = CheckPassword("", "qualsiasi")
+	require.NoError(t, err)
+	assert.False(t, ok, "un utente senza password non può fare login")
+
+	_, err = CheckPassword("md5$abc", "x")
+	assert.Error(t, err)
+
+	assert.Error(t, ValidatePassword("corta"))
+	assert.Error(t, ValidatePassword(strings.Repeat("x", MaxPasswordLength+1)))
+	assert.NoError(t, ValidatePassword("abbastanza lunga"))
+}
+
+func TestTokens(t *testing.T) {
+	_, err := NewTokens([]byte("corta"), time.Minute, time.Hour)
+	assert.Error(t, err)
+
+	tokens, err := NewTokens(testSecret, time.Minute, time.Hour)
+	require.NoError(t, err)
+	clock := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tokens.now = func() time.Time { return clock }
+
+	pair, err := tokens.IssuePair(42)
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer", pair.TokenType)
+	assert.Equal(t, 60, pair.ExpiresIn)
+
+	claims, err := tokens.Parse(pair.AccessToken, AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, 42, claims.UserID())
+
+	t.Run("i tipi non sono intercambiabili", func(t *testing.T) {
+		_, err := tokens.Parse(pair.RefreshToken, AccessToken)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+		_, err = tokens.Parse(pair.AccessToken, RefreshToken)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("firma e chiave", func(t *testing.T) {
+		other, err := NewTokens([]byte(strings.Repeat("k", 32)), time.Minute, time.Hour)
+		require.NoError(t, err)
+		_, err = other.Parse(pair.AccessToken, AccessToken)
+		assert.ErrorIs(t, err, ErrInvalidToken, "firmato con un'altra chiave")
+
+		// payload modificato a mano: la firma non corrisponde più
+		parts := strings.Split(pair.AccessToken, ".")
+		parts[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1","typ":"access","iss":"todolist-api","exp":9999999999}`))
+		_, err = tokens.Parse(strings.Join(parts, "."), AccessToken)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+
+		// alg "none" senza firma
+		none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+		_, err = tokens.Parse(none+"."+parts[1]+".", AccessToken)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+
+		_, err = tokens.Parse("non-un-token", AccessToken)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("scadenza", func(t *testing.T) {
+		clock = clock.Add(time.Minute)
+		_, err := tokens.Parse(pair.AccessToken, AccessToken)
+		assert.ErrorIs(t, err, ErrExpiredToken)
+
+		_, err = tokens.Parse(pair.RefreshToken, RefreshToken)
+		assert.NoError(t, err, "il refresh token dura di più")
+	})
+}
+
+func TestAPIKeyGeneration(t *testing.T) {
+	key, prefix, hash, err := GenerateAPIKey()
+	require.NoError(t, err)
+	assert.True(t, LooksLikeAPIKey(key))
+	assert.True(t, strings.HasPrefix(key, prefix))
+	assert.Len(t, prefix, len(APIKeyPrefix)+8)
+	assert.Equal(t, hash, HashAPIKey(key))
+	assert.NotContains(t, hash, key[len(APIKeyPrefix):])
+
+	other, _, otherHash, err := GenerateAPIKey()
+	require.NoError(t, err)
+	assert.NotEqual(t, key, other)
+	assert.NotEqual(t, hash, otherHash)
+
+	assert.False(t, LooksLikeAPIKey("Bearer xyz"))
+	assert.False(t, LooksLikeAPIKey(APIKeyPrefix))
+}
@@ -0,0 +1,112 @@
+// Package auth contiene le primitive di autenticazione: hash delle
+// password e token firmati (JWT HS256). Non sa nulla di HTTP: il
+// middleware e gli endpoint stanno nel package handler.
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Lunghezza ammessa per le password. Il massimo evita di far calcolare
+// hash su input enormi.
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 128
+)
+
+// hashIterations è il numero di iterazioni PBKDF2-SHA256 (raccomandazione
+// OWASP). È una variabile solo perché i test la abbassano: il valore usato
+// è salvato nell'hash, quindi cambiarla non invalida le password esistenti.
+var hashIterations = 600_000
+
+const (
+	hashScheme = "pbkdf2-sha256"
+	saltLength = 16
+	keyLength  = 32
+)
+
+// ValidatePassword controlla la lunghezza della password.
+func ValidatePassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("deve avere almeno %d caratteri", MinPasswordLength)
+	}
+	if len(password) > MaxPasswordLength {
+		return fmt.Errorf("può avere al massimo %d caratteri", MaxPasswordLength)
+	}
+	return nil
+}
+
+// HashPassword calcola l'hash da salvare nel database, nel formato
+// "pbkdf2-sha256$<iterazioni>$<salt>$<hash>" (base64 senza padding).
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, hashIterations, keyLength)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("%s$%d$%s$%s", hashScheme, hashIterations, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+var errBadHash = errors.New("hash della password in formato non valido")
+
+// CheckPassword dice se password corrisponde a hash. Un hash vuoto (utente
+// senza password) non corrisponde a nessuna password.
+func CheckPassword(hash, password string) (bool, error) {
+	if hash == "" {
+		return false, nil
+	}
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != hashScheme {
+		return false, errBadHash
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false, errBadHash
+	}
+	enc := base64.RawStdEncoding
+	salt, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return false, errBadHash
+	}
+	want, err := enc.DecodeString(parts[3])
+	if err != nil {
+		return false, errBadHash
+	}
+
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	if err != nil {
+		return false, err
+	}
+	// confronto a tempo costante, per non rivelare quanti byte coincidono
+	return subtle.ConstantTimeCompare(got, want) == 1, nil
+}
+
+// dummyHash serve a CheckDummy: un hash valido di una password casuale,
+// calcolato solo al primo uso.
+var dummyHash = sync.OnceValue(func() string {
+	h, err := HashPassword("nessuno-usera-mai-questa-password")
+	if err != nil {
+		panic(err)
+	}
+	return h
+})
+
+// CheckDummy fa lo stesso lavoro di CheckPassword senza un utente vero:
+// il login di uno username inesistente dura quanto quello di uno esistente,
+// così i tempi di risposta non rivelano quali account esistono.
+func CheckDummy(password string) {
+	CheckPassword(dummyHash(), password)
+}
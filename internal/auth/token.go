@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tipi di token. Il refresh token serve solo a ottenere una nuova coppia
+// di token e non viene accettato dalle altre API (e viceversa).
+const (
+	AccessToken  = "access"
+	RefreshToken = "refresh"
+)
+
+// MinSecretLength è la lunghezza minima della chiave HMAC: per HS256 la
+// chiave deve essere almeno lunga quanto l'hash.
+const MinSecretLength = 32
+
+var (
+	// ErrInvalidToken: token malformato, firma sbagliata o tipo sbagliato.
+	ErrInvalidToken = errors.New("token non valido")
+	// ErrExpiredToken: il token era valido ma è scaduto.
+	ErrExpiredToken = errors.New("token scaduto")
+)
+
+// Claims sono i campi del payload JWT che usiamo.
+type Claims struct {
+	Subject   string `json:"sub"`
+	Type      string `json:"typ"`
+	Issuer    string `json:"iss"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// UserID è l'utente a cui appartiene il token (il campo "sub").
+func (c Claims) UserID() int {
+	id, _ := strconv.Atoi(c.Subject)
+	return id
+}
+
+// TokenPair è la risposta di signup, login e refresh.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	// ExpiresIn è la durata dell'access token in secondi (come in OAuth 2).
+	ExpiresIn int `json:"expires_in"`
+}
+
+// Tokens emette e verifica token JWT firmati con HMAC-SHA256.
+type Tokens struct {
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	now        func() time.Time
+}
+
+// tokenIssuer finisce nel campo "iss" e viene verificato in lettura.
+const tokenIssuer = "todolist-api"
+
+// NewTokens crea un emettitore di token con la chiave e le durate indicate.
+func NewTokens(secret []byte, accessTTL, refreshTTL time.Duration) (*Tokens, error) {
+	if len(secret) < MinSecretLength {
+		return nil, fmt.Errorf("la chiave dei token deve avere almeno %d byte", MinSecretLength)
+	}
+	return &Tokens{secret: secret, accessTTL: accessTTL, refreshTTL: refreshTTL, now: time.Now}, nil
+}
+
+// IssuePair emette un access token e un refresh token per userID.
+func (t *Tokens) IssuePair(userID int) (TokenPair, error) {
+	access, err := t.issue(userID, AccessToken, t.accessTTL)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refresh, err := t.issue(userID, RefreshToken, t.refreshTTL)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(t.accessTTL / time.Second),
+	}, nil
+}
+
+// header JWT fisso: accettiamo solo HS256, mai "none" o altri algoritmi.
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+func (t *Tokens) issue(userID int, kind string, ttl time.Duration) (string, error) {
+	now := t.now()
+	payload, err := json.Marshal(Claims{
+		Subject:   strconv.Itoa(userID),
+		Type:      kind,
+		Issuer:    tokenIssuer,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + t.sign(signed), nil
+}
+
+func (t *Tokens) sign(signed string) string {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(signed))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Parse verifica firma, tipo e scadenza di un token e ne restituisce i claims.
+func (t *Tokens) Parse(token, kind string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrInvalidToken
+	}
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if json.Unmarshal(header, &h) != nil || h.Alg != "HS256" {
+		return Claims{}, ErrInvalidToken
+	}
+
+	// confronto a tempo costante della firma
+	expected := t.sign(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return Claims{}, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	var c Claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	if c.Type != kind || c.Issuer != tokenIssuer || c.UserID() <= 0 {
+		return Claims{}, ErrInvalidToken
+	}
+	if t.now().Unix() >= c.ExpiresAt {
+		return Claims{}, ErrExpiredToken
+	}
+	return c, nil
+}
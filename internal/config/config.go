@@ -0,0 +1,372 @@
+// Package config raccoglie la configurazione del server da più fonti.
+//
+// L'ordine di precedenza, dalla più debole alla più forte, è:
+//
+//	valori di default < file di configurazione < variabili d'ambiente < flag
+//
+// Il file è opzionale, in formato JSON, e si indica con -config oppure con
+// la variabile TODO_CONFIG. Le chiavi del file sono i nomi dei campi
+// (es. "listen_addr"), le variabili d'ambiente gli stessi nomi in
+// maiuscolo con il prefisso TODO_ (es. TODO_LISTEN_ADDR).
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"todolist-api-v2/internal/auth"
+	"todolist-api-v2/internal/store"
+)
+
+// Config è la configurazione effettiva del server.
+type Config struct {
+	// ListenAddr è l'indirizzo su cui ascolta il server HTTP (es. ":8080").
+	ListenAddr string
+	// Store è il backend di persistenza: sqlite, json o memory.
+	Store string
+	// DBPath è il file del database (sqlite) o dei todo (json). Se non è
+	// indicato dipende dal backend: todos.db per sqlite, todos.json per json.
+	DBPath string
+	// RequestTimeout è il tempo massimo concesso a un handler.
+	RequestTimeout time.Duration
+
+	// Timeout del server HTTP: lettura della richiesta, scrittura della
+	// risposta e attesa tra una richiesta e l'altra sulle connessioni keep-alive.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// ShutdownTimeout è quanto si aspetta, allo spegnimento, che le
+	// richieste in corso finiscano prima di chiuderle comunque.
+	ShutdownTimeout time.Duration
+
+	// AuthSecret è la chiave HMAC con cui si firmano i token. Se è vuota
+	// il server ne genera una casuale a ogni avvio (i token non
+	// sopravvivono al riavvio): va bene in sviluppo, non in produzione.
+	AuthSecret string
+	// Durata dei token di accesso e di refresh.
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// TrashRetention è per quanto tempo un todo cancellato resta nel
+	// cestino prima di essere eliminato definitivamente; PurgeInterval è
+	// ogni quanto il server controlla il cestino.
+	TrashRetention time.Duration
+	PurgeInterval  time.Duration
+
+	// sources ricorda da dove arriva ogni valore, per "config print".
+	sources map[string]string
+}
+
+// Fonti possibili di un valore.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// EnvPrefix è il prefisso delle variabili d'ambiente lette da Load.
+const EnvPrefix = "TODO_"
+
+// field descrive un'opzione: come si chiama in ogni fonte e come si
+// legge/scrive su Config come stringa.
+type field struct {
+	name  string // chiave nel file e, in maiuscolo, nome della variabile d'ambiente
+	flag  string
+	usage string
+	get   func(c *Config) string
+	set   func(c *Config, v string) error
+	// secret nasconde il valore in "config print".
+	secret bool
+}
+
+var fields = []field{
+	{
+		name: "listen_addr", flag: "addr",
+		usage: "indirizzo di ascolto del server HTTP",
+		get:   func(c *Config) string { return c.ListenAddr },
+		set:   func(c *Config, v string) error { c.ListenAddr = v; return nil },
+	},
+	{
+		name: "store", flag: "store",
+		usage: "backend di persistenza: sqlite, json o memory",
+		get:   func(c *Config) string { return c.Store },
+		set:   func(c *Config, v string) error { c.Store = v; return nil },
+	},
+	{
+		name: "db_path", flag: "db",
+		usage: "percorso del database (sqlite) o del file (json); default todos.db o todos.json",
+		get:   func(c *Config) string { return c.DBPath },
+		set:   func(c *Config, v string) error { c.DBPath = v; return nil },
+	},
+	durationField("request_timeout", "request-timeout", "tempo massimo per gestire una richiesta",
+		func(c *Config) *time.Duration { return &c.RequestTimeout }),
+	durationField("read_timeout", "read-timeout", "tempo massimo per leggere una richiesta, corpo compreso",
+		func(c *Config) *time.Duration { return &c.ReadTimeout }),
+	durationField("write_timeout", "write-timeout", "tempo massimo per scrivere la risposta; deve superare request_timeout",
+		func(c *Config) *time.Duration { return &c.WriteTimeout }),
+	durationField("idle_timeout", "idle-timeout", "tempo massimo di inattività di una connessione keep-alive",
+		func(c *Config) *time.Duration { return &c.IdleTimeout }),
+	durationField("shutdown_timeout", "shutdown-timeout", "attesa massima per le richieste in corso allo spegnimento",
+		func(c *Config) *time.Duration { return &c.ShutdownTimeout }),
+	{
+		name: "auth_secret", flag: "auth-secret",
+		usage:  fmt.Sprintf("chiave per firmare i token, almeno %d caratteri (meglio da file o variabile d'ambiente)", auth.MinSecretLength),
+		get:    func(c *Config) string { return c.AuthSecret },
+		set:    func(c *Config, v string) error { c.AuthSecret = v; return nil },
+		secret: true,
+	},
+	durationField("access_token_ttl", "access-token-ttl", "durata dei token di accesso",
+		func(c *Config) *time.Duration { return &c.AccessTokenTTL }),
+	durationField("refresh_token_ttl", "refresh-token-ttl", "durata dei token di refresh",
+		func(c *Config) *time.Duration { return &c.RefreshTokenTTL }),
+	durationField("trash_retention", "trash-retention", "per quanto tempo i todo cancellati restano nel cestino",
+		func(c *Config) *time.Duration { return &c.TrashRetention }),
+	durationField("purge_interval", "purge-interval", "ogni quanto si eliminano i todo rimasti troppo nel cestino",
+		func(c *Config) *time.Duration { return &c.PurgeInterval }),
+}
+
+func durationField(name, flag, usage string, ptr func(c *Config) *time.Duration) field {
+	return field{
+		name: name, flag: flag,
+		usage: usage + " (es. 30s, 1m)",
+		get:   func(c *Config) string { return ptr(c).String() },
+		set: func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("durata %q non valida", v)
+			}
+			*ptr(c) = d
+			return nil
+		},
+	}
+}
+
+// Default restituisce la configurazione usata quando nessuna fonte
+// specifica un valore.
+func Default() Config {
+	return Config{
+		ListenAddr:      ":8080",
+		Store:           store.DriverSQLite,
+		RequestTimeout:  60 * time.Second,
+		ReadTimeout:     15 * time.Second,
+		WriteTimeout:    75 * time.Second,
+		IdleTimeout:     120 * time.Second,
+		ShutdownTimeout: 30 * time.Second,
+		AccessTokenTTL:  15 * time.Minute,
+		RefreshTokenTTL: 30 * 24 * time.Hour,
+		TrashRetention:  30 * 24 * time.Hour,
+		PurgeInterval:   time.Hour,
+	}
+}
+
+// Load costruisce la configurazione a partire dagli argomenti della riga
+// di comando (senza il nome del programma) e dall'ambiente. Restituisce
+// anche gli argomenti rimasti dopo i flag, cioè l'eventuale sottocomando.
+func Load(args []string, getenv func(string) string) (Config, []string, error) {
+	cfg := Default()
+	cfg.sources = map[string]string{}
+	for _, f := range fields {
+		cfg.sources[f.name] = SourceDefault
+	}
+
+	fs := flag.NewFlagSet("todolist-api", flag.ContinueOnError)
+	configPath := fs.String("config", "", "file di configurazione JSON (anche "+EnvPrefix+"CONFIG)")
+	flagValues := map[string]*string{}
+	for _, f := range fields {
+		def := f.get(&cfg)
+		flagValues[f.name] = fs.String(f.flag, def, f.usage+" ("+envName(f)+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+
+	// 1. file di configurazione
+	path := *configPath
+	if path == "" {
+		path = getenv(EnvPrefix + "CONFIG")
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return Config{}, nil, err
+		}
+	}
+
+	// 2. variabili d'ambiente
+	var errs []error
+	for _, f := range fields {
+		if v := getenv(envName(f)); v != "" {
+			if err := f.set(&cfg, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", envName(f), err))
+			}
+			cfg.sources[f.name] = SourceEnv
+		}
+	}
+
+	// 3. flag, ma solo quelli indicati davvero sulla riga di comando
+	fs.Visit(func(fl *flag.Flag) {
+		for _, f := range fields {
+			if f.flag == fl.Name {
+				if err := f.set(&cfg, *flagValues[f.name]); err != nil {
+					errs = append(errs, fmt.Errorf("-%s: %w", f.flag, err))
+				}
+				cfg.sources[f.name] = SourceFlag
+			}
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return Config{}, nil, err
+	}
+
+	if cfg.DBPath == "" {
+		cfg.DBPath = defaultDBPath(cfg.Store)
+		cfg.sources["db_path"] = SourceDefault
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+func envName(f field) string {
+	return EnvPrefix + strings.ToUpper(f.name)
+}
+
+func defaultDBPath(driver string) string {
+	switch driver {
+	case store.DriverJSON:
+		return "todos.json"
+	case store.DriverSQLite:
+		return "todos.db"
+	}
+	return ""
+}
+
+// loadFile applica i valori del file JSON; le chiavi sconosciute sono un
+// errore, così un refuso non viene ignorato in silenzio.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("errore nella lettura della configurazione: %w", err)
+	}
+	var values map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil {
+		return fmt.Errorf("configurazione %s non valida: %w", path, err)
+	}
+
+	var errs []error
+	for key, raw := range values {
+		f, ok := lookup(key)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: chiave %q sconosciuta", path, key))
+			continue
+		}
+		var v string
+		switch raw := raw.(type) {
+		case string:
+			v = raw
+		case json.Number:
+			v = raw.String()
+		default:
+			errs = append(errs, fmt.Errorf("%s: %s deve essere una stringa", path, key))
+			continue
+		}
+		if err := f.set(c, v); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", path, key, err))
+			continue
+		}
+		c.sources[f.name] = SourceFile + " " + path
+	}
+	return errors.Join(errs...)
+}
+
+func lookup(name string) (field, bool) {
+	for _, f := range fields {
+		if f.name == name {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+// Validate controlla che la configurazione sia utilizzabile e restituisce
+// tutti i problemi trovati, non solo il primo.
+func (c Config) Validate() error {
+	var errs []error
+	if _, port, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		errs = append(errs, fmt.Errorf("listen_addr %q non valido: %w", c.ListenAddr, err))
+	} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		errs = append(errs, fmt.Errorf("listen_addr %q: porta non valida", c.ListenAddr))
+	}
+
+	switch c.Store {
+	case store.DriverSQLite, store.DriverJSON:
+		if c.DBPath == "" {
+			errs = append(errs, fmt.Errorf("db_path è obbligatorio con store %s", c.Store))
+		}
+	case store.DriverMemory:
+	default:
+		errs = append(errs, fmt.Errorf("store %q sconosciuto (valori ammessi: %s, %s, %s)",
+			c.Store, store.DriverSQLite, store.DriverJSON, store.DriverMemory))
+	}
+
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"request_timeout", c.RequestTimeout},
+		{"read_timeout", c.ReadTimeout},
+		{"write_timeout", c.WriteTimeout},
+		{"idle_timeout", c.IdleTimeout},
+		{"shutdown_timeout", c.ShutdownTimeout},
+		{"access_token_ttl", c.AccessTokenTTL},
+		{"refresh_token_ttl", c.RefreshTokenTTL},
+		{"trash_retention", c.TrashRetention},
+		{"purge_interval", c.PurgeInterval},
+	} {
+		if d.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s deve essere positivo", d.name))
+		}
+	}
+	if c.AuthSecret != "" && len(c.AuthSecret) < auth.MinSecretLength {
+		errs = append(errs, fmt.Errorf("auth_secret deve avere almeno %d caratteri", auth.MinSecretLength))
+	}
+	if c.RefreshTokenTTL > 0 && c.RefreshTokenTTL <= c.AccessTokenTTL {
+		errs = append(errs, fmt.Errorf("refresh_token_ttl deve essere maggiore di access_token_ttl"))
+	}
+	// se la connessione viene chiusa prima che scatti il timeout della
+	// richiesta, il client riceve un errore di rete invece di un 503.
+	if c.WriteTimeout > 0 && c.RequestTimeout > 0 && c.WriteTimeout <= c.RequestTimeout {
+		errs = append(errs, fmt.Errorf("write_timeout (%s) deve essere maggiore di request_timeout (%s)", c.WriteTimeout, c.RequestTimeout))
+	}
+	return errors.Join(errs...)
+}
+
+// Fprint scrive i valori effettivi e la loro provenienza, uno per riga.
+func (c Config) Fprint(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, f := range fields {
+		source := c.sources[f.name]
+		if source == "" {
+			source = SourceDefault
+		}
+		value := f.get(&c)
+		if f.secret && value != "" {
+			value = "********"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t(%s)\n", f.name, value, source)
+	}
+	return tw.Flush()
+}
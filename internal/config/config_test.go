@@ -0,0 +1,97 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// env simula l'ambiente con una mappa, così i test non dipendono da os.Getenv.
+func env(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, rest, err := Load(nil, env(nil))
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+	assert.Equal(t, ":8080", cfg.ListenAddr)
+	assert.Equal(t, "sqlite", cfg.Store)
+	assert.Equal(t, "todos.db", cfg.DBPath, "il default dipende dal backend")
+	assert.Equal(t, 60*time.Second, cfg.RequestTimeout)
+	assert.Greater(t, cfg.WriteTimeout, cfg.RequestTimeout)
+	assert.Equal(t, 30*24*time.Hour, cfg.TrashRetention)
+
+	cfg, _, err = Load([]string{"-store", "json"}, env(nil))
+	require.NoError(t, err)
+	assert.Equal(t, "todos.json", cfg.DBPath)
+}
+
+// Ogni fonte deve prevalere su quella precedente: default < file < env < flag.
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	file := `{"listen_addr": ":7000", "db_path": "file.db", "request_timeout": "5s", "store": "json"}`
+	require.NoError(t, os.WriteFile(path, []byte(file), 0644))
+
+	vars := map[string]string{
+		"TODO_CONFIG":          path,
+		"TODO_DB_PATH":         "env.db",
+		"TODO_REQUEST_TIMEOUT": "10s",
+	}
+	cfg, rest, err := Load([]string{"-request-timeout", "15s", "migrate", "status"}, env(vars))
+	require.NoError(t, err)
+
+	assert.Equal(t, ":7000", cfg.ListenAddr, "dal file")
+	assert.Equal(t, "json", cfg.Store, "dal file")
+	assert.Equal(t, "env.db", cfg.DBPath, "l'ambiente vince sul file")
+	assert.Equal(t, 15*time.Second, cfg.RequestTimeout, "il flag vince su tutto")
+	assert.Equal(t, []string{"migrate", "status"}, rest)
+
+	var out strings.Builder
+	require.NoError(t, cfg.Fprint(&out))
+	assert.Contains(t, out.String(), "(file "+path+")")
+	assert.Contains(t, out.String(), "(env)")
+	assert.Contains(t, out.String(), "(flag)")
+
+	// i segreti non vanno mai stampati
+	cfg, _, err = Load(nil, env(map[string]string{"TODO_AUTH_SECRET": strings.Repeat("s", 40)}))
+	require.NoError(t, err)
+	out.Reset()
+	require.NoError(t, cfg.Fprint(&out))
+	assert.NotContains(t, out.String(), "sssss")
+	assert.Contains(t, out.String(), "auth_secret")
+}
+
+func TestLoadValidation(t *testing.T) {
+	_, _, err := Load([]string{"-addr", "localhost", "-store", "mongo", "-request-timeout", "0s"}, env(nil))
+	require.Error(t, err)
+	// tutti gli errori vengono riportati insieme
+	assert.Contains(t, err.Error(), "listen_addr")
+	assert.Contains(t, err.Error(), "store")
+	assert.Contains(t, err.Error(), "request_timeout")
+
+	_, _, err = Load([]string{"-request-timeout", "2m"}, env(nil))
+	assert.ErrorContains(t, err, "write_timeout", "la risposta deve poter durare più dell'handler")
+
+	_, _, err = Load([]string{"-auth-secret", "corto"}, env(nil))
+	assert.ErrorContains(t, err, "auth_secret")
+
+	_, _, err = Load(nil, env(map[string]string{"TODO_TRASH_RETENTION": "-1h"}))
+	assert.ErrorContains(t, err, "trash_retention")
+
+	_, _, err = Load(nil, env(map[string]string{"TODO_REQUEST_TIMEOUT": "presto"}))
+	assert.ErrorContains(t, err, "TODO_REQUEST_TIMEOUT")
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"listen_adr": ":1"}`), 0644))
+	_, _, err = Load([]string{"-config", path}, env(nil))
+	assert.ErrorContains(t, err, `chiave "listen_adr" sconosciuta`)
+
+	_, _, err = Load([]string{"-config", filepath.Join(t.TempDir(), "manca.json")}, env(nil))
+	assert.Error(t, err, "un file indicato esplicitamente deve esistere")
+}
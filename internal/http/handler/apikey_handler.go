@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"todolist-api-v2/internal/auth"
+	"todolist-api-v2/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// APIKeyHandler gestisce le API key dell'utente autenticato.
+type APIKeyHandler struct {
+	Keys store.APIKeyRepository
+}
+
+func NewAPIKeyHandler(keys store.APIKeyRepository) *APIKeyHandler {
+	return &APIKeyHandler{Keys: keys}
+}
+
+// createdAPIKey è la risposta alla creazione: l'unica volta in cui il
+// client vede la chiave in chiaro.
+type createdAPIKey struct {
+	store.APIKey
+	Key string `json:"key"`
+}
+
+// Create genera una nuova chiave con il nome e lo scope richiesti.
+func (h *APIKeyHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Name  string            `json:"name"`
+		Scope store.APIKeyScope `json:"scope"`
+	}
+	if err := decodeStrict(r.Body, &in); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidBody, "Corpo della richiesta JSON non valido: "+err.Error())
+		return
+	}
+	var verr validationError
+	if in.Name == "" {
+		verr.add("name", "è obbligatorio")
+	}
+	if !in.Scope.Valid() {
+		verr.add("scope", "deve essere "+string(store.ScopeReadOnly)+" o "+string(store.ScopeReadWrite))
+	}
+	if err := verr.err(); err != nil {
+		writeValidationError(w, r, CodeValidationFailed, err)
+		return
+	}
+
+	key, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	created, err := h.Keys.CreateAPIKey(r.Context(), store.APIKey{Name: in.Name, Scope: in.Scope, Prefix: prefix, KeyHash: hash})
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Location", r.URL.Path+"/"+strconv.Itoa(created.ID))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createdAPIKey{APIKey: created, Key: key})
+}
+
+// List elenca le chiavi dell'utente, senza il loro valore.
+func (h *APIKeyHandler) List(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.Keys.ListAPIKeys(r.Context())
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// Revoke disattiva una chiave. La chiave resta nell'elenco, con la data
+// di revoca, così si sa quando è stata disattivata.
+func (h *APIKeyHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "keyID"))
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidID, "ID non valido, deve essere un numero intero")
+		return
+	}
+	if _, err := h.Keys.RevokeAPIKey(r.Context(), id); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
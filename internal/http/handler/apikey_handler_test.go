@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"todolist-api-v2/internal/store"
+)
+
+func TestAPIKeys(t *testing.T) {
+	router := setupAuthAPI(t)
+
+	do := func(method, url, body, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do(http.MethodPost, "/auth/signup", `{"username":"carla","password":"password-sicura"}`, "")
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var carla authResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &carla))
+	bearer := "Bearer " + carla.AccessToken
+
+	createKey := func(t *testing.T, body string) createdAPIKey {
+		rr := do(http.MethodPost, "/auth/api-keys", body, bearer)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+		var key createdAPIKey
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &key))
+		assert.Equal(t, "/auth/api-keys/"+mustField(t, rr.Body.Bytes(), "id"), rr.Header().Get("Location"))
+		return key
+	}
+
+	t.Run("validazione", func(t *testing.T) {
+		rr := do(http.MethodPost, "/auth/api-keys", `{"name":"","scope":"admin"}`, bearer)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		var p Problem
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p))
+		assert.Len(t, p.Errors, 2)
+
+		rr = do(http.MethodPost, "/auth/api-keys", `{"name":"cron","scope":"read_write"}`, "")
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	rw := createKey(t, `{"name":"script di backup","scope":"read_write"}`)
+	ro := createKey(t, `{"name":"dashboard","scope":"read_only"}`)
+
+	t.Run("la chiave in chiaro si vede solo alla creazione", func(t *testing.T) {
+		assert.True(t, len(rw.Key) > len(rw.Prefix))
+		assert.Equal(t, rw.Prefix, rw.Key[:len(rw.Prefix)])
+
+		rr := do(http.MethodGet, "/auth/api-keys", "", bearer)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.NotContains(t, rr.Body.String(), rw.Key)
+		var keys []store.APIKey
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &keys))
+		assert.Len(t, keys, 2)
+	})
+
+	t.Run("accesso con la chiave", func(t *testing.T) {
+		rr := do(http.MethodPost, "/todos", `{"title":"Dallo script"}`, "ApiKey "+rw.Key)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+		rr = do(http.MethodGet, "/todos", "", "apikey "+ro.Key)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "1", rr.Header().Get("X-Total-Count"))
+
+		rr = do(http.MethodGet, "/auth/api-keys", "", bearer)
+		var keys []store.APIKey
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &keys))
+		for _, k := range keys {
+			assert.NotNil(t, k.LastUsedAt, "last_used_at di %q", k.Name)
+		}
+	})
+
+	t.Run("una chiave non gestisce le chiavi", func(t *testing.T) {
+		rr := do(http.MethodGet, "/auth/api-keys", "", "ApiKey "+rw.Key)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("scope read_only", func(t *testing.T) {
+		rr := do(http.MethodPost, "/todos", `{"title":"Non passa"}`, "ApiKey "+ro.Key)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.JSONEq(t, `"insufficient_scope"`, mustField(t, rr.Body.Bytes(), "code"))
+	})
+
+	t.Run("chiave sconosciuta", func(t *testing.T) {
+		rr := do(http.MethodGet, "/todos", "", "ApiKey tdk_inventata")
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Contains(t, rr.Header().Get("WWW-Authenticate"), "ApiKey")
+
+		rr = do(http.MethodGet, "/todos", "", "")
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Len(t, rr.Header().Values("WWW-Authenticate"), 2, "senza credenziali proponiamo entrambi gli schemi")
+	})
+
+	t.Run("revoca", func(t *testing.T) {
+		rr := do(http.MethodDelete, "/auth/api-keys/"+mustField(t, mustJSON(t, rw), "id"), "", bearer)
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+
+		rr = do(http.MethodGet, "/todos", "", "ApiKey "+rw.Key)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+		rr = do(http.MethodDelete, "/auth/api-keys/999", "", bearer)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		rr = do(http.MethodDelete, "/auth/api-keys/abc", "", bearer)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("le chiavi sono per utente", func(t *testing.T) {
+		rr := do(http.MethodPost, "/auth/signup", `{"username":"dario","password":"password-sicura"}`, "")
+		require.Equal(t, http.StatusCreated, rr.Code)
+		var dario authResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &dario))
+
+		rr = do(http.MethodGet, "/auth/api-keys", "", "Bearer "+dario.AccessToken)
+		assert.JSONEq(t, `[]`, rr.Body.String())
+		rr = do(http.MethodDelete, "/auth/api-keys/"+mustField(t, mustJSON(t, ro), "id"), "", "Bearer "+dario.AccessToken)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
+
+func mustJSON(t *testing.T, v any) []byte {
+	b, err := json.Marshal(v)
+	require.NoError(t, err)
+	return b
+}
@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"todolist-api-v2/internal/auth"
+	"todolist-api-v2/internal/store"
+)
+
+// AuthHandler gestisce registrazione, login e rinnovo dei token.
+type AuthHandler struct {
+	Users  store.UserRepository
+	Tokens *auth.Tokens
+}
+
+func NewAuthHandler(users store.UserRepository, tokens *auth.Tokens) *AuthHandler {
+	return &AuthHandler{Users: users, Tokens: tokens}
+}
+
+// credentials è il corpo di signup e login.
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// authResponse è la risposta di signup, login e refresh: l'utente e una
+// nuova coppia di token.
+type authResponse struct {
+	User store.User `json:"user"`
+	auth.TokenPair
+}
+
+// Signup crea un account e restituisce subito i token, così il client
+// non deve fare anche il login.
+func (h *AuthHandler) Signup(w http.ResponseWriter, r *http.Request) {
+	var in credentials
+	if err := decodeStrict(r.Body, &in); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidBody, "Corpo della richiesta JSON non valido: "+err.Error())
+		return
+	}
+
+	var verr validationError
+	if strings.TrimSpace(in.Username) == "" {
+		verr.add("username", "è obbligatorio")
+	}
+	if err := auth.ValidatePassword(in.Password); err != nil {
+		verr.add("password", err.Error())
+	}
+	if err := verr.err(); err != nil {
+		writeValidationError(w, r, CodeValidationFailed, err)
+		return
+	}
+
+	hash, err := auth.HashPassword(in.Password)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	user, err := h.Users.CreateUser(r.Context(), in.Username, hash)
+	if err != nil {
+		// username già usato (409) o non valido (400)
+		writeStoreError(w, r, err)
+		return
+	}
+	h.writeTokens(w, r, http.StatusCreated, user)
+}
+
+// Login scambia username e password con una coppia di token. Username
+// inesistente, utente senza password e password sbagliata danno la stessa
+// risposta, e nello stesso tempo: in tutti i casi calcoliamo un hash.
+func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	var in credentials
+	if err := decodeStrict(r.Body, &in); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidBody, "Corpo della richiesta JSON non valido: "+err.Error())
+		return
+	}
+
+	user, err := h.Users.GetUserByUsername(r.Context(), in.Username)
+	if errors.Is(err, store.ErrNotFound) {
+		auth.CheckDummy(in.Password)
+		writeProblem(w, r, http.StatusUnauthorized, CodeInvalidCredentials, "Username o password errati")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if user.PasswordHash == "" {
+		// CheckPassword risponderebbe subito: dai tempi si capirebbe che
+		// l'utente esiste ma non ha una password
+		auth.CheckDummy(in.Password)
+		writeProblem(w, r, http.StatusUnauthorized, CodeInvalidCredentials, "Username o password errati")
+		return
+	}
+	ok, err := auth.CheckPassword(user.PasswordHash, in.Password)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, CodeInvalidCredentials, "Username o password errati")
+		return
+	}
+	h.writeTokens(w, r, http.StatusOK, user)
+}
+
+// Refresh emette una nuova coppia di token a partire da un refresh token
+// ancora valido.
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := decodeStrict(r.Body, &in); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidBody, "Corpo della richiesta JSON non valido: "+err.Error())
+		return
+	}
+
+	claims, err := h.Tokens.Parse(in.RefreshToken, auth.RefreshToken)
+	if err != nil {
+		writeTokenError(w, r, err)
+		return
+	}
+	user, err := h.Users.GetUser(r.Context(), claims.UserID())
+	if errors.Is(err, store.ErrNotFound) {
+		writeTokenError(w, r, auth.ErrInvalidToken)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	h.writeTokens(w, r, http.StatusOK, user)
+}
+
+// Me restituisce l'utente autenticato.
+func (h *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
+	userID, _ := store.OwnerFromContext(r.Context())
+	user, err := h.Users.GetUser(r.Context(), userID)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func (h *AuthHandler) writeTokens(w http.ResponseWriter, r *http.Request, status int, user store.User) {
+	pair, err := h.Tokens.IssuePair(user.ID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	// i token non devono finire in nessuna cache intermedia
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(authResponse{User: user, TokenPair: pair})
+}
+
+// writeTokenError risponde 401 per un token mancante, non valido o
+// scaduto, con l'header WWW-Authenticate previsto da RFC 6750.
+func writeTokenError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case err == nil:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="todolist-api"`)
+		writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "Autenticazione richiesta")
+	case errors.Is(err, auth.ErrExpiredToken):
+		w.Header().Set("WWW-Authenticate", `Bearer realm="todolist-api", error="invalid_token", error_description="token scaduto"`)
+		writeProblem(w, r, http.StatusUnauthorized, CodeTokenExpired, "Il token è scaduto: rinnovarlo con /auth/refresh")
+	default:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="todolist-api", error="invalid_token"`)
+		writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "Token non valido")
+	}
+}
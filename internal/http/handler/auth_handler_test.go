@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"todolist-api-v2/internal/auth"
+	"todolist-api-v2/internal/store"
+)
+
+// setupAuthAPI monta le rotte di /auth e /todos protette dal middleware,
+// come in main.go.
+func setupAuthAPI(t *testing.T) http.Handler {
+	s := store.NewMemoryStore()
+	tokens, err := auth.NewTokens([]byte("una-chiave-di-test-lunga-almeno-32-byte"), time.Minute, time.Hour)
+	require.NoError(t, err)
+
+	ah := NewAuthHandler(s, tokens)
+	th := NewTodoHandler(s)
+	kh := NewAPIKeyHandler(s)
+	r := chi.NewRouter()
+	r.Route("/auth", func(r chi.Router) {
+		r.Post("/signup", ah.Signup)
+		r.Post("/login", ah.Login)
+		r.Post("/refresh", ah.Refresh)
+		r.With(RequireUser(tokens)).Get("/me", ah.Me)
+		r.Route("/api-keys", func(r chi.Router) {
+			r.Use(RequireUser(tokens))
+			r.Get("/", kh.List)
+			r.Post("/", kh.Create)
+			r.Delete("/{keyID}", kh.Revoke)
+		})
+	})
+	r.Route("/todos", func(r chi.Router) {
+		r.Use(RequireUserOrAPIKey(tokens, s))
+		r.Get("/", th.GetAll)
+		r.Post("/", th.Create)
+		r.Get("/{todoID}", th.GetByID)
+	})
+	return r
+}
+
+func TestAuthFlow(t *testing.T) {
+	router := setupAuthAPI(t)
+
+	do := func(method, url, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder) authResponse {
+		var resp authResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp), rr.Body.String())
+		return resp
+	}
+
+	var anna authResponse
+	t.Run("signup", func(t *testing.T) {
+		rr := do(http.MethodPost, "/auth/signup", `{"username":"anna","password":"password-sicura"}`, "")
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+		assert.NotContains(t, rr.Body.String(), "pbkdf2", "l'hash della password non va mai esposto")
+		anna = decode(rr)
+		assert.Equal(t, "anna", anna.User.Username)
+		assert.NotEmpty(t, anna.AccessToken)
+		assert.NotEmpty(t, anna.RefreshToken)
+
+		rr = do(http.MethodPost, "/auth/signup", `{"username":"Anna","password":"un'altra-password"}`, "")
+		assert.Equal(t, http.StatusConflict, rr.Code)
+
+		rr = do(http.MethodPost, "/auth/signup", `{"username":"","password":"corta"}`, "")
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		var p Problem
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p))
+		assert.Len(t, p.Errors, 2)
+	})
+
+	t.Run("le API richiedono il token", func(t *testing.T) {
+		rr := do(http.MethodGet, "/todos", "", "")
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Contains(t, rr.Header().Get("WWW-Authenticate"), "Bearer")
+
+		rr = do(http.MethodGet, "/todos", "", "token-inventato")
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Contains(t, rr.Header().Get("WWW-Authenticate"), "invalid_token")
+
+		rr = do(http.MethodGet, "/todos", "", anna.RefreshToken)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, "il refresh token non apre le API")
+
+		rr = do(http.MethodPost, "/todos", `{"title":"Di Anna"}`, anna.AccessToken)
+		assert.Equal(t, http.StatusCreated, rr.Code)
+	})
+
+	t.Run("login", func(t *testing.T) {
+		rr := do(http.MethodPost, "/auth/login", `{"username":"anna","password":"sbagliata!"}`, "")
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		wrongPassword := rr.Body.String()
+
+		rr = do(http.MethodPost, "/auth/login", `{"username":"nessuno","password":"sbagliata!"}`, "")
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Equal(t, wrongPassword, rr.Body.String(), "non si deve capire se lo username esiste")
+
+		// l'utente predefinito non ha password: risponde come gli altri due
+		rr = do(http.MethodPost, "/auth/login", `{"username":"`+store.DefaultUsername+`","password":"sbagliata!"}`, "")
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Equal(t, wrongPassword, rr.Body.String())
+
+		rr = do(http.MethodPost, "/auth/login", `{"username":"anna","password":"password-sicura"}`, "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		login := decode(rr)
+
+		rr = do(http.MethodGet, "/auth/me", "", login.AccessToken)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, `"anna"`, mustField(t, rr.Body.Bytes(), "username"))
+	})
+
+	t.Run("refresh", func(t *testing.T) {
+		rr := do(http.MethodPost, "/auth/refresh", `{"refresh_token":"`+anna.AccessToken+`"}`, "")
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, "serve un refresh token, non un access token")
+
+		rr = do(http.MethodPost, "/auth/refresh", `{"refresh_token":"`+anna.RefreshToken+`"}`, "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		refreshed := decode(rr)
+
+		rr = do(http.MethodGet, "/todos", "", refreshed.AccessToken)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "1", rr.Header().Get("X-Total-Count"))
+	})
+
+	t.Run("ogni utente vede i propri todo", func(t *testing.T) {
+		rr := do(http.MethodPost, "/auth/signup", `{"username":"bruno","password":"password-sicura"}`, "")
+		require.Equal(t, http.StatusCreated, rr.Code)
+		bruno := decode(rr)
+
+		rr = do(http.MethodGet, "/todos", "", bruno.AccessToken)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, `[]`, rr.Body.String())
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/todos/1", "", bruno.AccessToken).Code)
+	})
+}
+
+func mustField(t *testing.T, body []byte, name string) string {
+	var m map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(body, &m))
+	return string(m[name])
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"todolist-api-v2/internal/auth"
+	"todolist-api-v2/internal/store"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// apiKeyTouchInterval limita le scritture di last_used_at: una chiave
+// usata di continuo da uno script non deve costare una UPDATE per richiesta.
+const apiKeyTouchInterval = time.Minute
+
+// RequireUser accetta solo richieste con un access token valido
+// nell'header "Authorization: Bearer <token>" e mette l'utente nel
+// contesto: da lì lo leggono gli handler e lo store. Nel contesto finisce
+// anche l'ID della richiesta (middleware.RequestID), per il registro delle
+// modifiche.
+func RequireUser(tokens *auth.Tokens) func(http.Handler) http.Handler {
+	return RequireUserOrAPIKey(tokens, nil)
+}
+
+// RequireUserOrAPIKey accetta, oltre al token, anche una API key
+// nell'header "Authorization: ApiKey <chiave>". Le chiavi read_only
+// possono fare solo letture: il resto riceve 403.
+func RequireUserOrAPIKey(tokens *auth.Tokens, keys store.APIKeyRepository) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme, credential, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			credential = strings.TrimSpace(credential)
+
+			var userID int
+			switch {
+			case strings.EqualFold(scheme, "Bearer") && credential != "":
+				claims, err := tokens.Parse(credential, auth.AccessToken)
+				if err != nil {
+					writeTokenError(w, r, err)
+					return
+				}
+				userID = claims.UserID()
+			case strings.EqualFold(scheme, "ApiKey") && credential != "" && keys != nil:
+				key, ok := checkAPIKey(w, r, keys, credential)
+				if !ok {
+					return
+				}
+				userID = key.OwnerID
+			default:
+				// nessuna credenziale: proponiamo tutti gli schemi accettati
+				w.Header().Add("WWW-Authenticate", `Bearer realm="todolist-api"`)
+				if keys != nil {
+					w.Header().Add("WWW-Authenticate", `ApiKey realm="todolist-api"`)
+				}
+				writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "Autenticazione richiesta")
+				return
+			}
+			ctx := store.WithOwner(r.Context(), userID)
+			ctx = store.WithRequestID(ctx, middleware.GetReqID(r.Context()))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// checkAPIKey verifica la chiave e il suo scope; se qualcosa non va ha già
+// risposto al client e restituisce false.
+func checkAPIKey(w http.ResponseWriter, r *http.Request, keys store.APIKeyRepository, credential string) (store.APIKey, bool) {
+	invalid := func() (store.APIKey, bool) {
+		w.Header().Set("WWW-Authenticate", `ApiKey realm="todolist-api", error="invalid_key"`)
+		writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "API key non valida o revocata")
+		return store.APIKey{}, false
+	}
+	if !auth.LooksLikeAPIKey(credential) {
+		return invalid()
+	}
+	key, err := keys.GetAPIKeyByHash(r.Context(), auth.HashAPIKey(credential))
+	if errors.Is(err, store.ErrNotFound) || (err == nil && key.Revoked()) {
+		return invalid()
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return store.APIKey{}, false
+	}
+
+	if key.Scope == store.ScopeReadOnly && !isSafeMethod(r.Method) {
+		writeProblem(w, r, http.StatusForbidden, CodeInsufficientScope,
+			"La API key è di sola lettura: per modificare i dati serve una chiave "+string(store.ScopeReadWrite))
+		return store.APIKey{}, false
+	}
+
+	at := time.Now().UTC()
+	if key.LastUsedAt == nil || at.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		// un errore qui non deve far fallire la richiesta: lo logghiamo e basta
+		if err := keys.TouchAPIKey(r.Context(), key.ID, at); err != nil {
+			log.Printf("errore nell'aggiornare l'ultimo uso della API key %d: %v", key.ID, err)
+		}
+	}
+	return key, true
+}
+
+// isSafeMethod dice se il metodo è di sola lettura (RFC 9110, sezione 9.2.1).
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,253 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"todolist-api-v2/internal/store"
+)
+
+// Modalità di POST /todos/batch.
+const (
+	// BatchAllOrNothing (il default) annulla tutto alla prima operazione
+	// che fallisce.
+	BatchAllOrNothing = "all_or_nothing"
+	// BatchPerItem salta le operazioni che falliscono e applica le altre,
+	// riportando l'esito di ognuna.
+	BatchPerItem = "per_item"
+)
+
+// MaxBatchOperations limita le operazioni di una singola richiesta: finché
+// la transazione non finisce, le altre scritture restano in attesa.
+const MaxBatchOperations = 1000
+
+// operazioni ammesse in un batch.
+const (
+	batchCreate = "create"
+	batchUpdate = "update"
+	batchDelete = "delete"
+)
+
+// BatchHandler esegue più operazioni sui todo in un'unica transazione.
+type BatchHandler struct {
+	Store store.Transactor
+}
+
+func NewBatchHandler(s store.Transactor) *BatchHandler {
+	return &BatchHandler{Store: s}
+}
+
+// batchRequest è il corpo di POST /todos/batch.
+type batchRequest struct {
+	Mode       string           `json:"mode"`
+	Operations []batchOperation `json:"operations"`
+}
+
+// batchOperation è una singola operazione: "todo" ha lo stesso formato del
+// corpo di POST /todos (create) o di PUT /todos/{id} (update); "version" è
+// la versione attesa per update e delete.
+type batchOperation struct {
+	Op      string          `json:"op"`
+	ID      *int            `json:"id"`
+	Version *int            `json:"version"`
+	Todo    json.RawMessage `json:"todo"`
+}
+
+// batchResult è l'esito di un'operazione: lo status è quello che avrebbe
+// avuto la richiesta singola, con il todo o con l'errore.
+type batchResult struct {
+	Index  int         `json:"index"`
+	Op     string      `json:"op"`
+	Status int         `json:"status"`
+	ID     int         `json:"id,omitempty"`
+	Todo   *store.Todo `json:"todo,omitempty"`
+	Error  *Problem    `json:"error,omitempty"`
+}
+
+// batchStep è un'operazione già validata, da eseguire nella transazione.
+type batchStep func(ctx context.Context, tx store.TodoRepository) (batchResult, error)
+
+// Run gestisce POST /todos/batch. Le operazioni vengono eseguite in ordine
+// in un'unica transazione. Con mode "all_or_nothing" la prima che fallisce
+// annulla tutte le altre e la risposta è il suo errore; con "per_item"
+// quelle che falliscono non lasciano traccia e la risposta (200) riporta
+// l'esito di ognuna. In entrambi i casi un errore del server annulla tutto.
+func (h *BatchHandler) Run(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	if err := decodeStrict(r.Body, &req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidBody, "Corpo della richiesta JSON non valido: "+err.Error())
+		return
+	}
+
+	var verr validationError
+	switch req.Mode {
+	case "":
+		req.Mode = BatchAllOrNothing
+	case BatchAllOrNothing, BatchPerItem:
+	default:
+		verr.add("mode", fmt.Sprintf("%q non valido (valori ammessi: %s, %s)", req.Mode, BatchAllOrNothing, BatchPerItem))
+	}
+	switch {
+	case len(req.Operations) == 0:
+		verr.add("operations", "deve contenere almeno un'operazione")
+	case len(req.Operations) > MaxBatchOperations:
+		verr.add("operations", fmt.Sprintf("può contenere al massimo %d operazioni", MaxBatchOperations))
+	}
+	if err := verr.err(); err != nil {
+		writeValidationError(w, r, CodeValidationFailed, err)
+		return
+	}
+
+	// 1. Validiamo tutto prima di aprire la transazione.
+	steps := make([]batchStep, len(req.Operations))
+	results := make([]batchResult, len(req.Operations))
+	for i, op := range req.Operations {
+		results[i] = batchResult{Index: i, Op: op.Op}
+		step, err := op.prepare()
+		if err != nil {
+			verr.addAll(fmt.Sprintf("operations[%d]", i), err)
+			p := validationProblem(CodeValidationFailed, err).described()
+			results[i].Status, results[i].Error = p.Status, &p
+			continue
+		}
+		steps[i] = step
+	}
+	if req.Mode == BatchAllOrNothing && len(verr) > 0 {
+		writeValidationError(w, r, CodeValidationFailed, verr)
+		return
+	}
+
+	// 2. Eseguiamo le operazioni valide.
+	var failed *batchResult // l'operazione che ha annullato la transazione
+	err := h.Store.WithTx(r.Context(), func(tx store.Repository) error {
+		for i, step := range steps {
+			if step == nil {
+				continue // non valida, l'errore è già nei risultati
+			}
+			res, err := step(r.Context(), tx)
+			if err == nil {
+				res.Index, res.Op = i, results[i].Op
+				results[i] = res
+				continue
+			}
+			p := storeProblem(r, err).described()
+			results[i].Status, results[i].Error = p.Status, &p
+			if req.Mode == BatchAllOrNothing || p.Status >= http.StatusInternalServerError {
+				failed = &results[i]
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if failed == nil {
+			writeStoreError(w, r, err) // la transazione stessa non è partita o non è stata confermata
+			return
+		}
+		p := *failed.Error
+		p.Detail = fmt.Sprintf("Operazione %d (%s) fallita, nessuna modifica applicata: %s", failed.Index, failed.Op, p.Detail)
+		writeStoreProblem(w, r, p)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]batchResult{"results": results})
+}
+
+// prepare valida l'operazione e restituisce il passo da eseguire, oppure un
+// validationError con i campi relativi all'operazione (es. "todo.title").
+func (op batchOperation) prepare() (batchStep, error) {
+	var verr validationError
+	switch op.Op {
+	case batchCreate:
+		if op.ID != nil {
+			verr.add("id", "non è ammesso con create: lo decide lo store")
+		}
+		if op.Version != nil {
+			verr.add("version", "non è ammesso con create")
+		}
+	case batchUpdate, batchDelete:
+		if op.ID == nil || *op.ID < 1 {
+			verr.add("id", "è obbligatorio e deve essere un intero positivo")
+		}
+		if op.Version != nil && *op.Version < 1 {
+			verr.add("version", "deve essere un intero positivo")
+		}
+	default:
+		verr.add("op", fmt.Sprintf("%q non valido (valori ammessi: %s, %s, %s)", op.Op, batchCreate, batchUpdate, batchDelete))
+		return nil, verr
+	}
+	if op.Op == batchDelete && len(op.Todo) > 0 {
+		verr.add("todo", "non è ammesso con delete")
+	}
+	if op.Op != batchDelete && len(op.Todo) == 0 {
+		verr.add("todo", "è obbligatorio con "+op.Op)
+		return nil, verr
+	}
+
+	var step batchStep
+	switch op.Op {
+	case batchCreate:
+		var in createInput
+		if err := json.Unmarshal(op.Todo, &in); err != nil {
+			verr.add("todo", "JSON non valido: "+err.Error())
+			break
+		}
+		todo, err := in.newTodo(0)
+		if err != nil {
+			verr.addAll("todo", err)
+			break
+		}
+		step = func(ctx context.Context, tx store.TodoRepository) (batchResult, error) {
+			created, err := tx.Create(ctx, todo)
+			return batchResult{Status: http.StatusCreated, ID: created.ID, Todo: &created}, err
+		}
+
+	case batchUpdate:
+		var in todoInput
+		if err := decodeStrict(bytes.NewReader(op.Todo), &in); err != nil {
+			verr.add("todo", "JSON non valido: "+err.Error())
+			break
+		}
+		id := 0
+		if op.ID != nil {
+			id = *op.ID
+		}
+		todo, err := in.toTodo(id)
+		if err != nil {
+			verr.addAll("todo", err)
+			break
+		}
+		// la versione attesa può stare sia nell'operazione che nel todo
+		// (come in un PUT), ma se ci sono entrambe devono coincidere
+		if op.Version != nil {
+			if todo.Version != 0 && todo.Version != *op.Version {
+				verr.add("version", fmt.Sprintf("non corrisponde a todo.version (%d)", todo.Version))
+			}
+			todo.Version = *op.Version
+		}
+		step = func(ctx context.Context, tx store.TodoRepository) (batchResult, error) {
+			updated, err := tx.Update(ctx, todo)
+			return batchResult{Status: http.StatusOK, ID: updated.ID, Todo: &updated}, err
+		}
+
+	case batchDelete:
+		id, version := 0, 0
+		if op.ID != nil {
+			id = *op.ID
+		}
+		if op.Version != nil {
+			version = *op.Version
+		}
+		step = func(ctx context.Context, tx store.TodoRepository) (batchResult, error) {
+			err := tx.Delete(ctx, id, version)
+			return batchResult{Status: http.StatusNoContent, ID: id}, err
+		}
+	}
+	if err := verr.err(); err != nil {
+		return nil, err
+	}
+	return step, nil
+}
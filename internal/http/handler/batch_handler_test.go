@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"todolist-api-v2/internal/store"
+)
+
+func TestBatchHandler(t *testing.T) {
+	s := store.NewMemoryStore()
+	th := NewTodoHandler(s)
+	bh := NewBatchHandler(s)
+	router := chi.NewRouter()
+	router.Use(asUser(store.DefaultUserID))
+	router.Get("/todos", th.GetAll)
+	router.Post("/todos/batch", bh.Run)
+
+	batch := func(body string) (*httptest.ResponseRecorder, []batchResult) {
+		req := httptest.NewRequest(http.MethodPost, "/todos/batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var resp struct{ Results []batchResult }
+		if rr.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		}
+		return rr, resp.Results
+	}
+	total := func() string {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/todos", nil))
+		return rr.Header().Get("X-Total-Count")
+	}
+	statuses := func(results []batchResult) []int {
+		var out []int
+		for _, res := range results {
+			out = append(out, res.Status)
+		}
+		return out
+	}
+
+	t.Run("tutto o niente", func(t *testing.T) {
+		rr, results := batch(`{"operations":[
+			{"op":"create","todo":{"title":"Spesa"}},
+			{"op":"create","todo":{"title":"Bollette","priority":"high"}},
+			{"op":"update","id":1,"version":1,"todo":{"title":"Spesa","status":"done"}},
+			{"op":"delete","id":2}
+		]}`)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Equal(t, []int{201, 201, 200, 204}, statuses(results))
+		assert.Equal(t, store.StatusDone, results[2].Todo.Status)
+		assert.Equal(t, 2, results[3].ID)
+		assert.Nil(t, results[3].Todo)
+		assert.Equal(t, "1", total())
+
+		rr, _ = batch(`{"mode":"all_or_nothing","operations":[
+			{"op":"create","todo":{"title":"Mai creato"}},
+			{"op":"update","id":99,"todo":{"title":"x","status":"pending"}}
+		]}`)
+		require.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Equal(t, ProblemContentType, rr.Header().Get("Content-Type"))
+		assert.Contains(t, rr.Body.String(), "Operazione 1 (update)")
+		assert.Equal(t, "1", total(), "la creazione viene annullata")
+
+		rr, _ = batch(`{"operations":[
+			{"op":"create","todo":{"title":""}},
+			{"op":"update","todo":{"title":"x"}}
+		]}`)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		var problem Problem
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+		var fields []string
+		for _, fe := range problem.Errors {
+			fields = append(fields, fe.Field)
+		}
+		assert.Equal(t, []string{"operations[0].todo.title", "operations[1].id", "operations[1].todo.status"}, fields)
+	})
+
+	t.Run("esito per ogni operazione", func(t *testing.T) {
+		rr, results := batch(`{"mode":"per_item","operations":[
+			{"op":"create","todo":{"title":"Palestra"}},
+			{"op":"update","id":1,"version":1,"todo":{"title":"Spesa","status":"pending"}},
+			{"op":"archive","id":1},
+			{"op":"delete","id":1,"todo":{}},
+			{"op":"delete","id":1}
+		]}`)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Equal(t, []int{201, 409, 400, 400, 204}, statuses(results))
+		assert.Equal(t, CodeVersionConflict, results[1].Error.Code)
+		assert.Equal(t, "/problems/version-conflict", results[1].Error.Type)
+		assert.Equal(t, "op", results[2].Error.Errors[0].Field)
+		assert.Equal(t, "archive", results[2].Op)
+		assert.Equal(t, "1", total(), "Palestra creato, Spesa nel cestino")
+	})
+
+	t.Run("richieste non valide", func(t *testing.T) {
+		for _, body := range []string{
+			`{"operations":[]}`,
+			`{"mode":"best_effort","operations":[{"op":"delete","id":1}]}`,
+			`{"operations":[{"op":"delete","id":1}],"extra":true}`,
+			`[{"op":"delete","id":1}]`,
+		} {
+			rr, _ := batch(body)
+			assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+		}
+	})
+}
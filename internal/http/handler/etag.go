@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"todolist-api-v2/internal/store"
+)
+
+// todoETag è l'ETag forte di un singolo todo: cambia a ogni modifica
+// perché include la versione.
+func todoETag(t store.Todo) string {
+	return fmt.Sprintf(`"%d-%d"`, t.ID, t.Version)
+}
+
+// bodyETag è un ETag debole calcolato sul corpo della risposta; lo usiamo
+// per le liste, che cambiano quando cambia uno qualsiasi dei loro elementi.
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// parseETags divide il valore di If-Match / If-None-Match nei singoli tag.
+func parseETags(header string) []string {
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// ifMatch dice se la precondizione If-Match è soddisfatta per etag.
+// RFC 9110 richiede il confronto forte: un tag debole non corrisponde mai.
+func ifMatch(r *http.Request, etag string) bool {
+	for _, tag := range parseETags(r.Header.Get("If-Match")) {
+		if tag == "*" || (tag == etag && !strings.HasPrefix(tag, "W/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// ifNoneMatch dice se il client ha già la rappresentazione con etag.
+// Qui il confronto è debole: conta solo il valore, non il prefisso W/.
+func ifNoneMatch(r *http.Request, etag string) bool {
+	for _, tag := range parseETags(r.Header.Get("If-None-Match")) {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch verifica If-Match, se presente, contro il todo attuale.
+// Restituisce false dopo aver risposto 412 se la precondizione fallisce.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, current store.Todo) bool {
+	if r.Header.Get("If-Match") == "" || ifMatch(r, todoETag(current)) {
+		return true
+	}
+	w.Header().Set("ETag", todoETag(current))
+	writeProblem(w, r, http.StatusPreconditionFailed, CodePreconditionFailed, "Il todo è stato modificato: rileggerlo e riprovare")
+	return false
+}
+
+// conflictProblem è la risposta a un ErrVersionConflict dello store: 412 se
+// il client aveva posto una precondizione con If-Match, altrimenti 409.
+func conflictProblem(r *http.Request) Problem {
+	if r.Header.Get("If-Match") != "" {
+		return Problem{Status: http.StatusPreconditionFailed, Code: CodePreconditionFailed, Detail: "Il todo è stato modificato: rileggerlo e riprovare"}
+	}
+	return Problem{Status: http.StatusConflict, Code: CodeVersionConflict, Detail: "Il todo è stato modificato da un'altra richiesta"}
+}
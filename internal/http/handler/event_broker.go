@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+	"todolist-api-v2/internal/store"
+)
+
+const (
+	// EventPollInterval è ogni quanto EventBroker cerca nuovi eventi nel
+	// registro dello store.
+	EventPollInterval = 500 * time.Millisecond
+	// EventBufferSize è il numero di eventi recenti che EventBroker tiene
+	// in memoria per chi si ricollega con Last-Event-ID.
+	EventBufferSize = 1000
+
+	// eventPollBatch è il numero massimo di eventi letti con una query.
+	eventPollBatch = 500
+	// subscriberBuffer sono gli eventi che possono restare in coda per un
+	// client lento prima che venga scollegato.
+	subscriberBuffer = 64
+)
+
+// errBrokerClosed è restituito da subscribe quando il server si sta
+// spegnendo.
+var errBrokerClosed = errors.New("lo stream degli eventi è chiuso")
+
+// EventBroker segue il registro delle modifiche dello store e inoltra i
+// nuovi eventi agli stream aperti, ognuno con i soli eventi del suo
+// utente. Il registro è scritto nella stessa transazione delle modifiche,
+// quindi ogni metodo dello store che cambia un todo arriva qui, e solo
+// dopo essere stato confermato.
+//
+// Gli ultimi eventi restano in un buffer limitato: chi si ricollega con
+// Last-Event-ID riceve quelli persi, se sono ancora nel buffer.
+type EventBroker struct {
+	store    store.EventFeed
+	interval time.Duration
+	size     int
+
+	ready chan struct{} // chiuso quando il buffer è stato caricato
+
+	mu     sync.Mutex
+	buffer []store.Event // gli ultimi eventi, dal più vecchio
+	lastID int           // l'ultimo evento letto dallo store
+	subs   map[*subscription]struct{}
+	closed bool
+}
+
+// subscription è uno stream aperto. events viene chiuso quando lo stream
+// deve terminare: il server si spegne o il client non sta al passo.
+type subscription struct {
+	owner  int
+	events chan store.Event
+}
+
+func NewEventBroker(s store.EventFeed, interval time.Duration, size int) *EventBroker {
+	return &EventBroker{
+		store:    s,
+		interval: interval,
+		size:     size,
+		ready:    make(chan struct{}),
+		subs:     make(map[*subscription]struct{}),
+	}
+}
+
+// Run carica gli eventi più recenti e poi controlla il registro ogni
+// interval, finché ctx non viene annullato; a quel punto chiude tutti gli
+// stream, così lo spegnimento del server non deve aspettarli.
+func (b *EventBroker) Run(ctx context.Context) {
+	defer b.close()
+
+	// all'avvio il buffer riparte dagli ultimi eventi del registro, così
+	// anche chi si ricollega dopo un riavvio del server non perde nulla
+	for ctx.Err() == nil {
+		err := b.load(ctx)
+		if err == nil {
+			break
+		}
+		log.Printf("Stream degli eventi: errore nella lettura del registro: %v", err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(b.interval):
+		}
+	}
+	close(b.ready)
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := b.poll(ctx); err != nil && ctx.Err() == nil {
+			// riproviamo al prossimo giro
+			log.Printf("Stream degli eventi: errore nella lettura del registro: %v", err)
+		}
+	}
+}
+
+func (b *EventBroker) load(ctx context.Context) error {
+	last, err := b.store.LastEventID(ctx)
+	if err != nil {
+		return err
+	}
+	events, err := b.store.EventsAfter(ctx, max(0, last-b.size), b.size)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buffer, b.lastID = events, last
+	return nil
+}
+
+// poll legge gli eventi nuovi e li inoltra.
+func (b *EventBroker) poll(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		after := b.lastID
+		b.mu.Unlock()
+
+		events, err := b.store.EventsAfter(ctx, after, eventPollBatch)
+		if err != nil || len(events) == 0 {
+			return err
+		}
+		b.publish(events)
+		if len(events) < eventPollBatch {
+			return nil
+		}
+	}
+}
+
+func (b *EventBroker) publish(events []store.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, e := range events {
+		b.buffer = append(b.buffer, e)
+		b.lastID = e.ID
+		for sub := range b.subs {
+			if sub.owner != e.OwnerID {
+				continue
+			}
+			select {
+			case sub.events <- e:
+			default:
+				// il client non legge abbastanza in fretta: invece di
+				// bloccare tutti lo scolleghiamo, si ricollegherà con
+				// Last-Event-ID
+				close(sub.events)
+				delete(b.subs, sub)
+			}
+		}
+	}
+	if extra := len(b.buffer) - b.size; extra > 0 {
+		// copiamo per non tenere in vita il vecchio array
+		b.buffer = append([]store.Event(nil), b.buffer[extra:]...)
+	}
+}
+
+func (b *EventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subs {
+		close(sub.events)
+		delete(b.subs, sub)
+	}
+}
+
+// subscribe apre uno stream per gli eventi di owner. Se lastEventID non è
+// nil restituisce anche gli eventi successivi ancora nel buffer; complete
+// è false se il buffer non arriva così indietro (o l'ID non esiste), e il
+// client deve ricaricare i todo da capo.
+func (b *EventBroker) subscribe(ctx context.Context, owner int, lastEventID *int) (sub *subscription, missed []store.Event, complete bool, err error) {
+	select {
+	case <-b.ready:
+	case <-ctx.Done():
+		return nil, nil, false, ctx.Err()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, nil, false, errBrokerClosed
+	}
+	complete = true
+	if lastEventID != nil {
+		// il buffer copre gli eventi da oldest in poi
+		oldest := b.lastID + 1
+		if len(b.buffer) > 0 {
+			oldest = b.buffer[0].ID
+		}
+		complete = *lastEventID >= oldest-1 && *lastEventID <= b.lastID
+		for _, e := range b.buffer {
+			if e.ID > *lastEventID && e.OwnerID == owner {
+				missed = append(missed, e)
+			}
+		}
+	}
+	sub = &subscription{owner: owner, events: make(chan store.Event, subscriberBuffer)}
+	b.subs[sub] = struct{}{}
+	return sub, missed, complete, nil
+}
+
+// unsubscribe chiude lo stream; si può chiamare anche se il broker l'ha
+// già chiuso.
+func (b *EventBroker) unsubscribe(sub *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[sub]; ok {
+		close(sub.events)
+		delete(b.subs, sub)
+	}
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+	"todolist-api-v2/internal/store"
+)
+
+// eventHeartbeat è ogni quanto lo stream manda un commento vuoto: tiene
+// aperta la connessione attraverso i proxy che chiudono quelle inattive.
+const eventHeartbeat = 15 * time.Second
+
+// EventsHandler inoltra in tempo reale le modifiche ai todo.
+type EventsHandler struct {
+	Broker *EventBroker
+}
+
+func NewEventsHandler(b *EventBroker) *EventsHandler {
+	return &EventsHandler{Broker: b}
+}
+
+// Stream gestisce GET /todos/events, uno stream Server-Sent Events con le
+// modifiche ai todo dell'utente, anche quelle fatte da altri client. Ogni
+// evento ha come tipo l'azione (created, updated, deleted, restored,
+// purged), come id l'ID dell'evento e come dati lo stesso oggetto di
+// GET /todos/{todoID}/history.
+//
+// Chi si ricollega con l'header Last-Event-ID (EventSource lo fa da solo),
+// o con ?last_event_id= al primo collegamento, riceve prima gli eventi
+// persi. Se sono troppo vecchi per essere ancora nel buffer lo stream
+// inizia con un evento "reset": il client deve ricaricare i todo da capo.
+func (h *EventsHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	owner, ok := store.OwnerFromContext(r.Context())
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "Autenticazione richiesta")
+		return
+	}
+	var lastEventID *int
+	field, value := "Last-Event-ID", r.Header.Get("Last-Event-ID")
+	if value == "" {
+		field, value = "last_event_id", r.URL.Query().Get("last_event_id")
+	}
+	if value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil || id < 0 {
+			writeValidationError(w, r, CodeInvalidQuery, validationError{{Field: field, Message: "deve essere un intero non negativo"}})
+			return
+		}
+		lastEventID = &id
+	}
+
+	sub, missed, complete, err := h.Broker.subscribe(r.Context(), owner, lastEventID)
+	if err != nil {
+		writeProblem(w, r, http.StatusServiceUnavailable, CodeUnavailable, "Lo stream degli eventi non è disponibile, riprovare tra poco")
+		return
+	}
+	defer h.Broker.unsubscribe(sub)
+
+	// lo stream dura quanto vuole il client: il WriteTimeout del server
+	// non vale (se il writer non lo permette, pazienza)
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // niente buffer in nginx
+	w.WriteHeader(http.StatusOK)
+	if !complete {
+		io.WriteString(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, e := range missed {
+		if err := writeSSE(w, e); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return // il client se n'è andato
+		case e, ok := <-sub.events:
+			if !ok {
+				return // server in spegnimento o client troppo lento
+			}
+			if err := writeSSE(w, e); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ":\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeSSE scrive un evento nel formato text/event-stream. Il JSON di
+// json.Marshal è su una sola riga, come richiede il campo data.
+func writeSSE(w io.Writer, e store.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Action, data)
+	return err
+}
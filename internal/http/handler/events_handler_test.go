@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"todolist-api-v2/internal/store"
+)
+
+// sseEvent è un evento letto dallo stream.
+type sseEvent struct {
+	ID, Event, Data string
+}
+
+// readSSE legge il prossimo evento dello stream, saltando i commenti.
+func readSSE(t *testing.T, r *bufio.Reader) sseEvent {
+	t.Helper()
+	var e sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && e.Event != "":
+			return e
+		case strings.HasPrefix(line, "id: "):
+			e.ID = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			e.Event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			e.Data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	s := store.NewMemoryStore()
+	ctx := store.WithOwner(context.Background(), store.DefaultUserID)
+	bruno, err := s.CreateUser(ctx, "bruno", "")
+	require.NoError(t, err)
+	brunoCtx := store.WithOwner(context.Background(), bruno.ID)
+
+	// tre eventi prima dell'avvio: nel buffer (da 2) restano gli ultimi due
+	for _, title := range []string{"Pane", "Latte", "Uova"} {
+		_, err := s.Create(ctx, store.Todo{Title: title})
+		require.NoError(t, err)
+	}
+
+	broker := NewEventBroker(s, 10*time.Millisecond, 2)
+	brokerCtx, stopBroker := context.WithCancel(context.Background())
+	defer stopBroker()
+	go broker.Run(brokerCtx)
+
+	h := NewEventsHandler(broker)
+	server := func(userID int) *httptest.Server {
+		router := chi.NewRouter()
+		router.Use(asUser(userID))
+		router.Get("/todos/events", h.Stream)
+		srv := httptest.NewServer(router)
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	srv := server(store.DefaultUserID)
+
+	open := func(srv *httptest.Server, lastEventID string) *bufio.Reader {
+		reqCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		t.Cleanup(cancel)
+		req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, srv.URL+"/todos/events", nil)
+		require.NoError(t, err)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { res.Body.Close() })
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+		return bufio.NewReader(res.Body)
+	}
+
+	t.Run("eventi in tempo reale, solo dell'utente", func(t *testing.T) {
+		stream := open(srv, "")
+		_, err := s.Create(brunoCtx, store.Todo{Title: "Di bruno"})
+		require.NoError(t, err)
+		todo, err := s.Create(ctx, store.Todo{Title: "Burro"})
+		require.NoError(t, err)
+
+		e := readSSE(t, stream)
+		assert.Equal(t, "created", e.Event)
+		var event store.Event
+		require.NoError(t, json.Unmarshal([]byte(e.Data), &event))
+		assert.Equal(t, todo.ID, event.TodoID)
+		assert.Equal(t, "5", e.ID) // il 4 è di bruno
+
+		require.NoError(t, s.Delete(ctx, todo.ID, todo.Version))
+		e = readSSE(t, stream)
+		assert.Equal(t, "6", e.ID)
+		assert.Equal(t, "deleted", e.Event)
+	})
+
+	t.Run("Last-Event-ID recupera gli eventi persi", func(t *testing.T) {
+		// nel buffer ci sono il 5 e il 6: il 4 è l'ultimo che si può indicare
+		stream := open(srv, "4")
+		for _, id := range []string{"5", "6"} {
+			assert.Equal(t, id, readSSE(t, stream).ID)
+		}
+	})
+
+	t.Run("reset se il buffer non arriva così indietro", func(t *testing.T) {
+		stream := open(srv, "1")
+		assert.Equal(t, "reset", readSSE(t, stream).Event)
+		for _, id := range []string{"5", "6"} {
+			assert.Equal(t, id, readSSE(t, stream).ID)
+		}
+	})
+
+	t.Run("Last-Event-ID non valido", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/todos/events?last_event_id=-1", nil)
+		h.Stream(rr, req.WithContext(store.WithOwner(req.Context(), store.DefaultUserID)))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), CodeInvalidQuery)
+	})
+
+	t.Run("lo spegnimento chiude gli stream", func(t *testing.T) {
+		stream := open(server(bruno.ID), "")
+		stopBroker()
+		_, err := io.ReadAll(stream)
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"todolist-api-v2/internal/store"
+)
+
+// HistoryHandler espone il registro delle modifiche dei todo.
+type HistoryHandler struct {
+	Store store.EventRepository
+}
+
+func NewHistoryHandler(s store.EventRepository) *HistoryHandler {
+	return &HistoryHandler{Store: s}
+}
+
+// GetByID gestisce GET /todos/{todoID}/history: gli eventi del todo, dal
+// più vecchio, con chi ha fatto ogni modifica, quando e in quale
+// richiesta. Funziona anche per i todo nel cestino o già eliminati.
+func (h *HistoryHandler) GetByID(w http.ResponseWriter, r *http.Request) {
+	id, ok := todoIDParam(w, r)
+	if !ok {
+		return
+	}
+	events, err := h.Store.GetHistory(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"todolist-api-v2/internal/auth"
+	"todolist-api-v2/internal/store"
+)
+
+func TestHistoryHandler(t *testing.T) {
+	s := store.NewMemoryStore()
+	tokens, err := auth.NewTokens([]byte("una-chiave-di-test-lunga-almeno-32-byte"), time.Minute, time.Hour)
+	require.NoError(t, err)
+	pair, err := tokens.IssuePair(store.DefaultUserID)
+	require.NoError(t, err)
+
+	// come in main.go: l'ID della richiesta arriva da middleware.RequestID
+	th := NewTodoHandler(s)
+	history := NewHistoryHandler(s)
+	router := chi.NewRouter()
+	router.Use(middleware.RequestID)
+	router.Route("/todos", func(r chi.Router) {
+		r.Use(RequireUser(tokens))
+		r.Post("/", th.Create)
+		r.Patch("/{todoID}", th.Patch)
+		r.Delete("/{todoID}", th.Delete)
+		r.Get("/{todoID}/history", history.GetByID)
+	})
+
+	do := func(method, url, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+		req.Header.Set(middleware.RequestIDHeader, "chiusura-42")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/todos", "application/json", `{"title":"Bollette"}`).Code)
+	rr := do(http.MethodPatch, "/todos/1", "application/merge-patch+json", `{"status":"done"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/todos/1", "", "").Code)
+
+	rr = do(http.MethodGet, "/todos/1/history", "", "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var events []struct {
+		Action    string          `json:"action"`
+		ActorID   int             `json:"actor_id"`
+		RequestID string          `json:"request_id"`
+		Changed   []string        `json:"changed"`
+		Before    json.RawMessage `json:"before"`
+		After     json.RawMessage `json:"after"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &events))
+	require.Len(t, events, 3, "la storia resta visibile anche con il todo nel cestino")
+	assert.Equal(t, "created", events[0].Action)
+	assert.JSONEq(t, "null", string(events[0].Before))
+	closed := events[1]
+	assert.Equal(t, "updated", closed.Action)
+	assert.Equal(t, store.DefaultUserID, closed.ActorID)
+	assert.Equal(t, "chiusura-42", closed.RequestID)
+	assert.Contains(t, closed.Changed, "status")
+	assert.Equal(t, `"done"`, mustField(t, closed.After, "status"))
+	assert.Equal(t, "deleted", events[2].Action)
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/todos/99/history", "", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/todos/abc/history", "", "").Code)
+}
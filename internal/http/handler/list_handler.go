@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"todolist-api-v2/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ListHandler gestisce le liste e le rotte annidate /lists/{listID}/todos,
+// che per i todo riusano la logica di TodoHandler.
+type ListHandler struct {
+	Store store.ListRepository
+	Todos *TodoHandler
+}
+
+func NewListHandler(s store.ListRepository, todos *TodoHandler) *ListHandler {
+	return &ListHandler{Store: s, Todos: todos}
+}
+
+// listInput è il corpo di POST e PUT /lists.
+type listInput struct {
+	Name string `json:"name"`
+}
+
+// decodeListInput legge e valida il corpo; se non va bene ha già risposto 400.
+func decodeListInput(w http.ResponseWriter, r *http.Request) (listInput, bool) {
+	var in listInput
+	if err := decodeStrict(r.Body, &in); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidBody, "Corpo della richiesta JSON non valido: "+err.Error())
+		return listInput{}, false
+	}
+	if in.Name == "" {
+		writeValidationError(w, r, CodeValidationFailed, validationError{{Field: "name", Message: "è obbligatorio"}})
+		return listInput{}, false
+	}
+	return in, true
+}
+
+// listIDParam legge l'ID della lista dall'URL, come todoIDParam.
+func listIDParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "listID"))
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidID, "ID non valido, deve essere un numero intero")
+		return 0, false
+	}
+	return id, true
+}
+
+func writeList(w http.ResponseWriter, status int, l store.List) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(l)
+}
+
+// GetAll restituisce tutte le liste dell'utente con il numero di todo.
+func (h *ListHandler) GetAll(w http.ResponseWriter, r *http.Request) {
+	lists, err := h.Store.GetLists(r.Context())
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lists)
+}
+
+func (h *ListHandler) Create(w http.ResponseWriter, r *http.Request) {
+	in, ok := decodeListInput(w, r)
+	if !ok {
+		return
+	}
+	l, err := h.Store.CreateList(r.Context(), in.Name)
+	if err != nil {
+		// nome già usato (409) o non valido (400)
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/lists/"+strconv.Itoa(l.ID))
+	writeList(w, http.StatusCreated, l)
+}
+
+func (h *ListHandler) GetByID(w http.ResponseWriter, r *http.Request) {
+	id, ok := listIDParam(w, r)
+	if !ok {
+		return
+	}
+	l, err := h.Store.GetList(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	writeList(w, http.StatusOK, l)
+}
+
+// Update rinomina la lista: il nome è l'unico campo modificabile.
+func (h *ListHandler) Update(w http.ResponseWriter, r *http.Request) {
+	id, ok := listIDParam(w, r)
+	if !ok {
+		return
+	}
+	in, ok := decodeListInput(w, r)
+	if !ok {
+		return
+	}
+	l, err := h.Store.RenameList(r.Context(), id, in.Name)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	writeList(w, http.StatusOK, l)
+}
+
+// Delete cancella la lista. Di default una lista con dei todo non si
+// cancella (409): con ?cascade=true i suoi todo finiscono nel cestino, da
+// dove si possono ancora ripristinare.
+func (h *ListHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := listIDParam(w, r)
+	if !ok {
+		return
+	}
+	cascade := false
+	if v := r.URL.Query().Get("cascade"); v != "" {
+		var err error
+		if cascade, err = strconv.ParseBool(v); err != nil {
+			writeValidationError(w, r, CodeInvalidQuery, validationError{{Field: "cascade", Message: "deve essere true o false"}})
+			return
+		}
+	}
+
+	if err := h.Store.DeleteList(r.Context(), id, cascade); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetTodos gestisce GET /lists/{listID}/todos: come GET /todos, con gli
+// stessi filtri, ma solo per i todo della lista.
+func (h *ListHandler) GetTodos(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.requireList(w, r)
+	if !ok {
+		return
+	}
+	h.Todos.getAll(w, r, func(opts *store.ListOptions) { opts.ListID = id })
+}
+
+// CreateTodo gestisce POST /lists/{listID}/todos: crea il todo già nella lista.
+func (h *ListHandler) CreateTodo(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.requireList(w, r)
+	if !ok {
+		return
+	}
+	h.Todos.create(w, r, id)
+}
+
+// requireList controlla che la lista dell'URL esista, così le rotte
+// annidate rispondono 404 per una lista sconosciuta invece di una lista
+// vuota (GET) o di un errore di validazione (POST).
+func (h *ListHandler) requireList(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, ok := listIDParam(w, r)
+	if !ok {
+		return 0, false
+	}
+	if _, err := h.Store.GetList(r.Context(), id); err != nil {
+		writeStoreError(w, r, err)
+		return 0, false
+	}
+	return id, true
+}
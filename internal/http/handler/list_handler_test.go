@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"todolist-api-v2/internal/store"
+)
+
+// setupListAPI monta le rotte di /lists e /todos come in main.go.
+func setupListAPI(t *testing.T) http.Handler {
+	s := store.NewMemoryStore()
+	th := NewTodoHandler(s)
+	lh := NewListHandler(s, th)
+
+	r := chi.NewRouter()
+	r.Use(asUser(store.DefaultUserID))
+	r.Route("/todos", func(r chi.Router) {
+		r.Get("/", th.GetAll)
+		r.Post("/", th.Create)
+		r.Patch("/{todoID}", th.Patch)
+	})
+	r.Route("/lists", func(r chi.Router) {
+		r.Get("/", lh.GetAll)
+		r.Post("/", lh.Create)
+		r.Route("/{listID}", func(r chi.Router) {
+			r.Get("/", lh.GetByID)
+			r.Put("/", lh.Update)
+			r.Delete("/", lh.Delete)
+			r.Get("/todos", lh.GetTodos)
+			r.Post("/todos", lh.CreateTodo)
+		})
+	})
+	return r
+}
+
+func TestListHandlers(t *testing.T) {
+	router := setupListAPI(t)
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	createList := func(t *testing.T, name string) store.List {
+		rr := do(http.MethodPost, "/lists", `{"name":"`+name+`"}`)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		var l store.List
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &l))
+		assert.Equal(t, "/lists/"+strconv.Itoa(l.ID), rr.Header().Get("Location"))
+		return l
+	}
+
+	work := createList(t, "Lavoro")
+	home := createList(t, "Casa")
+	workURL := "/lists/" + strconv.Itoa(work.ID)
+
+	t.Run("validazione e conflitti", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/lists", `{"name":""}`).Code)
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/lists", `{"nome":"x"}`).Code)
+		assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/lists", `{"name":"lavoro"}`).Code)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/lists/999", "").Code)
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/lists/abc", "").Code)
+	})
+
+	var todoID int
+	t.Run("todo annidati", func(t *testing.T) {
+		rr := do(http.MethodPost, workURL+"/todos", `{"title":"Relazione"}`)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		assert.JSONEq(t, strconv.Itoa(work.ID), mustField(t, rr.Body.Bytes(), "list_id"))
+		require.NoError(t, json.Unmarshal([]byte(mustField(t, rr.Body.Bytes(), "id")), &todoID))
+
+		rr = do(http.MethodPost, workURL+"/todos", `{"title":"Altrove","list_id":`+strconv.Itoa(home.ID)+`}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "list_id in contrasto con l'URL")
+		rr = do(http.MethodPost, "/lists/999/todos", `{"title":"Perso"}`)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		rr = do(http.MethodPost, "/todos", `{"title":"Lista inventata","list_id":999}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+		rr = do(http.MethodPost, "/todos", `{"title":"Senza lista"}`)
+		require.Equal(t, http.StatusCreated, rr.Code)
+		assert.JSONEq(t, `null`, mustField(t, rr.Body.Bytes(), "list_id"))
+
+		rr = do(http.MethodGet, workURL+"/todos", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "1", rr.Header().Get("X-Total-Count"))
+		rr = do(http.MethodGet, "/todos?list_id="+strconv.Itoa(work.ID), "")
+		assert.Equal(t, "1", rr.Header().Get("X-Total-Count"))
+		rr = do(http.MethodGet, "/lists/999/todos", "")
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("spostare un todo", func(t *testing.T) {
+		rr := do(http.MethodPatch, "/todos/"+strconv.Itoa(todoID), `{"list_id":`+strconv.Itoa(home.ID)+`}`)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.JSONEq(t, strconv.Itoa(home.ID), mustField(t, rr.Body.Bytes(), "list_id"))
+
+		rr = do(http.MethodGet, workURL, "")
+		assert.JSONEq(t, `0`, mustField(t, rr.Body.Bytes(), "todo_count"))
+		rr = do(http.MethodPatch, "/todos/"+strconv.Itoa(todoID), `{"list_id":999}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("rinomina", func(t *testing.T) {
+		rr := do(http.MethodPut, workURL, `{"name":"Ufficio"}`)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, `"Ufficio"`, mustField(t, rr.Body.Bytes(), "name"))
+	})
+
+	t.Run("cancellazione", func(t *testing.T) {
+		homeURL := "/lists/" + strconv.Itoa(home.ID)
+		rr := do(http.MethodDelete, homeURL, "")
+		assert.Equal(t, http.StatusConflict, rr.Code, "una lista con dei todo va cancellata a cascata")
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodDelete, homeURL+"?cascade=forse", "").Code)
+
+		assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, homeURL+"?cascade=true", "").Code)
+		assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, workURL, "").Code)
+
+		rr = do(http.MethodGet, "/lists", "")
+		assert.JSONEq(t, `[]`, rr.Body.String())
+		rr = do(http.MethodGet, "/todos", "")
+		assert.Equal(t, "1", rr.Header().Get("X-Total-Count"), "resta solo il todo senza lista")
+	})
+}
@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"todolist-api-v2/internal/store"
+)
+
+const (
+	// numero di todo restituiti da GET /todos se il client non specifica limit.
+	defaultPageSize = 100
+	// limite massimo accettato, per non tornare a "tutto in un colpo solo".
+	maxPageSize = 500
+)
+
+// parseListOptions legge i parametri di GET /todos:
+//
+//	status=pending,done   filtra per stato (anche ripetuto: status=a&status=b)
+//	q=pane                cerca nel titolo (maiuscole = minuscole, solo per le lettere ASCII)
+//	list_id=3             solo i todo della lista 3
+//	tag=casa&tag=urgente  solo i todo con almeno uno dei tag (anche tag=casa,urgente)
+//	tag_mode=all          ...oppure con tutti i tag indicati (default any)
+//	overdue=true          solo i todo scaduti (false: solo quelli non scaduti)
+//	due_after=...&due_before=...  scadenza in [due_after, due_before), date RFC 3339
+//	                      (il "+" del fuso orario va codificato come %2B)
+//	sort=title&order=desc ordinamento (id, title, status, completed_at, due_at,
+//	                      priority: prima le più urgenti, poi per scadenza)
+//	limit=20&offset=40    paginazione classica
+//	limit=20&cursor=57    paginazione a cursore (keyset sull'id), cursor=0 per iniziare
+func parseListOptions(q url.Values) (store.ListOptions, error) {
+	opts := store.ListOptions{
+		Search: q.Get("q"),
+		Sort:   store.SortField(q.Get("sort")),
+		Limit:  defaultPageSize,
+	}
+	// raccogliamo tutti i parametri sbagliati invece di fermarci al primo.
+	var verr validationError
+
+	for _, value := range q["status"] {
+		for _, s := range strings.Split(value, ",") {
+			status, err := store.ParseStatus(strings.TrimSpace(s))
+			if err != nil {
+				verr.add("status", err.Error())
+				continue
+			}
+			opts.Statuses = append(opts.Statuses, status)
+		}
+	}
+
+	for _, value := range q["tag"] {
+		for _, t := range strings.Split(value, ",") {
+			tag, err := store.NormalizeTag(t)
+			if err != nil {
+				verr.add("tag", err.Error())
+				continue
+			}
+			opts.Tags = append(opts.Tags, tag)
+		}
+	}
+	switch q.Get("tag_mode") {
+	case "", "any":
+	case "all":
+		opts.AllTags = true
+	default:
+		verr.add("tag_mode", "deve essere 'any' o 'all'")
+	}
+
+	if v := q.Get("overdue"); v != "" {
+		overdue, err := strconv.ParseBool(v)
+		if err != nil {
+			verr.add("overdue", "deve essere true o false")
+		} else {
+			opts.Overdue = &overdue
+		}
+	}
+	opts.DueAfter = timeParam(q, "due_after", &verr)
+	opts.DueBefore = timeParam(q, "due_before", &verr)
+	if opts.DueAfter != nil && opts.DueBefore != nil && !opts.DueAfter.Before(*opts.DueBefore) {
+		verr.add("due_after", "deve precedere due_before")
+	}
+
+	if opts.Sort != "" && !slices.Contains(store.SortFields, opts.Sort) {
+		verr.add("sort", fmt.Sprintf("campo di ordinamento %q non valido", opts.Sort))
+	}
+
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		verr.add("order", "deve essere 'asc' o 'desc'")
+	}
+
+	var ok bool
+	if opts.Limit, ok = intParam(q, "limit", defaultPageSize, &verr); ok && (opts.Limit < 1 || opts.Limit > maxPageSize) {
+		verr.add("limit", fmt.Sprintf("deve essere compreso tra 1 e %d", maxPageSize))
+	}
+	opts.ListID, _ = intParam(q, "list_id", 0, &verr)
+	opts.Offset, _ = intParam(q, "offset", 0, &verr)
+	opts.AfterID, _ = intParam(q, "cursor", 0, &verr)
+	// anche "cursor=0" (o vuoto) attiva la paginazione a cursore dall'inizio,
+	// quindi controlliamo la presenza del parametro e non solo il valore.
+	if q.Has("cursor") {
+		if q.Has("offset") {
+			verr.add("cursor", "cursor e offset non possono essere usati insieme")
+		}
+		if opts.Sort != "" && opts.Sort != store.SortByID {
+			verr.add("cursor", "il cursore è disponibile solo con sort=id")
+		}
+	}
+
+	if err := verr.err(); err != nil {
+		return store.ListOptions{}, err
+	}
+	return opts, opts.Validate()
+}
+
+// intParam legge un intero non negativo; se non è valido aggiunge l'errore
+// a verr e restituisce false.
+func intParam(q url.Values, name string, def int, verr *validationError) (int, bool) {
+	v := q.Get(name)
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		verr.add(name, "deve essere un intero non negativo")
+		return def, false
+	}
+	return n, true
+}
+
+// timeParam legge un parametro facoltativo con una data RFC 3339.
+func timeParam(q url.Values, name string, verr *validationError) *time.Time {
+	v := q.Get(name)
+	if v == "" {
+		return nil
+	}
+	t, err := parseTime(v)
+	if err != nil {
+		verr.add(name, err.Error())
+	}
+	return t
+}
+
+// setPaginationHeaders aggiunge X-Total-Count e l'header Link (RFC 8288)
+// con i collegamenti alle altre pagine, mantenendo filtri e ordinamento.
+func setPaginationHeaders(w http.ResponseWriter, r *http.Request, opts store.ListOptions, page store.TodoPage) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+
+	link := func(rel string, set map[string]string) string {
+		u := *r.URL
+		q := u.Query()
+		for k, v := range set {
+			if v == "" {
+				q.Del(k)
+			} else {
+				q.Set(k, v)
+			}
+		}
+		u.RawQuery = q.Encode()
+		return fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel)
+	}
+	limit := strconv.Itoa(opts.Limit)
+
+	var links []string
+	if r.URL.Query().Has("cursor") {
+		// paginazione a cursore: si può solo ripartire o andare avanti.
+		links = append(links, link("first", map[string]string{"cursor": "0", "limit": limit}))
+		if page.HasMore && len(page.Todos) > 0 {
+			next := strconv.Itoa(page.Todos[len(page.Todos)-1].ID)
+			links = append(links, link("next", map[string]string{"cursor": next, "limit": limit}))
+		}
+	} else {
+		links = append(links, link("first", map[string]string{"offset": "", "limit": limit}))
+		if opts.Offset > 0 {
+			prev := opts.Offset - opts.Limit
+			if prev < 0 {
+				prev = 0
+			}
+			links = append(links, link("prev", map[string]string{"offset": strconv.Itoa(prev), "limit": limit}))
+		}
+		if page.HasMore {
+			links = append(links, link("next", map[string]string{"offset": strconv.Itoa(opts.Offset + opts.Limit), "limit": limit}))
+		}
+		if page.Total > 0 {
+			last := (page.Total - 1) / opts.Limit * opts.Limit
+			links = append(links, link("last", map[string]string{"offset": strconv.Itoa(last), "limit": limit}))
+		}
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
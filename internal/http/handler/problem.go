@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"todolist-api-v2/internal/store"
+)
+
+// Codici di errore stabili: il frontend deve basarsi su questi e non sul
+// testo di "detail", che può cambiare.
+const (
+	CodeInvalidID            = "invalid_id"
+	CodeInvalidBody          = "invalid_body"
+	CodeInvalidQuery         = "invalid_query"
+	CodeValidationFailed     = "validation_failed"
+	CodeNotFound             = "not_found"
+	CodeUnauthorized         = "unauthorized"
+	CodeTokenExpired         = "token_expired"
+	CodeInvalidCredentials   = "invalid_credentials"
+	CodeInsufficientScope    = "insufficient_scope"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeVersionConflict      = "version_conflict"
+	CodeConflict             = "conflict"
+	CodePreconditionFailed   = "precondition_failed"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodePatchFailed          = "patch_failed"
+	CodePatchTestFailed      = "patch_test_failed"
+	CodeUnavailable          = "unavailable"
+	CodeSearchUnavailable    = "search_unavailable"
+	CodeInternal             = "internal_error"
+)
+
+// titoli fissi per ogni codice, come richiesto da RFC 7807 per "title".
+var problemTitles = map[string]string{
+	CodeInvalidID:            "ID non valido",
+	CodeInvalidBody:          "Corpo della richiesta non valido",
+	CodeInvalidQuery:         "Parametri della richiesta non validi",
+	CodeValidationFailed:     "Dati non validi",
+	CodeNotFound:             "Risorsa non trovata",
+	CodeUnauthorized:         "Autenticazione richiesta",
+	CodeTokenExpired:         "Token scaduto",
+	CodeInvalidCredentials:   "Credenziali non valide",
+	CodeInsufficientScope:    "Permessi insufficienti",
+	CodeMethodNotAllowed:     "Metodo non consentito",
+	CodeVersionConflict:      "Conflitto di versione",
+	CodeConflict:             "Conflitto con lo stato attuale",
+	CodePreconditionFailed:   "Precondizione fallita",
+	CodeUnsupportedMediaType: "Content-Type non supportato",
+	CodePatchFailed:          "Patch non applicabile",
+	CodePatchTestFailed:      "Operazione test della patch fallita",
+	CodeUnavailable:          "Servizio temporaneamente non disponibile",
+	CodeSearchUnavailable:    "Ricerca full-text non disponibile",
+	CodeInternal:             "Errore interno del server",
+}
+
+// ProblemContentType è il media type delle risposte di errore (RFC 7807).
+const ProblemContentType = "application/problem+json"
+
+// Problem è il corpo di ogni risposta di errore dell'API (RFC 7807),
+// esteso con un codice stabile e l'elenco dei campi non validi.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError descrive un problema su un singolo campo del corpo o su un
+// parametro della query string.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validationError raccoglie tutti i campi non validi di una richiesta,
+// così il client li scopre in un colpo solo e non uno alla volta.
+type validationError []FieldError
+
+func (v validationError) Error() string {
+	msgs := make([]string, len(v))
+	for i, fe := range v {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (v *validationError) add(field, message string) {
+	*v = append(*v, FieldError{Field: field, Message: message})
+}
+
+// addAll aggiunge gli errori di err (un validationError o un errore
+// qualsiasi) mettendo prefix, se c'è, davanti al nome dei campi, es.
+// "todo.title".
+func (v *validationError) addAll(prefix string, err error) {
+	var inner validationError
+	if !errors.As(err, &inner) {
+		v.add(prefix, err.Error())
+		return
+	}
+	for _, fe := range inner {
+		field := fe.Field
+		switch {
+		case prefix == "":
+		case field == "":
+			field = prefix
+		default:
+			field = prefix + "." + field
+		}
+		v.add(field, fe.Message)
+	}
+}
+
+// err restituisce nil se non ci sono errori (evita il classico nil tipizzato).
+func (v validationError) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+// writeProblem invia un problem+json con lo status e il codice indicati.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	writeProblemBody(w, r, Problem{Status: status, Code: code, Detail: detail})
+}
+
+func writeProblemBody(w http.ResponseWriter, r *http.Request, p Problem) {
+	p = p.described()
+	p.Instance = r.URL.Path
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// described completa type e title in base al codice.
+func (p Problem) described() Problem {
+	p.Type = "/problems/" + strings.ReplaceAll(p.Code, "_", "-")
+	p.Title = problemTitles[p.Code]
+	return p
+}
+
+// writeValidationError risponde 400 elencando i campi non validi.
+func writeValidationError(w http.ResponseWriter, r *http.Request, code string, err error) {
+	writeProblemBody(w, r, validationProblem(code, err))
+}
+
+// validationProblem è il 400 con i campi non validi. Se err non è un
+// validationError viene trattato come errore su un campo generico.
+func validationProblem(code string, err error) Problem {
+	var verr validationError
+	if !errors.As(err, &verr) {
+		verr = validationError{{Field: "", Message: err.Error()}}
+	}
+	return Problem{
+		Status: http.StatusBadRequest,
+		Code:   code,
+		Detail: "La richiesta contiene dati non validi",
+		Errors: verr,
+	}
+}
+
+// writeInternalError logga l'errore vero e risponde con un 500 generico,
+// senza esporre dettagli interni al client.
+func writeInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("errore interno su %s %s: %v", r.Method, r.URL.Path, err)
+	writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "")
+}
+
+// writeStoreError traduce un errore dello store nella risposta HTTP adatta,
+// in base alla sua categoria (store.ErrNotFound, store.ErrConflict...).
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	writeStoreProblem(w, r, storeProblem(r, err))
+}
+
+// writeStoreProblem invia un Problem ottenuto da storeProblem.
+func writeStoreProblem(w http.ResponseWriter, r *http.Request, p Problem) {
+	if p.Status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+	}
+	writeProblemBody(w, r, p)
+}
+
+// storeProblem sceglie il Problem per un errore dello store. Gli errori
+// interni e di disponibilità vengono loggati: il client non ne vede i dettagli.
+func storeProblem(r *http.Request, err error) Problem {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return Problem{Status: http.StatusNotFound, Code: CodeNotFound, Detail: "Elemento non presente nella lista"}
+	case errors.Is(err, store.ErrVersionConflict):
+		// va controllato prima di ErrConflict, di cui è un caso particolare
+		return conflictProblem(r)
+	case errors.Is(err, store.ErrConflict):
+		return Problem{Status: http.StatusConflict, Code: CodeConflict, Detail: err.Error()}
+	case errors.Is(err, store.ErrInvalid):
+		return Problem{Status: http.StatusBadRequest, Code: CodeValidationFailed, Detail: err.Error()}
+	case errors.Is(err, store.ErrSearchUnavailable):
+		// non è temporaneo: il server va ricompilato, riprovare non serve
+		log.Printf("ricerca non disponibile su %s %s: %v", r.Method, r.URL.Path, err)
+		return Problem{Status: http.StatusNotImplemented, Code: CodeSearchUnavailable, Detail: "Questo server è stato compilato senza la ricerca full-text"}
+	case errors.Is(err, store.ErrUnavailable):
+		// il dettaglio resta nei log: al client basta sapere di riprovare
+		log.Printf("store non disponibile su %s %s: %v", r.Method, r.URL.Path, err)
+		return Problem{Status: http.StatusServiceUnavailable, Code: CodeUnavailable, Detail: "Riprovare tra poco"}
+	default:
+		log.Printf("errore interno su %s %s: %v", r.Method, r.URL.Path, err)
+		return Problem{Status: http.StatusInternalServerError, Code: CodeInternal}
+	}
+}
+
+// NotFound e MethodNotAllowed sostituiscono le risposte di testo di chi,
+// così anche gli errori di routing sono problem+json.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusNotFound, CodeNotFound, "Nessuna risorsa a questo indirizzo")
+}
+
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Metodo "+r.Method+" non consentito su questa risorsa")
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"todolist-api-v2/internal/store"
+)
+
+// defaultSearchSize è il numero di risultati di GET /todos/search se il
+// client non specifica limit: chi cerca guarda i primi.
+const defaultSearchSize = 20
+
+// SearchHandler espone la ricerca full-text nei todo.
+type SearchHandler struct {
+	Store store.SearchRepository
+}
+
+func NewSearchHandler(s store.SearchRepository) *SearchHandler {
+	return &SearchHandler{Store: s}
+}
+
+// Search gestisce GET /todos/search?q=...&limit=20&offset=0: i todo in cui
+// titolo o descrizione corrispondono alla ricerca, dal più pertinente, con
+// le parole trovate evidenziate. La ricerca ammette prefissi (spes*),
+// frasi ("latte fresco") e gli operatori AND, OR e NOT con le parentesi.
+// Come GET /todos restituisce X-Total-Count e l'header Link.
+func (h *SearchHandler) Search(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var verr validationError
+	query := q.Get("q")
+	if query == "" {
+		verr.add("q", "è obbligatorio")
+	}
+	limit, ok := intParam(q, "limit", defaultSearchSize, &verr)
+	if ok && (limit < 1 || limit > maxPageSize) {
+		verr.add("limit", fmt.Sprintf("deve essere compreso tra 1 e %d", maxPageSize))
+	}
+	offset, _ := intParam(q, "offset", 0, &verr)
+	if err := verr.err(); err != nil {
+		writeValidationError(w, r, CodeInvalidQuery, err)
+		return
+	}
+
+	page, err := h.Store.Search(r.Context(), store.SearchOptions{Query: query, Limit: limit, Offset: offset})
+	if err != nil {
+		writeStoreError(w, r, err) // anche una ricerca scritta male (400)
+		return
+	}
+	setPaginationHeaders(w, r, store.ListOptions{Limit: limit, Offset: offset}, store.TodoPage{Total: page.Total, HasMore: page.HasMore})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page.Results)
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"todolist-api-v2/internal/store"
+)
+
+func TestSearchHandler(t *testing.T) {
+	s := store.NewMemoryStore()
+	ctx := store.WithOwner(context.Background(), store.DefaultUserID)
+	router := chi.NewRouter()
+	router.Use(asUser(store.DefaultUserID))
+	router.Get("/todos/search", NewSearchHandler(s).Search)
+
+	do := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/todos/search?"+query, nil))
+		return rr
+	}
+
+	for _, todo := range []store.Todo{
+		{Title: "Comprare il pane"},
+		{Title: "Latte", Description: "e anche il pane"},
+		{Title: "Pagare la bolletta"},
+	} {
+		_, err := s.Create(ctx, todo)
+		require.NoError(t, err)
+	}
+
+	rr := do("q=" + url.QueryEscape("pane") + "&limit=1")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, "2", rr.Header().Get("X-Total-Count"))
+	assert.Contains(t, rr.Header().Get("Link"), `rel="next"`)
+	var results []store.SearchResult
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &results))
+	require.Len(t, results, 1)
+	assert.Equal(t, 1, results[0].Todo.ID)
+	assert.Equal(t, "Comprare il <mark>pane</mark>", results[0].Highlights.Title)
+
+	rr = do("q=" + url.QueryEscape(`pan* NOT "comprare il"`))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &results))
+	require.Len(t, results, 1)
+	assert.Equal(t, "e anche il <mark>pane</mark>", results[0].Highlights.Description)
+
+	assert.Equal(t, "[]\n", do("q=gatto").Body.String())
+
+	for _, query := range []string{"", "q=pane&limit=0", "q=pane&offset=-1"} {
+		rr := do(query)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, query)
+		assert.Contains(t, rr.Body.String(), CodeInvalidQuery, query)
+	}
+	rr = do("q=" + url.QueryEscape(`"pane`))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "virgolette")
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"todolist-api-v2/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// TagHandler gestisce l'elenco dei tag e i tag dei singoli todo.
+type TagHandler struct {
+	Store store.TagRepository
+}
+
+func NewTagHandler(s store.TagRepository) *TagHandler {
+	return &TagHandler{Store: s}
+}
+
+// GetAll gestisce GET /tags: i tag usati, ciascuno con il numero di todo.
+func (h *TagHandler) GetAll(w http.ResponseWriter, r *http.Request) {
+	tags, err := h.Store.GetTags(r.Context())
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
+
+// Add gestisce PUT /todos/{todoID}/tags/{tag}. È idempotente: aggiungere
+// un tag già presente non cambia nulla.
+func (h *TagHandler) Add(w http.ResponseWriter, r *http.Request) {
+	h.change(w, r, h.Store.AddTag)
+}
+
+// Remove gestisce DELETE /todos/{todoID}/tags/{tag}; anche togliere un
+// tag assente va bene.
+func (h *TagHandler) Remove(w http.ResponseWriter, r *http.Request) {
+	h.change(w, r, h.Store.RemoveTag)
+}
+
+// change esegue op sul todo e sul tag indicati nell'URL e risponde con il
+// todo aggiornato, così il client ha subito la nuova versione e l'ETag.
+func (h *TagHandler) change(w http.ResponseWriter, r *http.Request, op func(ctx context.Context, todoID int, tag string) (store.Todo, error)) {
+	id, ok := todoIDParam(w, r)
+	if !ok {
+		return
+	}
+	// chi restituisce il parametro così come è nell'URL: "casa%20mia"
+	// diventa "casa mia"
+	tag, err := url.PathUnescape(chi.URLParam(r, "tag"))
+	if err != nil {
+		writeValidationError(w, r, CodeValidationFailed, validationError{{Field: "tag", Message: "codifica non valida"}})
+		return
+	}
+	if tag, err = store.NormalizeTag(tag); err != nil {
+		writeValidationError(w, r, CodeValidationFailed, validationError{{Field: "tag", Message: err.Error()}})
+		return
+	}
+
+	todo, err := op(r.Context(), id, tag)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", todoETag(todo))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(todo)
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"todolist-api-v2/internal/store"
+)
+
+func TestTagHandlers(t *testing.T) {
+	s := store.NewMemoryStore()
+	th := NewTodoHandler(s)
+	tags := NewTagHandler(s)
+	router := chi.NewRouter()
+	router.Use(asUser(store.DefaultUserID))
+	router.Get("/tags", tags.GetAll)
+	router.Route("/todos", func(r chi.Router) {
+		r.Get("/", th.GetAll)
+		r.Post("/", th.Create)
+		r.Put("/{todoID}", th.Update)
+		r.Put("/{todoID}/tags/{tag}", tags.Add)
+		r.Delete("/{todoID}/tags/{tag}", tags.Remove)
+	})
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	for _, title := range []string{"Pane", "Relazione", "Telefonare"} {
+		require.Equal(t, http.StatusCreated, do(http.MethodPost, "/todos", `{"title":"`+title+`"}`).Code)
+	}
+
+	rr := do(http.MethodPut, "/todos/1/tags/Casa-mia", "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.JSONEq(t, `["casa-mia"]`, mustField(t, rr.Body.Bytes(), "tags"))
+	assert.Equal(t, `"1-2"`, rr.Header().Get("ETag"))
+	do(http.MethodPut, "/todos/1/tags/urgente", "")
+	do(http.MethodPut, "/todos/2/tags/lavoro", "")
+	do(http.MethodPut, "/todos/3/tags/urgente", "")
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPut, "/todos/99/tags/casa", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/todos/1/tags/a,b", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/todos/1/tags/casa%20mia", "").Code)
+
+	rr = do(http.MethodGet, "/tags", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `[{"name":"casa-mia","todo_count":1},{"name":"lavoro","todo_count":1},{"name":"urgente","todo_count":2}]`, rr.Body.String())
+
+	t.Run("filtri", func(t *testing.T) {
+		assert.Equal(t, "3", do(http.MethodGet, "/todos?tag=urgente,lavoro", "").Header().Get("X-Total-Count"))
+		assert.Equal(t, "3", do(http.MethodGet, "/todos?tag=urgente&tag=lavoro&tag_mode=any", "").Header().Get("X-Total-Count"))
+		assert.Equal(t, "1", do(http.MethodGet, "/todos?tag=urgente&tag=casa-mia&tag_mode=all", "").Header().Get("X-Total-Count"))
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/todos?tag_mode=forse", "").Code)
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/todos?tag=", "").Code)
+	})
+
+	t.Run("PUT non tocca i tag", func(t *testing.T) {
+		rr := do(http.MethodPut, "/todos/3", `{"title":"Chiamare","status":"done","tags":["ignorato"]}`)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.JSONEq(t, `["urgente"]`, mustField(t, rr.Body.Bytes(), "tags"))
+	})
+
+	rr = do(http.MethodDelete, "/todos/1/tags/urgente", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `["casa-mia"]`, mustField(t, rr.Body.Bytes(), "tags"))
+	rr = do(http.MethodGet, "/tags", "")
+	assert.Contains(t, rr.Body.String(), `{"name":"urgente","todo_count":1}`)
+}
@@ -1,10 +1,14 @@
 package handler
 
 import (
+	"bytes"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"io"
+	"mime"
 	"net/http"
 	"strconv" // Pacchetto per la conversione di stringhe
+	"todolist-api-v2/internal/jsonpatch"
 	"todolist-api-v2/internal/store"
 
 	"github.com/go-chi/chi/v5"
@@ -16,35 +20,71 @@ import (
 //TodoHandler collega gli handler HTTP conlo store
 
 type TodoHandler struct {
-	Store *store.Store
+	Store store.TodoRepository
 }
 
-// crea un nuovo handler con una dipendenza dallo store
-func NewTodoHandler(s *store.Store) *TodoHandler {
+// crea un nuovo handler con una dipendenza dallo store: qualsiasi backend
+// che implementi store.TodoRepository va bene.
+func NewTodoHandler(s store.TodoRepository) *TodoHandler {
 	return &TodoHandler{
 		Store: s,
 	}
 }
 
-// GetAll è l'handler per GET /todos.
+// GetAll è l'handler per GET /todos. Il corpo resta un array JSON; il totale
+// e i link alle altre pagine viaggiano negli header X-Total-Count e Link.
 // Nota il ricevitore (h *TodoHandler). Questo lega la funzione alla struct.
 func (h *TodoHandler) GetAll(w http.ResponseWriter, r *http.Request) {
-	// 1. Chiama la logica di business (la cucina).
-	todos := h.Store.GetAll()
+	h.getAll(w, r, nil)
+}
 
-	// 2. Prepara e invia la risposta HTTP (il cameriere serve il piatto).
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK) // 200 OK
+// getAll fa il lavoro di GetAll; scope, se non è nil, restringe le opzioni
+// lette dalla query string (lo usano le rotte annidate come
+// GET /lists/{listID}/todos).
+func (h *TodoHandler) getAll(w http.ResponseWriter, r *http.Request, scope func(*store.ListOptions)) {
+	// 1. Leggiamo filtri, ordinamento e paginazione dalla query string.
+	opts, err := parseListOptions(r.URL.Query())
+	if err != nil {
+		writeValidationError(w, r, CodeInvalidQuery, err)
+		return
+	}
+	if scope != nil {
+		scope(&opts)
+	}
+
+	// 2. Chiama la logica di business (la cucina).
+	page, err := h.Store.GetAll(r.Context(), opts)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	todos := page.Todos
+	setPaginationHeaders(w, r, opts, page)
 
-	if err := json.NewEncoder(w).Encode(todos); err != nil {
+	// 3. Codifichiamo prima in un buffer: l'ETag della lista è l'hash del corpo.
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(todos); err != nil {
 		// Se c'è un errore qui, è un problema del server.
-		// Potremmo loggarlo, ma per ora rispondiamo con un errore generico.
 		// (In realtà è difficile che json.NewEncoder fallisca con una slice valida)
-		http.Error(w, "Errore durante la codifica della risposta", http.StatusInternalServerError)
+		writeInternalError(w, r, err)
+		return
 	}
+	etag := bodyETag(body.Bytes())
+	w.Header().Set("ETag", etag)
+	if ifNoneMatch(r, etag) {
+		w.WriteHeader(http.StatusNotModified) // il client ha già questa pagina
+		return
+	}
+
+	// 4. Prepara e invia la risposta HTTP (il cameriere serve il piatto).
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK) // 200 OK
+	w.Write(body.Bytes())
 }
 
-func (h *TodoHandler) GetByID(w http.ResponseWriter, r *http.Request) {
+// todoIDParam legge l'ID del todo dall'URL. Se non è valido risponde 400
+// e restituisce false: l'handler deve solo interrompersi.
+func todoIDParam(w http.ResponseWriter, r *http.Request) (int, bool) {
 	// === PASSO 1: Estrarre il parametro dall'URL ===
 	// chi.URLParam prende la richiesta (r) e il nome del parametro
 	// che abbiamo definito nella rotta ("todoID").
@@ -58,54 +98,82 @@ func (h *TodoHandler) GetByID(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		// Se la conversione fallisce, significa che il client ha inviato un ID non valido
 		// (es. /todos/abc). Questa è una "Bad Request".
-		http.Error(w, "ID non valido, deve essere un numero intero", http.StatusBadRequest) // 400
-		return                                                                              // Interrompiamo l'esecuzione dell'handler.
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidID, "ID non valido, deve essere un numero intero") // 400
+		return 0, false
 	}
-	getedTodo, statusSearch := h.Store.GetByID(id)
+	return id, true
+}
 
-	if statusSearch {
-		fmt.Println("trovato")
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(getedTodo)
-	} else {
-		fmt.Println("non trovato")
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		//json.NewEncoder(w).Encode("Elemento non presente nella lista")
-		http.Error(w, "Elemento non presente nella lista", http.StatusNotFound)
+func (h *TodoHandler) GetByID(w http.ResponseWriter, r *http.Request) {
+	id, ok := todoIDParam(w, r)
+	if !ok {
+		return // Interrompiamo l'esecuzione dell'handler.
+	}
+	getedTodo, err := h.Store.GetByID(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
 
+	w.Header().Set("ETag", todoETag(getedTodo))
+	if ifNoneMatch(r, todoETag(getedTodo)) {
+		w.WriteHeader(http.StatusNotModified)
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(getedTodo)
+}
 
+// Children gestisce GET /todos/{todoID}/children: le sottoattività dirette
+// del todo, con gli stessi filtri e la stessa paginazione di GET /todos.
+func (h *TodoHandler) Children(w http.ResponseWriter, r *http.Request) {
+	id, ok := todoIDParam(w, r)
+	if !ok {
+		return
+	}
+	// un todo sconosciuto è un 404, non un elenco vuoto
+	if _, err := h.Store.GetByID(r.Context(), id); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	h.getAll(w, r, func(opts *store.ListOptions) { opts.ParentID = id })
 }
 
 // gestisce le richieste POST /todos
 func (h *TodoHandler) Create(w http.ResponseWriter, r *http.Request) {
-	// 1. Definiamo una struct per decodificare il JSON in arrivo.
-	//    Ci aspettiamo solo il campo 'title' dal client.
-	var input struct {
-		Title string `json:"title"`
-	}
+	h.create(w, r, 0)
+}
 
-	// 2. Decodifichiamo il corpo della richiesta.
+// create fa il lavoro di Create; con listID != 0 il todo nasce in quella
+// lista (lo usa POST /lists/{listID}/todos).
+func (h *TodoHandler) create(w http.ResponseWriter, r *http.Request, listID int) {
+	// 1. Decodifichiamo il corpo della richiesta.
 	//    json.NewDecoder legge da r.Body (la richiesta) e Decode popola
 	//    la nostra struct 'input'.
+	var input createInput
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		// Se il JSON è malformato o mancante, è un errore del client.
-		http.Error(w, "Corpo della richiesta JSON non valido", http.StatusBadRequest) // 400 Bad Request
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidBody, "Corpo della richiesta JSON non valido") // 400 Bad Request
 		return
 	}
 
-	// 3. Facciamo una validazione di base.
-	if input.Title == "" {
-		http.Error(w, "Il campo 'title' non può essere vuoto", http.StatusBadRequest)
+	// 2. Facciamo una validazione di base.
+	todo, err := input.newTodo(listID)
+	if err != nil {
+		writeValidationError(w, r, CodeValidationFailed, err)
 		return
 	}
 
-	// 4. Chiamiamo lo store per creare effettivamente il todo.
-	createdTodo := h.Store.Create(input.Title)
+	// 3. Chiamiamo lo store per creare effettivamente il todo.
+	createdTodo, err := h.Store.Create(r.Context(), todo)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
 
-	// 5. Rispondiamo al client.
+	// 4. Rispondiamo al client.
+	w.Header().Set("ETag", todoETag(createdTodo))
 	w.Header().Set("Content-Type", "application/json")
 	// Impostiamo lo status code a 201 Created, che è lo standard per POST andati a buon fine.
 	w.WriteHeader(http.StatusCreated)
@@ -114,61 +182,159 @@ func (h *TodoHandler) Create(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *TodoHandler) Update(w http.ResponseWriter, r *http.Request) {
-	idStr := chi.URLParam(r, "todoID")
-	id, err := strconv.Atoi(idStr)
+	id, ok := todoIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	// PUT è una sostituzione completa: tutti i campi modificabili sono
+	// obbligatori e i campi sconosciuti vengono rifiutati.
+	var input todoInput
+	if err := decodeStrict(r.Body, &input); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidBody, "Corpo della richiesta JSON non valido: "+err.Error()) // 400 Bad Request
+		return
+	}
+
+	todo, err := input.toTodo(id)
 	if err != nil {
-		// Se la conversione fallisce, significa che il client ha inviato un ID non valido
-		// (es. /todos/abc). Questa è una "Bad Request".
-		http.Error(w, "ID non valido, deve essere un numero intero", http.StatusBadRequest) // 400
-		return                                                                              // Interrompiamo l'esecuzione dell'handler.
+		writeValidationError(w, r, CodeValidationFailed, err)
+		return
 	}
 
-	var input struct {
-		Title     string `json:"title"`
-		Completed string `json:"completed"`
+	// Con If-Match verifichiamo la precondizione sulla versione attuale e
+	// chiediamo allo store di aggiornare solo se è ancora quella.
+	if r.Header.Get("If-Match") != "" {
+		current, err := h.Store.GetByID(r.Context(), id)
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		if !checkIfMatch(w, r, current) {
+			return
+		}
+		if todo.Version == 0 {
+			todo.Version = current.Version
+		}
 	}
 
-	// 2. Decodifichiamo il corpo della richiesta.
-	//    json.NewDecoder legge da r.Body (la richiesta) e Decode popola
-	//    la nostra struct 'input'.
-	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		// Se il JSON è malformato o mancante, è un errore del client.
-		http.Error(w, "Corpo della richiesta JSON non valido", http.StatusBadRequest) // 400 Bad Request
+	updatedTodo, err := h.Store.Update(r.Context(), todo)
+	if err != nil {
+		writeStoreError(w, r, err)
 		return
 	}
 
-	updatedTodo, statusSearch := h.Store.Update(id, input.Title, input.Completed)
-	if statusSearch {
-		fmt.Println("trovato")
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(updatedTodo)
-	} else {
-		fmt.Println("non trovato")
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		http.Error(w, "Elemento non presente nella lista", http.StatusNotFound)
+	w.Header().Set("ETag", todoETag(updatedTodo))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(updatedTodo)
+}
 
+// Patch gestisce PATCH /todos/{id}: aggiornamento parziale con JSON Merge
+// Patch (RFC 7396, anche come application/json) o JSON Patch (RFC 6902).
+// La patch viene applicata alla rappresentazione JSON corrente del todo e il
+// risultato viene validato esattamente come un PUT.
+func (h *TodoHandler) Patch(w http.ResponseWriter, r *http.Request) {
+	id, ok := todoIDParam(w, r)
+	if !ok {
+		return
 	}
 
+	// 1. Scegliamo il formato della patch in base al Content-Type.
+	var applyPatch func(doc, patch []byte) ([]byte, error)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case jsonpatch.MergePatchType, "application/json":
+		applyPatch = jsonpatch.MergePatch
+	case jsonpatch.JSONPatchType:
+		applyPatch = jsonpatch.Apply
+	default:
+		w.Header().Set("Accept-Patch", acceptPatch)
+		writeProblem(w, r, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Usare uno tra "+acceptPatch)
+		return
+	}
+
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidBody, "Impossibile leggere il corpo della richiesta")
+		return
+	}
+
+	// 2. Leggiamo lo stato attuale e applichiamo la patch alla sua forma JSON.
+	current, err := h.Store.GetByID(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if !checkIfMatch(w, r, current) {
+		return
+	}
+	doc, err := json.Marshal(current)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	patched, err := applyPatch(doc, patch)
+	if err != nil {
+		if errors.Is(err, jsonpatch.ErrTestFailed) {
+			// un'operazione "test" non soddisfatta è un conflitto con lo stato attuale
+			writeProblem(w, r, http.StatusConflict, CodePatchTestFailed, err.Error())
+			return
+		}
+		writeProblem(w, r, http.StatusBadRequest, CodePatchFailed, err.Error())
+		return
+	}
+
+	// 3. Il documento risultante deve essere un todo valido.
+	var input todoInput
+	if err := decodeStrict(bytes.NewReader(patched), &input); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeValidationFailed, "Il risultato della patch non è un todo valido: "+err.Error())
+		return
+	}
+	todo, err := input.patchedTodo(current)
+	if err != nil {
+		writeValidationError(w, r, CodeValidationFailed, err)
+		return
+	}
+
+	// 4. Salviamo solo se nessuno ha modificato il todo dopo la nostra lettura.
+	updatedTodo, err := h.Store.Update(r.Context(), todo)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", todoETag(updatedTodo))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(updatedTodo)
 }
 
+// Delete gestisce DELETE /todos/{todoID}: il todo non viene cancellato
+// subito ma spostato nel cestino (vedi TrashHandler).
 func (h *TodoHandler) Delete(w http.ResponseWriter, r *http.Request) {
-	idStr := chi.URLParam(r, "todoID")
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
-		http.Error(w, "ID non valido, deve essere un intero", http.StatusBadRequest)
+	id, ok := todoIDParam(w, r)
+	if !ok {
 		return
 	}
 
-	resultDelete := h.Store.Delete(id)
+	version := 0
+	if r.Header.Get("If-Match") != "" {
+		current, err := h.Store.GetByID(r.Context(), id)
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		if !checkIfMatch(w, r, current) {
+			return
+		}
+		version = current.Version
+	}
 
-	if resultDelete {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNoContent)
-		json.NewEncoder(w).Encode("Eliminato")
-	} else {
-		http.Error(w, "Todo non trovato", http.StatusNotFound)
+	if err := h.Store.Delete(r.Context(), id, version); err != nil {
+		writeStoreError(w, r, err)
 		return
 	}
+
+	// 204 No Content: la risposta non deve avere un corpo.
+	w.WriteHeader(http.StatusNoContent)
 }
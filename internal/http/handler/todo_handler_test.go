@@ -5,14 +5,17 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"strconv"
+	"strings"
 	"testing"
 	"todolist-api-v2/internal/store"
 )
@@ -21,11 +24,8 @@ import (
 // Crea uno store, un handler e un router chi, proprio come in main.go.
 // Restituisce il router (che possiamo usare per inviare richieste) e una funzione di teardown.
 func setupTestAPI(t *testing.T) (http.Handler, func()) {
-	testFile := "handler_test_todos.json"
-
-	// 1. Crea lo store
-	s, err := store.New(testFile)
-	require.NoError(t, err)
+	// 1. Crea lo store: quello in memoria, così i test non toccano il disco.
+	s := store.NewMemoryStore()
 
 	// 2. Crea l'handler
 	h := NewTodoHandler(s)
@@ -33,24 +33,36 @@ func setupTestAPI(t *testing.T) (http.Handler, func()) {
 	// 3. Crea il router e registra le rotte
 	// Questo è il passo FONDAMENTALE per testare handler che usano parametri URL.
 	r := chi.NewRouter()
+	r.NotFound(NotFound)
+	r.MethodNotAllowed(MethodNotAllowed)
+	r.Use(asUser(store.DefaultUserID))
 	r.Route("/todos", func(r chi.Router) {
 		r.Get("/", http.HandlerFunc(h.GetAll))
 		r.Post("/", http.HandlerFunc(h.Create))
 		r.Route("/{todoID}", func(r chi.Router) {
 			r.Get("/", http.HandlerFunc(h.GetByID))
 			r.Put("/", http.HandlerFunc(h.Update))
+			r.Patch("/", http.HandlerFunc(h.Patch))
 			r.Delete("/", http.HandlerFunc(h.Delete))
 		})
 	})
 
-	// 4. Definisci la funzione di pulizia
-	teardown := func() {
-		os.Remove(testFile)
-	}
+	// 4. Definisci la funzione di pulizia (con lo store in memoria non c'è nulla da fare)
+	teardown := func() {}
 
 	return r, teardown
 }
 
+// asUser esegue ogni richiesta per conto di userID, al posto del
+// middleware di autenticazione (che ha i suoi test in auth_handler_test.go).
+func asUser(userID int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(store.WithOwner(r.Context(), userID)))
+		})
+	}
+}
+
 // TestTodoHandlers copre l'intero ciclo di vita di un todo attraverso l'API.
 func TestTodoHandlers(t *testing.T) {
 	// Setup
@@ -77,7 +89,8 @@ func TestTodoHandlers(t *testing.T) {
 		err := json.Unmarshal(rr.Body.Bytes(), &respBody)
 		require.NoError(t, err)
 		assert.Equal(t, "Testare gli handler", respBody.Title)
-		assert.Equal(t, "not completed", respBody.Completed)
+		assert.Equal(t, store.StatusPending, respBody.Status)
+		assert.False(t, respBody.Completed)
 		assert.NotZero(t, respBody.ID) // L'ID dovrebbe essere stato assegnato
 
 		createdTodoID = respBody.ID // Salviamo l'ID per i test successivi
@@ -119,7 +132,7 @@ func TestTodoHandlers(t *testing.T) {
 
 	// === Test 4: Aggiornare il Todo (PUT /todos/{id}) ===
 	t.Run("PUT /todos/{id} - Success", func(t *testing.T) {
-		payload := `{"title":"Titolo aggiornato dagli handler","completed":"completed"}`
+		payload := `{"title":"Titolo aggiornato dagli handler","status":"done"}`
 		req := httptest.NewRequest(http.MethodPut, "/todos/"+strconv.Itoa(createdTodoID), bytes.NewBufferString(payload))
 		req.Header.Set("Content-Type", "application/json")
 		rr := httptest.NewRecorder()
@@ -132,7 +145,26 @@ func TestTodoHandlers(t *testing.T) {
 		err := json.Unmarshal(rr.Body.Bytes(), &respBody)
 		require.NoError(t, err)
 		assert.Equal(t, "Titolo aggiornato dagli handler", respBody.Title)
-		assert.Equal(t, "completed", respBody.Completed)
+		assert.Equal(t, store.StatusDone, respBody.Status)
+		assert.True(t, respBody.Completed)
+		assert.NotNil(t, respBody.CompletedAt)
+	})
+
+	// === Test 4b: Stati non validi vengono rifiutati (PUT /todos/{id}) ===
+	t.Run("PUT /todos/{id} - Invalid status", func(t *testing.T) {
+		for _, payload := range []string{
+			`{"title":"x","status":"banana"}`,
+			`{"title":"x","completed":"yes"}`,
+			`{"title":"x"}`,
+			`{"title":"x","status":"pending","completed":true}`,
+		} {
+			req := httptest.NewRequest(http.MethodPut, "/todos/"+strconv.Itoa(createdTodoID), bytes.NewBufferString(payload))
+			rr := httptest.NewRecorder()
+
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code, payload)
+		}
 	})
 
 	// === Test 5: Tentare di ottenere un todo inesistente (GET /todos/{id}) ===
@@ -182,5 +214,529 @@ func TestCreateTodoHandler_Failures(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, rr.Code)
 	})
 
-	// Aggiungi altri test per titoli vuoti, etc.
+	post := func(payload string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/todos", bytes.NewBufferString(payload)))
+		return rr
+	}
+
+	t.Run("POST /todos - campi che non si possono scegliere", func(t *testing.T) {
+		rr := post(`{"title":"Copia","id":7,"version":3,"tags":["casa"]}`)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		var problem struct {
+			Errors []FieldError `json:"errors"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+		var fields []string
+		for _, fe := range problem.Errors {
+			fields = append(fields, fe.Field)
+		}
+		assert.ElementsMatch(t, []string{"id", "version", "tags"}, fields)
+
+		assert.Equal(t, http.StatusCreated, post(`{"title":"Senza tag","tags":[]}`).Code, "una lista vuota non perde nulla")
+	})
+
+	t.Run("POST /todos - stato", func(t *testing.T) {
+		rr := post(`{"title":"Già fatto","completed":true}`)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		var todo store.Todo
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &todo))
+		assert.Equal(t, store.StatusDone, todo.Status)
+		assert.NotNil(t, todo.CompletedAt)
+
+		rr = post(`{"title":"In corso","status":"in_progress"}`)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &todo))
+		assert.Equal(t, store.StatusInProgress, todo.Status)
+
+		assert.Equal(t, http.StatusBadRequest, post(`{"title":"Boh","status":"boh"}`).Code)
+		assert.Equal(t, http.StatusBadRequest, post(`{"title":"Boh","status":"pending","completed":true}`).Code)
+		assert.Equal(t, http.StatusBadRequest,
+			post(`{"title":"Ogni lunedì","status":"done","due_at":"2025-06-02T09:00:00Z","recurrence":"FREQ=WEEKLY"}`).Code)
+	})
+}
+
+// TestGetAllPagination verifica filtri, header Link e X-Total-Count di GET /todos.
+func TestGetAllPagination(t *testing.T) {
+	router, teardown := setupTestAPI(t)
+	defer teardown()
+
+	for i := 1; i <= 5; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/todos", bytes.NewBufferString(`{"title":"todo `+strconv.Itoa(i)+`"}`))
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	get := func(url string) (*httptest.ResponseRecorder, []store.Todo) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, url, nil))
+		var body []store.Todo
+		if rr.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+		}
+		return rr, body
+	}
+
+	t.Run("offset", func(t *testing.T) {
+		rr, body := get("/todos?limit=2&offset=2")
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Len(t, body, 2)
+		assert.Equal(t, 3, body[0].ID)
+		assert.Equal(t, "5", rr.Header().Get("X-Total-Count"))
+		link := rr.Header().Get("Link")
+		assert.Contains(t, link, `</todos?limit=2&offset=4>; rel="next"`)
+		assert.Contains(t, link, `</todos?limit=2&offset=0>; rel="prev"`)
+		assert.Contains(t, link, `</todos?limit=2&offset=4>; rel="last"`)
+	})
+
+	t.Run("cursor", func(t *testing.T) {
+		rr, body := get("/todos?limit=2&cursor=0&order=desc")
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Len(t, body, 2)
+		assert.Equal(t, 5, body[0].ID)
+		assert.Contains(t, rr.Header().Get("Link"), `</todos?cursor=4&limit=2&order=desc>; rel="next"`)
+
+		_, body = get("/todos?limit=2&cursor=4&order=desc")
+		require.Len(t, body, 2)
+		assert.Equal(t, 3, body[0].ID)
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		rr, body := get("/todos?q=todo%203&status=pending")
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Len(t, body, 1)
+		assert.Equal(t, "1", rr.Header().Get("X-Total-Count"))
+	})
+
+	t.Run("invalid params", func(t *testing.T) {
+		for _, url := range []string{
+			"/todos?status=banana",
+			"/todos?sort=banana",
+			"/todos?order=up",
+			"/todos?limit=0",
+			"/todos?limit=100000",
+			"/todos?offset=-1",
+			"/todos?cursor=2&offset=2",
+			"/todos?cursor=2&sort=title",
+		} {
+			rr, _ := get(url)
+			assert.Equal(t, http.StatusBadRequest, rr.Code, url)
+		}
+	})
+}
+
+// TestScheduling verifica descrizione, priorità e scadenza: validazione,
+// filtri sulle scadenze e ordinamento per priorità.
+func TestScheduling(t *testing.T) {
+	router, teardown := setupTestAPI(t)
+	defer teardown()
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do(http.MethodPost, "/todos", `{"title":"Bollette","description":"luce e gas","priority":"high","due_at":"2000-01-01T10:00:00+02:00"}`)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var todo store.Todo
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &todo))
+	assert.Equal(t, "luce e gas", todo.Description)
+	assert.Equal(t, store.PriorityHigh, todo.Priority)
+	assert.Contains(t, rr.Body.String(), `"due_at":"2000-01-01T10:00:00+02:00"`, "le scadenze tengono l'offset del client")
+
+	rr = do(http.MethodPost, "/todos", `{"title":"Ferie","priority":"urgent","due_at":"2999-08-01T00:00:00Z"}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	rr = do(http.MethodPost, "/todos", `{"title":"Libro"}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"priority":"normal"`)
+	assert.NotContains(t, rr.Body.String(), "due_at")
+
+	t.Run("validazione", func(t *testing.T) {
+		long := strings.Repeat("a", store.MaxDescriptionLength+1)
+		rr := do(http.MethodPost, "/todos", `{"title":"x","priority":"altissima","due_at":"2024-05-01T10:00:00","description":"`+long+`"}`)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		for _, field := range []string{`"field":"priority"`, `"field":"due_at"`, `"field":"description"`} {
+			assert.Contains(t, rr.Body.String(), field)
+		}
+		rr = do(http.MethodPut, "/todos/1", `{"title":"Bollette","status":"pending","due_at":"domani"}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("filtri", func(t *testing.T) {
+		assert.Equal(t, "1", do(http.MethodGet, "/todos?overdue=true", "").Header().Get("X-Total-Count"))
+		assert.Equal(t, "2", do(http.MethodGet, "/todos?overdue=false", "").Header().Get("X-Total-Count"))
+		assert.Equal(t, "1", do(http.MethodGet, "/todos?due_before=2000-01-02T00:00:00%2B01:00", "").Header().Get("X-Total-Count"))
+		assert.Equal(t, "1", do(http.MethodGet, "/todos?due_after=2100-01-01T00:00:00Z", "").Header().Get("X-Total-Count"))
+		for _, url := range []string{
+			"/todos?overdue=forse",
+			"/todos?due_before=ieri",
+			"/todos?due_after=2100-01-01T00:00:00Z&due_before=2000-01-01T00:00:00Z",
+		} {
+			assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, url, "").Code, url)
+		}
+	})
+
+	t.Run("ordinamento per priorità", func(t *testing.T) {
+		rr := do(http.MethodGet, "/todos?sort=priority", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		var todos []store.Todo
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &todos))
+		require.Len(t, todos, 3)
+		assert.Equal(t, []string{"Ferie", "Bollette", "Libro"}, []string{todos[0].Title, todos[1].Title, todos[2].Title})
+	})
+}
+
+func TestRecurrence(t *testing.T) {
+	router, teardown := setupTestAPI(t)
+	defer teardown()
+
+	do := func(method, url, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do(http.MethodPost, "/todos", "application/json", `{"title":"Palestra","due_at":"2025-01-06T18:00:00Z","recurrence":"FREQ=WEEKLY;BYDAY=MO,TH"}`)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), `"recurrence":"FREQ=WEEKLY;BYDAY=MO,TH"`)
+
+	// con una merge patch il resto del todo, ricorrenza compresa, resta com'è
+	rr = do(http.MethodPatch, "/todos/1", "application/merge-patch+json", `{"status":"done"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), `"next_occurrence_id":2`)
+
+	rr = do(http.MethodGet, "/todos/2", "", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"due_at":"2025-01-09T18:00:00Z"`)
+	assert.Contains(t, rr.Body.String(), `"status":"pending"`)
+
+	t.Run("validazione", func(t *testing.T) {
+		rr := do(http.MethodPost, "/todos", "application/json", `{"title":"x","due_at":"2025-01-06T18:00:00Z","recurrence":"FREQ=YEARLY"}`)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"field":"recurrence"`)
+
+		rr = do(http.MethodPut, "/todos/2", "application/json", `{"title":"Palestra","status":"pending","recurrence":"FREQ=DAILY"}`)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"field":"recurrence"`, "serve anche due_at")
+	})
+}
+
+// TestPatchAndPut verifica le patch parziali e la sostituzione completa con PUT.
+func TestPatchAndPut(t *testing.T) {
+	router, teardown := setupTestAPI(t)
+	defer teardown()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/todos", bytes.NewBufferString(`{"title":"Comprare il latte"}`)))
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	send := func(method, contentType, payload string) (*httptest.ResponseRecorder, store.Todo) {
+		req := httptest.NewRequest(method, "/todos/1", bytes.NewBufferString(payload))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var todo store.Todo
+		if rr.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &todo))
+		}
+		return rr, todo
+	}
+
+	t.Run("merge patch mantiene i campi non indicati", func(t *testing.T) {
+		rr, todo := send(http.MethodPatch, "application/merge-patch+json", `{"completed":true}`)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Equal(t, "Comprare il latte", todo.Title)
+		assert.Equal(t, store.StatusDone, todo.Status)
+		assert.NotNil(t, todo.CompletedAt)
+	})
+
+	t.Run("application/json è trattato come merge patch", func(t *testing.T) {
+		rr, todo := send(http.MethodPatch, "application/json", `{"status":"in_progress"}`)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Equal(t, store.StatusInProgress, todo.Status)
+		assert.False(t, todo.Completed)
+	})
+
+	t.Run("json patch", func(t *testing.T) {
+		rr, todo := send(http.MethodPatch, "application/json-patch+json",
+			`[{"op":"test","path":"/title","value":"Comprare il latte"},{"op":"replace","path":"/title","value":"Comprare il pane"}]`)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Equal(t, "Comprare il pane", todo.Title)
+		assert.Equal(t, store.StatusInProgress, todo.Status)
+	})
+
+	t.Run("json patch con test fallito", func(t *testing.T) {
+		rr, _ := send(http.MethodPatch, "application/json-patch+json", `[{"op":"test","path":"/title","value":"altro"}]`)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+	})
+
+	t.Run("patch non valide", func(t *testing.T) {
+		for _, c := range []struct{ contentType, payload string }{
+			{"application/merge-patch+json", `{"title":null}`},
+			{"application/merge-patch+json", `{"status":"banana"}`},
+			{"application/merge-patch+json", `{"id":99}`},
+			{"application/merge-patch+json", `{"colore":"rosso"}`},
+			{"application/json-patch+json", `[{"op":"remove","path":"/status"}]`},
+			{"application/json-patch+json", `{"op":"add"}`},
+		} {
+			rr, _ := send(http.MethodPatch, c.contentType, c.payload)
+			assert.Equal(t, http.StatusBadRequest, rr.Code, c.payload)
+		}
+
+		rr, _ := send(http.MethodPatch, "text/plain", `title=x`)
+		assert.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+		assert.Contains(t, rr.Header().Get("Accept-Patch"), "application/json-patch+json")
+	})
+
+	t.Run("PUT richiede tutti i campi", func(t *testing.T) {
+		rr, _ := send(http.MethodPut, "application/json", `{"completed":true}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "senza title il PUT non deve cancellare il titolo")
+
+		rr, _ = send(http.MethodPut, "application/json", `{"title":"x","status":"done","priorita":1}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "i campi sconosciuti vanno rifiutati")
+
+		// rimandare indietro quanto ricevuto da GET deve funzionare
+		get := httptest.NewRecorder()
+		router.ServeHTTP(get, httptest.NewRequest(http.MethodGet, "/todos/1", nil))
+		rr, todo := send(http.MethodPut, "application/json", get.Body.String())
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Equal(t, "Comprare il pane", todo.Title)
+	})
+}
+
+// TestConditionalRequests verifica ETag, If-None-Match e If-Match.
+func TestConditionalRequests(t *testing.T) {
+	router, teardown := setupTestAPI(t)
+	defer teardown()
+
+	do := func(method, url, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	created := do(http.MethodPost, "/todos", `{"title":"Condiviso"}`, nil)
+	require.Equal(t, http.StatusCreated, created.Code)
+	etag := created.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	t.Run("If-None-Match sulle letture", func(t *testing.T) {
+		rr := do(http.MethodGet, "/todos/1", "", map[string]string{"If-None-Match": etag})
+		assert.Equal(t, http.StatusNotModified, rr.Code)
+		assert.Empty(t, rr.Body.String())
+
+		list := do(http.MethodGet, "/todos", "", nil)
+		require.Equal(t, http.StatusOK, list.Code)
+		listETag := list.Header().Get("ETag")
+		rr = do(http.MethodGet, "/todos", "", map[string]string{"If-None-Match": listETag})
+		assert.Equal(t, http.StatusNotModified, rr.Code)
+	})
+
+	t.Run("If-Match con la versione giusta", func(t *testing.T) {
+		rr := do(http.MethodPatch, "/todos/1", `{"title":"Modificato da Anna"}`, map[string]string{"If-Match": etag})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.NotEqual(t, etag, rr.Header().Get("ETag"))
+	})
+
+	t.Run("If-Match con una versione vecchia", func(t *testing.T) {
+		rr := do(http.MethodPut, "/todos/1", `{"title":"Modificato da Bruno","status":"done"}`, map[string]string{"If-Match": etag})
+		assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+
+		rr = do(http.MethodPatch, "/todos/1", `{"completed":true}`, map[string]string{"If-Match": etag})
+		assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+
+		rr = do(http.MethodDelete, "/todos/1", "", map[string]string{"If-Match": etag})
+		assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+
+		// il todo è cambiato: il vecchio ETag non corrisponde più
+		rr = do(http.MethodGet, "/todos/1", "", map[string]string{"If-None-Match": etag})
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("versione nel corpo del PUT", func(t *testing.T) {
+		rr := do(http.MethodPut, "/todos/1", `{"title":"x","status":"done","version":1}`, nil)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+	})
+
+	t.Run("If-Match: * e DELETE", func(t *testing.T) {
+		rr := do(http.MethodDelete, "/todos/1", "", map[string]string{"If-Match": "*"})
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+
+		rr = do(http.MethodDelete, "/todos/1", "", map[string]string{"If-Match": "*"})
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
+
+// TestProblemResponses verifica che gli errori siano problem+json con un
+// codice stabile e, per la validazione, l'elenco dei campi sbagliati.
+func TestProblemResponses(t *testing.T) {
+	router, teardown := setupTestAPI(t)
+	defer teardown()
+
+	do := func(method, url, body, contentType string) (*httptest.ResponseRecorder, Problem) {
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var p Problem
+		assert.Equal(t, ProblemContentType, rr.Header().Get("Content-Type"))
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p), rr.Body.String())
+		assert.Equal(t, rr.Code, p.Status)
+		assert.NotEmpty(t, p.Title)
+		return rr, p
+	}
+	fields := func(p Problem) []string {
+		var names []string
+		for _, fe := range p.Errors {
+			names = append(names, fe.Field)
+		}
+		return names
+	}
+
+	created := httptest.NewRecorder()
+	router.ServeHTTP(created, httptest.NewRequest(http.MethodPost, "/todos", bytes.NewBufferString(`{"title":"Esistente"}`)))
+	require.Equal(t, http.StatusCreated, created.Code)
+
+	tests := []struct {
+		name              string
+		method, url, body string
+		contentType       string
+		wantStatus        int
+		wantCode          string
+		wantFields        []string
+	}{
+		{"id non numerico", http.MethodGet, "/todos/abc", "", "application/json", http.StatusBadRequest, CodeInvalidID, nil},
+		{"todo inesistente", http.MethodGet, "/todos/99", "", "application/json", http.StatusNotFound, CodeNotFound, nil},
+		{"rotta inesistente", http.MethodGet, "/nulla", "", "application/json", http.StatusNotFound, CodeNotFound, nil},
+		{"metodo non consentito", http.MethodPost, "/todos/1", "", "application/json", http.StatusMethodNotAllowed, CodeMethodNotAllowed, nil},
+		{"JSON malformato", http.MethodPost, "/todos", `{"title":`, "application/json", http.StatusBadRequest, CodeInvalidBody, nil},
+		{"titolo vuoto", http.MethodPost, "/todos", `{"title":""}`, "application/json", http.StatusBadRequest, CodeValidationFailed, []string{"title"}},
+		{"PUT vuoto", http.MethodPut, "/todos/1", `{}`, "application/json", http.StatusBadRequest, CodeValidationFailed, []string{"title", "status"}},
+		{"PUT con più errori", http.MethodPut, "/todos/1", `{"id":2,"title":"x","status":"boh","version":0}`, "application/json", http.StatusBadRequest, CodeValidationFailed, []string{"id", "status", "version"}},
+		{"query non valida", http.MethodGet, "/todos?limit=0&order=su&status=boh", "", "", http.StatusBadRequest, CodeInvalidQuery, []string{"status", "order", "limit"}},
+		{"PATCH con media type sbagliato", http.MethodPatch, "/todos/1", `{}`, "text/plain", http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, nil},
+		{"JSON Patch su percorso inesistente", http.MethodPatch, "/todos/1", `[{"op":"remove","path":"/nulla"}]`, "application/json-patch+json", http.StatusBadRequest, CodePatchFailed, nil},
+		{"JSON Patch con test fallito", http.MethodPatch, "/todos/1", `[{"op":"test","path":"/title","value":"Altro"}]`, "application/json-patch+json", http.StatusConflict, CodePatchTestFailed, nil},
+		{"versione vecchia", http.MethodPut, "/todos/1", `{"title":"x","status":"done","version":7}`, "application/json", http.StatusConflict, CodeVersionConflict, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr, p := do(tc.method, tc.url, tc.body, tc.contentType)
+			assert.Equal(t, tc.wantStatus, rr.Code, rr.Body.String())
+			assert.Equal(t, tc.wantCode, p.Code)
+			assert.Equal(t, tc.wantFields, fields(p))
+			assert.Equal(t, "/problems/"+strings.ReplaceAll(tc.wantCode, "_", "-"), p.Type)
+		})
+	}
+}
+
+// failingStore restituisce sempre lo stesso errore: serve a verificare come
+// gli handler traducono le categorie di errore dello store.
+type failingStore struct {
+	store.TodoRepository
+	err error
+}
+
+func (f failingStore) GetByID(ctx context.Context, ID int) (store.Todo, error) {
+	return store.Todo{}, f.err
+}
+
+// TestStoreErrorMapping verifica lo status HTTP per ogni categoria di errore.
+func TestStoreErrorMapping(t *testing.T) {
+	tests := []struct {
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{fmt.Errorf("todo 1: %w", store.ErrNotFound), http.StatusNotFound, CodeNotFound},
+		{store.ErrVersionConflict, http.StatusConflict, CodeVersionConflict},
+		{fmt.Errorf("%w: duplicato", store.ErrConflict), http.StatusConflict, CodeConflict},
+		{fmt.Errorf("%w: titolo vuoto", store.ErrInvalid), http.StatusBadRequest, CodeValidationFailed},
+		{fmt.Errorf("%w: database is locked", store.ErrUnavailable), http.StatusServiceUnavailable, CodeUnavailable},
+		{store.ErrSearchUnavailable, http.StatusNotImplemented, CodeSearchUnavailable},
+		{errors.New("disco rotto"), http.StatusInternalServerError, CodeInternal},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.err.Error(), func(t *testing.T) {
+			h := NewTodoHandler(failingStore{err: tc.err})
+			r := chi.NewRouter()
+			r.Use(asUser(store.DefaultUserID))
+			r.Get("/todos/{todoID}", h.GetByID)
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/todos/1", nil))
+
+			assert.Equal(t, tc.wantStatus, rr.Code)
+			var p Problem
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p))
+			assert.Equal(t, tc.wantCode, p.Code)
+			if tc.wantStatus == http.StatusServiceUnavailable {
+				assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+			}
+			if tc.wantStatus == http.StatusInternalServerError {
+				assert.NotContains(t, rr.Body.String(), "disco rotto", "i dettagli interni non vanno esposti")
+			}
+		})
+	}
+}
+
+// TestTodosArePerUser verifica che attraverso l'API un utente non possa
+// vedere né toccare i todo di un altro: per lui non esistono (404).
+func TestTodosArePerUser(t *testing.T) {
+	s := store.NewMemoryStore()
+	ctx := context.Background()
+	alice, err := s.CreateUser(ctx, "alice", "")
+	require.NoError(t, err)
+	bob, err := s.CreateUser(ctx, "bob", "")
+	require.NoError(t, err)
+
+	h := NewTodoHandler(s)
+	routerFor := func(userID int) http.Handler {
+		r := chi.NewRouter()
+		r.Use(asUser(userID))
+		r.Get("/todos", h.GetAll)
+		r.Post("/todos", h.Create)
+		r.Get("/todos/{todoID}", h.GetByID)
+		r.Put("/todos/{todoID}", h.Update)
+		r.Delete("/todos/{todoID}", h.Delete)
+		return r
+	}
+	asAlice, asBob := routerFor(alice.ID), routerFor(bob.ID)
+	do := func(router http.Handler, method, url, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(method, url, bytes.NewBufferString(body)))
+		return rr
+	}
+
+	rr := do(asAlice, http.MethodPost, "/todos", `{"title":"Regalo per Bob"}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var created store.Todo
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	url := "/todos/" + strconv.Itoa(created.ID)
+	assert.NotContains(t, rr.Body.String(), "owner", "il proprietario non viene esposto")
+
+	assert.Equal(t, http.StatusNotFound, do(asBob, http.MethodGet, url, "").Code)
+	assert.Equal(t, http.StatusNotFound, do(asBob, http.MethodPut, url, `{"title":"Visto!","status":"done"}`).Code)
+	assert.Equal(t, http.StatusNotFound, do(asBob, http.MethodDelete, url, "").Code)
+
+	rr = do(asBob, http.MethodGet, "/todos", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `[]`, rr.Body.String())
+	assert.Equal(t, "0", rr.Header().Get("X-Total-Count"))
+
+	assert.Equal(t, http.StatusOK, do(asAlice, http.MethodGet, url, "").Code)
 }
@@ -0,0 +1,277 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"todolist-api-v2/internal/jsonpatch"
+	"todolist-api-v2/internal/rrule"
+	"todolist-api-v2/internal/store"
+	"unicode/utf8"
+)
+
+// formati accettati da PATCH, annunciati nell'header Accept-Patch.
+const acceptPatch = jsonpatch.MergePatchType + ", " + jsonpatch.JSONPatchType
+
+// todoInput è la rappresentazione di un todo inviata dal client con PUT o
+// ottenuta applicando una PATCH. I puntatori distinguono un campo assente
+// da un campo vuoto. I campi di sola lettura sono accettati, così un client
+// può rimandare indietro ciò che ha ricevuto da GET.
+type todoInput struct {
+	ID          *int    `json:"id"`
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	Status      *string `json:"status"`
+	// Priority assente o vuota vale "normal".
+	Priority *string `json:"priority"`
+	// DueAt è una data RFC 3339 con il fuso orario (es.
+	// "2025-06-01T18:00:00+02:00"), restituita con lo stesso offset;
+	// assente o null = nessuna scadenza.
+	DueAt *string `json:"due_at"`
+	// Recurrence è una regola RRULE (es. "FREQ=WEEKLY;BYDAY=MO"): richiede
+	// due_at, da cui partono le occorrenze. Assente o vuota = non si ripete.
+	Recurrence *string `json:"recurrence"`
+	// NextOccurrenceID è deciso dallo store quando un todo ricorrente viene
+	// completato: il valore ricevuto viene ignorato.
+	NextOccurrenceID json.RawMessage `json:"next_occurrence_id"`
+	// Completed è un'alternativa abbreviata a Status (true = done, false = pending).
+	Completed *bool `json:"completed"`
+	// CompletedAt è calcolato dallo store: il valore ricevuto viene ignorato.
+	CompletedAt json.RawMessage `json:"completed_at"`
+	// ListID è la lista del todo: cambiarla sposta il todo. Con PUT, un
+	// valore assente o null toglie il todo da ogni lista.
+	ListID *int `json:"list_id"`
+	// ParentID è il todo genitore: come per ListID, con PUT un valore
+	// assente o null porta il todo al primo livello.
+	ParentID *int `json:"parent_id"`
+	// Progress è calcolato dallo store e viene ignorato.
+	Progress json.RawMessage `json:"progress"`
+	// Tags si modificano con /todos/{id}/tags/{tag}: il valore ricevuto
+	// qui viene ignorato, come CompletedAt.
+	Tags json.RawMessage `json:"tags"`
+	// Version, se presente, è la versione che il client si aspetta di
+	// modificare: se nel frattempo è cambiata l'aggiornamento fallisce.
+	Version *int `json:"version"`
+}
+
+// createInput è il corpo di POST /todos: obbligatorio è solo il titolo,
+// gli altri campi modificabili, stato compreso, sono facoltativi. ID,
+// versione e tag non si possono scegliere alla creazione: invece di
+// ignorarli li rifiutiamo, così il client sa che non sono stati salvati.
+type createInput struct {
+	todoInput
+	Title  string `json:"title"`
+	ListID *int   `json:"list_id"`
+}
+
+// newTodo valida l'input di una creazione; con listID != 0 il todo nasce in
+// quella lista (lo usa POST /lists/{listID}/todos).
+func (in createInput) newTodo(listID int) (store.Todo, error) {
+	var verr validationError
+	if in.Title == "" {
+		verr.add("title", "non può essere vuoto")
+	}
+	if in.ParentID != nil && *in.ParentID < 1 {
+		verr.add("parent_id", "deve essere un intero positivo")
+	}
+	switch {
+	case listID != 0 && in.ListID != nil && *in.ListID != listID:
+		verr.add("list_id", fmt.Sprintf("non corrisponde all'URL (%d)", listID))
+	case listID != 0:
+		in.ListID = &listID
+	case in.ListID != nil && *in.ListID < 1:
+		verr.add("list_id", "deve essere un intero positivo")
+	}
+	if in.ID != nil {
+		verr.add("id", "è deciso dal server, non si può indicare nella creazione")
+	}
+	if in.Version != nil {
+		verr.add("version", "un todo nuovo parte sempre dalla versione 1")
+	}
+	var tags []json.RawMessage
+	if err := json.Unmarshal(in.Tags, &tags); len(in.Tags) > 0 && (err != nil || len(tags) > 0) {
+		verr.add("tags", "si aggiungono dopo la creazione, con PUT /todos/{id}/tags/{tag}")
+	}
+	todo := store.Todo{Title: in.Title, ListID: in.ListID, ParentID: in.ParentID}
+	in.schedule(&todo, &verr)
+	if in.Status != nil || in.Completed != nil {
+		var status string
+		if in.Status != nil {
+			status = *in.Status
+		}
+		parsed, err := statusFromInput(status, in.Completed)
+		if err != nil {
+			verr.add("status", err.Error())
+		}
+		todo.Status = parsed
+		if parsed == store.StatusDone && todo.Recurrence != "" {
+			verr.add("status", "un todo ricorrente non può nascere già completato")
+		}
+	}
+	if err := verr.err(); err != nil {
+		return store.Todo{}, err
+	}
+	return todo, nil
+}
+
+// decodeStrict decodifica un singolo oggetto JSON rifiutando i campi sconosciuti.
+func decodeStrict(r io.Reader, v any) error {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("contenuto inatteso dopo l'oggetto JSON")
+	}
+	return nil
+}
+
+// toTodo valida l'input di un PUT: tutti i campi modificabili sono obbligatori.
+// Gli errori di tutti i campi vengono restituiti insieme in un validationError.
+func (in todoInput) toTodo(id int) (store.Todo, error) {
+	var verr validationError
+	if in.ID != nil && *in.ID != id {
+		verr.add("id", fmt.Sprintf("non corrisponde all'URL (%d)", id))
+	}
+	todo := store.Todo{ID: id}
+	if in.Title == nil || *in.Title == "" {
+		verr.add("title", "è obbligatorio e non può essere vuoto")
+	} else {
+		todo.Title = *in.Title
+	}
+
+	in.schedule(&todo, &verr)
+
+	var status string
+	if in.Status != nil {
+		status = *in.Status
+	}
+	parsed, err := statusFromInput(status, in.Completed)
+	if err != nil {
+		verr.add("status", err.Error())
+	}
+	todo.Status = parsed
+
+	if in.ListID != nil {
+		if *in.ListID < 1 {
+			verr.add("list_id", "deve essere un intero positivo")
+		}
+		todo.ListID = in.ListID
+	}
+	if in.ParentID != nil {
+		if *in.ParentID < 1 {
+			verr.add("parent_id", "deve essere un intero positivo")
+		}
+		todo.ParentID = in.ParentID
+	}
+
+	if in.Version != nil {
+		if *in.Version < 1 {
+			verr.add("version", "deve essere un intero positivo")
+		}
+		todo.Version = *in.Version
+	}
+	if err := verr.err(); err != nil {
+		return store.Todo{}, err
+	}
+	return todo, nil
+}
+
+// schedule copia su todo descrizione, priorità, scadenza e ricorrenza, che sono
+// facoltative sia in POST che in PUT, aggiungendo a verr gli errori.
+func (in todoInput) schedule(todo *store.Todo, verr *validationError) {
+	if in.Description != nil {
+		if utf8.RuneCountInString(*in.Description) > store.MaxDescriptionLength {
+			verr.add("description", fmt.Sprintf("può avere al massimo %d caratteri", store.MaxDescriptionLength))
+		}
+		todo.Description = *in.Description
+	}
+
+	var priority string
+	if in.Priority != nil {
+		priority = *in.Priority
+	}
+	parsed, err := store.ParsePriority(priority)
+	if err != nil {
+		verr.add("priority", err.Error())
+	}
+	todo.Priority = parsed
+
+	if in.DueAt != nil {
+		due, err := parseTime(*in.DueAt)
+		if err != nil {
+			verr.add("due_at", err.Error())
+		}
+		todo.DueAt = due
+	}
+
+	if in.Recurrence != nil && *in.Recurrence != "" {
+		if _, err := rrule.Parse(*in.Recurrence); err != nil {
+			verr.add("recurrence", err.Error())
+		} else if in.DueAt == nil {
+			verr.add("recurrence", "richiede anche due_at")
+		}
+		todo.Recurrence = *in.Recurrence
+	}
+}
+
+// parseTime legge una data RFC 3339, che deve indicare il fuso orario
+// (una "Z" finale o uno scostamento come +02:00).
+func parseTime(s string) (*time.Time, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, fmt.Errorf("%q non è una data RFC 3339 con fuso orario (es. 2025-06-01T18:00:00+02:00)", s)
+	}
+	return &t, nil
+}
+
+// patchedTodo valida il risultato di una PATCH applicata a current. Dopo la
+// patch il documento contiene sia "status" che "completed": vale quello che
+// la patch ha effettivamente cambiato. La versione attesa è quella letta,
+// salvo che la patch stessa non ne indichi un'altra.
+func (in todoInput) patchedTodo(current store.Todo) (store.Todo, error) {
+	if in.Version == nil {
+		in.Version = &current.Version
+	}
+
+	statusChanged := in.Status != nil && *in.Status != string(current.Status)
+	completedChanged := in.Completed != nil && *in.Completed != current.Completed
+
+	switch {
+	case statusChanged:
+		if !completedChanged {
+			in.Completed = nil
+		}
+	case completedChanged:
+		in.Status = nil
+	default:
+		in.Completed = nil
+	}
+	return in.toTodo(current.ID)
+}
+
+// statusFromInput valida lo stato ricevuto dal client. "completed" è
+// accettato solo se coerente con "status", quando sono presenti entrambi.
+func statusFromInput(status string, completed *bool) (store.Status, error) {
+	if status == "" {
+		if completed == nil {
+			return "", errors.New("specificare 'status' oppure 'completed'")
+		}
+		if *completed {
+			return store.StatusDone, nil
+		}
+		return store.StatusPending, nil
+	}
+
+	parsed, err := store.ParseStatus(status)
+	if err != nil {
+		return "", err
+	}
+	if completed != nil && *completed != (parsed == store.StatusDone) {
+		return "", errors.New("'status' e 'completed' sono in contraddizione")
+	}
+	return parsed, nil
+}
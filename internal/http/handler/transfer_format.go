@@ -0,0 +1,336 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"todolist-api-v2/internal/store"
+)
+
+// Formati di import ed export.
+const (
+	formatCSV      = "csv"
+	formatJSON     = "json"
+	formatMarkdown = "md"
+)
+
+// formatContentTypes associa ogni formato al suo media type.
+var formatContentTypes = map[string]string{
+	formatCSV:      "text/csv; charset=utf-8",
+	formatJSON:     "application/json",
+	formatMarkdown: "text/markdown; charset=utf-8",
+}
+
+// csvColumns sono le colonne dell'export CSV, in quest'ordine. L'import le
+// riconosce per nome in qualsiasi ordine e ignora le altre.
+var csvColumns = []string{"id", "title", "description", "status", "priority", "due_at", "recurrence", "list_id", "parent_id", "tags", "completed_at"}
+
+// Caselle della checklist Markdown. Oltre a quelle classiche usiamo le
+// varianti diffuse negli editor di note per "in corso" e "archiviato".
+var markdownBoxes = map[store.Status]string{
+	store.StatusPending:    " ",
+	store.StatusInProgress: "/",
+	store.StatusDone:       "x",
+	store.StatusArchived:   "-",
+}
+
+// --- export ---
+
+// todoEncoder scrive i todo di un export uno alla volta.
+type todoEncoder interface {
+	encode(t store.Todo) error
+	// flush manda al writer quello che l'encoder tiene nel buffer.
+	flush() error
+	// close chiude il documento e fa flush.
+	close() error
+}
+
+func newTodoEncoder(w io.Writer, format string) todoEncoder {
+	switch format {
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write(csvColumns) // un eventuale errore lo restituisce il primo flush
+		return &csvEncoder{w: cw}
+	case formatMarkdown:
+		return &markdownEncoder{w: bufio.NewWriter(w)}
+	default:
+		return &jsonEncoder{w: w}
+	}
+}
+
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvEncoder) encode(t store.Todo) error {
+	return e.w.Write([]string{
+		strconv.Itoa(t.ID), t.Title, t.Description, string(t.Status), string(t.Priority),
+		formatOptTime(t.DueAt), t.Recurrence, formatOptInt(t.ListID), formatOptInt(t.ParentID),
+		strings.Join(t.Tags, ","), formatOptTime(t.CompletedAt),
+	})
+}
+
+func (e *csvEncoder) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) close() error { return e.flush() }
+
+func formatOptTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func formatOptInt(n *int) string {
+	if n == nil {
+		return ""
+	}
+	return strconv.Itoa(*n)
+}
+
+// jsonEncoder scrive un array con gli stessi oggetti di GET /todos, uno per
+// riga.
+type jsonEncoder struct {
+	w io.Writer
+	n int
+}
+
+func (e *jsonEncoder) encode(t store.Todo) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if e.n == 0 {
+		sep = "[\n"
+	}
+	e.n++
+	_, err = io.WriteString(e.w, sep+string(data))
+	return err
+}
+
+func (e *jsonEncoder) flush() error { return nil }
+
+func (e *jsonEncoder) close() error {
+	end := "\n]\n"
+	if e.n == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(e.w, end)
+	return err
+}
+
+// markdownEncoder scrive una checklist con titolo, stato e tag: gli altri
+// campi non hanno un posto naturale in Markdown.
+type markdownEncoder struct {
+	w *bufio.Writer
+}
+
+func (e *markdownEncoder) encode(t store.Todo) error {
+	// un a capo nel titolo spezzerebbe l'elemento della lista
+	title := strings.Join(strings.Fields(t.Title), " ")
+	line := "- [" + markdownBoxes[t.Status] + "] " + title
+	for _, tag := range t.Tags {
+		line += " #" + tag
+	}
+	_, err := e.w.WriteString(line + "\n")
+	return err
+}
+
+func (e *markdownEncoder) flush() error { return e.w.Flush() }
+
+func (e *markdownEncoder) close() error { return e.flush() }
+
+// --- import ---
+
+// maxImportRows limita le righe di un import: vengono tutte lette in
+// memoria e scritte in un'unica transazione.
+const maxImportRows = 5000
+
+// importRow è un todo letto da un file di import, non ancora validato.
+type importRow struct {
+	// row è la riga del file (CSV e Markdown, contando da 1) o la
+	// posizione nell'array (JSON, sempre da 1).
+	row   int
+	input todoInput
+	tags  []string
+	// completedAt è la data di completamento del file, conservata per i
+	// todo importati come "done" o "archived".
+	completedAt *string
+	// errs sono gli errori trovati già in lettura (es. un ID non numerico).
+	errs validationError
+}
+
+// readImport legge tutte le righe del file. Un errore vuol dire che il file
+// non è leggibile nel suo insieme; i problemi delle singole righe restano
+// nelle righe.
+func readImport(r io.Reader, format string) ([]importRow, error) {
+	var rows []importRow
+	var err error
+	switch format {
+	case formatCSV:
+		rows, err = readCSV(r)
+	case formatMarkdown:
+		rows, err = readMarkdown(r)
+	default:
+		rows, err = readJSON(r)
+	}
+	if err == nil && len(rows) > maxImportRows {
+		err = fmt.Errorf("il file può contenere al massimo %d todo", maxImportRows)
+	}
+	return rows, err
+}
+
+func readCSV(r io.Reader) ([]importRow, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("intestazione CSV non leggibile: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		// Excel mette un BOM all'inizio dei CSV in UTF-8
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\uFEFF")))
+		columns[name] = i
+	}
+	if _, ok := columns["title"]; !ok {
+		return nil, errors.New("manca la colonna title")
+	}
+
+	var rows []importRow
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		line, _ := cr.FieldPos(0)
+		row := importRow{row: line}
+		if err != nil {
+			if !errors.Is(err, csv.ErrFieldCount) {
+				return nil, err
+			}
+			row.errs.add("", fmt.Sprintf("la riga ha %d colonne invece di %d", len(record), len(header)))
+			rows = append(rows, row)
+			continue
+		}
+
+		cell := func(name string) *string {
+			if i, ok := columns[name]; ok && record[i] != "" {
+				return &record[i]
+			}
+			return nil
+		}
+		row.input.Title = cell("title")
+		row.input.Description = cell("description")
+		row.input.Status = cell("status")
+		row.input.Priority = cell("priority")
+		row.input.DueAt = cell("due_at")
+		row.input.Recurrence = cell("recurrence")
+		row.completedAt = cell("completed_at")
+		row.input.ID = row.intCell("id", cell("id"))
+		row.input.ListID = row.intCell("list_id", cell("list_id"))
+		row.input.ParentID = row.intCell("parent_id", cell("parent_id"))
+		if tags := cell("tags"); tags != nil {
+			row.tags = strings.Split(*tags, ",")
+		}
+		rows = append(rows, row)
+	}
+}
+
+// intCell converte una cella numerica, annotando l'errore sulla riga.
+func (row *importRow) intCell(name string, value *string) *int {
+	if value == nil {
+		return nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(*value))
+	if err != nil {
+		row.errs.add(name, fmt.Sprintf("%q non è un numero intero", *value))
+		return nil
+	}
+	return &n
+}
+
+func readJSON(r io.Reader) ([]importRow, error) {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, errors.New("il file JSON deve contenere un array di todo")
+	}
+
+	var rows []importRow
+	for dec.More() {
+		row := importRow{row: len(rows) + 1}
+		var item struct {
+			todoInput
+			Tags []string `json:"tags"`
+		}
+		if err := dec.Decode(&item); err != nil {
+			// con un errore di tipo il decoder ha comunque letto tutto
+			// l'oggetto e può andare avanti; con gli altri no
+			var typeErr *json.UnmarshalTypeError
+			if !errors.As(err, &typeErr) {
+				return nil, fmt.Errorf("todo %d: %w", row.row, err)
+			}
+			row.errs.add(typeErr.Field, "tipo non valido: atteso "+typeErr.Type.String())
+		}
+		row.input, row.tags = item.todoInput, item.Tags
+		if len(item.CompletedAt) > 0 {
+			if err := json.Unmarshal(item.CompletedAt, &row.completedAt); err != nil {
+				row.errs.add("completed_at", "tipo non valido: attesa una stringa")
+			}
+		}
+		rows = append(rows, row)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("fine dell'array JSON non valida: %w", err)
+	}
+	return rows, nil
+}
+
+// markdownItem riconosce un elemento di checklist come "- [x] titolo",
+// anche rientrato e con * o + al posto del trattino.
+var markdownItem = regexp.MustCompile(`^\s*[-*+] \[([ xX/-])\]\s+(.*)$`)
+
+// readMarkdown legge gli elementi di checklist e ignora tutte le altre
+// righe (titoli, testo, elenchi normali). Le parole in fondo che
+// iniziano con # diventano tag.
+func readMarkdown(r io.Reader) ([]importRow, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	var rows []importRow
+	for line := 1; sc.Scan(); line++ {
+		m := markdownItem.FindStringSubmatch(sc.Text())
+		if m == nil {
+			continue
+		}
+		row := importRow{row: line}
+		status := string(store.StatusPending)
+		for st, box := range markdownBoxes {
+			if strings.EqualFold(m[1], box) {
+				status = string(st)
+			}
+		}
+		title := strings.TrimSpace(m[2])
+		for {
+			i := strings.LastIndexByte(title, ' ')
+			word := title[i+1:]
+			if i < 0 || len(word) < 2 || word[0] != '#' {
+				break
+			}
+			row.tags = append([]string{word[1:]}, row.tags...)
+			title = strings.TrimSpace(title[:i])
+		}
+		row.input.Title, row.input.Status = &title, &status
+		rows = append(rows, row)
+	}
+	return rows, sc.Err()
+}
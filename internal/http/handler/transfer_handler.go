@@ -0,0 +1,278 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"todolist-api-v2/internal/store"
+)
+
+// maxImportSize è la dimensione massima del file di POST /todos/import.
+const maxImportSize = 10 << 20
+
+// transferStore è ciò che serve a TransferHandler: leggere i todo per
+// l'export e scriverli in una transazione per l'import.
+type transferStore interface {
+	store.TodoRepository
+	store.Transactor
+}
+
+// TransferHandler porta i todo dentro e fuori dall'API in blocco, come
+// CSV, JSON o checklist Markdown.
+type TransferHandler struct {
+	Store transferStore
+}
+
+func NewTransferHandler(s transferStore) *TransferHandler {
+	return &TransferHandler{Store: s}
+}
+
+// importReport è la risposta di POST /todos/import.
+type importReport struct {
+	DryRun bool `json:"dry_run"`
+	// Total sono i todo trovati nel file, Imported quelli importati (o
+	// che lo sarebbero, in prova) e Failed quelli scartati.
+	Total    int              `json:"total"`
+	Imported int              `json:"imported"`
+	Failed   int              `json:"failed"`
+	Errors   []importRowError `json:"errors"`
+}
+
+// importRowError elenca i problemi di una riga scartata.
+type importRowError struct {
+	Row    int          `json:"row"`
+	Errors []FieldError `json:"errors"`
+}
+
+// errDryRun annulla la transazione di un import di prova.
+var errDryRun = errors.New("import di prova")
+
+// Export gestisce GET /todos/export?format=csv|json|md (default json): tutti
+// i todo dell'utente, dal primo all'ultimo per ID, con gli stessi filtri di
+// GET /todos. La risposta viene scritta man mano, una pagina alla volta,
+// così anche un export grande non sta tutto in memoria.
+func (h *TransferHandler) Export(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = formatJSON
+	}
+	contentType, ok := formatContentTypes[format]
+	if !ok {
+		writeValidationError(w, r, CodeInvalidQuery, validationError{{Field: "format", Message: "deve essere csv, json o md"}})
+		return
+	}
+	opts, err := parseListOptions(q)
+	if err != nil {
+		writeValidationError(w, r, CodeInvalidQuery, err)
+		return
+	}
+	// ordinamento e paginazione li decide l'export
+	opts.Sort, opts.Desc, opts.Offset, opts.AfterID, opts.Limit = store.SortByID, false, 0, 0, maxPageSize
+
+	// la prima pagina la leggiamo prima di rispondere, così un errore
+	// dello store è ancora un normale problem+json
+	page, err := h.Store.GetAll(r.Context(), opts)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="todos.`+format+`"`)
+	enc := newTodoEncoder(w, format)
+	for {
+		for _, t := range page.Todos {
+			if err := enc.encode(t); err != nil {
+				abortExport(r, err)
+			}
+		}
+		if !page.HasMore {
+			break
+		}
+		if err := enc.flush(); err != nil {
+			abortExport(r, err)
+		}
+		http.NewResponseController(w).Flush() // se non è supportato, pazienza
+		opts.AfterID = page.Todos[len(page.Todos)-1].ID
+		if page, err = h.Store.GetAll(r.Context(), opts); err != nil {
+			abortExport(r, err)
+		}
+	}
+	if err := enc.close(); err != nil {
+		abortExport(r, err)
+	}
+}
+
+// abortExport interrompe un export già iniziato. Lo status 200 è già
+// partito: l'unico modo per far capire al client che il file è incompleto
+// è chiudere la connessione senza terminare la risposta.
+func abortExport(r *http.Request, err error) {
+	log.Printf("export interrotto su %s %s: %v", r.Method, r.URL.Path, err)
+	panic(http.ErrAbortHandler)
+}
+
+// Import gestisce POST /todos/import: il corpo è un file nello stesso
+// formato dell'export, indicato con ?format= oppure dal Content-Type
+// (text/csv, application/json, text/markdown). Ogni riga diventa un nuovo
+// todo; le righe non valide vengono scartate e riportate nella risposta,
+// le altre vengono importate. Con ?dry_run=true nulla viene salvato ma la
+// risposta è la stessa, errori dello store compresi.
+//
+// Gli ID del file servono solo a collegare le sottoattività: parent_id
+// deve indicare un todo che compare prima nel file. Un todo ricorrente già
+// completato viene importato senza ricorrenza, perché la serie continua
+// nell'occorrenza successiva, che è esportata a sua volta.
+func (h *TransferHandler) Import(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var verr validationError
+	format := q.Get("format")
+	if format == "" {
+		format = formatFromContentType(r.Header.Get("Content-Type"))
+	}
+	if _, ok := formatContentTypes[format]; !ok {
+		verr.add("format", "deve essere csv, json o md (oppure va indicato il Content-Type)")
+	}
+	dryRun := false
+	if v := q.Get("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			verr.add("dry_run", "deve essere true o false")
+		}
+	}
+	if err := verr.err(); err != nil {
+		writeValidationError(w, r, CodeInvalidQuery, err)
+		return
+	}
+
+	rows, err := readImport(http.MaxBytesReader(w, r.Body, maxImportSize), format)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeProblem(w, r, http.StatusRequestEntityTooLarge, CodeInvalidBody, fmt.Sprintf("Il file può essere al massimo di %d byte", maxImportSize))
+			return
+		}
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidBody, "File non leggibile: "+err.Error())
+		return
+	}
+
+	report := importReport{DryRun: dryRun, Total: len(rows), Errors: []importRowError{}}
+	err = h.Store.WithTx(r.Context(), func(tx store.Repository) error {
+		newIDs := make(map[int]int) // ID nel file -> ID del todo creato
+		for _, row := range rows {
+			created, err := importRowTodo(r.Context(), tx, row, newIDs)
+			if err != nil {
+				var verr validationError
+				if !errors.As(err, &verr) {
+					p := storeProblem(r, err)
+					if p.Status >= http.StatusInternalServerError {
+						return err
+					}
+					verr = validationError{{Field: "", Message: p.Detail}}
+				}
+				report.Errors = append(report.Errors, importRowError{Row: row.row, Errors: verr})
+				report.Failed++
+				continue
+			}
+			report.Imported++
+			if row.input.ID != nil {
+				newIDs[*row.input.ID] = created.ID
+			}
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// formatFromContentType riconosce il formato dal media type del corpo.
+func formatFromContentType(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		return formatCSV
+	case "application/json":
+		return formatJSON
+	case "text/markdown":
+		return formatMarkdown
+	}
+	return ""
+}
+
+// importRowTodo valida una riga e crea il suo todo, già con lo stato e
+// la data di completamento del file, poi aggiunge i tag; tutto in una
+// transazione annidata, così se un passo fallisce la riga non lascia
+// nulla. Gli errori di validazione sono validationError.
+func importRowTodo(ctx context.Context, tx store.Repository, row importRow, newIDs map[int]int) (store.Todo, error) {
+	verr := row.errs
+	in := row.input
+	in.Version = nil
+	if in.Status == nil && in.Completed == nil {
+		pending := string(store.StatusPending)
+		in.Status = &pending
+	}
+	id := 0
+	if in.ID != nil {
+		id = *in.ID
+	}
+	todo, err := in.toTodo(id)
+	if err != nil {
+		verr.addAll("", err)
+	}
+	if row.completedAt != nil {
+		at, err := parseTime(*row.completedAt)
+		if err != nil {
+			verr.add("completed_at", err.Error())
+		}
+		todo.CompletedAt = at
+	}
+	var tags []string
+	for _, t := range row.tags {
+		tag, err := store.NormalizeTag(t)
+		if err != nil {
+			verr.add("tags", err.Error())
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	if todo.ParentID != nil {
+		parentID, ok := newIDs[*todo.ParentID]
+		if !ok {
+			verr.add("parent_id", fmt.Sprintf("il todo %d non compare prima nel file o non è stato importato", *todo.ParentID))
+		}
+		todo.ParentID = &parentID
+	}
+	if err := verr.err(); err != nil {
+		return store.Todo{}, err
+	}
+
+	if todo.Status == store.StatusDone {
+		todo.Recurrence = ""
+	}
+	var created store.Todo
+	err = tx.WithTx(ctx, func(tx store.Repository) error {
+		var err error
+		if created, err = tx.Create(ctx, todo); err != nil {
+			return err
+		}
+		for _, tag := range tags {
+			if created, err = tx.AddTag(ctx, created.ID, tag); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return created, err
+}
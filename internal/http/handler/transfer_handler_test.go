@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"todolist-api-v2/internal/store"
+)
+
+func TestTransferHandler(t *testing.T) {
+	s := store.NewMemoryStore()
+	ctx := store.WithOwner(context.Background(), store.DefaultUserID)
+	h := NewTransferHandler(s)
+	router := chi.NewRouter()
+	router.Use(asUser(store.DefaultUserID))
+	router.Get("/todos/export", h.Export)
+	router.Post("/todos/import", h.Import)
+
+	do := func(method, url, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	importFile := func(url, contentType, body string) importReport {
+		t.Helper()
+		rr := do(http.MethodPost, url, contentType, body)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var report importReport
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+		return report
+	}
+	count := func() int {
+		page, err := s.GetAll(ctx, store.ListOptions{})
+		require.NoError(t, err)
+		return page.Total
+	}
+
+	spesa, err := s.Create(ctx, store.Todo{Title: "Spesa, per sabato", Description: "latte\npane"})
+	require.NoError(t, err)
+	_, err = s.AddTag(ctx, spesa.ID, "casa")
+	require.NoError(t, err)
+	latte, err := s.Create(ctx, store.Todo{Title: "Latte", ParentID: &spesa.ID})
+	require.NoError(t, err)
+	_, err = s.Update(ctx, store.Todo{ID: latte.ID, Title: "Latte", Status: store.StatusDone, ParentID: &spesa.ID})
+	require.NoError(t, err)
+
+	t.Run("export", func(t *testing.T) {
+		rr := do(http.MethodGet, "/todos/export", "", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+		var todos []store.Todo
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &todos))
+		require.Len(t, todos, 2)
+		assert.Equal(t, []string{"casa"}, todos[0].Tags)
+
+		rr = do(http.MethodGet, "/todos/export?format=csv&status=done", "", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, `attachment; filename="todos.csv"`, rr.Header().Get("Content-Disposition"))
+		records, err := csv.NewReader(rr.Body).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, 2, "intestazione e il solo todo fatto")
+		assert.Equal(t, csvColumns, records[0])
+		assert.Equal(t, "Latte", records[1][1])
+		assert.Equal(t, fmt.Sprint(spesa.ID), records[1][8])
+
+		rr = do(http.MethodGet, "/todos/export?format=md", "", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "- [ ] Spesa, per sabato #casa\n- [x] Latte\n", rr.Body.String())
+
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/todos/export?format=xlsx", "", "").Code)
+		assert.Equal(t, "[]\n", do(http.MethodGet, "/todos/export?q=nessuno", "", "").Body.String())
+	})
+
+	t.Run("andata e ritorno", func(t *testing.T) {
+		exported := make(map[string]string)
+		for _, format := range []string{formatCSV, formatJSON} {
+			exported[format] = do(http.MethodGet, "/todos/export?format="+format, "", "").Body.String()
+		}
+		for format, file := range exported {
+			before := count()
+			events, err := s.EventsAfter(ctx, 0, 1000)
+			require.NoError(t, err)
+			report := importFile("/todos/import?format="+format, "", file)
+			assert.Equal(t, importReport{Total: 2, Imported: 2, Errors: []importRowError{}}, report, format)
+			assert.Equal(t, before+2, count())
+
+			page, err := s.GetAll(ctx, store.ListOptions{Sort: store.SortByID, Desc: true, Limit: 2})
+			require.NoError(t, err)
+			child, parent := page.Todos[0], page.Todos[1]
+			assert.Equal(t, "Spesa, per sabato", parent.Title)
+			assert.Equal(t, "latte\npane", parent.Description)
+			assert.Equal(t, []string{"casa"}, parent.Tags)
+			assert.Equal(t, store.StatusDone, child.Status)
+			assert.Equal(t, &parent.ID, child.ParentID, "il genitore è il nuovo todo, non quello del file")
+
+			// il todo fatto nasce già completato: un solo evento, "created"
+			after, err := s.EventsAfter(ctx, events[len(events)-1].ID, 1000)
+			require.NoError(t, err)
+			var childEvents []store.EventAction
+			for _, e := range after {
+				if e.TodoID == child.ID {
+					childEvents = append(childEvents, e.Action)
+				}
+			}
+			assert.Equal(t, []store.EventAction{store.EventCreated}, childEvents, format)
+		}
+	})
+
+	t.Run("stato e data di completamento", func(t *testing.T) {
+		file := "title,status,completed_at\n" +
+			"Fatto,done,2024-01-02T03:04:05+01:00\n" +
+			"Archiviato,archived,2024-02-03T04:05:06Z\n" +
+			"Fatto senza data,done,\n" +
+			"Da fare,pending,2024-01-02T03:04:05Z\n" +
+			"Data sbagliata,done,ieri\n"
+		report := importFile("/todos/import", "text/csv", file)
+		assert.Equal(t, 4, report.Imported)
+		require.Len(t, report.Errors, 1)
+		assert.Equal(t, "completed_at", report.Errors[0].Errors[0].Field)
+
+		page, err := s.GetAll(ctx, store.ListOptions{Sort: store.SortByID, Desc: true, Limit: 4})
+		require.NoError(t, err)
+		pending, doneNow, archived, done := page.Todos[0], page.Todos[1], page.Todos[2], page.Todos[3]
+		assert.Equal(t, store.StatusDone, done.Status)
+		assert.Equal(t, time.Date(2024, 1, 2, 2, 4, 5, 0, time.UTC), *done.CompletedAt)
+		assert.Equal(t, store.StatusArchived, archived.Status)
+		assert.Equal(t, time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC), *archived.CompletedAt)
+		assert.NotNil(t, doneNow.CompletedAt, "senza data nel file vale il momento dell'import")
+		assert.Nil(t, pending.CompletedAt, "un todo da fare non ha data di completamento")
+	})
+
+	t.Run("markdown", func(t *testing.T) {
+		before := count()
+		report := importFile("/todos/import", "text/markdown", "# Casa\n\n"+
+			"- [ ] Bollette #casa #Urgente\n"+
+			"  * [/] Dentista\n"+
+			"- elenco normale, ignorato\n"+
+			"- [x] \n")
+		assert.Equal(t, 2, report.Imported)
+		require.Len(t, report.Errors, 1)
+		assert.Equal(t, 6, report.Errors[0].Row)
+		assert.Equal(t, "title", report.Errors[0].Errors[0].Field)
+		assert.Equal(t, before+2, count())
+
+		page, err := s.GetAll(ctx, store.ListOptions{Search: "Bollette"})
+		require.NoError(t, err)
+		require.Len(t, page.Todos, 1)
+		assert.Equal(t, []string{"casa", "urgente"}, page.Todos[0].Tags)
+	})
+
+	t.Run("righe non valide e prova", func(t *testing.T) {
+		before := count()
+		file := "title,status,id,parent_id,list_id,tags\n" +
+			"Buona,,1,,,\n" +
+			"Figlia,in_progress,2,1,,a\n" +
+			",boh,x,,,\n" +
+			"Orfana,,,99,,\n" +
+			"Senza lista,,,,42,\n"
+		report := importFile("/todos/import?dry_run=true", "text/csv", file)
+		assert.True(t, report.DryRun)
+		assert.Equal(t, 5, report.Total)
+		assert.Equal(t, 2, report.Imported)
+		assert.Equal(t, 3, report.Failed)
+		var rows []int
+		for _, e := range report.Errors {
+			rows = append(rows, e.Row)
+		}
+		assert.Equal(t, []int{4, 5, 6}, rows)
+		var fields []string
+		for _, fe := range report.Errors[0].Errors {
+			fields = append(fields, fe.Field)
+		}
+		assert.ElementsMatch(t, []string{"id", "title", "status"}, fields)
+		assert.Contains(t, report.Errors[2].Errors[0].Message, "42", "anche gli errori dello store finiscono nel rapporto")
+		assert.Equal(t, before, count(), "in prova non viene salvato nulla")
+
+		report = importFile("/todos/import", "text/csv", file)
+		assert.False(t, report.DryRun)
+		assert.Equal(t, 2, report.Imported)
+		assert.Equal(t, before+2, count())
+	})
+
+	t.Run("file non validi", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/todos/import", "text/plain", "x").Code)
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/todos/import?format=csv", "", "nome\nx\n").Code)
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/todos/import?format=json", "", `{"title":"x"}`).Code)
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/todos/import?format=json&dry_run=forse", "", `[]`).Code)
+
+		report := importFile("/todos/import", "application/json", `[{"title":"ok"},{"title":3}]`)
+		assert.Equal(t, 1, report.Imported)
+		require.Len(t, report.Errors, 1)
+		assert.Equal(t, 2, report.Errors[0].Row)
+		assert.Equal(t, "title", report.Errors[0].Errors[0].Field)
+	})
+
+	t.Run("export su più pagine", func(t *testing.T) {
+		for i := count(); i < maxPageSize+10; i++ {
+			_, err := s.Create(ctx, store.Todo{Title: fmt.Sprintf("Todo %d", i)})
+			require.NoError(t, err)
+		}
+		rr := do(http.MethodGet, "/todos/export", "", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		var todos []store.Todo
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &todos))
+		assert.Len(t, todos, maxPageSize+10)
+	})
+}
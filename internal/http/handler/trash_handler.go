@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"todolist-api-v2/internal/store"
+)
+
+// TrashHandler gestisce il cestino: DELETE /todos/{todoID} ci sposta i
+// todo, che da qui si possono ripristinare finché il server non li elimina
+// definitivamente (vedi l'opzione trash_retention).
+type TrashHandler struct {
+	Store store.TrashRepository
+}
+
+func NewTrashHandler(s store.TrashRepository) *TrashHandler {
+	return &TrashHandler{Store: s}
+}
+
+// GetAll gestisce GET /trash: i todo nel cestino, dal più recente, con il
+// campo "deleted_at".
+func (h *TrashHandler) GetAll(w http.ResponseWriter, r *http.Request) {
+	todos, err := h.Store.GetTrash(r.Context())
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(todos)
+}
+
+// Restore gestisce POST /todos/{todoID}/restore: il todo esce dal cestino
+// e viene restituito con la nuova versione. Un todo che non è nel cestino
+// (o che è già stato eliminato) è un 404.
+func (h *TrashHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	id, ok := todoIDParam(w, r)
+	if !ok {
+		return
+	}
+	restored, err := h.Store.Restore(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", todoETag(restored))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(restored)
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"todolist-api-v2/internal/store"
+)
+
+func TestTrashHandlers(t *testing.T) {
+	s := store.NewMemoryStore()
+	th := NewTodoHandler(s)
+	trash := NewTrashHandler(s)
+	router := chi.NewRouter()
+	router.Use(asUser(store.DefaultUserID))
+	router.Route("/todos", func(r chi.Router) {
+		r.Get("/", th.GetAll)
+		r.Post("/", th.Create)
+		r.Get("/{todoID}", th.GetByID)
+		r.Delete("/{todoID}", th.Delete)
+		r.Post("/{todoID}/restore", trash.Restore)
+	})
+	router.Get("/trash", trash.GetAll)
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/todos", `{"title":"Spesa"}`).Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/todos", `{"title":"Bollette"}`).Code)
+
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/todos/1", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/todos/1", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/todos/1", "").Code)
+	assert.Equal(t, "1", do(http.MethodGet, "/todos", "").Header().Get("X-Total-Count"))
+
+	rr := do(http.MethodGet, "/trash", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var trashed []store.Todo
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &trashed))
+	require.Len(t, trashed, 1)
+	assert.Equal(t, "Spesa", trashed[0].Title)
+	assert.Contains(t, rr.Body.String(), `"deleted_at"`)
+
+	rr = do(http.MethodPost, "/todos/1/restore", "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, `"1-3"`, rr.Header().Get("ETag"), "cancellazione e ripristino cambiano la versione")
+	assert.NotContains(t, rr.Body.String(), "deleted_at")
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/todos/1", "").Code)
+	assert.Equal(t, "[]\n", do(http.MethodGet, "/trash", "").Body.String())
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/todos/2/restore", "").Code, "il todo non è nel cestino")
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/todos/99/restore", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/todos/abc/restore", "").Code)
+}
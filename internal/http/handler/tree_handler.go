@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"todolist-api-v2/internal/store"
+)
+
+// TreeHandler restituisce i todo come albero, con le sottoattività annidate
+// nel campo "children" di ogni todo.
+type TreeHandler struct {
+	Store store.SubtaskRepository
+}
+
+func NewTreeHandler(s store.SubtaskRepository) *TreeHandler {
+	return &TreeHandler{Store: s}
+}
+
+// GetAll gestisce GET /todos/tree: tutti i todo di primo livello, ciascuno
+// con le sue sottoattività.
+func (h *TreeHandler) GetAll(w http.ResponseWriter, r *http.Request) {
+	h.writeTree(w, r, 0)
+}
+
+// GetByID gestisce GET /todos/{todoID}/tree: il todo con tutte le sue
+// sottoattività, a qualsiasi livello.
+func (h *TreeHandler) GetByID(w http.ResponseWriter, r *http.Request) {
+	id, ok := todoIDParam(w, r)
+	if !ok {
+		return
+	}
+	h.writeTree(w, r, id)
+}
+
+func (h *TreeHandler) writeTree(w http.ResponseWriter, r *http.Request, rootID int) {
+	nodes, err := h.Store.GetTree(r.Context(), rootID)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if rootID != 0 {
+		// un solo albero: rispondiamo con l'oggetto e non con un array
+		json.NewEncoder(w).Encode(nodes[0])
+		return
+	}
+	json.NewEncoder(w).Encode(nodes)
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"todolist-api-v2/internal/store"
+)
+
+func TestSubtaskHandlers(t *testing.T) {
+	s := store.NewMemoryStore()
+	th := NewTodoHandler(s)
+	tree := NewTreeHandler(s)
+	router := chi.NewRouter()
+	router.Use(asUser(store.DefaultUserID))
+	router.Route("/todos", func(r chi.Router) {
+		r.Post("/", th.Create)
+		r.Get("/tree", tree.GetAll)
+		r.Get("/{todoID}", th.GetByID)
+		r.Put("/{todoID}", th.Update)
+		r.Patch("/{todoID}", th.Patch)
+		r.Get("/{todoID}/children", th.Children)
+		r.Get("/{todoID}/tree", tree.GetByID)
+	})
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/todos", `{"title":"Trasloco"}`).Code)
+	rr := do(http.MethodPost, "/todos", `{"title":"Scatoloni","parent_id":1}`)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	assert.Equal(t, "1", mustField(t, rr.Body.Bytes(), "parent_id"))
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/todos", `{"title":"Nastro adesivo","parent_id":2}`).Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/todos", `{"title":"Disdire la luce","parent_id":1}`).Code)
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/todos", `{"title":"x","parent_id":0}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/todos", `{"title":"x","parent_id":99}`).Code)
+
+	t.Run("avanzamento", func(t *testing.T) {
+		rr := do(http.MethodPatch, "/todos/4", `{"status":"done"}`)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		rr = do(http.MethodGet, "/todos/1", "")
+		assert.Equal(t, "50", mustField(t, rr.Body.Bytes(), "progress"))
+		// il genitore si può modificare con PATCH anche se "progress" è nel documento
+		rr = do(http.MethodPatch, "/todos/1", `{"title":"Trasloco a Bologna"}`)
+		assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	})
+
+	t.Run("figli", func(t *testing.T) {
+		rr := do(http.MethodGet, "/todos/1/children", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		var todos []store.Todo
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &todos))
+		require.Len(t, todos, 2)
+		assert.Equal(t, "Scatoloni", todos[0].Title)
+		assert.Equal(t, "2", rr.Header().Get("X-Total-Count"))
+		assert.Equal(t, "1", do(http.MethodGet, "/todos/1/children?status=done", "").Header().Get("X-Total-Count"))
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/todos/99/children", "").Code)
+	})
+
+	t.Run("albero", func(t *testing.T) {
+		rr := do(http.MethodGet, "/todos/tree", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		var forest []store.TodoNode
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &forest))
+		require.Len(t, forest, 1)
+		require.Len(t, forest[0].Children, 2)
+		assert.Equal(t, "Nastro adesivo", forest[0].Children[0].Children[0].Title)
+
+		rr = do(http.MethodGet, "/todos/2/tree", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		var node store.TodoNode
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &node))
+		assert.Equal(t, "Scatoloni", node.Title)
+		assert.Len(t, node.Children, 1)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/todos/99/tree", "").Code)
+	})
+
+	t.Run("cicli", func(t *testing.T) {
+		rr := do(http.MethodPut, "/todos/1", `{"title":"Trasloco","status":"pending","parent_id":3}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), "ciclo")
+	})
+}
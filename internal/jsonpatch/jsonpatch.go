@@ -0,0 +1,398 @@
+// Package jsonpatch applica patch a documenti JSON secondo RFC 7396
+// (JSON Merge Patch) e RFC 6902 (JSON Patch), con i puntatori RFC 6901.
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Content-Type dei due formati di patch.
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+// ErrTestFailed è restituito quando un'operazione "test" non è soddisfatta.
+var ErrTestFailed = errors.New("operazione test fallita")
+
+// decode legge un valore JSON conservando i numeri come json.Number,
+// così gli interi non passano da float64.
+func decode(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("contenuto inatteso dopo il valore JSON")
+	}
+	return v, nil
+}
+
+// MergePatch applica una JSON Merge Patch (RFC 7396) a doc.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, fmt.Errorf("documento non valido: %w", err)
+	}
+	p, err := decode(patch)
+	if err != nil {
+		return nil, fmt.Errorf("merge patch non valida: %w", err)
+	}
+	return json.Marshal(mergeValue(target, p))
+}
+
+// mergeValue è l'algoritmo MergePatch della sezione 2 di RFC 7396.
+func mergeValue(target, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = map[string]any{}
+	}
+	for name, value := range patchObj {
+		if value == nil {
+			delete(targetObj, name)
+		} else {
+			targetObj[name] = mergeValue(targetObj[name], value)
+		}
+	}
+	return targetObj
+}
+
+// Operation è una singola operazione di una JSON Patch.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Apply applica una JSON Patch (RFC 6902) a doc. Le operazioni sono
+// atomiche: se una fallisce, il documento originale resta invariato.
+func Apply(doc, patch []byte) ([]byte, error) {
+	var ops []Operation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("json patch non valida: deve essere un array di operazioni: %w", err)
+	}
+	target, err := decode(doc)
+	if err != nil {
+		return nil, fmt.Errorf("documento non valido: %w", err)
+	}
+
+	for i, op := range ops {
+		if target, err = applyOp(target, op); err != nil {
+			return nil, fmt.Errorf("operazione %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return json.Marshal(target)
+}
+
+func applyOp(doc any, op Operation) (any, error) {
+	value := func() (any, error) {
+		if op.Value == nil {
+			return nil, errors.New("manca il campo value")
+		}
+		return decode(op.Value)
+	}
+
+	switch op.Op {
+	case "add":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.Path, v)
+	case "remove":
+		doc, _, err := remove(doc, op.Path)
+		return doc, err
+	case "replace":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		if op.Path == "" {
+			return v, nil // sostituzione dell'intero documento
+		}
+		doc, _, err = remove(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.Path, v)
+	case "move":
+		if op.Path == op.From || strings.HasPrefix(op.Path, op.From+"/") {
+			if op.Path != op.From {
+				return nil, errors.New("impossibile spostare un valore dentro se stesso")
+			}
+			return doc, nil
+		}
+		doc, v, err := remove(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.Path, v)
+	case "copy":
+		v, err := get(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+		// copia profonda tramite JSON, così i due valori non condividono mappe
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		if v, err = decode(data); err != nil {
+			return nil, err
+		}
+		return add(doc, op.Path, v)
+	case "test":
+		want, err := value()
+		if err != nil {
+			return nil, err
+		}
+		got, err := get(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(got, want) {
+			return nil, ErrTestFailed
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("operazione %q sconosciuta", op.Op)
+	}
+}
+
+// parsePointer divide un JSON Pointer (RFC 6901) nei suoi token.
+func parsePointer(ptr string) ([]string, error) {
+	if ptr == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, fmt.Errorf("puntatore %q non valido: deve iniziare con '/'", ptr)
+	}
+	tokens := strings.Split(ptr[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex interpreta un token come indice di un array lungo n.
+// Con allowEnd accetta anche "-" e n (aggiunta in coda).
+func arrayIndex(token string, n int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return n, nil
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("indice %q non valido", token)
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("indice %q non valido", token)
+	}
+	if i > n || (i == n && !allowEnd) {
+		return 0, fmt.Errorf("indice %d fuori dai limiti", i)
+	}
+	return i, nil
+}
+
+// Il documento viene avvolto in una mappa con chiave "" (vedi pointerTokens):
+// così anche la radice ha un genitore e le operazioni non hanno casi speciali.
+func wrap(doc any) map[string]any { return map[string]any{"": doc} }
+
+func pointerTokens(ptr string) ([]string, error) {
+	tokens, err := parsePointer(ptr)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{""}, tokens...), nil
+}
+
+// add inserisce value in ptr e restituisce il documento aggiornato.
+func add(doc any, ptr string, value any) (any, error) {
+	tokens, err := pointerTokens(ptr)
+	if err != nil {
+		return nil, err
+	}
+	root, err := addAt(wrap(doc), tokens, value)
+	if err != nil {
+		return nil, fmt.Errorf("percorso %q: %w", ptr, err)
+	}
+	return root.(map[string]any)[""], nil
+}
+
+// addAt scende ricorsivamente lungo tokens; restituisce il nodo aggiornato
+// perché inserire in un array può riallocarlo.
+func addAt(node any, tokens []string, value any) (any, error) {
+	if len(tokens) > 1 {
+		c, err := child(node, tokens[0])
+		if err != nil {
+			return nil, err
+		}
+		newChild, err := addAt(c, tokens[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		return setChild(node, tokens[0], newChild), nil
+	}
+
+	switch n := node.(type) {
+	case map[string]any:
+		n[tokens[0]] = value
+		return n, nil
+	case []any:
+		i, err := arrayIndex(tokens[0], len(n), true)
+		if err != nil {
+			return nil, err
+		}
+		n = append(n, nil)
+		copy(n[i+1:], n[i:])
+		n[i] = value
+		return n, nil
+	default:
+		return nil, errors.New("il genitore non è un oggetto né un array")
+	}
+}
+
+// remove elimina il valore in ptr e restituisce il documento aggiornato
+// insieme al valore rimosso.
+func remove(doc any, ptr string) (any, any, error) {
+	tokens, err := pointerTokens(ptr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 1 {
+		return nil, nil, errors.New("impossibile rimuovere la radice del documento")
+	}
+	root, removed, err := removeAt(wrap(doc), tokens)
+	if err != nil {
+		return nil, nil, fmt.Errorf("percorso %q: %w", ptr, err)
+	}
+	return root.(map[string]any)[""], removed, nil
+}
+
+func removeAt(node any, tokens []string) (any, any, error) {
+	if len(tokens) > 1 {
+		c, err := child(node, tokens[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		newChild, removed, err := removeAt(c, tokens[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		return setChild(node, tokens[0], newChild), removed, nil
+	}
+
+	switch n := node.(type) {
+	case map[string]any:
+		v, ok := n[tokens[0]]
+		if !ok {
+			return nil, nil, errors.New("inesistente")
+		}
+		delete(n, tokens[0])
+		return n, v, nil
+	case []any:
+		i, err := arrayIndex(tokens[0], len(n), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		v := n[i]
+		return append(n[:i:i], n[i+1:]...), v, nil
+	default:
+		return nil, nil, errors.New("inesistente")
+	}
+}
+
+func get(doc any, ptr string) (any, error) {
+	tokens, err := parsePointer(ptr)
+	if err != nil {
+		return nil, err
+	}
+	cur := doc
+	for _, t := range tokens {
+		if cur, err = child(cur, t); err != nil {
+			return nil, fmt.Errorf("percorso %q: %w", ptr, err)
+		}
+	}
+	return cur, nil
+}
+
+func child(node any, token string) (any, error) {
+	switch n := node.(type) {
+	case map[string]any:
+		v, ok := n[token]
+		if !ok {
+			return nil, errors.New("inesistente")
+		}
+		return v, nil
+	case []any:
+		i, err := arrayIndex(token, len(n), false)
+		if err != nil {
+			return nil, err
+		}
+		return n[i], nil
+	default:
+		return nil, errors.New("inesistente")
+	}
+}
+
+// setChild sostituisce un figlio già esistente (quindi l'indice è valido).
+func setChild(node any, token string, value any) any {
+	switch n := node.(type) {
+	case map[string]any:
+		n[token] = value
+	case []any:
+		i, _ := strconv.Atoi(token)
+		n[i] = value
+	}
+	return node
+}
+
+// equal confronta due valori JSON come richiesto dall'operazione test:
+// i numeri sono confrontati per valore, non per rappresentazione.
+func equal(a, b any) bool {
+	na, aNum := a.(json.Number)
+	nb, bNum := b.(json.Number)
+	if aNum && bNum {
+		fa, errA := na.Float64()
+		fb, errB := nb.Float64()
+		return errA == nil && errB == nil && fa == fb
+	}
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			w, ok := bv[k]
+			if !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		bv, ok := b.([]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !equal(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
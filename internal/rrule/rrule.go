@@ -0,0 +1,298 @@
+// Package rrule interpreta un sottoinsieme delle regole di ricorrenza di
+// iCalendar (RFC 5545, sezione 3.3.10): FREQ=DAILY, WEEKLY o MONTHLY, con
+// INTERVAL, BYDAY, COUNT e UNTIL. I giorni sono quelli del fuso della data
+// di partenza, non di UTC: un todo che scade lunedì alle 00:30 a Roma si
+// ripete il lunedì di Roma.
+package rrule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency è l'unità di base della ricorrenza.
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+)
+
+// MaxInterval limita INTERVAL: oltre non ha senso per dei todo e la
+// ricerca della prossima occorrenza diventerebbe lunga.
+const MaxInterval = 366
+
+// untilLayout è il formato di UTC di UNTIL (es. 20250601T180000Z).
+const untilLayout = "20060102T150405Z"
+
+// codici dei giorni della settimana, nell'ordine di time.Weekday.
+var weekdayCodes = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// Day è un elemento di BYDAY: un giorno della settimana, con N diverso da
+// 0 solo per FREQ=MONTHLY (1MO = primo lunedì del mese, -1FR = ultimo
+// venerdì).
+type Day struct {
+	Weekday time.Weekday
+	N       int
+}
+
+func (d Day) String() string {
+	if d.N == 0 {
+		return weekdayCodes[d.Weekday]
+	}
+	return strconv.Itoa(d.N) + weekdayCodes[d.Weekday]
+}
+
+// Rule è una regola di ricorrenza già validata.
+type Rule struct {
+	Freq Frequency
+	// Interval è ogni quante unità di Freq si ripete (1 = ogni giorno,
+	// ogni settimana...).
+	Interval int
+	// ByDay, se non vuoto, indica i giorni in cui cadono le occorrenze.
+	ByDay []Day
+	// Count è il numero di occorrenze rimaste, compresa quella attuale;
+	// 0 vuol dire senza limite.
+	Count int
+	// Until, se presente, è l'ultimo istante in cui può cadere un'occorrenza.
+	Until *time.Time
+}
+
+// Parse legge una regola come "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;COUNT=10".
+// Il prefisso "RRULE:" è facoltativo e maiuscole e minuscole sono
+// indifferenti; le parti non supportate danno errore invece di essere
+// ignorate, così il client non crede che vengano rispettate.
+func Parse(s string) (Rule, error) {
+	s = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "RRULE:")
+	if s == "" {
+		return Rule{}, errors.New("regola vuota")
+	}
+
+	r := Rule{Interval: 1}
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ";") {
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return Rule{}, fmt.Errorf("%q non valido: atteso CHIAVE=valore", part)
+		}
+		if seen[key] {
+			return Rule{}, fmt.Errorf("%s compare più volte", key)
+		}
+		seen[key] = true
+
+		var err error
+		switch key {
+		case "FREQ":
+			r.Freq = Frequency(value)
+			if r.Freq != Daily && r.Freq != Weekly && r.Freq != Monthly {
+				err = fmt.Errorf("FREQ=%s non supportata (valori ammessi: DAILY, WEEKLY, MONTHLY)", value)
+			}
+		case "INTERVAL":
+			r.Interval, err = parseInt(key, value, 1, MaxInterval)
+		case "COUNT":
+			r.Count, err = parseInt(key, value, 1, 0)
+		case "BYDAY":
+			r.ByDay, err = parseByDay(value)
+		case "UNTIL":
+			r.Until, err = parseUntil(value)
+		default:
+			err = fmt.Errorf("%s non è supportato (parti ammesse: FREQ, INTERVAL, BYDAY, COUNT, UNTIL)", key)
+		}
+		if err != nil {
+			return Rule{}, err
+		}
+	}
+
+	if r.Freq == "" {
+		return Rule{}, errors.New("manca FREQ")
+	}
+	if r.Count > 0 && r.Until != nil {
+		return Rule{}, errors.New("COUNT e UNTIL non possono essere usati insieme")
+	}
+	for _, d := range r.ByDay {
+		if d.N != 0 && r.Freq != Monthly {
+			return Rule{}, fmt.Errorf("BYDAY=%s: il numero davanti al giorno è ammesso solo con FREQ=MONTHLY", d)
+		}
+	}
+	return r, nil
+}
+
+// parseInt legge un intero in [min, max]; max 0 vuol dire nessun massimo.
+func parseInt(key, value string, min, max int) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || (max > 0 && n > max) {
+		if max > 0 {
+			return 0, fmt.Errorf("%s=%s non valido: deve essere un intero tra %d e %d", key, value, min, max)
+		}
+		return 0, fmt.Errorf("%s=%s non valido: deve essere un intero maggiore o uguale a %d", key, value, min)
+	}
+	return n, nil
+}
+
+func parseByDay(value string) ([]Day, error) {
+	var days []Day
+	for _, item := range strings.Split(value, ",") {
+		if len(item) < 2 {
+			return nil, fmt.Errorf("BYDAY: giorno %q non valido", item)
+		}
+		code, prefix := item[len(item)-2:], item[:len(item)-2]
+		d := Day{Weekday: -1}
+		for i, c := range weekdayCodes {
+			if c == code {
+				d.Weekday = time.Weekday(i)
+			}
+		}
+		if d.Weekday < 0 {
+			return nil, fmt.Errorf("BYDAY: giorno %q non valido (usare MO, TU, WE, TH, FR, SA, SU)", item)
+		}
+		if prefix != "" {
+			n, err := strconv.Atoi(prefix)
+			if err != nil || n == 0 || n < -5 || n > 5 {
+				return nil, fmt.Errorf("BYDAY: %q non valido, il numero deve essere tra -5 e 5 (escluso 0)", item)
+			}
+			d.N = n
+		}
+		days = append(days, d)
+	}
+	return days, nil
+}
+
+// parseUntil accetta una data e ora UTC (20250601T180000Z) oppure una
+// data sola (20250601), che vale fino alla fine di quel giorno.
+func parseUntil(value string) (*time.Time, error) {
+	if t, err := time.Parse(untilLayout, value); err == nil {
+		return &t, nil
+	}
+	if t, err := time.Parse("20060102", value); err == nil {
+		t = t.Add(24*time.Hour - time.Second)
+		return &t, nil
+	}
+	return nil, fmt.Errorf("UNTIL=%s non valido: usare AAAAMMGG oppure AAAAMMGGThhmmssZ (in UTC)", value)
+}
+
+// String restituisce la regola in forma canonica, con le parti sempre
+// nello stesso ordine e senza i valori di default.
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, d := range r.ByDay {
+			days[i] = d.String()
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if r.Until != nil {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(untilLayout))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Next calcola l'occorrenza successiva a prev, che deve essere a sua volta
+// un'occorrenza della serie (o il suo inizio), nel fuso di prev: l'ora del
+// giorno in quel fuso resta la stessa, anche dopo un cambio dell'ora
+// legale se prev ha un fuso vero. I todo salvati dallo store hanno solo
+// l'offset (vedi due_offset), quindi per loro l'ora segue l'offset fisso
+// e non l'ora legale. Restituisce anche la regola per il resto della
+// serie, con COUNT diminuito di uno; ok è false se la serie è finita.
+func (r Rule) Next(prev time.Time) (next time.Time, rest Rule, ok bool) {
+	if r.Count == 1 {
+		return time.Time{}, Rule{}, false
+	}
+	// un intervallo mensile può saltare i mesi senza il giorno cercato (il
+	// 31, il quinto lunedì...): cinque anni per intervallo bastano e avanzano
+	limit := 5 * 366 * r.Interval
+	for days := 1; days <= limit; days++ {
+		candidate := prev.AddDate(0, 0, days)
+		if r.Until != nil && candidate.After(*r.Until) {
+			break
+		}
+		if r.matches(prev, candidate) {
+			rest = r
+			if rest.Count > 0 {
+				rest.Count--
+			}
+			return candidate, rest, true
+		}
+	}
+	return time.Time{}, Rule{}, false
+}
+
+// matches dice se il giorno di t è un'occorrenza, prendendo prev come
+// riferimento per INTERVAL e, senza BYDAY, per il giorno.
+func (r Rule) matches(prev, t time.Time) bool {
+	switch r.Freq {
+	case Daily:
+		days := int(dayOf(t).Sub(dayOf(prev)).Hours() / 24)
+		return days%r.Interval == 0 && (len(r.ByDay) == 0 || r.hasWeekday(t))
+	case Weekly:
+		weeks := int(dayOf(startOfWeek(t)).Sub(dayOf(startOfWeek(prev))).Hours() / (24 * 7))
+		if weeks%r.Interval != 0 {
+			return false
+		}
+		if len(r.ByDay) == 0 {
+			return t.Weekday() == prev.Weekday()
+		}
+		return r.hasWeekday(t)
+	case Monthly:
+		months := (t.Year()-prev.Year())*12 + int(t.Month()) - int(prev.Month())
+		if months%r.Interval != 0 {
+			return false
+		}
+		if len(r.ByDay) == 0 {
+			// i mesi senza quel giorno (il 31 di aprile) si saltano, come
+			// prevede RFC 5545
+			return t.Day() == prev.Day()
+		}
+		return r.hasMonthDay(t)
+	}
+	return false
+}
+
+func (r Rule) hasWeekday(t time.Time) bool {
+	for _, d := range r.ByDay {
+		if d.Weekday == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// hasMonthDay controlla BYDAY con FREQ=MONTHLY, numero compreso.
+func (r Rule) hasMonthDay(t time.Time) bool {
+	daysInMonth := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	fromStart := (t.Day()-1)/7 + 1            // 1 = il primo di quel giorno nel mese
+	fromEnd := -((daysInMonth-t.Day())/7 + 1) // -1 = l'ultimo
+	for _, d := range r.ByDay {
+		if d.Weekday == t.Weekday() && (d.N == 0 || d.N == fromStart || d.N == fromEnd) {
+			return true
+		}
+	}
+	return false
+}
+
+// startOfWeek è il lunedì della settimana di t (WKST=MO, il default di
+// RFC 5545), alla stessa ora.
+func startOfWeek(t time.Time) time.Time {
+	return t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+}
+
+// dayOf è la data di t, nel suo fuso, come mezzanotte UTC: la differenza
+// tra due date è sempre un numero intero di giorni, anche se in mezzo c'è
+// un cambio dell'ora legale.
+func dayOf(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
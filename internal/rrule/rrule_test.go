@@ -0,0 +1,133 @@
+package rrule
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // per Europe/Rome anche senza i fusi del sistema
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	valid := map[string]string{
+		"FREQ=DAILY":                                "FREQ=DAILY",
+		"rrule:freq=weekly;interval=1":              "FREQ=WEEKLY",
+		"FREQ=WEEKLY;BYDAY=MO,TH;INTERVAL=2;":       "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH",
+		"FREQ=MONTHLY;BYDAY=-1FR;COUNT=3":           "FREQ=MONTHLY;BYDAY=-1FR;COUNT=3",
+		"FREQ=DAILY;UNTIL=20250601":                 "FREQ=DAILY;UNTIL=20250601T235959Z",
+		"FREQ=DAILY;UNTIL=20250601T080000Z":         "FREQ=DAILY;UNTIL=20250601T080000Z",
+		"FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR;COUNT=100": "FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR;COUNT=100",
+	}
+	for in, want := range valid {
+		r, err := Parse(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, r.String(), in)
+	}
+
+	for _, in := range []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=YEARLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;INTERVAL=1000",
+		"FREQ=DAILY;COUNT=0",
+		"FREQ=DAILY;COUNT=2;UNTIL=20250601",
+		"FREQ=DAILY;FREQ=WEEKLY",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=MONTHLY;BYDAY=6MO",
+		"FREQ=DAILY;BYHOUR=9",
+		"FREQ=DAILY;UNTIL=domani",
+		"FREQ",
+	} {
+		_, err := Parse(in)
+		assert.Error(t, err, in)
+	}
+}
+
+// occurrences restituisce le prime n occorrenze dopo start.
+func occurrences(t *testing.T, rule string, start time.Time, n int) []string {
+	r, err := Parse(rule)
+	require.NoError(t, err)
+	var out []string
+	prev := start
+	for range n {
+		next, rest, ok := r.Next(prev)
+		if !ok {
+			break
+		}
+		out = append(out, next.Format("2006-01-02 Mon"))
+		prev, r = next, rest
+	}
+	return out
+}
+
+func TestNext(t *testing.T) {
+	// lunedì 6 gennaio 2025, alle 9 UTC
+	start := time.Date(2025, 1, 6, 9, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, []string{"2025-01-09 Thu", "2025-01-12 Sun", "2025-01-15 Wed"},
+		occurrences(t, "FREQ=DAILY;INTERVAL=3", start, 3))
+	assert.Equal(t, []string{"2025-01-07 Tue", "2025-01-08 Wed", "2025-01-09 Thu", "2025-01-10 Fri", "2025-01-13 Mon"},
+		occurrences(t, "FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR", start, 5))
+	assert.Equal(t, []string{"2025-01-13 Mon", "2025-01-20 Mon"},
+		occurrences(t, "FREQ=WEEKLY", start, 2))
+	assert.Equal(t, []string{"2025-01-09 Thu", "2025-01-20 Mon", "2025-01-23 Thu", "2025-02-03 Mon"},
+		occurrences(t, "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH", start, 4))
+	assert.Equal(t, []string{"2025-01-31 Fri", "2025-02-28 Fri", "2025-03-28 Fri"},
+		occurrences(t, "FREQ=MONTHLY;BYDAY=-1FR", start, 3))
+	assert.Equal(t, []string{"2025-02-03 Mon", "2025-03-03 Mon"},
+		occurrences(t, "FREQ=MONTHLY;BYDAY=1MO", start, 2))
+
+	// il 31 salta i mesi che non lo hanno
+	jan31 := time.Date(2025, 1, 31, 9, 0, 0, 0, time.UTC)
+	assert.Equal(t, []string{"2025-03-31 Mon", "2025-05-31 Sat", "2025-07-31 Thu"},
+		occurrences(t, "FREQ=MONTHLY", jan31, 3))
+
+	t.Run("giorni nel fuso della scadenza", func(t *testing.T) {
+		// lunedì 2 giugno alle 00:30 a Roma, in UTC è ancora domenica
+		rome := time.FixedZone("", 2*60*60)
+		monday := time.Date(2025, 6, 2, 0, 30, 0, 0, rome)
+		assert.Equal(t, []string{"2025-06-09 Mon", "2025-06-16 Mon"},
+			occurrences(t, "FREQ=WEEKLY;BYDAY=MO", monday, 2))
+		assert.Equal(t, []string{"2025-06-03 Tue", "2025-06-04 Wed"},
+			occurrences(t, "FREQ=DAILY", monday, 2))
+		assert.Equal(t, []string{"2025-07-07 Mon"},
+			occurrences(t, "FREQ=MONTHLY;BYDAY=1MO", monday, 1))
+
+		r, err := Parse("FREQ=WEEKLY;BYDAY=MO")
+		require.NoError(t, err)
+		next, _, ok := r.Next(monday)
+		require.True(t, ok)
+		assert.Equal(t, "2025-06-09T00:30:00+02:00", next.Format(time.RFC3339))
+
+		// con un fuso vero l'ora resta la stessa anche dopo il cambio
+		// dell'ora legale (30 marzo 2025)
+		loc, err := time.LoadLocation("Europe/Rome")
+		require.NoError(t, err)
+		r, err = Parse("FREQ=DAILY")
+		require.NoError(t, err)
+		next, _, ok = r.Next(time.Date(2025, 3, 29, 0, 30, 0, 0, loc))
+		require.True(t, ok)
+		assert.Equal(t, "2025-03-30T00:30:00+01:00", next.Format(time.RFC3339))
+		next, _, ok = r.Next(next)
+		require.True(t, ok)
+		assert.Equal(t, "2025-03-31T00:30:00+02:00", next.Format(time.RFC3339))
+	})
+
+	t.Run("fine della serie", func(t *testing.T) {
+		assert.Len(t, occurrences(t, "FREQ=DAILY;COUNT=3", start, 10), 2, "COUNT comprende l'occorrenza di partenza")
+		assert.Equal(t, []string{"2025-01-07 Tue", "2025-01-08 Wed"},
+			occurrences(t, "FREQ=DAILY;UNTIL=20250108", start, 10))
+
+		r, err := Parse("FREQ=DAILY;COUNT=2")
+		require.NoError(t, err)
+		next, rest, ok := r.Next(start)
+		require.True(t, ok)
+		assert.Equal(t, 9, next.Hour(), "l'ora resta la stessa")
+		assert.Equal(t, "FREQ=DAILY;COUNT=1", rest.String())
+		_, _, ok = rest.Next(next)
+		assert.False(t, ok)
+	})
+}
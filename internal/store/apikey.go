@@ -0,0 +1,178 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// APIKeyScope dice cosa può fare una API key.
+type APIKeyScope string
+
+const (
+	// ScopeReadOnly permette solo le letture (GET e HEAD).
+	ScopeReadOnly APIKeyScope = "read_only"
+	// ScopeReadWrite permette tutte le operazioni sui todo.
+	ScopeReadWrite APIKeyScope = "read_write"
+)
+
+// Valid dice se lo scope è uno di quelli previsti.
+func (s APIKeyScope) Valid() bool {
+	return s == ScopeReadOnly || s == ScopeReadWrite
+}
+
+// APIKey è una chiave di accesso per i client non interattivi. Il valore
+// della chiave non viene mai salvato: solo il suo hash.
+type APIKey struct {
+	ID         int         `json:"id"`
+	Name       string      `json:"name"`
+	Prefix     string      `json:"prefix"`
+	Scope      APIKeyScope `json:"scope"`
+	CreatedAt  time.Time   `json:"created_at"`
+	LastUsedAt *time.Time  `json:"last_used_at"`
+	RevokedAt  *time.Time  `json:"revoked_at,omitempty"`
+
+	OwnerID int    `json:"-"`
+	KeyHash string `json:"-"`
+}
+
+// Revoked dice se la chiave è stata revocata.
+func (k APIKey) Revoked() bool {
+	return k.RevokedAt != nil
+}
+
+// APIKeyRepository gestisce le API key. Come per i todo, i metodi lavorano
+// sulle chiavi dell'utente nel contesto, tranne GetAPIKeyByHash e
+// TouchAPIKey che servono ad autenticare la richiesta e quindi vengono
+// chiamati prima di sapere chi è l'utente.
+type APIKeyRepository interface {
+	// CreateAPIKey salva key (Name, Prefix, KeyHash e Scope) per l'utente
+	// nel contesto.
+	CreateAPIKey(ctx context.Context, key APIKey) (APIKey, error)
+	// ListAPIKeys restituisce tutte le chiavi dell'utente, revocate comprese.
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	// RevokeAPIKey revoca la chiave; revocarla di nuovo non è un errore.
+	RevokeAPIKey(ctx context.Context, ID int) (APIKey, error)
+
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (APIKey, error)
+	// TouchAPIKey aggiorna la data di ultimo utilizzo.
+	TouchAPIKey(ctx context.Context, ID int, at time.Time) error
+}
+
+func (k APIKey) validate() error {
+	if k.Name == "" {
+		return invalidf("il nome della chiave non può essere vuoto")
+	}
+	if len(k.Name) > 100 {
+		return invalidf("il nome della chiave può avere al massimo 100 caratteri")
+	}
+	if !k.Scope.Valid() {
+		return invalidf("scope %q non valido (valori ammessi: %s, %s)", k.Scope, ScopeReadOnly, ScopeReadWrite)
+	}
+	if k.KeyHash == "" || k.Prefix == "" {
+		return invalidf("hash e prefisso della chiave sono obbligatori")
+	}
+	return nil
+}
+
+// --- implementazione SQL ---
+
+const apiKeyColumns = "id, owner_id, name, prefix, key_hash, scope, created_at, last_used_at, revoked_at"
+
+func scanAPIKey(row scanner) (APIKey, error) {
+	var k APIKey
+	var createdAt string
+	var lastUsed, revoked *string
+	if err := row.Scan(&k.ID, &k.OwnerID, &k.Name, &k.Prefix, &k.KeyHash, &k.Scope, &createdAt, &lastUsed, &revoked); err != nil {
+		return APIKey{}, err
+	}
+	created, err := parseDBTime(&createdAt)
+	if err != nil {
+		return APIKey{}, err
+	}
+	k.CreatedAt = *created
+	if k.LastUsedAt, err = parseDBTime(lastUsed); err != nil {
+		return APIKey{}, err
+	}
+	if k.RevokedAt, err = parseDBTime(revoked); err != nil {
+		return APIKey{}, err
+	}
+	return k, nil
+}
+
+func (s *Store) CreateAPIKey(ctx context.Context, key APIKey) (APIKey, error) {
+	if err := key.validate(); err != nil {
+		return APIKey{}, err
+	}
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return APIKey{}, err
+	}
+	key.OwnerID = owner
+	key.CreatedAt = now()
+	key.LastUsedAt, key.RevokedAt = nil, nil
+
+	query := "INSERT INTO api_keys (owner_id, name, prefix, key_hash, scope, created_at) VALUES (?, ?, ?, ?, ?, ?) RETURNING id"
+	err = s.conn().QueryRowContext(ctx, query, owner, key.Name, key.Prefix, key.KeyHash, key.Scope, formatDBTime(&key.CreatedAt)).Scan(&key.ID)
+	if err != nil {
+		return APIKey{}, dbError("errore nella creazione della API key", err)
+	}
+	return key, nil
+}
+
+func (s *Store) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.conn().QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE owner_id = ? ORDER BY id", owner)
+	if err != nil {
+		return nil, dbError("errore nella lettura delle API key", err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, dbError("errore nello scan di una API key", err)
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError("errore durante l'iterazione delle API key", err)
+	}
+	return keys, nil
+}
+
+func (s *Store) RevokeAPIKey(ctx context.Context, ID int) (APIKey, error) {
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return APIKey{}, err
+	}
+	// COALESCE: se era già revocata teniamo la data originale
+	query := "UPDATE api_keys SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ? AND owner_id = ? RETURNING " + apiKeyColumns
+	key, err := scanAPIKey(s.conn().QueryRowContext(ctx, query, formatDBTime(ptr(now())), ID, owner))
+	if err != nil {
+		return APIKey{}, dbError(fmt.Sprintf("API key %d", ID), err)
+	}
+	return key, nil
+}
+
+func (s *Store) GetAPIKeyByHash(ctx context.Context, keyHash string) (APIKey, error) {
+	key, err := scanAPIKey(s.conn().QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = ?", keyHash))
+	if err != nil {
+		return APIKey{}, dbError("API key", err)
+	}
+	return key, nil
+}
+
+func (s *Store) TouchAPIKey(ctx context.Context, ID int, at time.Time) error {
+	_, err := s.conn().ExecContext(ctx, "UPDATE api_keys SET last_used_at = ? WHERE id = ?", formatDBTime(&at), ID)
+	return dbError(fmt.Sprintf("errore nell'aggiornamento della API key %d", ID), err)
+}
+
+// ptr restituisce un puntatore a una copia di v.
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Errori restituiti da tutti i backend. I chiamanti li riconoscono con
+// errors.Is, senza dover conoscere il driver che c'è sotto: l'errore
+// originale resta comunque nella catena, utile per i log.
+var (
+	// ErrNotFound: l'elemento richiesto non esiste.
+	ErrNotFound = errors.New("elemento non trovato")
+	// ErrConflict: l'operazione contrasta con lo stato attuale dei dati
+	// (versione cambiata, vincolo di unicità violato...).
+	ErrConflict = errors.New("conflitto con lo stato attuale")
+	// ErrInvalid: i dati o le opzioni passati allo store non sono validi.
+	ErrInvalid = errors.New("dati non validi")
+	// ErrUnavailable: l'archivio non è raggiungibile in questo momento
+	// (database bloccato, disco pieno o in sola lettura...). Riprovare
+	// più tardi può funzionare.
+	ErrUnavailable = errors.New("archivio non disponibile")
+)
+
+// invalidf crea un errore di validazione che soddisfa errors.Is(err, ErrInvalid).
+func invalidf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalid, fmt.Sprintf(format, args...))
+}
+
+// dbError traduce un errore di database/sql o di SQLite in uno degli
+// errori dello store, aggiungendo il contesto op. Gli errori che non
+// rientrano in nessuna categoria restano solo avvolti.
+func dbError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if kind := classify(err); kind != nil {
+		return fmt.Errorf("%s: %w: %w", op, kind, err)
+	}
+	return fmt.Errorf("%s: %w", op, err)
+}
+
+func classify(err error) error {
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrConflict),
+		errors.Is(err, ErrInvalid), errors.Is(err, ErrUnavailable):
+		return nil // già classificato
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrNotFound
+	case errors.Is(err, sql.ErrConnDone), errors.Is(err, context.DeadlineExceeded):
+		return ErrUnavailable
+	}
+
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return nil
+	}
+	switch sqliteErr.Code {
+	case sqlite3.ErrBusy, sqlite3.ErrLocked, sqlite3.ErrCantOpen, sqlite3.ErrIoErr,
+		sqlite3.ErrFull, sqlite3.ErrReadonly, sqlite3.ErrNotADB, sqlite3.ErrCorrupt:
+		return ErrUnavailable
+	case sqlite3.ErrConstraint:
+		switch sqliteErr.ExtendedCode {
+		case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+			return ErrConflict
+		default: // CHECK, NOT NULL, FOREIGN KEY...
+			return ErrInvalid
+		}
+	}
+	return nil
+}
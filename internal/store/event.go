@@ -0,0 +1,292 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// EventAction è il tipo di modifica registrata in un Event.
+type EventAction string
+
+const (
+	EventCreated  EventAction = "created"
+	EventUpdated  EventAction = "updated"  // campi, stato o tag
+	EventDeleted  EventAction = "deleted"  // spostato nel cestino
+	EventRestored EventAction = "restored" // uscito dal cestino
+	EventPurged   EventAction = "purged"   // eliminato definitivamente
+)
+
+// Event è una voce del registro delle modifiche di un todo. Il registro è
+// in sola aggiunta e viene scritto insieme alla modifica: se questa
+// fallisce, l'evento non resta. Le modifiche fatte di riflesso (la
+// versione del genitore, le sottoattività che salgono al primo livello
+// quando il genitore viene eliminato...) non generano eventi.
+type Event struct {
+	ID     int         `json:"id"`
+	TodoID int         `json:"todo_id"`
+	Action EventAction `json:"action"`
+	// ActorID è l'utente che ha fatto la modifica; nil per quelle fatte
+	// dal server, come lo svuotamento del cestino.
+	ActorID *int `json:"actor_id"`
+	// RequestID è l'ID della richiesta HTTP (l'header X-Request-Id), per
+	// ritrovarla nei log.
+	RequestID string `json:"request_id,omitempty"`
+	// Changed sono i campi che la modifica ha cambiato (version esclusa);
+	// vuoto per creazioni ed eliminazioni definitive.
+	Changed []string `json:"changed,omitempty"`
+	// Before e After sono il todo prima e dopo la modifica, nel formato
+	// dell'API; null se prima non esisteva o dopo non esiste più.
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	CreatedAt time.Time       `json:"created_at"`
+	OwnerID   int             `json:"-"`
+}
+
+// EventRepository legge il registro delle modifiche dei todo dell'utente
+// nel contesto; gli eventi li scrivono i metodi degli altri repository.
+type EventRepository interface {
+	// GetHistory restituisce gli eventi del todo, dal più vecchio. Funziona
+	// anche per i todo nel cestino o già eliminati; ErrNotFound se per
+	// l'utente il todo non è mai esistito.
+	GetHistory(ctx context.Context, todoID int) ([]Event, error)
+}
+
+// EventFeed legge il registro di tutti gli utenti, dal più vecchio, per
+// chi lo inoltra in tempo reale (lo stream di GET /todos/events). Come
+// Purge non guarda l'utente nel contesto: gli eventi vanno smistati da chi
+// li legge, con OwnerID.
+type EventFeed interface {
+	// EventsAfter restituisce al massimo limit eventi con ID maggiore di
+	// afterID. Gli ID sono crescenti nell'ordine in cui le modifiche sono
+	// state confermate, quindi basta ricordare l'ultimo letto.
+	EventsAfter(ctx context.Context, afterID, limit int) ([]Event, error)
+	// LastEventID è l'ID dell'evento più recente; 0 se il registro è vuoto.
+	LastEventID(ctx context.Context) (int, error)
+}
+
+type requestIDKey struct{}
+
+// WithRequestID restituisce un contesto in cui le modifiche vengono
+// registrate con l'ID della richiesta HTTP. Lo imposta il middleware di
+// autenticazione, insieme all'utente.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// newEvent prepara l'evento di una modifica da before ad after (nil se il
+// todo non esisteva o non esiste più), con l'utente e la richiesta del
+// contesto.
+func newEvent(ctx context.Context, action EventAction, before, after *Todo) Event {
+	e := Event{Action: action, CreatedAt: now()}
+	for _, t := range []*Todo{before, after} {
+		if t != nil {
+			e.TodoID, e.OwnerID = t.ID, t.OwnerID
+		}
+	}
+	if actor, ok := OwnerFromContext(ctx); ok {
+		e.ActorID = &actor
+	}
+	e.RequestID, _ = ctx.Value(requestIDKey{}).(string)
+	e.Before, e.After = snapshot(before), snapshot(after)
+	return e
+}
+
+// snapshot è il todo in JSON, senza l'avanzamento: dipende dalle
+// sottoattività e non fa parte della modifica.
+func snapshot(t *Todo) json.RawMessage {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	c.Progress = nil
+	data, err := json.Marshal(c)
+	if err != nil {
+		// Todo contiene solo tipi che si codificano sempre
+		panic(fmt.Sprintf("codifica del todo %d: %v", t.ID, err))
+	}
+	return data
+}
+
+// withChanges calcola Changed confrontando i campi di Before e After.
+func (e Event) withChanges() Event {
+	var before, after map[string]json.RawMessage
+	if json.Unmarshal(e.Before, &before) != nil || json.Unmarshal(e.After, &after) != nil || before == nil || after == nil {
+		return e
+	}
+	for name, value := range after {
+		if name != "version" && !bytes.Equal(before[name], value) {
+			e.Changed = append(e.Changed, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			e.Changed = append(e.Changed, name)
+		}
+	}
+	sort.Strings(e.Changed)
+	return e
+}
+
+// --- implementazione SQL ---
+
+const eventColumns = "id, todo_id, owner_id, action, actor_id, request_id, before, after, created_at"
+
+// recordEvent scrive l'evento nella transazione della modifica.
+func recordEvent(ctx context.Context, q querier, action EventAction, before, after *Todo) error {
+	e := newEvent(ctx, action, before, after)
+	query := "INSERT INTO todo_events (todo_id, owner_id, action, actor_id, request_id, before, after, created_at) VALUES (?,?,?,?,?,?,?,?)"
+	_, err := q.ExecContext(ctx, query, e.TodoID, e.OwnerID, e.Action, e.ActorID, e.RequestID,
+		nullJSON(e.Before), nullJSON(e.After), formatDBTime(&e.CreatedAt))
+	if err != nil {
+		return dbError("errore nella scrittura del registro delle modifiche", err)
+	}
+	return nil
+}
+
+// nullJSON salva i documenti assenti come NULL.
+func nullJSON(data json.RawMessage) any {
+	if data == nil {
+		return nil
+	}
+	return string(data)
+}
+
+func (s *Store) GetHistory(ctx context.Context, todoID int) ([]Event, error) {
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := "SELECT " + eventColumns + " FROM todo_events WHERE todo_id = ? AND owner_id = ? ORDER BY id"
+	events, err := queryEvents(ctx, s.conn(), query, todoID, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(events) == 0 {
+		// un todo creato prima del registro non ha eventi, ma esiste
+		var n int
+		query := "SELECT COUNT(*) FROM todos WHERE id = ? AND owner_id = ?"
+		if err := s.conn().QueryRowContext(ctx, query, todoID, owner).Scan(&n); err != nil {
+			return nil, dbError(fmt.Sprintf("todo %d", todoID), err)
+		}
+		if n == 0 {
+			return nil, notFound(todoID)
+		}
+	}
+	return events, nil
+}
+
+func (s *Store) EventsAfter(ctx context.Context, afterID, limit int) ([]Event, error) {
+	query := "SELECT " + eventColumns + " FROM todo_events WHERE id > ? ORDER BY id LIMIT ?"
+	return queryEvents(ctx, s.conn(), query, afterID, limit)
+}
+
+func (s *Store) LastEventID(ctx context.Context) (int, error) {
+	var id int
+	if err := s.conn().QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM todo_events").Scan(&id); err != nil {
+		return 0, dbError("errore nella lettura del registro", err)
+	}
+	return id, nil
+}
+
+// queryEvents esegue una SELECT di eventColumns e calcola Changed.
+func queryEvents(ctx context.Context, q querier, query string, args ...any) ([]Event, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, dbError("errore nella lettura del registro", err)
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var e Event
+		var before, after *string
+		var createdAt string
+		if err := rows.Scan(&e.ID, &e.TodoID, &e.OwnerID, &e.Action, &e.ActorID, &e.RequestID, &before, &after, &createdAt); err != nil {
+			return nil, dbError("errore nello scan di un evento", err)
+		}
+		if before != nil {
+			e.Before = json.RawMessage(*before)
+		}
+		if after != nil {
+			e.After = json.RawMessage(*after)
+		}
+		at, err := parseDBTime(&createdAt)
+		if err != nil {
+			return nil, dbError("errore nello scan di un evento", err)
+		}
+		e.CreatedAt = *at
+		events = append(events, e.withChanges())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError("errore durante l'iterazione del registro", err)
+	}
+	return events, nil
+}
+
+// --- implementazione in memoria ---
+
+func (s *MemoryStore) GetHistory(ctx context.Context, todoID int) ([]Event, error) {
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := []Event{}
+	for _, e := range s.events {
+		if e.TodoID == todoID && e.OwnerID == owner {
+			events = append(events, e.withChanges())
+		}
+	}
+	if t, ok := s.todos[todoID]; len(events) == 0 && (!ok || t.OwnerID != owner) {
+		return nil, notFound(todoID)
+	}
+	return events, nil
+}
+
+func (s *MemoryStore) EventsAfter(ctx context.Context, afterID, limit int) ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// gli ID del registro sono crescenti: cerchiamo il primo dopo afterID
+	i := sort.Search(len(s.events), func(i int) bool { return s.events[i].ID > afterID })
+	events := []Event{}
+	for _, e := range s.events[i:min(i+limit, len(s.events))] {
+		events = append(events, e.withChanges())
+	}
+	return events, nil
+}
+
+func (s *MemoryStore) LastEventID(ctx context.Context) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if n := len(s.events); n > 0 {
+		return s.events[n-1].ID, nil
+	}
+	return 0, nil
+}
+
+// record aggiunge l'evento al registro. Se il salvataggio fallisce il
+// chiamante lo toglie con rollbackEvents. PRESUPPONE il lock già acquisito.
+func (s *MemoryStore) record(ctx context.Context, action EventAction, before, after *Todo) {
+	e := newEvent(ctx, action, before, after)
+	e.ID = 1
+	if n := len(s.events); n > 0 {
+		e.ID = s.events[n-1].ID + 1
+	}
+	s.events = append(s.events, e)
+}
+
+// rollbackEvents toglie gli eventi registrati dopo che il registro aveva
+// n voci. PRESUPPONE il lock già acquisito.
+func (s *MemoryStore) rollbackEvents(n int) {
+	s.events = s.events[:n]
+}
@@ -0,0 +1,295 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// JSONStore è il vecchio store basato su file JSON: tiene i dati in memoria
+// (riusando MemoryStore) e riscrive l'intero file dopo ogni modifica.
+type JSONStore struct {
+	*MemoryStore
+	filePath string
+}
+
+// fileTodo è il formato di un todo su disco. Completed è letto come
+// json.RawMessage perché nei file scritti dalle versioni precedenti era una
+// stringa libera ("not completed"), mentre oggi è un booleano.
+type fileTodo struct {
+	ID               int             `json:"id"`
+	Title            string          `json:"title"`
+	Description      string          `json:"description,omitempty"`
+	Status           Status          `json:"status,omitempty"`
+	Priority         Priority        `json:"priority,omitempty"`
+	DueAt            *time.Time      `json:"due_at,omitempty"`
+	Recurrence       string          `json:"recurrence,omitempty"`
+	NextOccurrenceID *int            `json:"next_occurrence_id,omitempty"`
+	Completed        json.RawMessage `json:"completed,omitempty"`
+	CompletedAt      *time.Time      `json:"completed_at,omitempty"`
+	DeletedAt        *time.Time      `json:"deleted_at,omitempty"`
+	Version          int             `json:"version,omitempty"`
+	OwnerID          int             `json:"owner_id,omitempty"`
+	ListID           *int            `json:"list_id,omitempty"`
+	ParentID         *int            `json:"parent_id,omitempty"`
+	Tags             []string        `json:"tags,omitempty"`
+	LegacyCompleted  string          `json:"legacy_completed,omitempty"`
+}
+
+// fileData è il contenuto del file. Le versioni precedenti salvavano solo
+// l'array dei todo: load accetta anche quel formato.
+type fileData struct {
+	Users   []fileUser   `json:"users"`
+	APIKeys []fileAPIKey `json:"api_keys"`
+	Lists   []fileList   `json:"lists"`
+	Todos   []fileTodo   `json:"todos"`
+	Events  []fileEvent  `json:"events,omitempty"`
+}
+
+// fileList è una lista su disco: il numero di todo non si salva, si ricalcola.
+type fileList struct {
+	ID        int       `json:"id"`
+	OwnerID   int       `json:"owner_id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// fileAPIKey è una API key su disco, con i campi che l'API non espone.
+type fileAPIKey struct {
+	APIKey
+	OwnerID int    `json:"owner_id"`
+	KeyHash string `json:"key_hash"`
+}
+
+// fileEvent è una voce del registro su disco, con il proprietario del todo.
+type fileEvent struct {
+	Event
+	OwnerID int `json:"owner_id"`
+}
+
+// fileUser è un utente su disco: a differenza della risposta dell'API
+// contiene anche l'hash della password.
+type fileUser struct {
+	User
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+// toTodo converte un record del file, interpretando il formato legacy
+// come fa la migrazione SQL 0002_status.
+func (f fileTodo) toTodo() (Todo, error) {
+	t := Todo{
+		ID:               f.ID,
+		Title:            f.Title,
+		Description:      f.Description,
+		Status:           f.Status,
+		CompletedAt:      f.CompletedAt,
+		DeletedAt:        f.DeletedAt,
+		Version:          f.Version,
+		OwnerID:          f.OwnerID,
+		ListID:           f.ListID,
+		ParentID:         f.ParentID,
+		NextOccurrenceID: f.NextOccurrenceID,
+		Tags:             []string{},
+		legacyCompleted:  f.LegacyCompleted,
+	}
+	for _, name := range f.Tags {
+		// i file scritti prima che gli spazi fossero vietati: come nella
+		// migrazione 0015_tag_spaces, diventano trattini
+		tag, err := NormalizeTag(strings.ReplaceAll(strings.TrimSpace(name), " ", "-"))
+		if err != nil {
+			return Todo{}, fmt.Errorf("todo %d: %w", f.ID, err)
+		}
+		if !slices.Contains(t.Tags, tag) {
+			t.Tags = append(t.Tags, tag)
+		}
+	}
+	slices.Sort(t.Tags)
+	if t.Version == 0 {
+		t.Version = 1 // i file legacy non hanno la versione
+	}
+	if t.OwnerID == 0 {
+		t.OwnerID = DefaultUserID // né il proprietario
+	}
+	t.setSchedule(f.Priority, f.DueAt, f.Recurrence)
+	if !t.Priority.Valid() {
+		return Todo{}, fmt.Errorf("todo %d: priorità %q non valida", f.ID, t.Priority)
+	}
+	if t.Status == "" {
+		var legacy string
+		if err := json.Unmarshal(f.Completed, &legacy); err != nil {
+			return Todo{}, fmt.Errorf("todo %d: né status né completed legacy validi", f.ID)
+		}
+		t.Status = LegacyStatus(legacy)
+		t.legacyCompleted = legacy
+	}
+	if !t.Status.Valid() {
+		return Todo{}, fmt.Errorf("todo %d: stato %q non valido", f.ID, t.Status)
+	}
+	t.Completed = t.Status == StatusDone
+	return t, nil
+}
+
+func newFileTodo(t Todo) fileTodo {
+	completed, _ := json.Marshal(t.Completed)
+	return fileTodo{
+		ID:               t.ID,
+		Title:            t.Title,
+		Description:      t.Description,
+		Status:           t.Status,
+		Priority:         t.Priority,
+		DueAt:            t.DueAt,
+		Recurrence:       t.Recurrence,
+		NextOccurrenceID: t.NextOccurrenceID,
+		Completed:        completed,
+		CompletedAt:      t.CompletedAt,
+		DeletedAt:        t.DeletedAt,
+		Version:          t.Version,
+		OwnerID:          t.OwnerID,
+		ListID:           t.ListID,
+		ParentID:         t.ParentID,
+		Tags:             t.Tags,
+		LegacyCompleted:  t.legacyCompleted,
+	}
+}
+
+// NewJSONStore crea lo store e carica i dati da filePath, se esiste.
+func NewJSONStore(filePath string) (*JSONStore, error) {
+	s := &JSONStore{
+		MemoryStore: NewMemoryStore(),
+		filePath:    filePath,
+	}
+	s.persist = s.saveInternal
+	return s, s.load()
+}
+
+// carica i dati dal file json
+func (s *JSONStore) load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil //il file non esiste e va bene, partiamo da zero
+		}
+		return fmt.Errorf("errore nella lettura di %s: %w", s.filePath, err) //altro errore di lettura
+	}
+
+	var content fileData
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		// formato legacy: solo i todo, tutti dell'utente di default
+		err = json.Unmarshal(data, &content.Todos)
+	} else {
+		err = json.Unmarshal(data, &content)
+	}
+	if err != nil {
+		return fmt.Errorf("errore nel parsing di %s: %w", s.filePath, err)
+	}
+
+	for _, fu := range content.Users {
+		u := fu.User
+		u.PasswordHash = fu.PasswordHash
+		s.users[u.ID] = u
+		if u.ID >= s.nextUserID {
+			s.nextUserID = u.ID + 1
+		}
+	}
+
+	for _, fk := range content.APIKeys {
+		k := fk.APIKey
+		k.OwnerID, k.KeyHash = fk.OwnerID, fk.KeyHash
+		s.apiKeys[k.ID] = k
+		if k.ID >= s.nextAPIKeyID {
+			s.nextAPIKeyID = k.ID + 1
+		}
+	}
+
+	for _, fl := range content.Lists {
+		s.lists[fl.ID] = List{ID: fl.ID, OwnerID: fl.OwnerID, Name: fl.Name, CreatedAt: fl.CreatedAt}
+		if fl.ID >= s.nextListID {
+			s.nextListID = fl.ID + 1
+		}
+	}
+
+	//popoliamo la mappa e troviamo il nextID corretto
+	for _, r := range content.Todos {
+		t, err := r.toTodo()
+		if err != nil {
+			return fmt.Errorf("errore nel parsing di %s: %w", s.filePath, err)
+		}
+		s.todos[t.ID] = t
+		if t.ID >= s.nextID {
+			s.nextID = t.ID + 1
+		}
+	}
+
+	for _, fe := range content.Events {
+		e := fe.Event
+		e.OwnerID = fe.OwnerID
+		s.events = append(s.events, e)
+	}
+	sort.Slice(s.events, func(i, j int) bool { return s.events[i].ID < s.events[j].ID })
+	return nil
+}
+
+// saveInternal fa il lavoro sporco, ma PRESUPPONE che un lock
+// sia già stato acquisito dal chiamante.
+func (s *JSONStore) saveInternal() error {
+	content := fileData{
+		Users:   make([]fileUser, 0, len(s.users)),
+		APIKeys: make([]fileAPIKey, 0, len(s.apiKeys)),
+		Lists:   make([]fileList, 0, len(s.lists)),
+		Todos:   make([]fileTodo, 0, len(s.todos)),
+	}
+	for _, u := range s.users {
+		content.Users = append(content.Users, fileUser{User: u, PasswordHash: u.PasswordHash})
+	}
+	sort.Slice(content.Users, func(i, j int) bool { return content.Users[i].ID < content.Users[j].ID })
+	for _, k := range s.apiKeys {
+		content.APIKeys = append(content.APIKeys, fileAPIKey{APIKey: k, OwnerID: k.OwnerID, KeyHash: k.KeyHash})
+	}
+	sort.Slice(content.APIKeys, func(i, j int) bool { return content.APIKeys[i].ID < content.APIKeys[j].ID })
+	for _, l := range s.lists {
+		content.Lists = append(content.Lists, fileList{ID: l.ID, OwnerID: l.OwnerID, Name: l.Name, CreatedAt: l.CreatedAt})
+	}
+	sort.Slice(content.Lists, func(i, j int) bool { return content.Lists[i].ID < content.Lists[j].ID })
+	for _, t := range s.todos {
+		content.Todos = append(content.Todos, newFileTodo(t))
+	}
+	sort.Slice(content.Todos, func(i, j int) bool { return content.Todos[i].ID < content.Todos[j].ID })
+	for _, e := range s.events {
+		content.Events = append(content.Events, fileEvent{Event: e, OwnerID: e.OwnerID})
+	}
+
+	data, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return fmt.Errorf("errore nella codifica dei todo: %w", err)
+	}
+
+	// scriviamo su un file temporaneo e poi lo rinominiamo: così un crash
+	// a metà scrittura non lascia mai un file troncato.
+	tmp, err := os.CreateTemp(filepath.Dir(s.filePath), filepath.Base(s.filePath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("errore nel salvataggio su file: %w: %w", ErrUnavailable, err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("errore nel salvataggio su file: %w: %w", ErrUnavailable, err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("errore nel salvataggio su file: %w: %w", ErrUnavailable, err)
+	}
+	if err := os.Rename(tmp.Name(), s.filePath); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("errore nel salvataggio su file: %w: %w", ErrUnavailable, err)
+	}
+	return nil
+}
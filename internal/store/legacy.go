@@ -0,0 +1,222 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// ErrJSONFile è restituito da OpenDB quando il file del database è in
+// realtà un file dello store JSON: SQLite non saprebbe leggerlo.
+var ErrJSONFile = errors.New("il file è un archivio JSON del vecchio store, non un database SQLite: convertirlo con il comando import-legacy")
+
+// IsJSONFile dice se path contiene un archivio dello store JSON (anche nel
+// formato legacy, un array di todo) invece di un database SQLite. Un file
+// che non esiste o vuoto non lo è: SQLite lo tratta come un database nuovo.
+func IsJSONFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	// basta il primo carattere significativo: un database SQLite inizia
+	// sempre con "SQLite format 3"
+	head := make([]byte, 512)
+	n, err := io.ReadFull(bufio.NewReader(f), head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	head = bytes.TrimLeft(head[:n], " \t\r\n")
+	return len(head) > 0 && (head[0] == '[' || head[0] == '{'), nil
+}
+
+// LegacyImport conta cosa ha copiato ImportJSON.
+type LegacyImport struct {
+	Users, APIKeys, Lists, Todos, Events int
+}
+
+// ImportJSON copia nel database tutto il contenuto di un archivio dello
+// store JSON (utenti, API key, liste, todo con i loro tag ed eventi),
+// conservando gli ID, poi riallinea le sequenze AUTOINCREMENT così i nuovi
+// record continuano dopo quelli importati. I file legacy, con il solo array
+// di todo e "completed" testuale, vengono letti come fa JSONStore.
+//
+// Il database deve essere vuoto (al massimo con l'utente di default, che
+// prende nome e password di quello del file): se qualcosa va storto non
+// viene importato nulla.
+func (s *Store) ImportJSON(ctx context.Context, path string) (LegacyImport, error) {
+	if _, err := os.Stat(path); err != nil {
+		return LegacyImport{}, err
+	}
+	src, err := NewJSONStore(path)
+	if err != nil {
+		return LegacyImport{}, err
+	}
+
+	var stats LegacyImport
+	err = s.WithTx(ctx, func(tx Repository) error {
+		var err error
+		stats, err = importJSON(ctx, tx.(*Store).conn(), src.MemoryStore)
+		return err
+	})
+	return stats, err
+}
+
+// importJSON fa il lavoro di ImportJSON dentro la transazione q.
+func importJSON(ctx context.Context, q querier, src *MemoryStore) (LegacyImport, error) {
+	var existing int
+	err := q.QueryRowContext(ctx, `SELECT (SELECT COUNT(*) FROM users WHERE id <> ?) + (SELECT COUNT(*) FROM api_keys) +
+		(SELECT COUNT(*) FROM lists) + (SELECT COUNT(*) FROM todos) + (SELECT COUNT(*) FROM todo_events)`, DefaultUserID).Scan(&existing)
+	if err != nil {
+		return LegacyImport{}, dbError("errore nel controllo del database", err)
+	}
+	if existing > 0 {
+		return LegacyImport{}, fmt.Errorf("%w: il database contiene già dei dati, l'import va fatto su un database nuovo", ErrConflict)
+	}
+
+	var stats LegacyImport
+	for _, u := range sortedByID(src.users, func(u User) int { return u.ID }) {
+		query := "INSERT INTO users (id, username, created_at, password_hash) VALUES (?, ?, ?, ?)"
+		args := []any{u.ID, u.Username, formatDBTime(&u.CreatedAt), nullString(u.PasswordHash)}
+		if u.ID == DefaultUserID {
+			query = "UPDATE users SET username = ?, created_at = ?, password_hash = ? WHERE id = ?"
+			args = append(args[1:], u.ID)
+		}
+		if _, err := q.ExecContext(ctx, query, args...); err != nil {
+			return LegacyImport{}, dbError(fmt.Sprintf("utente %d", u.ID), err)
+		}
+		stats.Users++
+	}
+
+	for _, k := range sortedByID(src.apiKeys, func(k APIKey) int { return k.ID }) {
+		query := `INSERT INTO api_keys (id, owner_id, name, prefix, key_hash, scope, created_at, last_used_at, revoked_at)
+			VALUES (?,?,?,?,?,?,?,?,?)`
+		_, err := q.ExecContext(ctx, query, k.ID, k.OwnerID, k.Name, k.Prefix, k.KeyHash, k.Scope,
+			formatDBTime(&k.CreatedAt), formatDBTime(k.LastUsedAt), formatDBTime(k.RevokedAt))
+		if err != nil {
+			return LegacyImport{}, dbError(fmt.Sprintf("API key %d", k.ID), err)
+		}
+		stats.APIKeys++
+	}
+
+	for _, l := range sortedByID(src.lists, func(l List) int { return l.ID }) {
+		query := "INSERT INTO lists (id, owner_id, name, created_at) VALUES (?, ?, ?, ?)"
+		if _, err := q.ExecContext(ctx, query, l.ID, l.OwnerID, l.Name, formatDBTime(&l.CreatedAt)); err != nil {
+			return LegacyImport{}, dbError(fmt.Sprintf("lista %d", l.ID), err)
+		}
+		stats.Lists++
+	}
+
+	todos := sortedByID(src.todos, func(t Todo) int { return t.ID })
+	for _, t := range todos {
+		query := `INSERT INTO todos (id, owner_id, list_id, parent_id, title, description, status, priority, due_at,
+			due_offset, recurrence, next_occurrence_id, completed_at, deleted_at, version, legacy_completed)
+			VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`
+		_, err := q.ExecContext(ctx, query, t.ID, t.OwnerID, t.ListID, t.ParentID, t.Title, t.Description, t.Status,
+			t.Priority, formatDBTime(t.DueAt), dueOffset(t.DueAt), t.Recurrence, t.NextOccurrenceID, formatDBTime(t.CompletedAt),
+			formatDBTime(t.DeletedAt), t.Version, nullString(t.legacyCompleted))
+		if err != nil {
+			return LegacyImport{}, dbError(fmt.Sprintf("todo %d", t.ID), err)
+		}
+		for _, tag := range t.Tags {
+			var tagID int
+			err := q.QueryRowContext(ctx, `INSERT INTO tags (owner_id, name) VALUES (?, ?)
+				ON CONFLICT (owner_id, name) DO UPDATE SET name = excluded.name RETURNING id`, t.OwnerID, tag).Scan(&tagID)
+			if err != nil {
+				return LegacyImport{}, dbError(fmt.Sprintf("tag %q del todo %d", tag, t.ID), err)
+			}
+			if _, err := q.ExecContext(ctx, "INSERT INTO todo_tags (todo_id, tag_id) VALUES (?, ?)", t.ID, tagID); err != nil {
+				return LegacyImport{}, dbError(fmt.Sprintf("tag %q del todo %d", tag, t.ID), err)
+			}
+		}
+		stats.Todos++
+	}
+	// il trigger todos_parent_insert ha aumentato la versione dei genitori
+	// a ogni sottoattività inserita: rimettiamo quelle del file
+	for _, t := range todos {
+		if _, err := q.ExecContext(ctx, "UPDATE todos SET version = ? WHERE id = ?", t.Version, t.ID); err != nil {
+			return LegacyImport{}, dbError(fmt.Sprintf("todo %d", t.ID), err)
+		}
+	}
+
+	for _, e := range src.events {
+		query := `INSERT INTO todo_events (id, todo_id, owner_id, action, actor_id, request_id, before, after, created_at)
+			VALUES (?,?,?,?,?,?,?,?,?)`
+		// nel file i documenti sono indentati, recordEvent li scrive compatti
+		before, err := compactJSON(e.Before)
+		if err != nil {
+			return LegacyImport{}, fmt.Errorf("evento %d: %w", e.ID, err)
+		}
+		after, err := compactJSON(e.After)
+		if err != nil {
+			return LegacyImport{}, fmt.Errorf("evento %d: %w", e.ID, err)
+		}
+		_, err = q.ExecContext(ctx, query, e.ID, e.TodoID, e.OwnerID, e.Action, e.ActorID, e.RequestID,
+			nullJSON(before), nullJSON(after), formatDBTime(&e.CreatedAt))
+		if err != nil {
+			return LegacyImport{}, dbError(fmt.Sprintf("evento %d", e.ID), err)
+		}
+		stats.Events++
+	}
+
+	for _, table := range []string{"users", "api_keys", "lists", "todos", "todo_events"} {
+		if err := resetSequence(ctx, q, table); err != nil {
+			return LegacyImport{}, err
+		}
+	}
+	return stats, nil
+}
+
+// resetSequence porta la sequenza AUTOINCREMENT di table all'ID più alto
+// presente. sqlite_sequence non ha vincoli sul nome, quindi se la riga
+// della tabella non c'è ancora va inserita a mano.
+func resetSequence(ctx context.Context, q querier, table string) error {
+	var maxID int
+	if err := q.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM "+table).Scan(&maxID); err != nil {
+		return dbError("errore nella lettura degli ID di "+table, err)
+	}
+	result, err := q.ExecContext(ctx, "UPDATE sqlite_sequence SET seq = max(seq, ?) WHERE name = ?", maxID, table)
+	if err != nil {
+		return dbError("errore nell'aggiornamento della sequenza di "+table, err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 || maxID == 0 {
+		return nil
+	}
+	if _, err := q.ExecContext(ctx, "INSERT INTO sqlite_sequence (name, seq) VALUES (?, ?)", table, maxID); err != nil {
+		return dbError("errore nell'aggiornamento della sequenza di "+table, err)
+	}
+	return nil
+}
+
+// compactJSON riporta un documento dell'evento alla forma del database;
+// null (nessun documento) diventa NULL.
+func compactJSON(data json.RawMessage) (json.RawMessage, error) {
+	if data == nil || string(data) == "null" {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sortedByID restituisce i valori della mappa in ordine di ID.
+func sortedByID[T any](m map[int]T, id func(T) int) []T {
+	out := make([]T, 0, len(m))
+	for _, v := range m {
+		out = append(out, v)
+	}
+	sort.Slice(out, func(i, j int) bool { return id(out[i]) < id(out[j]) })
+	return out
+}
@@ -0,0 +1,461 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// List è una lista (un progetto) che raggruppa dei todo. Ogni todo sta al
+// massimo in una lista; quelli senza lista hanno ListID nil.
+type List struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	// TodoCount è il numero di todo nella lista (cestino escluso),
+	// calcolato a ogni lettura.
+	TodoCount int `json:"todo_count"`
+	OwnerID   int `json:"-"`
+}
+
+// ErrListNotEmpty è restituito da DeleteList quando la lista contiene
+// ancora dei todo e non è stata chiesta la cancellazione a cascata.
+var ErrListNotEmpty = fmt.Errorf("%w: la lista contiene ancora dei todo", ErrConflict)
+
+// ListRepository gestisce le liste dell'utente nel contesto. I nomi sono
+// unici per utente (senza distinguere le maiuscole): un doppione dà
+// ErrConflict.
+type ListRepository interface {
+	CreateList(ctx context.Context, name string) (List, error)
+	// GetLists restituisce tutte le liste dell'utente, ordinate per ID.
+	GetLists(ctx context.Context) ([]List, error)
+	GetList(ctx context.Context, ID int) (List, error)
+	RenameList(ctx context.Context, ID int, name string) (List, error)
+	// DeleteList cancella la lista. Se contiene dei todo, con cascade li
+	// sposta nel cestino, altrimenti restituisce ErrListNotEmpty. In tutti
+	// e due i casi i todo nel cestino restano lì senza lista: si possono
+	// ripristinare finché Purge non li elimina.
+	DeleteList(ctx context.Context, ID int, cascade bool) error
+}
+
+// validateListName normalizza il nome togliendo gli spazi ai lati.
+func validateListName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", invalidf("il nome della lista non può essere vuoto")
+	}
+	if utf8.RuneCountInString(name) > 100 {
+		return "", invalidf("il nome della lista può avere al massimo 100 caratteri")
+	}
+	return name, nil
+}
+
+func listNotFound(ID int) error {
+	return fmt.Errorf("lista %d: %w", ID, ErrNotFound)
+}
+
+// listNotEmpty aggiunge a ErrListNotEmpty il numero di todo.
+func listNotEmpty(ID, count int) error {
+	return fmt.Errorf("%w (lista %d, %d todo): cancellarla a cascata o spostarli prima", ErrListNotEmpty, ID, count)
+}
+
+// missingList è l'errore di un todo assegnato a una lista inesistente (o
+// di un altro utente): è un dato non valido, non una risorsa mancante.
+func missingList(ID int) error {
+	return invalidf("la lista %d non esiste", ID)
+}
+
+// --- implementazione SQL ---
+
+// il conteggio dei todo usa l'indice idx_todos_list.
+const listColumns = "id, owner_id, name, created_at, " +
+	"(SELECT COUNT(*) FROM todos WHERE todos.list_id = lists.id AND todos.deleted_at IS NULL)"
+
+func scanList(row scanner) (List, error) {
+	var l List
+	var createdAt string
+	if err := row.Scan(&l.ID, &l.OwnerID, &l.Name, &createdAt, &l.TodoCount); err != nil {
+		return List{}, err
+	}
+	t, err := parseDBTime(&createdAt)
+	if err != nil {
+		return List{}, err
+	}
+	l.CreatedAt = *t
+	return l, nil
+}
+
+// getList legge una lista di owner: quelle degli altri utenti non la trova.
+func getList(ctx context.Context, q querier, owner, ID int) (List, error) {
+	l, err := scanList(q.QueryRowContext(ctx, "SELECT "+listColumns+" FROM lists WHERE id = ? AND owner_id = ?", ID, owner))
+	if err != nil {
+		return List{}, dbError(fmt.Sprintf("lista %d", ID), err)
+	}
+	return l, nil
+}
+
+// checkList verifica che il todo possa stare nella lista listID (nil = nessuna).
+func checkList(ctx context.Context, q querier, owner int, listID *int) error {
+	if listID == nil {
+		return nil
+	}
+	var exists bool
+	err := q.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM lists WHERE id = ? AND owner_id = ?)", *listID, owner).Scan(&exists)
+	if err != nil {
+		return dbError(fmt.Sprintf("lista %d", *listID), err)
+	}
+	if !exists {
+		return missingList(*listID)
+	}
+	return nil
+}
+
+func (s *Store) CreateList(ctx context.Context, name string) (List, error) {
+	name, err := validateListName(name)
+	if err != nil {
+		return List{}, err
+	}
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return List{}, err
+	}
+	l := List{Name: name, CreatedAt: now(), OwnerID: owner}
+	err = s.conn().QueryRowContext(ctx, "INSERT INTO lists (owner_id, name, created_at) VALUES (?, ?, ?) RETURNING id",
+		owner, name, formatDBTime(&l.CreatedAt)).Scan(&l.ID)
+	if err != nil {
+		return List{}, dbError(fmt.Sprintf("errore nella creazione della lista %q", name), err)
+	}
+	return l, nil
+}
+
+func (s *Store) GetLists(ctx context.Context) ([]List, error) {
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.conn().QueryContext(ctx, "SELECT "+listColumns+" FROM lists WHERE owner_id = ? ORDER BY id", owner)
+	if err != nil {
+		return nil, dbError("errore nella lettura delle liste", err)
+	}
+	defer rows.Close()
+
+	lists := []List{}
+	for rows.Next() {
+		l, err := scanList(rows)
+		if err != nil {
+			return nil, dbError("errore nello scan di una lista", err)
+		}
+		lists = append(lists, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError("errore durante l'iterazione delle liste", err)
+	}
+	return lists, nil
+}
+
+func (s *Store) GetList(ctx context.Context, ID int) (List, error) {
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return List{}, err
+	}
+	return getList(ctx, s.conn(), owner, ID)
+}
+
+func (s *Store) RenameList(ctx context.Context, ID int, name string) (List, error) {
+	name, err := validateListName(name)
+	if err != nil {
+		return List{}, err
+	}
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return List{}, err
+	}
+	query := "UPDATE lists SET name = ? WHERE id = ? AND owner_id = ? RETURNING " + listColumns
+	l, err := scanList(s.conn().QueryRowContext(ctx, query, name, ID, owner))
+	if err != nil {
+		return List{}, dbError(fmt.Sprintf("lista %d", ID), err)
+	}
+	return l, nil
+}
+
+// DeleteList controlla e cancella nella stessa transazione, così un todo
+// aggiunto nel frattempo non resta orfano.
+func (s *Store) DeleteList(ctx context.Context, ID int, cascade bool) error {
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return dbError("errore nell'apertura della transazione", err)
+	}
+	defer tx.Rollback()
+
+	l, err := getList(ctx, tx, owner, ID)
+	if err != nil {
+		return err
+	}
+	if l.TodoCount > 0 && !cascade {
+		return listNotEmpty(ID, l.TodoCount)
+	}
+	if cascade {
+		if err := trashListTodos(ctx, tx, owner, ID); err != nil {
+			return err
+		}
+	}
+	// sono rimasti solo i todo nel cestino
+	query := "UPDATE todos SET list_id = NULL, version = version + 1 WHERE list_id = ? AND owner_id = ?"
+	if _, err := tx.ExecContext(ctx, query, ID, owner); err != nil {
+		return dbError("errore nello spostamento dei todo della lista", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM lists WHERE id = ? AND owner_id = ?", ID, owner); err != nil {
+		return dbError("errore nella cancellazione della lista", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return dbError("errore nel commit della cancellazione", err)
+	}
+	return nil
+}
+
+// trashListTodos sposta nel cestino, senza lista, i todo attivi della
+// lista ID, come farebbe Delete per ciascuno. Ogni todo è riletto subito
+// prima: il trigger todos_parent_trash può averne appena cambiato la
+// versione, se è il genitore di un todo della stessa lista.
+func trashListTodos(ctx context.Context, q querier, owner, ID int) error {
+	rows, err := q.QueryContext(ctx, "SELECT id FROM todos WHERE list_id = ? AND owner_id = ? AND deleted_at IS NULL ORDER BY id", ID, owner)
+	if err != nil {
+		return dbError("errore nella lettura dei todo della lista", err)
+	}
+	var IDs []int
+	for rows.Next() {
+		var todoID int
+		if err := rows.Scan(&todoID); err != nil {
+			rows.Close()
+			return dbError("errore nello scan di una riga", err)
+		}
+		IDs = append(IDs, todoID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return dbError("errore durante l'iterazione delle righe", err)
+	}
+
+	at := now()
+	for _, todoID := range IDs {
+		todo, err := getTodo(ctx, q, owner, todoID)
+		if err != nil {
+			return err
+		}
+		trashed := todo
+		trashed.DeletedAt = &at
+		trashed.ListID = nil
+		trashed.Version++
+		query := "UPDATE todos SET deleted_at = ?, list_id = NULL, version = ? WHERE id = ? AND owner_id = ?"
+		if _, err := q.ExecContext(ctx, query, formatDBTime(trashed.DeletedAt), trashed.Version, todoID, owner); err != nil {
+			return dbError("errore nello spostamento nel cestino", err)
+		}
+		if err := recordEvent(ctx, q, EventDeleted, &todo, &trashed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- implementazione in memoria ---
+
+func (s *MemoryStore) CreateList(ctx context.Context, name string) (List, error) {
+	name, err := validateListName(name)
+	if err != nil {
+		return List{}, err
+	}
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return List{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkListName(owner, 0, name); err != nil {
+		return List{}, err
+	}
+	l := List{ID: s.nextListID, Name: name, CreatedAt: now(), OwnerID: owner}
+	s.lists[l.ID] = l
+	s.nextListID++
+
+	if err := s.save(); err != nil {
+		delete(s.lists, l.ID)
+		s.nextListID--
+		return List{}, err
+	}
+	return l, nil
+}
+
+func (s *MemoryStore) GetLists(ctx context.Context) ([]List, error) {
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lists := []List{}
+	for _, l := range s.lists {
+		if l.OwnerID == owner {
+			lists = append(lists, s.withCount(l))
+		}
+	}
+	sort.Slice(lists, func(i, j int) bool { return lists[i].ID < lists[j].ID })
+	return lists, nil
+}
+
+func (s *MemoryStore) GetList(ctx context.Context, ID int) (List, error) {
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return List{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.getList(owner, ID)
+}
+
+func (s *MemoryStore) RenameList(ctx context.Context, ID int, name string) (List, error) {
+	name, err := validateListName(name)
+	if err != nil {
+		return List{}, err
+	}
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return List{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, err := s.getList(owner, ID)
+	if err != nil {
+		return List{}, err
+	}
+	if err := s.checkListName(owner, ID, name); err != nil {
+		return List{}, err
+	}
+	l := old
+	l.Name = name
+	s.lists[ID] = l
+	if err := s.save(); err != nil {
+		s.lists[ID] = old
+		return List{}, err
+	}
+	return l, nil
+}
+
+func (s *MemoryStore) DeleteList(ctx context.Context, ID int, cascade bool) error {
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, err := s.getList(owner, ID)
+	if err != nil {
+		return err
+	}
+	if l.TodoCount > 0 && !cascade {
+		return listNotEmpty(ID, l.TodoCount)
+	}
+
+	// i todo della lista perdono la lista e quelli ancora attivi (solo con
+	// cascade) finiscono nel cestino; in ordine di ID, così anche il
+	// registro ha un ordine prevedibile. prev tiene da parte lo stato
+	// precedente, per rimetterlo se il salvataggio fallisce.
+	var IDs []int
+	for id, t := range s.todos {
+		if t.ListID != nil && *t.ListID == ID {
+			IDs = append(IDs, id)
+		}
+	}
+	sort.Ints(IDs)
+	prev := make(map[int]Todo)
+	events := len(s.events)
+	at := now()
+	for _, id := range IDs {
+		old := s.todos[id]
+		if _, saved := prev[id]; !saved {
+			prev[id] = old
+		}
+		t := old
+		t.ListID = nil
+		t.Version++
+		if t.DeletedAt != nil {
+			s.todos[id] = t
+			continue
+		}
+		t.DeletedAt = &at
+		s.todos[id] = t
+		s.touchParents(prev, t.ParentID)
+		s.record(ctx, EventDeleted, &old, &t)
+	}
+	delete(s.lists, ID)
+
+	if err := s.save(); err != nil {
+		s.restore(prev)
+		s.rollbackEvents(events)
+		s.lists[ID] = l
+		return err
+	}
+	return nil
+}
+
+// getList restituisce la lista ID se appartiene a owner, con il conteggio
+// dei todo aggiornato. PRESUPPONE il lock già acquisito.
+func (s *MemoryStore) getList(owner, ID int) (List, error) {
+	l, ok := s.lists[ID]
+	if !ok || l.OwnerID != owner {
+		return List{}, listNotFound(ID)
+	}
+	return s.withCount(l), nil
+}
+
+// withCount calcola TodoCount. PRESUPPONE il lock già acquisito.
+func (s *MemoryStore) withCount(l List) List {
+	l.TodoCount = 0
+	for _, t := range s.todos {
+		if t.ListID != nil && *t.ListID == l.ID && t.DeletedAt == nil {
+			l.TodoCount++
+		}
+	}
+	return l
+}
+
+// checkListName rifiuta un nome già usato da un'altra lista dell'utente,
+// come il vincolo UNIQUE dello store SQL. PRESUPPONE il lock già acquisito.
+func (s *MemoryStore) checkListName(owner, ID int, name string) error {
+	for _, l := range s.lists {
+		if l.OwnerID == owner && l.ID != ID && strings.EqualFold(l.Name, name) {
+			return fmt.Errorf("%w: esiste già una lista %q", ErrConflict, l.Name)
+		}
+	}
+	return nil
+}
+
+// checkList verifica che il todo possa stare nella lista listID (nil =
+// nessuna). PRESUPPONE il lock già acquisito.
+func (s *MemoryStore) checkList(owner int, listID *int) error {
+	if listID == nil {
+		return nil
+	}
+	if l, ok := s.lists[*listID]; !ok || l.OwnerID != owner {
+		return missingList(*listID)
+	}
+	return nil
+}
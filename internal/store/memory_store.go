@@ -0,0 +1,446 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore è un'implementazione di TodoRepository che tiene tutto in RAM.
+// Non richiede cgo né accesso al disco: è ideale per i test degli handler.
+type MemoryStore struct {
+	mu sync.RWMutex //RWMutex è più performante per letture multiple
+	memoryData
+
+	// persist, se impostata, viene chiamata dopo ogni modifica con il lock
+	// già acquisito. La usa JSONStore per salvare lo stato su file.
+	persist func() error
+}
+
+// memoryData sono i dati di MemoryStore, separati dal lock così WithTx può
+// copiarli e rimetterli a posto in un colpo solo.
+type memoryData struct {
+	todos  map[int]Todo // mappa per accesso veloce tramite ID
+	nextID int
+
+	users      map[int]User
+	nextUserID int
+
+	apiKeys      map[int]APIKey
+	nextAPIKeyID int
+
+	lists      map[int]List
+	nextListID int
+
+	// events è il registro delle modifiche, in ordine di ID.
+	events []Event
+}
+
+// NewMemoryStore crea uno store in memoria vuoto, con il solo utente di
+// default (come un database appena migrato).
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{memoryData: memoryData{
+		todos:  make(map[int]Todo),
+		nextID: 1,
+		users: map[int]User{
+			DefaultUserID: {ID: DefaultUserID, Username: DefaultUsername, CreatedAt: now()},
+		},
+		nextUserID:   DefaultUserID + 1,
+		apiKeys:      make(map[int]APIKey),
+		nextAPIKeyID: 1,
+		lists:        make(map[int]List),
+		nextListID:   1,
+	}}
+}
+
+// save invoca il salvataggio, se previsto. PRESUPPONE che il lock sia già
+// stato acquisito dal chiamante.
+func (s *MemoryStore) save() error {
+	if s.persist == nil {
+		return nil
+	}
+	return s.persist()
+}
+
+// notFound è l'errore per un ID assente, con lo stesso testo dello store SQL.
+func notFound(ID int) error {
+	return fmt.Errorf("todo %d: %w", ID, ErrNotFound)
+}
+
+// GetAll applica filtri, ordinamento e paginazione come fa lo store SQL.
+func (s *MemoryStore) GetAll(ctx context.Context, opts ListOptions) (TodoPage, error) {
+	if err := opts.Validate(); err != nil {
+		return TodoPage{}, err
+	}
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return TodoPage{}, err
+	}
+
+	s.mu.RLock() //Lock in lettura, più goroutine possono leggere contemporaneamente
+	defer s.mu.RUnlock()
+
+	// solo i todo dell'utente fuori dal cestino, come fa la WHERE
+	// owner_id = ? AND deleted_at IS NULL dello store SQL
+	allTodos := make([]Todo, 0, len(s.todos))
+	for _, todo := range s.todos {
+		if todo.OwnerID == owner && todo.DeletedAt == nil {
+			allTodos = append(allTodos, todo)
+		}
+	}
+	s.withProgress(allTodos)
+	return opts.paginate(allTodos), nil
+}
+
+func (s *MemoryStore) GetByID(ctx context.Context, ID int) (Todo, error) {
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return Todo{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.get(owner, ID)
+}
+
+// get restituisce il todo ID se appartiene a owner, con Progress
+// aggiornato; i todo degli altri utenti e quelli nel cestino risultano
+// inesistenti. PRESUPPONE il lock già acquisito.
+func (s *MemoryStore) get(owner, ID int) (Todo, error) {
+	result, ok := s.todos[ID]
+	if !ok || result.OwnerID != owner || result.DeletedAt != nil {
+		return Todo{}, notFound(ID)
+	}
+	todos := []Todo{result}
+	s.withProgress(todos)
+	return todos[0], nil
+}
+
+func (s *MemoryStore) Create(ctx context.Context, input Todo) (Todo, error) {
+	newTodo, err := input.newTodo()
+	if err != nil {
+		return Todo{}, err
+	}
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return Todo{}, err
+	}
+	// Usiamo un Lock() completo perché stiamo per modificare i dati (nextID e la mappa).
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkList(owner, input.ListID); err != nil {
+		return Todo{}, err
+	}
+	if err := s.checkParent(owner, 0, input.ParentID); err != nil {
+		return Todo{}, err
+	}
+
+	//completiamo la nuova struct todo
+	newTodo.ID = s.nextID
+	newTodo.OwnerID = owner
+
+	// aggiungiamo il nuovo elemento alla mappa in memoria
+	s.todos[newTodo.ID] = newTodo
+	s.nextID++
+	prev := make(map[int]Todo)
+	s.touchParents(prev, newTodo.ParentID)
+	events := len(s.events)
+	s.record(ctx, EventCreated, nil, &newTodo)
+
+	if err := s.save(); err != nil {
+		// se il salvataggio fallisce annulliamo l'inserimento,
+		// così memoria e file restano allineati.
+		delete(s.todos, newTodo.ID)
+		s.nextID--
+		s.restore(prev)
+		s.rollbackEvents(events)
+		return Todo{}, err
+	}
+
+	return newTodo, nil
+}
+
+// Update sovrascrive i campi modificabili, esattamente come fa lo store SQL.
+func (s *MemoryStore) Update(ctx context.Context, input Todo) (Todo, error) {
+	if err := input.validate(); err != nil {
+		return Todo{}, err
+	}
+	ID := input.ID
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return Todo{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, err := s.get(owner, ID)
+	if err != nil {
+		return Todo{}, err
+	}
+
+	if err := checkVersion(input.Version, old.Version); err != nil {
+		return Todo{}, err
+	}
+	if err := s.checkList(owner, input.ListID); err != nil {
+		return Todo{}, err
+	}
+	if !sameID(old.ParentID, input.ParentID) {
+		if err := s.checkParent(owner, ID, input.ParentID); err != nil {
+			return Todo{}, err
+		}
+	}
+
+	newTodo := old
+	newTodo.apply(input, now())
+
+	prev := make(map[int]Todo)
+	events := len(s.events)
+	next, spawned := newTodo.nextOccurrence(old.Status == StatusDone)
+	if spawned {
+		next.ID = s.nextID
+		s.todos[next.ID] = next
+		s.nextID++
+		newTodo.NextOccurrenceID = &next.ID
+		s.touchParents(prev, next.ParentID)
+		s.record(ctx, EventCreated, nil, &next)
+	}
+	s.todos[ID] = newTodo
+	if !sameID(old.ParentID, newTodo.ParentID) || old.Status != newTodo.Status {
+		s.touchParents(prev, old.ParentID, newTodo.ParentID)
+	}
+	s.record(ctx, EventUpdated, &old, &newTodo)
+	if err := s.save(); err != nil {
+		s.todos[ID] = old
+		if spawned {
+			delete(s.todos, next.ID)
+			s.nextID--
+		}
+		s.restore(prev)
+		s.rollbackEvents(events)
+		return Todo{}, err
+	}
+	return newTodo, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, ID int, version int) error {
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, err := s.get(owner, ID)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(version, old.Version); err != nil {
+		return err
+	}
+
+	trashed := s.todos[ID]
+	trashed.DeletedAt = ptr(now())
+	trashed.Version++
+	s.todos[ID] = trashed
+	prev := make(map[int]Todo)
+	s.touchParents(prev, old.ParentID)
+	events := len(s.events)
+	s.record(ctx, EventDeleted, &old, &trashed)
+	if err := s.save(); err != nil {
+		s.todos[ID] = old
+		s.restore(prev)
+		s.rollbackEvents(events)
+		return err
+	}
+	return nil
+}
+
+// Close non deve rilasciare nulla: ogni modifica è già stata salvata
+// (per JSONStore) al momento della scrittura.
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func (s *MemoryStore) CreateUser(ctx context.Context, username, passwordHash string) (User, error) {
+	if err := validateUsername(username); err != nil {
+		return User{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.userByUsername(username); err == nil {
+		return User{}, fmt.Errorf("%w: lo username %q è già in uso", ErrConflict, username)
+	}
+	u := User{ID: s.nextUserID, Username: username, CreatedAt: now(), PasswordHash: passwordHash}
+	s.users[u.ID] = u
+	s.nextUserID++
+
+	if err := s.save(); err != nil {
+		delete(s.users, u.ID)
+		s.nextUserID--
+		return User{}, err
+	}
+	return u, nil
+}
+
+func (s *MemoryStore) SetPassword(ctx context.Context, ID int, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.users[ID]
+	if !ok {
+		return fmt.Errorf("utente %d: %w", ID, ErrNotFound)
+	}
+	u := old
+	u.PasswordHash = passwordHash
+	s.users[ID] = u
+	if err := s.save(); err != nil {
+		s.users[ID] = old
+		return err
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetUser(ctx context.Context, ID int) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[ID]
+	if !ok {
+		return User{}, fmt.Errorf("utente %d: %w", ID, ErrNotFound)
+	}
+	return u, nil
+}
+
+func (s *MemoryStore) GetUserByUsername(ctx context.Context, username string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.userByUsername(username)
+}
+
+// userByUsername cerca senza distinguere le maiuscole, come la colonna
+// COLLATE NOCASE dello store SQL. PRESUPPONE il lock già acquisito.
+func (s *MemoryStore) userByUsername(username string) (User, error) {
+	for _, u := range s.users {
+		if strings.EqualFold(u.Username, username) {
+			return u, nil
+		}
+	}
+	return User{}, fmt.Errorf("utente %q: %w", username, ErrNotFound)
+}
+
+func (s *MemoryStore) CreateAPIKey(ctx context.Context, key APIKey) (APIKey, error) {
+	if err := key.validate(); err != nil {
+		return APIKey{}, err
+	}
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range s.apiKeys {
+		if k.KeyHash == key.KeyHash {
+			return APIKey{}, fmt.Errorf("%w: API key duplicata", ErrConflict)
+		}
+	}
+	key.ID = s.nextAPIKeyID
+	key.OwnerID = owner
+	key.CreatedAt = now()
+	key.LastUsedAt, key.RevokedAt = nil, nil
+	s.apiKeys[key.ID] = key
+	s.nextAPIKeyID++
+
+	if err := s.save(); err != nil {
+		delete(s.apiKeys, key.ID)
+		s.nextAPIKeyID--
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+func (s *MemoryStore) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := []APIKey{}
+	for _, k := range s.apiKeys {
+		if k.OwnerID == owner {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+func (s *MemoryStore) RevokeAPIKey(ctx context.Context, ID int) (APIKey, error) {
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.apiKeys[ID]
+	if !ok || old.OwnerID != owner {
+		return APIKey{}, fmt.Errorf("API key %d: %w", ID, ErrNotFound)
+	}
+	if old.Revoked() {
+		return old, nil
+	}
+	key := old
+	key.RevokedAt = ptr(now())
+	s.apiKeys[ID] = key
+	if err := s.save(); err != nil {
+		s.apiKeys[ID] = old
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+func (s *MemoryStore) GetAPIKeyByHash(ctx context.Context, keyHash string) (APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, k := range s.apiKeys {
+		if k.KeyHash == keyHash {
+			return k, nil
+		}
+	}
+	return APIKey{}, fmt.Errorf("API key: %w", ErrNotFound)
+}
+
+func (s *MemoryStore) TouchAPIKey(ctx context.Context, ID int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.apiKeys[ID]
+	if !ok {
+		return fmt.Errorf("API key %d: %w", ID, ErrNotFound)
+	}
+	key := old
+	key.LastUsedAt = &at
+	s.apiKeys[ID] = key
+	if err := s.save(); err != nil {
+		s.apiKeys[ID] = old
+		return err
+	}
+	return nil
+}
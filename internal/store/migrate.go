@@ -0,0 +1,330 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Le migrazioni sono file SQL inclusi nel binario: NNNN_nome.up.sql applica
+// il passo, NNNN_nome.down.sql lo annulla. I numeri di versione stabiliscono
+// l'ordine e non vanno mai riutilizzati.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrationName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration è un singolo passo dello schema.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // sha256 dello script up
+}
+
+// MigrationStatus descrive lo stato di una migrazione rispetto al database.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// Modified è vero se lo script up è cambiato dopo essere stato applicato.
+	Modified bool
+	// Unknown è vero se la versione è registrata nel database ma non esiste
+	// nel binario (database creato da una versione più recente).
+	Unknown bool
+}
+
+// Migrator applica e annulla le migrazioni su un database SQLite,
+// registrando quelle applicate nella tabella schema_migrations.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration // ordinate per versione crescente
+}
+
+// NewMigrator crea un Migrator con le migrazioni incluse nel binario.
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	sub, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return newMigrator(db, sub)
+}
+
+// newMigrator legge le migrazioni da fsys; separata da NewMigrator per i test.
+func newMigrator(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := loadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	);`
+	if _, err := db.Exec(query); err != nil {
+		return nil, fmt.Errorf("errore nella creazione di schema_migrations: %w", err)
+	}
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// loadMigrations legge e valida tutti gli script presenti in fsys.
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("errore nella lettura delle migrazioni: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		m := migrationName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("nome di migrazione non valido: %s", e.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		if version <= 0 {
+			return nil, fmt.Errorf("versione non valida in %s: deve essere maggiore di zero", e.Name())
+		}
+		body, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("versione %d usata da due migrazioni: %s e %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("la migrazione %04d_%s deve avere sia up che down", mig.Version, mig.Name)
+		}
+		sum := sha256.Sum256([]byte(mig.Up))
+		mig.Checksum = hex.EncodeToString(sum[:])
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// appliedMigration è una riga di schema_migrations.
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int]appliedMigration, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("errore nella lettura di schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var version int
+		var a appliedMigration
+		var at string
+		if err := rows.Scan(&version, &a.name, &a.checksum, &at); err != nil {
+			return nil, fmt.Errorf("errore nello scan di schema_migrations: %w", err)
+		}
+		a.appliedAt, _ = time.Parse(time.RFC3339, at)
+		applied[version] = a
+	}
+	return applied, rows.Err()
+}
+
+// Status restituisce lo stato di tutte le migrazioni, note e sconosciute.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var status []MigrationStatus
+	for _, mig := range m.migrations {
+		st := MigrationStatus{Version: mig.Version, Name: mig.Name}
+		if a, ok := applied[mig.Version]; ok {
+			st.Applied = true
+			st.AppliedAt = a.appliedAt
+			st.Modified = a.checksum != mig.Checksum
+			delete(applied, mig.Version)
+		}
+		status = append(status, st)
+	}
+	for version, a := range applied {
+		status = append(status, MigrationStatus{
+			Version: version, Name: a.name, Applied: true, AppliedAt: a.appliedAt, Unknown: true,
+		})
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Version < status[j].Version })
+	return status, nil
+}
+
+// Version restituisce la versione più alta applicata (0 se nessuna).
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	var version int
+	err := m.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("errore nella lettura della versione dello schema: %w", err)
+	}
+	return version, nil
+}
+
+// verify controlla che le migrazioni applicate coincidano con quelle del
+// binario: uno script modificato o una versione sconosciuta bloccano tutto,
+// perché lo schema reale non sarebbe più quello che ci aspettiamo.
+func (m *Migrator) verify(ctx context.Context) error {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, st := range status {
+		if st.Unknown {
+			return fmt.Errorf("la migrazione %d (%s) è applicata al database ma non esiste in questo binario", st.Version, st.Name)
+		}
+		if st.Modified {
+			return fmt.Errorf("checksum diverso per la migrazione %04d_%s: lo script è stato modificato dopo essere stato applicato", st.Version, st.Name)
+		}
+	}
+	return nil
+}
+
+// Up applica tutte le migrazioni mancanti e restituisce quelle eseguite.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	if len(m.migrations) == 0 {
+		return nil, nil
+	}
+	return m.To(ctx, m.migrations[len(m.migrations)-1].Version)
+}
+
+// Down annulla le ultime steps migrazioni applicate.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if steps <= 0 {
+		return nil, fmt.Errorf("il numero di passi deve essere maggiore di zero")
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// cerchiamo la versione a cui fermarci scendendo di steps migrazioni applicate
+	target := 0
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		if _, ok := applied[m.migrations[i].Version]; !ok {
+			continue
+		}
+		if steps == 0 {
+			target = m.migrations[i].Version
+			break
+		}
+		steps--
+	}
+	return m.To(ctx, target)
+}
+
+// To porta lo schema esattamente alla versione indicata, applicando o
+// annullando le migrazioni necessarie. La versione 0 annulla tutto.
+func (m *Migrator) To(ctx context.Context, version int) ([]Migration, error) {
+	if version != 0 && m.find(version) < 0 {
+		return nil, fmt.Errorf("versione %d inesistente", version)
+	}
+	if err := m.verify(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	// prima annulliamo, dalla più recente, quelle oltre la versione richiesta...
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		mig := m.migrations[i]
+		if _, ok := applied[mig.Version]; !ok || mig.Version <= version {
+			continue
+		}
+		if err := m.run(ctx, mig, false); err != nil {
+			return done, err
+		}
+		done = append(done, mig)
+	}
+	// ...poi applichiamo, in ordine, quelle mancanti fino alla versione richiesta.
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; ok || mig.Version > version {
+			continue
+		}
+		if err := m.run(ctx, mig, true); err != nil {
+			return done, err
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+func (m *Migrator) find(version int) int {
+	for i, mig := range m.migrations {
+		if mig.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// run esegue un singolo passo in una transazione, insieme all'aggiornamento
+// di schema_migrations: o riesce tutto o non cambia nulla.
+func (m *Migrator) run(ctx context.Context, mig Migration, up bool) error {
+	direction, script := "up", mig.Up
+	if !up {
+		direction, script = "down", mig.Down
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // non fa nulla se la transazione è già stata confermata
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("errore nella migrazione %04d_%s (%s): %w", mig.Version, mig.Name, direction, err)
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)",
+			mig.Version, mig.Name, mig.Checksum, time.Now().UTC().Format(time.RFC3339))
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", mig.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("errore nell'aggiornamento di schema_migrations: %w", err)
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,330 @@
+package store
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// migrazioni finte per provare il Migrator senza dipendere da quelle reali.
+func testMigrations() fstest.MapFS {
+	return fstest.MapFS{
+		"0001_a.up.sql":   {Data: []byte("CREATE TABLE a (id INTEGER);")},
+		"0001_a.down.sql": {Data: []byte("DROP TABLE a;")},
+		"0002_b.up.sql":   {Data: []byte("CREATE TABLE b (id INTEGER);")},
+		"0002_b.down.sql": {Data: []byte("DROP TABLE b;")},
+		"0003_c.up.sql":   {Data: []byte("CREATE TABLE c (id INTEGER);")},
+		"0003_c.down.sql": {Data: []byte("DROP TABLE c;")},
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenDB(filepath.Join(t.TempDir(), "migrate.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	m, err := newMigrator(db, testMigrations())
+	require.NoError(t, err)
+
+	tableExists := func(name string) bool {
+		var n int
+		require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?", name).Scan(&n))
+		return n == 1
+	}
+	version := func() int {
+		v, err := m.Version(ctx)
+		require.NoError(t, err)
+		return v
+	}
+
+	t.Run("up applica tutto in ordine", func(t *testing.T) {
+		done, err := m.Up(ctx)
+		require.NoError(t, err)
+		require.Len(t, done, 3)
+		assert.Equal(t, []int{1, 2, 3}, []int{done[0].Version, done[1].Version, done[2].Version})
+		assert.True(t, tableExists("c"))
+		assert.Equal(t, 3, version())
+
+		// una seconda esecuzione non deve fare nulla
+		done, err = m.Up(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, done)
+	})
+
+	t.Run("down annulla dalla più recente", func(t *testing.T) {
+		done, err := m.Down(ctx, 2)
+		require.NoError(t, err)
+		require.Len(t, done, 2)
+		assert.Equal(t, 3, done[0].Version)
+		assert.Equal(t, 2, done[1].Version)
+		assert.False(t, tableExists("b"))
+		assert.True(t, tableExists("a"))
+		assert.Equal(t, 1, version())
+	})
+
+	t.Run("to sale e scende", func(t *testing.T) {
+		_, err := m.To(ctx, 2)
+		require.NoError(t, err)
+		assert.Equal(t, 2, version())
+
+		_, err = m.To(ctx, 0)
+		require.NoError(t, err)
+		assert.Equal(t, 0, version())
+		assert.False(t, tableExists("a"))
+
+		_, err = m.To(ctx, 42)
+		assert.Error(t, err, "una versione inesistente deve essere rifiutata")
+	})
+
+	t.Run("status", func(t *testing.T) {
+		_, err := m.To(ctx, 1)
+		require.NoError(t, err)
+
+		status, err := m.Status(ctx)
+		require.NoError(t, err)
+		require.Len(t, status, 3)
+		assert.True(t, status[0].Applied)
+		assert.False(t, status[1].Applied)
+		assert.False(t, status[0].Modified)
+	})
+
+	t.Run("checksum diverso blocca le migrazioni", func(t *testing.T) {
+		changed := testMigrations()
+		changed["0001_a.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE a (id INTEGER, x TEXT);")}
+		m2, err := newMigrator(db, changed)
+		require.NoError(t, err)
+
+		status, err := m2.Status(ctx)
+		require.NoError(t, err)
+		assert.True(t, status[0].Modified)
+
+		_, err = m2.Up(ctx)
+		assert.ErrorContains(t, err, "checksum")
+	})
+
+	t.Run("versione sconosciuta blocca le migrazioni", func(t *testing.T) {
+		older := testMigrations()
+		delete(older, "0001_a.up.sql")
+		delete(older, "0001_a.down.sql")
+		m2, err := newMigrator(db, older)
+		require.NoError(t, err)
+
+		_, err = m2.Up(ctx)
+		assert.Error(t, err)
+	})
+}
+
+func TestLoadMigrationsValidation(t *testing.T) {
+	_, err := loadMigrations(fstest.MapFS{
+		"0001_a.up.sql": {Data: []byte("SELECT 1;")},
+	})
+	assert.Error(t, err, "manca lo script down")
+
+	_, err = loadMigrations(fstest.MapFS{
+		"readme.txt": {Data: []byte("ciao")},
+	})
+	assert.Error(t, err, "nome non valido")
+
+	// le migrazioni reali devono essere sempre valide
+	sub, err := fs.Sub(migrationFiles, "migrations")
+	require.NoError(t, err)
+	migrations, err := loadMigrations(sub)
+	require.NoError(t, err)
+	assert.NotEmpty(t, migrations)
+}
+
+// La migrazione 0002 deve convertire i vecchi valori testuali senza perderli:
+// tornando indietro ritroviamo esattamente i valori di partenza.
+func TestStatusMigrationIsLossless(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenDB(filepath.Join(t.TempDir(), "legacy.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	m, err := NewMigrator(db)
+	require.NoError(t, err)
+	_, err = m.To(ctx, 1)
+	require.NoError(t, err)
+
+	legacy := map[int]string{1: "not completed", 2: "completed", 3: "banana", 4: "", 5: "Yes"}
+	for id, completed := range legacy {
+		_, err := db.Exec("INSERT INTO todos (id, title, completed) VALUES (?, 'x', ?)", id, completed)
+		require.NoError(t, err)
+	}
+	// l'ID 6 è stato usato e poi cancellato: non deve essere riassegnato
+	_, err = db.Exec("INSERT INTO todos (id, title, completed) VALUES (6, 'x', '')")
+	require.NoError(t, err)
+	_, err = db.Exec("DELETE FROM todos WHERE id = 6")
+	require.NoError(t, err)
+
+	_, err = m.To(ctx, 2)
+	require.NoError(t, err)
+
+	expected := map[int]Status{1: StatusPending, 2: StatusDone, 3: StatusPending, 4: StatusPending, 5: StatusDone}
+	for id, status := range expected {
+		var got string
+		require.NoError(t, db.QueryRow("SELECT status FROM todos WHERE id = ?", id).Scan(&got))
+		assert.Equal(t, string(status), got, "todo %d", id)
+		assert.Equal(t, LegacyStatus(legacy[id]), status, "LegacyStatus e la migrazione devono coincidere")
+	}
+
+	var newID int
+	require.NoError(t, db.QueryRow("INSERT INTO todos (title) VALUES ('nuovo') RETURNING id").Scan(&newID))
+	assert.Equal(t, 7, newID)
+	_, err = db.Exec("DELETE FROM todos WHERE id = 7")
+	require.NoError(t, err)
+
+	_, err = m.To(ctx, 1)
+	require.NoError(t, err)
+	for id, completed := range legacy {
+		var got string
+		require.NoError(t, db.QueryRow("SELECT completed FROM todos WHERE id = ?", id).Scan(&got))
+		assert.Equal(t, completed, got, "todo %d", id)
+	}
+}
+
+// La migrazione 0004 assegna i todo esistenti all'utente di default e
+// conserva il contatore degli ID; tornando indietro i todo restano tutti.
+func TestUsersMigration(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenDB(filepath.Join(t.TempDir(), "users.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	m, err := NewMigrator(db)
+	require.NoError(t, err)
+	_, err = m.To(ctx, 3)
+	require.NoError(t, err)
+
+	_, err = db.Exec("INSERT INTO todos (id, title, status, version) VALUES (1, 'vecchio', 'done', 3), (2, 'cancellato', 'pending', 1)")
+	require.NoError(t, err)
+	_, err = db.Exec("DELETE FROM todos WHERE id = 2")
+	require.NoError(t, err)
+
+	_, err = m.To(ctx, 4)
+	require.NoError(t, err)
+
+	var owner, version int
+	require.NoError(t, db.QueryRow("SELECT owner_id, version FROM todos WHERE id = 1").Scan(&owner, &version))
+	assert.Equal(t, DefaultUserID, owner)
+	assert.Equal(t, 3, version)
+
+	var username string
+	require.NoError(t, db.QueryRow("SELECT username FROM users WHERE id = ?", DefaultUserID).Scan(&username))
+	assert.Equal(t, DefaultUsername, username)
+
+	var newID int
+	require.NoError(t, db.QueryRow("INSERT INTO todos (owner_id, title) VALUES (1, 'nuovo') RETURNING id").Scan(&newID))
+	assert.Equal(t, 3, newID, "l'ID 2 cancellato non va riassegnato")
+
+	_, err = m.To(ctx, 3)
+	require.NoError(t, err)
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM todos").Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
+func TestListsMigrationDown(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenDB(filepath.Join(t.TempDir(), "lists.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	m, err := NewMigrator(db)
+	require.NoError(t, err)
+	_, err = m.To(ctx, 7)
+	require.NoError(t, err)
+
+	_, err = db.Exec("INSERT INTO lists (id, owner_id, name, created_at) VALUES (1, 1, 'Casa', '2025-01-01T00:00:00.000000000Z')")
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO todos (id, owner_id, list_id, title) VALUES (5, 1, 1, 'in lista'), (6, 1, NULL, 'fuori')")
+	require.NoError(t, err)
+
+	_, err = m.To(ctx, 6)
+	require.NoError(t, err)
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM todos").Scan(&count))
+	assert.Equal(t, 2, count, "i todo restano, perdono solo la lista")
+
+	var newID int
+	require.NoError(t, db.QueryRow("INSERT INTO todos (owner_id, title) VALUES (1, 'nuovo') RETURNING id").Scan(&newID))
+	assert.Equal(t, 7, newID)
+}
+
+func TestTagSpacesMigration(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenDB(filepath.Join(t.TempDir(), "tags.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	m, err := NewMigrator(db)
+	require.NoError(t, err)
+	_, err = m.To(ctx, 14)
+	require.NoError(t, err)
+
+	_, err = db.Exec("INSERT INTO todos (id, owner_id, title) VALUES (1, 1, 'uno'), (2, 1, 'due'), (3, 1, 'tre')")
+	require.NoError(t, err)
+	// "casa mia" finisce su "casa-mia", che esiste già; "a b-c" e "a-b c"
+	// diventano entrambi "a-b-c" e resta il più vecchio
+	_, err = db.Exec(`INSERT INTO tags (id, owner_id, name) VALUES
+		(1, 1, 'casa mia'), (2, 1, 'casa-mia'), (3, 1, 'a b-c'), (4, 1, 'a-b c'), (5, 1, 'lavoro')`)
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO todo_tags (todo_id, tag_id) VALUES (1, 1), (1, 2), (2, 1), (2, 3), (3, 4), (3, 5)")
+	require.NoError(t, err)
+
+	_, err = m.To(ctx, 15)
+	require.NoError(t, err)
+
+	tagsOf := func(todoID int) []string {
+		rows, err := db.Query(`SELECT t.name FROM todo_tags tt JOIN tags t ON t.id = tt.tag_id
+			WHERE tt.todo_id = ? ORDER BY t.name`, todoID)
+		require.NoError(t, err)
+		defer rows.Close()
+		var names []string
+		for rows.Next() {
+			var name string
+			require.NoError(t, rows.Scan(&name))
+			names = append(names, name)
+		}
+		require.NoError(t, rows.Err())
+		return names
+	}
+	assert.Equal(t, []string{"casa-mia"}, tagsOf(1))
+	assert.Equal(t, []string{"a-b-c", "casa-mia"}, tagsOf(2))
+	assert.Equal(t, []string{"a-b-c", "lavoro"}, tagsOf(3))
+
+	var ids []int
+	rows, err := db.Query("SELECT id FROM tags ORDER BY id")
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		require.NoError(t, rows.Scan(&id))
+		ids = append(ids, id)
+	}
+	assert.Equal(t, []int{2, 3, 5}, ids, "i tag unificati spariscono")
+}
+
+// TestMigrationsRoundTrip verifica che tutte le migrazioni si possano
+// annullare fino allo schema vuoto e poi riapplicare.
+func TestMigrationsRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenDB(filepath.Join(t.TempDir(), "roundtrip.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	m, err := NewMigrator(db)
+	require.NoError(t, err)
+	_, err = m.Up(ctx)
+	require.NoError(t, err)
+	_, err = m.To(ctx, 0)
+	require.NoError(t, err)
+	_, err = m.Up(ctx)
+	require.NoError(t, err)
+}
@@ -0,0 +1,7 @@
+-- Tabella di partenza, identica a quella che creava createTable.
+-- IF NOT EXISTS permette di adottare i database creati prima delle migrazioni.
+CREATE TABLE IF NOT EXISTS todos (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	title TEXT NOT NULL,
+	completed TEXT NOT NULL
+);
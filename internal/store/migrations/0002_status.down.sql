@@ -0,0 +1,22 @@
+-- Torna alla colonna testuale: se c'è il valore originale lo ripristiniamo,
+-- altrimenti lo ricaviamo dallo stato.
+CREATE TABLE todos_old (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	title TEXT NOT NULL,
+	completed TEXT NOT NULL
+);
+
+INSERT INTO todos_old (id, title, completed)
+SELECT id, title,
+	COALESCE(legacy_completed, CASE status WHEN 'done' THEN 'completed' ELSE 'not completed' END)
+FROM todos;
+
+INSERT INTO sqlite_sequence (name, seq)
+SELECT 'todos_old', seq FROM sqlite_sequence
+WHERE name = 'todos' AND NOT EXISTS (SELECT 1 FROM sqlite_sequence WHERE name = 'todos_old');
+UPDATE sqlite_sequence
+SET seq = max(seq, COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'todos'), 0))
+WHERE name = 'todos_old';
+
+DROP TABLE todos;
+ALTER TABLE todos_old RENAME TO todos;
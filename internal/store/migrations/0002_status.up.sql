@@ -0,0 +1,48 @@
+-- Sostituisce la colonna testuale "completed" con uno stato tipizzato.
+-- Il valore originale resta in legacy_completed, così la conversione è
+-- reversibile senza perdere nulla (anche valori come "banana").
+-- La CASE deve restare allineata a store.LegacyStatus.
+CREATE TABLE todos_new (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	title TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending'
+		CHECK (status IN ('pending', 'in_progress', 'done', 'archived')),
+	completed_at TEXT,
+	legacy_completed TEXT
+);
+
+INSERT INTO todos_new (id, title, status, completed_at, legacy_completed)
+SELECT id, title,
+	CASE lower(trim(completed))
+		WHEN 'completed' THEN 'done'
+		WHEN 'complete' THEN 'done'
+		WHEN 'done' THEN 'done'
+		WHEN 'true' THEN 'done'
+		WHEN 'yes' THEN 'done'
+		WHEN '1' THEN 'done'
+		WHEN 'completato' THEN 'done'
+		WHEN 'fatto' THEN 'done'
+		WHEN 'in progress' THEN 'in_progress'
+		WHEN 'in_progress' THEN 'in_progress'
+		WHEN 'doing' THEN 'in_progress'
+		WHEN 'in corso' THEN 'in_progress'
+		WHEN 'archived' THEN 'archived'
+		WHEN 'archiviato' THEN 'archived'
+		ELSE 'pending'
+	END,
+	NULL,
+	completed
+FROM todos;
+
+-- conserviamo il contatore AUTOINCREMENT, anche se gli ultimi ID sono stati cancellati
+INSERT INTO sqlite_sequence (name, seq)
+SELECT 'todos_new', seq FROM sqlite_sequence
+WHERE name = 'todos' AND NOT EXISTS (SELECT 1 FROM sqlite_sequence WHERE name = 'todos_new');
+UPDATE sqlite_sequence
+SET seq = max(seq, COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'todos'), 0))
+WHERE name = 'todos_new';
+
+DROP TABLE todos;
+ALTER TABLE todos_new RENAME TO todos;
+
+CREATE INDEX idx_todos_status ON todos (status);
@@ -0,0 +1,3 @@
+-- Numero di versione per il controllo di concorrenza ottimistico:
+-- parte da 1 e viene incrementato a ogni modifica.
+ALTER TABLE todos ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
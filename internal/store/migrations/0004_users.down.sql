@@ -0,0 +1,28 @@
+-- Torna a una lista unica: i todo di tutti gli utenti restano, perde solo
+-- l'informazione su chi li possedeva.
+CREATE TABLE todos_old (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	title TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending'
+		CHECK (status IN ('pending', 'in_progress', 'done', 'archived')),
+	completed_at TEXT,
+	legacy_completed TEXT,
+	version INTEGER NOT NULL DEFAULT 1
+);
+
+INSERT INTO todos_old (id, title, status, completed_at, legacy_completed, version)
+SELECT id, title, status, completed_at, legacy_completed, version FROM todos;
+
+INSERT INTO sqlite_sequence (name, seq)
+SELECT 'todos_old', seq FROM sqlite_sequence
+WHERE name = 'todos' AND NOT EXISTS (SELECT 1 FROM sqlite_sequence WHERE name = 'todos_old');
+UPDATE sqlite_sequence
+SET seq = max(seq, COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'todos'), 0))
+WHERE name = 'todos_old';
+
+DROP TABLE todos;
+ALTER TABLE todos_old RENAME TO todos;
+
+CREATE INDEX idx_todos_status ON todos (status);
+
+DROP TABLE users;
@@ -0,0 +1,39 @@
+-- Account degli utenti e proprietario di ogni todo. L'utente 1 ("default")
+-- riceve tutti i todo creati prima dell'introduzione degli account.
+CREATE TABLE users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+	created_at TEXT NOT NULL
+);
+
+INSERT INTO users (id, username, created_at)
+VALUES (1, 'default', strftime('%Y-%m-%dT%H:%M:%S.000000000Z', 'now'));
+
+-- SQLite non permette di aggiungere una colonna NOT NULL con chiave
+-- esterna senza default, quindi ricostruiamo la tabella come in 0002.
+CREATE TABLE todos_new (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	owner_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	title TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending'
+		CHECK (status IN ('pending', 'in_progress', 'done', 'archived')),
+	completed_at TEXT,
+	legacy_completed TEXT,
+	version INTEGER NOT NULL DEFAULT 1
+);
+
+INSERT INTO todos_new (id, owner_id, title, status, completed_at, legacy_completed, version)
+SELECT id, 1, title, status, completed_at, legacy_completed, version FROM todos;
+
+INSERT INTO sqlite_sequence (name, seq)
+SELECT 'todos_new', seq FROM sqlite_sequence
+WHERE name = 'todos' AND NOT EXISTS (SELECT 1 FROM sqlite_sequence WHERE name = 'todos_new');
+UPDATE sqlite_sequence
+SET seq = max(seq, COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'todos'), 0))
+WHERE name = 'todos_new';
+
+DROP TABLE todos;
+ALTER TABLE todos_new RENAME TO todos;
+
+CREATE INDEX idx_todos_status ON todos (status);
+CREATE INDEX idx_todos_owner ON todos (owner_id, id);
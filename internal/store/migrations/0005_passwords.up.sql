@@ -0,0 +1,4 @@
+-- Hash della password di ogni utente (vedi auth.HashPassword). NULL vuol
+-- dire che l'utente non può fare login, come "default" finché non gli si
+-- assegna una password con il comando "user passwd".
+ALTER TABLE users ADD COLUMN password_hash TEXT;
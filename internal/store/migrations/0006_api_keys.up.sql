@@ -0,0 +1,16 @@
+-- API key per i client non interattivi (script, CI). Della chiave salviamo
+-- solo lo SHA-256: chi legge il database non può usarla. prefix sono i
+-- primi caratteri, utili a riconoscerla nell'elenco.
+CREATE TABLE api_keys (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	owner_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	prefix TEXT NOT NULL,
+	key_hash TEXT NOT NULL UNIQUE,
+	scope TEXT NOT NULL CHECK (scope IN ('read_only', 'read_write')),
+	created_at TEXT NOT NULL,
+	last_used_at TEXT,
+	revoked_at TEXT
+);
+
+CREATE INDEX idx_api_keys_owner ON api_keys (owner_id, id);
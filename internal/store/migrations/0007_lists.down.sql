@@ -0,0 +1,30 @@
+-- SQLite non può eliminare una colonna con chiave esterna, quindi
+-- ricostruiamo todos come in 0004. I todo restano, perdono solo la lista.
+CREATE TABLE todos_old (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	owner_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	title TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending'
+		CHECK (status IN ('pending', 'in_progress', 'done', 'archived')),
+	completed_at TEXT,
+	legacy_completed TEXT,
+	version INTEGER NOT NULL DEFAULT 1
+);
+
+INSERT INTO todos_old (id, owner_id, title, status, completed_at, legacy_completed, version)
+SELECT id, owner_id, title, status, completed_at, legacy_completed, version FROM todos;
+
+INSERT INTO sqlite_sequence (name, seq)
+SELECT 'todos_old', seq FROM sqlite_sequence
+WHERE name = 'todos' AND NOT EXISTS (SELECT 1 FROM sqlite_sequence WHERE name = 'todos_old');
+UPDATE sqlite_sequence
+SET seq = max(seq, COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'todos'), 0))
+WHERE name = 'todos_old';
+
+DROP TABLE todos;
+ALTER TABLE todos_old RENAME TO todos;
+
+CREATE INDEX idx_todos_status ON todos (status);
+CREATE INDEX idx_todos_owner ON todos (owner_id, id);
+
+DROP TABLE lists;
@@ -0,0 +1,13 @@
+-- Liste (progetti) che raggruppano i todo. Un todo sta al massimo in una
+-- lista: list_id NULL significa "nessuna lista".
+CREATE TABLE lists (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	owner_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	name TEXT NOT NULL COLLATE NOCASE,
+	created_at TEXT NOT NULL,
+	UNIQUE (owner_id, name)
+);
+
+ALTER TABLE todos ADD COLUMN list_id INTEGER REFERENCES lists (id);
+
+CREATE INDEX idx_todos_list ON todos (list_id);
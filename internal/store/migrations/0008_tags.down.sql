@@ -0,0 +1,4 @@
+DROP TRIGGER todo_tags_prune;
+DROP TRIGGER todos_delete_tags;
+DROP TABLE todo_tags;
+DROP TABLE tags;
@@ -0,0 +1,31 @@
+-- Etichette dei todo: ogni utente ha i suoi tag, collegati ai todo dalla
+-- tabella todo_tags. I nomi sono salvati in minuscolo e non contengono
+-- virgole (vedi NormalizeTag).
+CREATE TABLE tags (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	owner_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	name TEXT NOT NULL COLLATE NOCASE,
+	UNIQUE (owner_id, name)
+);
+
+CREATE TABLE todo_tags (
+	todo_id INTEGER NOT NULL REFERENCES todos (id) ON DELETE CASCADE,
+	tag_id INTEGER NOT NULL REFERENCES tags (id) ON DELETE CASCADE,
+	PRIMARY KEY (todo_id, tag_id)
+) WITHOUT ROWID;
+
+CREATE INDEX idx_todo_tags_tag ON todo_tags (tag_id);
+
+-- Le chiavi esterne di SQLite non sono attive, quindi la pulizia la fanno
+-- i trigger: cancellando un todo spariscono i suoi collegamenti, e un tag
+-- che non è più usato da nessun todo viene eliminato.
+CREATE TRIGGER todos_delete_tags AFTER DELETE ON todos
+BEGIN
+	DELETE FROM todo_tags WHERE todo_id = OLD.id;
+END;
+
+CREATE TRIGGER todo_tags_prune AFTER DELETE ON todo_tags
+BEGIN
+	DELETE FROM tags WHERE id = OLD.tag_id
+		AND NOT EXISTS (SELECT 1 FROM todo_tags WHERE tag_id = OLD.tag_id);
+END;
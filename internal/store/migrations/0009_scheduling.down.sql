@@ -0,0 +1,4 @@
+DROP INDEX idx_todos_due;
+ALTER TABLE todos DROP COLUMN due_at;
+ALTER TABLE todos DROP COLUMN priority;
+ALTER TABLE todos DROP COLUMN description;
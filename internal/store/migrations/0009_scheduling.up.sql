@@ -0,0 +1,8 @@
+-- Pianificazione: descrizione libera, priorità e scadenza. due_at è in UTC
+-- nello stesso formato di completed_at, così si confronta come testo.
+ALTER TABLE todos ADD COLUMN description TEXT NOT NULL DEFAULT '';
+ALTER TABLE todos ADD COLUMN priority TEXT NOT NULL DEFAULT 'normal'
+	CHECK (priority IN ('low', 'normal', 'high', 'urgent'));
+ALTER TABLE todos ADD COLUMN due_at TEXT;
+
+CREATE INDEX idx_todos_due ON todos (owner_id, due_at);
@@ -0,0 +1,5 @@
+DROP TRIGGER todos_parent_delete;
+DROP TRIGGER todos_parent_update;
+DROP TRIGGER todos_parent_insert;
+DROP INDEX idx_todos_parent;
+ALTER TABLE todos DROP COLUMN parent_id;
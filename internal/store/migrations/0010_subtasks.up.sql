@@ -0,0 +1,28 @@
+-- Sottoattività: un todo può avere un genitore. Niente REFERENCES, così
+-- la colonna si può togliere con DROP COLUMN; che il genitore esista e
+-- che non si formino cicli lo controlla lo store.
+ALTER TABLE todos ADD COLUMN parent_id INTEGER;
+
+CREATE INDEX idx_todos_parent ON todos (parent_id);
+
+-- L'avanzamento di un genitore dipende dalle sue sottoattività, quindi
+-- quando queste cambiano deve cambiare anche la sua versione (e l'ETag).
+CREATE TRIGGER todos_parent_insert AFTER INSERT ON todos
+WHEN NEW.parent_id IS NOT NULL
+BEGIN
+	UPDATE todos SET version = version + 1 WHERE id = NEW.parent_id;
+END;
+
+CREATE TRIGGER todos_parent_update AFTER UPDATE OF parent_id, status ON todos
+WHEN OLD.parent_id IS NOT NEW.parent_id OR OLD.status IS NOT NEW.status
+BEGIN
+	UPDATE todos SET version = version + 1 WHERE id IN (OLD.parent_id, NEW.parent_id);
+END;
+
+-- Cancellando un genitore le sue sottoattività non spariscono: salgono
+-- al primo livello.
+CREATE TRIGGER todos_parent_delete AFTER DELETE ON todos
+BEGIN
+	UPDATE todos SET version = version + 1 WHERE id = OLD.parent_id;
+	UPDATE todos SET parent_id = NULL, version = version + 1 WHERE parent_id = OLD.id;
+END;
@@ -0,0 +1,4 @@
+DROP TRIGGER todos_next_delete;
+DROP INDEX idx_todos_next;
+ALTER TABLE todos DROP COLUMN next_occurrence_id;
+ALTER TABLE todos DROP COLUMN recurrence;
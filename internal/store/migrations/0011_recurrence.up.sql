@@ -0,0 +1,14 @@
+-- Ricorrenze: recurrence è una regola RRULE in forma canonica ('' = il
+-- todo non si ripete). Quando un todo ricorrente viene completato lo store
+-- crea l'occorrenza successiva e ne salva l'ID in next_occurrence_id.
+ALTER TABLE todos ADD COLUMN recurrence TEXT NOT NULL DEFAULT '';
+ALTER TABLE todos ADD COLUMN next_occurrence_id INTEGER;
+
+CREATE INDEX idx_todos_next ON todos (next_occurrence_id) WHERE next_occurrence_id IS NOT NULL;
+
+-- Se l'occorrenza successiva viene cancellata il collegamento sparisce, e
+-- completando di nuovo il todo se ne crea un'altra.
+CREATE TRIGGER todos_next_delete AFTER DELETE ON todos
+BEGIN
+	UPDATE todos SET next_occurrence_id = NULL, version = version + 1 WHERE next_occurrence_id = OLD.id;
+END;
@@ -0,0 +1,6 @@
+-- Attenzione: i todo ancora nel cestino tornerebbero visibili, quindi
+-- prima di togliere la colonna li cancelliamo davvero.
+DROP TRIGGER todos_parent_trash;
+DROP INDEX idx_todos_deleted;
+DELETE FROM todos WHERE deleted_at IS NOT NULL;
+ALTER TABLE todos DROP COLUMN deleted_at;
@@ -0,0 +1,14 @@
+-- Cestino: DELETE /todos/{id} imposta deleted_at invece di cancellare la
+-- riga. I todo nel cestino sono esclusi da tutte le letture e vengono
+-- cancellati davvero (Purge) dopo il periodo di conservazione.
+ALTER TABLE todos ADD COLUMN deleted_at TEXT;
+
+CREATE INDEX idx_todos_deleted ON todos (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Spostare un todo nel cestino (o ripristinarlo) cambia l'avanzamento del
+-- genitore, come una sottoattività aggiunta o cancellata.
+CREATE TRIGGER todos_parent_trash AFTER UPDATE OF deleted_at ON todos
+WHEN OLD.deleted_at IS NOT NEW.deleted_at AND NEW.parent_id IS NOT NULL
+BEGIN
+	UPDATE todos SET version = version + 1 WHERE id = NEW.parent_id;
+END;
@@ -0,0 +1,3 @@
+DROP TRIGGER todo_events_no_delete;
+DROP TRIGGER todo_events_no_update;
+DROP TABLE todo_events;
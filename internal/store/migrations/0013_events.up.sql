@@ -0,0 +1,30 @@
+-- Registro delle modifiche ai todo: una riga per ogni creazione, modifica,
+-- spostamento nel cestino, ripristino ed eliminazione definitiva, scritta
+-- nella stessa transazione della modifica. before e after sono il todo in
+-- JSON prima e dopo (NULL se non esisteva o non esiste più); actor_id è
+-- NULL per le operazioni del server, come lo svuotamento del cestino.
+-- Niente REFERENCES su todo_id: la storia resta anche dopo l'eliminazione.
+CREATE TABLE todo_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	todo_id INTEGER NOT NULL,
+	owner_id INTEGER NOT NULL,
+	action TEXT NOT NULL CHECK (action IN ('created', 'updated', 'deleted', 'restored', 'purged')),
+	actor_id INTEGER,
+	request_id TEXT NOT NULL DEFAULT '',
+	before TEXT,
+	after TEXT,
+	created_at TEXT NOT NULL
+);
+
+CREATE INDEX idx_todo_events_todo ON todo_events (todo_id, id);
+
+-- Il registro si può solo allungare.
+CREATE TRIGGER todo_events_no_update BEFORE UPDATE ON todo_events
+BEGIN
+	SELECT RAISE(ABORT, 'todo_events è in sola aggiunta');
+END;
+
+CREATE TRIGGER todo_events_no_delete BEFORE DELETE ON todo_events
+BEGIN
+	SELECT RAISE(ABORT, 'todo_events è in sola aggiunta');
+END;
@@ -0,0 +1,4 @@
+-- Il fuso delle scadenze: due_at resta in UTC per i confronti, due_offset
+-- è la differenza da UTC in secondi con cui il client l'aveva indicata.
+-- Le scadenze già salvate sono in UTC.
+ALTER TABLE todos ADD COLUMN due_offset INTEGER NOT NULL DEFAULT 0;
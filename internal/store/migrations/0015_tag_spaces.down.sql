@@ -0,0 +1,2 @@
+-- I nomi originali non sono conservati: i tag restano con i trattini.
+SELECT 1;
@@ -0,0 +1,27 @@
+-- I nomi dei tag non possono più contenere spazi (vedi NormalizeTag): nel
+-- Markdown un tag è una parola che inizia con #. Gli spazi dei tag già
+-- salvati diventano trattini; se il nuovo nome esiste già, o due tag
+-- finiscono con lo stesso nome, i loro todo passano a un solo tag: quello
+-- senza spazi se c'è, altrimenti il più vecchio.
+CREATE TEMP TABLE tag_renames AS
+SELECT t.id, (
+	SELECT k.id FROM tags k
+	WHERE k.owner_id = t.owner_id AND replace(k.name, ' ', '-') = replace(t.name, ' ', '-')
+	ORDER BY instr(k.name, ' ') > 0, k.id
+	LIMIT 1
+) AS target_id
+FROM tags t
+WHERE instr(t.name, ' ') > 0;
+
+INSERT OR IGNORE INTO todo_tags (todo_id, tag_id)
+SELECT tt.todo_id, r.target_id
+FROM todo_tags tt JOIN tag_renames r ON r.id = tt.tag_id
+WHERE r.target_id <> r.id;
+
+-- il trigger todo_tags_prune elimina i tag rimasti senza todo
+DELETE FROM todo_tags WHERE tag_id IN (SELECT id FROM tag_renames WHERE target_id <> id);
+DELETE FROM tags WHERE id IN (SELECT id FROM tag_renames WHERE target_id <> id);
+
+UPDATE tags SET name = replace(name, ' ', '-') WHERE instr(name, ' ') > 0;
+
+DROP TABLE tag_renames;
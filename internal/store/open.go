@@ -0,0 +1,26 @@
+package store
+
+import "fmt"
+
+// Nomi dei backend selezionabili all'avvio.
+const (
+	DriverSQLite = "sqlite"
+	DriverJSON   = "json"
+	DriverMemory = "memory"
+)
+
+// Open crea il backend indicato da driver. path è il file del database
+// (sqlite) o del JSON (json) ed è ignorato dal backend in memoria.
+func Open(driver, path string) (Repository, error) {
+	switch driver {
+	case DriverSQLite:
+		return New(path)
+	case DriverJSON:
+		return NewJSONStore(path)
+	case DriverMemory:
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("backend sconosciuto %q (valori ammessi: %s, %s, %s)",
+			driver, DriverSQLite, DriverJSON, DriverMemory)
+	}
+}
@@ -0,0 +1,43 @@
+package store
+
+// Priority è l'importanza di un todo.
+type Priority string
+
+const (
+	PriorityLow    Priority = "low"
+	PriorityNormal Priority = "normal"
+	PriorityHigh   Priority = "high"
+	PriorityUrgent Priority = "urgent"
+)
+
+// Priorities elenca le priorità valide, dalla più urgente alla meno: è
+// anche l'ordine crescente di sort=priority.
+var Priorities = []Priority{PriorityUrgent, PriorityHigh, PriorityNormal, PriorityLow}
+
+// Valid dice se p è una delle priorità previste.
+func (p Priority) Valid() bool {
+	return priorityRank(p) < len(Priorities)
+}
+
+// ParsePriority converte una stringa ricevuta dal client in una Priority;
+// la stringa vuota vale PriorityNormal.
+func ParsePriority(s string) (Priority, error) {
+	if s == "" {
+		return PriorityNormal, nil
+	}
+	p := Priority(s)
+	if !p.Valid() {
+		return "", invalidf("priorità %q non valida (valori ammessi: low, normal, high, urgent)", s)
+	}
+	return p, nil
+}
+
+// priorityRank è la posizione in Priorities: 0 per la più urgente.
+func priorityRank(p Priority) int {
+	for i, v := range Priorities {
+		if p == v {
+			return i
+		}
+	}
+	return len(Priorities)
+}
@@ -0,0 +1,420 @@
+package store
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SortField è un campo per cui si può ordinare la lista dei todo.
+type SortField string
+
+const (
+	SortByID          SortField = "id"
+	SortByTitle       SortField = "title"
+	SortByStatus      SortField = "status"
+	SortByCompletedAt SortField = "completed_at"
+	// SortByPriority ordina per priorità (in ordine crescente prima le più
+	// urgenti) e, a parità, per scadenza.
+	SortByPriority SortField = "priority"
+	SortByDueAt    SortField = "due_at"
+)
+
+// SortFields elenca i campi di ordinamento ammessi.
+var SortFields = []SortField{SortByID, SortByTitle, SortByStatus, SortByCompletedAt, SortByPriority, SortByDueAt}
+
+// ListOptions raccoglie filtri, ordinamento e paginazione di GetAll.
+// Il valore zero restituisce tutti i todo ordinati per ID crescente.
+type ListOptions struct {
+	// Statuses, se non vuoto, limita i risultati a questi stati.
+	Statuses []Status
+	// Search filtra i todo il cui titolo contiene il testo (senza
+	// distinguere maiuscole e minuscole, ma solo per le lettere ASCII come
+	// fa LIKE di SQLite: "È" ed "è" restano diverse).
+	Search string
+	// ListID, se diverso da 0, limita i risultati ai todo di quella lista.
+	ListID int
+	// ParentID, se diverso da 0, limita i risultati alle sottoattività
+	// dirette di quel todo.
+	ParentID int
+	// Tags, se non vuoto, limita i risultati ai todo con almeno uno di
+	// questi tag o, con AllTags, con tutti. I nomi vanno già normalizzati
+	// con NormalizeTag.
+	Tags    []string
+	AllTags bool
+	// Overdue, se non nil, tiene solo i todo scaduti (true) o solo quelli
+	// non scaduti (false). Scaduto vuol dire con la scadenza passata e non
+	// ancora fatto né archiviato.
+	Overdue *bool
+	// DueAfter e DueBefore limitano i risultati ai todo con la scadenza
+	// nell'intervallo [DueAfter, DueBefore); i todo senza scadenza restano
+	// fuori. Ognuno dei due può mancare.
+	DueAfter  *time.Time
+	DueBefore *time.Time
+
+	Sort SortField // default SortByID
+	Desc bool
+
+	// Limit è il numero massimo di risultati; 0 significa nessun limite.
+	Limit int
+	// Offset salta i primi risultati (paginazione classica).
+	Offset int
+	// AfterID attiva la paginazione a cursore (keyset sull'ID): restituisce
+	// i todo successivi a questo ID nel verso dell'ordinamento. Si può usare
+	// solo con l'ordinamento per ID e non insieme a Offset.
+	AfterID int
+}
+
+// TodoPage è una pagina di risultati di GetAll.
+type TodoPage struct {
+	Todos []Todo
+	// Total conta i todo che soddisfano i filtri, ignorando la paginazione.
+	Total int
+	// HasMore indica se dopo questa pagina ci sono altri risultati.
+	HasMore bool
+}
+
+// Validate controlla che le opzioni siano coerenti. Tutti i backend la
+// chiamano, così rispondono allo stesso modo a richieste sbagliate.
+func (o ListOptions) Validate() error {
+	for _, st := range o.Statuses {
+		if !st.Valid() {
+			return invalidf("stato %q non valido", st)
+		}
+	}
+	if o.Sort != "" && !o.Sort.valid() {
+		return invalidf("campo di ordinamento %q non valido", o.Sort)
+	}
+	for _, tag := range o.Tags {
+		if normalized, err := NormalizeTag(tag); err != nil {
+			return err
+		} else if normalized != tag {
+			return invalidf("tag %q non normalizzato", tag)
+		}
+	}
+	if o.ListID < 0 {
+		return invalidf("lista %d non valida", o.ListID)
+	}
+	if o.ParentID < 0 {
+		return invalidf("todo genitore %d non valido", o.ParentID)
+	}
+	if o.DueAfter != nil && o.DueBefore != nil && !o.DueAfter.Before(*o.DueBefore) {
+		return invalidf("due_after deve precedere due_before")
+	}
+	if o.Limit < 0 || o.Offset < 0 || o.AfterID < 0 {
+		return invalidf("limit, offset e cursore non possono essere negativi")
+	}
+	if o.AfterID > 0 && o.Offset > 0 {
+		return invalidf("cursore e offset non possono essere usati insieme")
+	}
+	if o.AfterID > 0 && o.sortField() != SortByID {
+		return invalidf("il cursore è disponibile solo con l'ordinamento per id")
+	}
+	return nil
+}
+
+func (f SortField) valid() bool {
+	for _, v := range SortFields {
+		if f == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (o ListOptions) sortField() SortField {
+	if o.Sort == "" {
+		return SortByID
+	}
+	return o.Sort
+}
+
+// tagSet restituisce i tag del filtro senza doppioni.
+func (o ListOptions) tagSet() []string {
+	tags := slices.Clone(o.Tags)
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
+
+// statusRank è l'ordine "logico" degli stati usato nell'ordinamento.
+func statusRank(s Status) int {
+	for i, v := range Statuses {
+		if s == v {
+			return i
+		}
+	}
+	return len(Statuses)
+}
+
+// --- implementazione SQL ---
+
+// sqlWhere costruisce la clausola WHERE (senza cursore) e i relativi
+// argomenti. La condizione sul proprietario c'è sempre.
+func (o ListOptions) sqlWhere(owner int) (string, []any) {
+	conds := []string{"owner_id = ?", "deleted_at IS NULL"}
+	args := []any{owner}
+
+	if len(o.Statuses) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(o.Statuses)), ",")
+		conds = append(conds, "status IN ("+placeholders+")")
+		for _, st := range o.Statuses {
+			args = append(args, st)
+		}
+	}
+	if o.ListID != 0 {
+		conds = append(conds, "list_id = ?")
+		args = append(args, o.ListID)
+	}
+	if o.ParentID != 0 {
+		conds = append(conds, "parent_id = ?")
+		args = append(args, o.ParentID)
+	}
+	if tags := o.tagSet(); len(tags) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(tags)), ",")
+		matching := "SELECT COUNT(*) FROM todo_tags JOIN tags ON tags.id = todo_tags.tag_id" +
+			" WHERE todo_tags.todo_id = todos.id AND tags.name IN (" + placeholders + ")"
+		// con "any" basta un tag in comune, con "all" servono tutti
+		// (un todo non può avere due volte lo stesso tag)
+		if o.AllTags {
+			conds = append(conds, "("+matching+") = ?")
+		} else {
+			conds = append(conds, "("+matching+") > 0")
+		}
+		for _, tag := range tags {
+			args = append(args, tag)
+		}
+		if o.AllTags {
+			args = append(args, len(tags))
+		}
+	}
+	if o.Overdue != nil {
+		// lo stesso confronto di isOverdue; con due_at NULL la condizione è
+		// falsa (non NULL), quindi anche il NOT funziona
+		overdue := "(due_at IS NOT NULL AND due_at < ? AND status IN ('pending', 'in_progress'))"
+		if !*o.Overdue {
+			overdue = "NOT " + overdue
+		}
+		conds = append(conds, overdue)
+		args = append(args, formatDBTime(ptr(now())))
+	}
+	if o.DueAfter != nil {
+		conds = append(conds, "due_at >= ?")
+		args = append(args, formatDBTime(o.DueAfter))
+	}
+	if o.DueBefore != nil {
+		conds = append(conds, "due_at < ?")
+		args = append(args, formatDBTime(o.DueBefore))
+	}
+	if o.Search != "" {
+		// escapiamo i caratteri jolly di LIKE, così "50%" cerca proprio "50%".
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(o.Search)
+		conds = append(conds, `title LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escaped+"%")
+	}
+
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// sqlOrderBy traduce l'ordinamento; l'ID fa sempre da spareggio, così
+// l'ordine è stabile tra una pagina e l'altra.
+func (o ListOptions) sqlOrderBy() string {
+	dir := " ASC"
+	if o.Desc {
+		dir = " DESC"
+	}
+
+	var expr string
+	switch o.sortField() {
+	case SortByTitle:
+		expr = "title COLLATE NOCASE"
+	case SortByStatus:
+		expr = "CASE status WHEN 'pending' THEN 0 WHEN 'in_progress' THEN 1 WHEN 'done' THEN 2 ELSE 3 END"
+	case SortByCompletedAt:
+		expr = "completed_at"
+	case SortByPriority:
+		// i todo senza scadenza vanno in fondo in entrambi i versi
+		return " ORDER BY CASE priority WHEN 'urgent' THEN 0 WHEN 'high' THEN 1 WHEN 'normal' THEN 2 ELSE 3 END" + dir +
+			", due_at IS NULL, due_at" + dir + ", id" + dir
+	case SortByDueAt:
+		return " ORDER BY due_at IS NULL, due_at" + dir + ", id" + dir
+	default:
+		return " ORDER BY id" + dir
+	}
+	return " ORDER BY " + expr + dir + ", id" + dir
+}
+
+// --- implementazione in memoria ---
+
+// isOverdue dice se t è scaduto al momento at.
+func isOverdue(t Todo, at time.Time) bool {
+	return t.DueAt != nil && t.DueAt.Before(at) && (t.Status == StatusPending || t.Status == StatusInProgress)
+}
+
+// asciiLower porta in minuscolo solo le lettere ASCII, come LIKE e COLLATE
+// NOCASE di SQLite: con strings.ToLower il backend in memoria troverebbe
+// (e ordinerebbe) i titoli accentati diversamente da quello SQL.
+func asciiLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, s)
+}
+
+// matches dice se t soddisfa i filtri (cursore escluso); at è l'istante
+// con cui valutare le scadenze.
+func (o ListOptions) matches(t Todo, at time.Time) bool {
+	if len(o.Statuses) > 0 {
+		found := false
+		for _, st := range o.Statuses {
+			if t.Status == st {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if o.ListID != 0 && (t.ListID == nil || *t.ListID != o.ListID) {
+		return false
+	}
+	if o.ParentID != 0 && (t.ParentID == nil || *t.ParentID != o.ParentID) {
+		return false
+	}
+	if tags := o.tagSet(); len(tags) > 0 {
+		found := 0
+		for _, tag := range tags {
+			if slices.Contains(t.Tags, tag) {
+				found++
+			}
+		}
+		if found == 0 || (o.AllTags && found < len(tags)) {
+			return false
+		}
+	}
+	if o.Overdue != nil && isOverdue(t, at) != *o.Overdue {
+		return false
+	}
+	if o.DueAfter != nil && (t.DueAt == nil || t.DueAt.Before(*o.DueAfter)) {
+		return false
+	}
+	if o.DueBefore != nil && (t.DueAt == nil || !t.DueAt.Before(*o.DueBefore)) {
+		return false
+	}
+	if o.Search != "" && !strings.Contains(asciiLower(t.Title), asciiLower(o.Search)) {
+		return false
+	}
+	return true
+}
+
+// sortTodos ordina come fa sqlOrderBy (i NULL di completed_at vengono prima
+// in ordine crescente, i todo senza scadenza stanno sempre in fondo).
+func (o ListOptions) sortTodos(todos []Todo) {
+	// i criteri in ordine di importanza; fixed indica quelli che non
+	// cambiano verso con Desc.
+	type sortKey struct {
+		cmp   func(a, b Todo) int
+		fixed bool
+	}
+	dueNullsLast := sortKey{fixed: true, cmp: func(a, b Todo) int {
+		return boolRank(a.DueAt == nil) - boolRank(b.DueAt == nil)
+	}}
+	dueAt := sortKey{cmp: func(a, b Todo) int {
+		if a.DueAt == nil || b.DueAt == nil {
+			return 0 // già deciso da dueNullsLast
+		}
+		return a.DueAt.Compare(*b.DueAt)
+	}}
+
+	var keys []sortKey
+	switch o.sortField() {
+	case SortByTitle:
+		keys = append(keys, sortKey{cmp: func(a, b Todo) int {
+			return strings.Compare(asciiLower(a.Title), asciiLower(b.Title))
+		}})
+	case SortByStatus:
+		keys = append(keys, sortKey{cmp: func(a, b Todo) int {
+			return statusRank(a.Status) - statusRank(b.Status)
+		}})
+	case SortByCompletedAt:
+		keys = append(keys, sortKey{cmp: func(a, b Todo) int {
+			switch {
+			case a.CompletedAt == nil && b.CompletedAt == nil:
+				return 0
+			case a.CompletedAt == nil:
+				return -1
+			case b.CompletedAt == nil:
+				return 1
+			}
+			return a.CompletedAt.Compare(*b.CompletedAt)
+		}})
+	case SortByPriority:
+		keys = append(keys, sortKey{cmp: func(a, b Todo) int {
+			return priorityRank(a.Priority) - priorityRank(b.Priority)
+		}}, dueNullsLast, dueAt)
+	case SortByDueAt:
+		keys = append(keys, dueNullsLast, dueAt)
+	}
+	keys = append(keys, sortKey{cmp: func(a, b Todo) int { return a.ID - b.ID }})
+
+	sort.SliceStable(todos, func(i, j int) bool {
+		for _, k := range keys {
+			c := k.cmp(todos[i], todos[j])
+			if c == 0 {
+				continue
+			}
+			if o.Desc && !k.fixed {
+				c = -c
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// paginate applica filtri, ordinamento e paginazione a una slice già
+// copiata: è il cuore di GetAll per i backend senza SQL.
+func (o ListOptions) paginate(todos []Todo) TodoPage {
+	filtered := todos[:0]
+	at := now()
+	for _, t := range todos {
+		if o.matches(t, at) {
+			filtered = append(filtered, t)
+		}
+	}
+	o.sortTodos(filtered)
+	page := TodoPage{Total: len(filtered)}
+
+	start := o.Offset
+	if o.AfterID > 0 {
+		// con l'ordinamento per ID il cursore è semplicemente il primo
+		// elemento oltre AfterID nel verso scelto.
+		start = sort.Search(len(filtered), func(i int) bool {
+			if o.Desc {
+				return filtered[i].ID < o.AfterID
+			}
+			return filtered[i].ID > o.AfterID
+		})
+	}
+	if start > len(filtered) {
+		start = len(filtered)
+	}
+	end := len(filtered)
+	if o.Limit > 0 && start+o.Limit < end {
+		end = start + o.Limit
+		page.HasMore = true
+	}
+
+	page.Todos = append([]Todo{}, filtered[start:end]...)
+	return page
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"slices"
+	"todolist-api-v2/internal/rrule"
+)
+
+// canonicalRule porta una regola di ricorrenza nella forma in cui viene
+// salvata. Una regola non valida resta com'è: la rifiuta validate.
+func canonicalRule(s string) string {
+	if s == "" {
+		return ""
+	}
+	r, err := rrule.Parse(s)
+	if err != nil {
+		return s
+	}
+	return r.String()
+}
+
+// validateRecurrence controlla la regola: serve anche una scadenza, da cui
+// si calcolano le occorrenze successive.
+func (t Todo) validateRecurrence() error {
+	if t.Recurrence == "" {
+		return nil
+	}
+	if _, err := rrule.Parse(t.Recurrence); err != nil {
+		return invalidf("ricorrenza non valida: %v", err)
+	}
+	if t.DueAt == nil {
+		return invalidf("un todo ricorrente deve avere una scadenza")
+	}
+	return nil
+}
+
+// nextOccurrence prepara l'occorrenza che segue t, che è appena stato
+// completato partendo da uno stato diverso da "done" (wasDone false).
+// Il nuovo todo copia titolo, descrizione, priorità, lista, genitore e
+// tag, scade alla data successiva della regola e porta con sé il resto
+// della serie. ok è false se t non si ripete, se la serie è finita o se
+// l'occorrenza successiva era già stata creata.
+func (t Todo) nextOccurrence(wasDone bool) (next Todo, ok bool) {
+	if wasDone || t.Status != StatusDone || t.Recurrence == "" || t.DueAt == nil || t.NextOccurrenceID != nil {
+		return Todo{}, false
+	}
+	rule, err := rrule.Parse(t.Recurrence)
+	if err != nil {
+		return Todo{}, false
+	}
+	due, rest, ok := rule.Next(*t.DueAt)
+	if !ok {
+		return Todo{}, false
+	}
+	return Todo{
+		Title:       t.Title,
+		Description: t.Description,
+		Status:      StatusPending,
+		Priority:    t.Priority,
+		DueAt:       &due,
+		Recurrence:  rest.String(),
+		Version:     1,
+		ListID:      t.ListID,
+		ParentID:    t.ParentID,
+		Tags:        slices.Clone(t.Tags),
+		OwnerID:     t.OwnerID,
+	}, true
+}
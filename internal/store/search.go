@@ -0,0 +1,271 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// SearchRepository è la ricerca full-text nei todo dell'utente nel contesto.
+type SearchRepository interface {
+	// Search restituisce i todo fuori dal cestino in cui titolo o
+	// descrizione corrispondono alla ricerca, dal più pertinente; la
+	// sintassi è descritta in parseSearchQuery. Una ricerca non valida è
+	// ErrInvalid.
+	Search(ctx context.Context, opts SearchOptions) (SearchPage, error)
+}
+
+// SearchOptions sono la ricerca e la paginazione di Search.
+type SearchOptions struct {
+	Query string
+	// Limit è il numero massimo di risultati; 0 significa nessun limite.
+	Limit  int
+	Offset int
+}
+
+// SearchResult è un todo trovato da Search.
+type SearchResult struct {
+	Todo Todo `json:"todo"`
+	// Score è la pertinenza, più alta per i risultati migliori: serve solo
+	// a confrontare i risultati della stessa ricerca.
+	Score      float64    `json:"score"`
+	Highlights Highlights `json:"highlights"`
+}
+
+// Highlights sono i campi del todo con le parole trovate tra <mark> e
+// </mark>. Il resto del testo è escapato per l'HTML, quindi si può
+// mostrare così com'è in una pagina web.
+type Highlights struct {
+	Title string `json:"title"`
+	// Description è un estratto della descrizione intorno alla prima parola
+	// trovata; vuoto se la descrizione non contiene nessun termine.
+	Description string `json:"description,omitempty"`
+}
+
+// SearchPage è una pagina di risultati di Search.
+type SearchPage struct {
+	Results []SearchResult
+	// Total conta tutti i todo trovati, ignorando la paginazione.
+	Total int
+	// HasMore indica se dopo questa pagina ci sono altri risultati.
+	HasMore bool
+}
+
+// parse controlla le opzioni e interpreta la ricerca.
+func (o SearchOptions) parse() (searchQuery, error) {
+	if o.Limit < 0 || o.Offset < 0 {
+		return searchQuery{}, invalidf("limit e offset non possono essere negativi")
+	}
+	return parseSearchQuery(o.Query)
+}
+
+// --- implementazione SQL ---
+
+// Lo schema della ricerca non sta nelle migrazioni: FTS5 c'è solo se
+// go-sqlite3 è compilato con -tags sqlite_fts5 e una migrazione che lo usa
+// fallirebbe con tutti gli altri binari. Lo crea ensureSearchIndex
+// all'avvio, quando è disponibile.
+//
+// todos_fts è una tabella "external content": il testo resta in todos e
+// l'indice viene aggiornato dai trigger.
+const searchSchema = `
+CREATE VIRTUAL TABLE IF NOT EXISTS todos_fts USING fts5 (
+    title, description,
+    content = 'todos', content_rowid = 'id',
+    tokenize = 'unicode61 remove_diacritics 2'
+);
+CREATE TRIGGER IF NOT EXISTS todos_fts_insert AFTER INSERT ON todos BEGIN
+    INSERT INTO todos_fts (rowid, title, description) VALUES (new.id, new.title, new.description);
+END;
+CREATE TRIGGER IF NOT EXISTS todos_fts_delete AFTER DELETE ON todos BEGIN
+    INSERT INTO todos_fts (todos_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description);
+END;
+CREATE TRIGGER IF NOT EXISTS todos_fts_update AFTER UPDATE OF title, description ON todos BEGIN
+    INSERT INTO todos_fts (todos_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description);
+    INSERT INTO todos_fts (rowid, title, description) VALUES (new.id, new.title, new.description);
+END;`
+
+// searchObjects sono la tabella e i trigger creati da searchSchema.
+var searchObjects = []string{"todos_fts", "todos_fts_insert", "todos_fts_delete", "todos_fts_update"}
+
+// ErrSearchUnavailable è restituito da Search e RebuildSearchIndex dello
+// store SQLite quando go-sqlite3 è stato compilato senza FTS5. Non c'è un
+// ripiego: scorrere tutti i todo a ogni ricerca non è la ricerca che
+// l'API promette, meglio un errore chiaro.
+var ErrSearchUnavailable = errors.New("ricerca full-text non disponibile: go-sqlite3 va compilato con -tags sqlite_fts5 (make build)")
+
+// ensureSearchIndex prepara la ricerca full-text e dice se è disponibile.
+// Se manca qualcosa (il primo avvio con FTS5, oppure una migrazione che ha
+// ricreato la tabella todos e con lei i trigger) crea quello che manca e
+// ricostruisce l'indice da zero.
+func ensureSearchIndex(ctx context.Context, db *sql.DB) (bool, error) {
+	var enabled bool
+	if err := db.QueryRowContext(ctx, "SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled); err != nil {
+		return false, fmt.Errorf("errore nel controllo di FTS5: %w", err)
+	}
+	if !enabled {
+		// i trigger lasciati da un binario con FTS5 farebbero fallire ogni
+		// scrittura sui todo con "no such module: fts5"
+		for _, trigger := range searchObjects[1:] {
+			if _, err := db.ExecContext(ctx, "DROP TRIGGER IF EXISTS "+trigger); err != nil {
+				return false, fmt.Errorf("errore nella rimozione del trigger %s: %w", trigger, err)
+			}
+		}
+		return false, nil
+	}
+
+	var present int
+	query := "SELECT COUNT(*) FROM sqlite_master WHERE name IN (?, ?, ?, ?)"
+	if err := db.QueryRowContext(ctx, query, searchObjects[0], searchObjects[1], searchObjects[2], searchObjects[3]).Scan(&present); err != nil {
+		return false, fmt.Errorf("errore nel controllo dell'indice di ricerca: %w", err)
+	}
+	if present == len(searchObjects) {
+		return true, nil
+	}
+	n, err := rebuildSearchIndex(ctx, db)
+	if err != nil {
+		return false, err
+	}
+	log.Printf("indice di ricerca ricostruito: %d todo", n)
+	return true, nil
+}
+
+// rebuildSearchIndex ricrea da zero tabella e trigger della ricerca e
+// restituisce quanti todo ha indicizzato.
+func rebuildSearchIndex(ctx context.Context, db *sql.DB) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, dbError("errore nell'apertura della transazione", err)
+	}
+	defer tx.Rollback()
+
+	for _, trigger := range searchObjects[1:] {
+		if _, err := tx.ExecContext(ctx, "DROP TRIGGER IF EXISTS "+trigger); err != nil {
+			return 0, dbError("errore nella rimozione dei trigger della ricerca", err)
+		}
+	}
+	steps := []string{
+		"DROP TABLE IF EXISTS todos_fts",
+		searchSchema,
+		"INSERT INTO todos_fts (todos_fts) VALUES ('rebuild')",
+	}
+	for _, step := range steps {
+		if _, err := tx.ExecContext(ctx, step); err != nil {
+			return 0, dbError("errore nella ricostruzione dell'indice di ricerca", err)
+		}
+	}
+	var n int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM todos").Scan(&n); err != nil {
+		return 0, dbError("errore nel conteggio dei todo", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, dbError("errore nel commit della transazione", err)
+	}
+	return n, nil
+}
+
+// RebuildSearchIndex ricostruisce da zero l'indice della ricerca, ad
+// esempio se si sospetta che non sia allineato ai todo, e restituisce
+// quanti todo ha indicizzato. Non si può usare dentro WithTx.
+func (s *Store) RebuildSearchIndex(ctx context.Context) (int, error) {
+	if !s.fts {
+		return 0, ErrSearchUnavailable
+	}
+	if s.tx != nil {
+		return 0, errors.New("l'indice di ricerca non si ricostruisce dentro una transazione")
+	}
+	return rebuildSearchIndex(ctx, s.db)
+}
+
+// bm25 dà la pertinenza di FTS5: più è basso meglio è, per questo lo
+// score è il suo opposto. I pesi sono quelli delle colonne.
+var bm25 = fmt.Sprintf("bm25(todos_fts, %d, 1)", titleWeight)
+
+func (s *Store) Search(ctx context.Context, opts SearchOptions) (SearchPage, error) {
+	q, err := opts.parse()
+	if err != nil {
+		return SearchPage{}, err
+	}
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return SearchPage{}, err
+	}
+	if !s.fts {
+		return SearchPage{}, ErrSearchUnavailable
+	}
+
+	// L'indice non sa nulla di utenti e cestino: li filtriamo sui todo.
+	// La MATCH sta in una sottoquery perché anche todos_fts ha le colonne
+	// title e description.
+	from := " FROM todos JOIN (SELECT rowid, " + bm25 + " AS bm25 FROM todos_fts WHERE todos_fts MATCH ?) AS m ON m.rowid = todos.id" +
+		" WHERE owner_id = ? AND deleted_at IS NULL"
+	match := q.fts()
+
+	page := SearchPage{Results: []SearchResult{}}
+	if err := s.conn().QueryRowContext(ctx, "SELECT COUNT(*)"+from, match, owner).Scan(&page.Total); err != nil {
+		return SearchPage{}, dbError("errore nella ricerca", err)
+	}
+	limit := opts.Limit
+	if limit == 0 {
+		limit = -1 // in SQLite LIMIT -1 vuol dire nessun limite
+	}
+	query := "SELECT " + todoColumns + ", -m.bm25" + from + " ORDER BY m.bm25, id LIMIT ? OFFSET ?"
+	rows, err := s.conn().QueryContext(ctx, query, match, owner, limit, opts.Offset)
+	if err != nil {
+		return SearchPage{}, dbError("errore nella ricerca", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var res SearchResult
+		// scanTodo non conosce la colonna in più: la leggiamo con un
+		// scanner che la aggiunge in fondo
+		if res.Todo, err = scanTodo(scoreScanner{rows, &res.Score}); err != nil {
+			return SearchPage{}, dbError("errore nello scan di una riga", err)
+		}
+		res.Highlights = q.highlight(res.Todo)
+		page.Results = append(page.Results, res)
+	}
+	if err := rows.Err(); err != nil {
+		return SearchPage{}, dbError("errore durante l'iterazione delle righe", err)
+	}
+	page.HasMore = opts.Offset+len(page.Results) < page.Total
+	return page, nil
+}
+
+// scoreScanner passa a Scan anche la destinazione dello score, che nella
+// query segue le colonne di todoColumns.
+type scoreScanner struct {
+	row   scanner
+	score *float64
+}
+
+func (s scoreScanner) Scan(dest ...any) error {
+	return s.row.Scan(append(dest, s.score)...)
+}
+
+// --- implementazione in memoria ---
+
+func (s *MemoryStore) Search(ctx context.Context, opts SearchOptions) (SearchPage, error) {
+	q, err := opts.parse()
+	if err != nil {
+		return SearchPage{}, err
+	}
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return SearchPage{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	todos := make([]Todo, 0, len(s.todos))
+	for _, t := range s.todos {
+		if t.OwnerID == owner && t.DeletedAt == nil {
+			todos = append(todos, t)
+		}
+	}
+	s.withProgress(todos)
+	return q.searchTodos(todos, opts), nil
+}
@@ -0,0 +1,517 @@
+package store
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxSearchQueryLength limita la lunghezza (in byte) di una ricerca.
+const maxSearchQueryLength = 500
+
+// --- parole ---
+
+// searchToken è una parola di un testo, già normalizzata, con la sua
+// posizione (in byte) nel testo originale.
+type searchToken struct {
+	word       string
+	start, end int
+}
+
+// tokenize divide il testo in parole come il tokenizer unicode61 di FTS5
+// con remove_diacritics 2: lettere e cifre fanno parte delle parole, tutto
+// il resto le separa; maiuscole e accenti non contano.
+func tokenize(text string) []searchToken {
+	var tokens []searchToken
+	var word strings.Builder
+	start := -1
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			if start < 0 {
+				start = i
+			}
+			word.WriteRune(foldRune(r))
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, searchToken{word: word.String(), start: start, end: i})
+			word.Reset()
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, searchToken{word: word.String(), start: start, end: len(text)})
+	}
+	return tokens
+}
+
+// diacritics associa le lettere accentate più comuni a quella senza accento.
+var diacritics = func() map[rune]rune {
+	m := make(map[rune]rune)
+	for base, accented := range map[rune]string{
+		'a': "àáâãäåāăą", 'c': "çćĉċč", 'd': "ď", 'e': "èéêëēĕėęě", 'g': "ĝğġģ",
+		'i': "ìíîïĩīĭįı", 'l': "ĺļľł", 'n': "ñńņň", 'o': "òóôõöōŏő", 'r': "ŕŗř",
+		's': "śŝşš", 't': "ţť", 'u': "ùúûüũūŭůűų", 'y': "ýÿŷ", 'z': "źżž",
+	} {
+		for _, r := range accented {
+			m[r] = base
+		}
+	}
+	return m
+}()
+
+func foldRune(r rune) rune {
+	r = unicode.ToLower(r)
+	if base, ok := diacritics[r]; ok {
+		return base
+	}
+	return r
+}
+
+// --- sintassi ---
+
+// searchNode è un pezzo della ricerca: un termine o un operatore.
+type searchNode interface {
+	// fts è la stessa condizione nella sintassi di FTS5 MATCH.
+	fts() string
+	match(d searchDoc) bool
+	// positive sono i termini che devono comparire, cioè quelli che non
+	// stanno a destra di un NOT: sono quelli da contare ed evidenziare.
+	positive() []searchTerm
+}
+
+// searchTerm è una parola o una frase (più parole consecutive); con
+// prefix l'ultima parola basta che inizi così.
+type searchTerm struct {
+	words  []string
+	prefix bool
+}
+
+type searchOp struct {
+	op          string // AND, OR o NOT
+	left, right searchNode
+}
+
+// searchQuery è una ricerca già controllata, pronta per qualsiasi backend.
+type searchQuery struct {
+	root searchNode
+}
+
+// parseSearchQuery interpreta la sintassi delle ricerche, la stessa delle
+// query di FTS5:
+//
+//	pane latte        entrambe le parole (AND implicito)
+//	pane OR latte     almeno una delle due
+//	pane NOT latte    pane ma non latte
+//	spes*             le parole che iniziano con "spes"
+//	"latte fresco"    le parole una dopo l'altra
+//	(pane OR latte) AND spesa
+//
+// Gli operatori vanno scritti in maiuscolo: "and" è una parola come le
+// altre. Maiuscole e accenti non contano, la punteggiatura viene ignorata.
+func parseSearchQuery(query string) (searchQuery, error) {
+	if len(query) > maxSearchQueryLength {
+		return searchQuery{}, invalidf("la ricerca può essere lunga al massimo %d caratteri", maxSearchQueryLength)
+	}
+	items, err := lexSearch(query)
+	if err != nil {
+		return searchQuery{}, err
+	}
+	if len(items) == 0 {
+		return searchQuery{}, invalidf("la ricerca non contiene parole")
+	}
+	p := &searchParser{items: items}
+	root, err := p.or()
+	if err != nil {
+		return searchQuery{}, err
+	}
+	if p.pos < len(p.items) {
+		return searchQuery{}, invalidf("%s inatteso nella ricerca", p.items[p.pos].describe())
+	}
+	return searchQuery{root: root}, nil
+}
+
+// tipi di searchItem
+const (
+	itemTerm = iota
+	itemOp
+	itemOpen
+	itemClose
+)
+
+// searchItem è un elemento della ricerca prima dell'analisi.
+type searchItem struct {
+	kind int
+	op   string     // itemOp
+	term searchTerm // itemTerm
+	text string     // com'era scritto, per i messaggi di errore
+}
+
+func (it searchItem) describe() string {
+	if it.kind == itemTerm {
+		return "il termine " + it.text
+	}
+	return "\"" + it.text + "\""
+}
+
+// lexSearch divide la ricerca in termini, operatori e parentesi. Le parole
+// fatte solo di punteggiatura spariscono; quelle che contengono
+// punteggiatura ("e-mail") diventano frasi, come in FTS5.
+func lexSearch(query string) ([]searchItem, error) {
+	var items []searchItem
+	for i := 0; i < len(query); {
+		r, size := utf8.DecodeRuneInString(query[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '(' || r == ')':
+			kind := itemOpen
+			if r == ')' {
+				kind = itemClose
+			}
+			items = append(items, searchItem{kind: kind, text: string(r)})
+			i += size
+		case r == '"':
+			end := strings.IndexByte(query[i+1:], '"')
+			if end < 0 {
+				return nil, invalidf("virgolette non chiuse nella ricerca")
+			}
+			text := query[i : i+end+2]
+			i += end + 2
+			prefix := strings.HasPrefix(query[i:], "*")
+			if prefix {
+				i++
+			}
+			if term, ok := newSearchTerm(text[1:len(text)-1], prefix); ok {
+				items = append(items, searchItem{kind: itemTerm, term: term, text: text})
+			}
+		default:
+			end := strings.IndexFunc(query[i:], func(r rune) bool {
+				return unicode.IsSpace(r) || r == '(' || r == ')' || r == '"'
+			})
+			if end < 0 {
+				end = len(query) - i
+			}
+			text := query[i : i+end]
+			i += end
+			if text == "AND" || text == "OR" || text == "NOT" {
+				items = append(items, searchItem{kind: itemOp, op: text, text: text})
+				continue
+			}
+			if term, ok := newSearchTerm(strings.TrimSuffix(text, "*"), strings.HasSuffix(text, "*")); ok {
+				items = append(items, searchItem{kind: itemTerm, term: term, text: text})
+			}
+		}
+	}
+	return items, nil
+}
+
+func newSearchTerm(text string, prefix bool) (searchTerm, bool) {
+	term := searchTerm{prefix: prefix}
+	for _, tok := range tokenize(text) {
+		term.words = append(term.words, tok.word)
+	}
+	return term, len(term.words) > 0
+}
+
+// searchParser analizza gli elementi con le precedenze di FTS5: NOT lega
+// più di AND, che lega più di OR.
+type searchParser struct {
+	items []searchItem
+	pos   int
+}
+
+func (p *searchParser) peek() (searchItem, bool) {
+	if p.pos >= len(p.items) {
+		return searchItem{}, false
+	}
+	return p.items[p.pos], true
+}
+
+func (p *searchParser) acceptOp(op string) bool {
+	if it, ok := p.peek(); ok && it.kind == itemOp && it.op == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *searchParser) or() (searchNode, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptOp("OR") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = searchOp{op: "OR", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *searchParser) and() (searchNode, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		// AND può anche essere sottinteso tra due termini
+		if !p.acceptOp("AND") {
+			if it, ok := p.peek(); !ok || (it.kind != itemTerm && it.kind != itemOpen) {
+				return left, nil
+			}
+		}
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = searchOp{op: "AND", left: left, right: right}
+	}
+}
+
+func (p *searchParser) not() (searchNode, error) {
+	left, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptOp("NOT") {
+		right, err := p.primary()
+		if err != nil {
+			return nil, err
+		}
+		left = searchOp{op: "NOT", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *searchParser) primary() (searchNode, error) {
+	it, ok := p.peek()
+	if !ok {
+		return nil, invalidf("la ricerca finisce con un operatore: manca un termine")
+	}
+	p.pos++
+	switch it.kind {
+	case itemTerm:
+		return it.term, nil
+	case itemOpen:
+		node, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if next, ok := p.peek(); !ok || next.kind != itemClose {
+			return nil, invalidf("parentesi non chiusa nella ricerca")
+		}
+		p.pos++
+		return node, nil
+	case itemOp:
+		return nil, invalidf("%q deve stare tra due termini", it.op)
+	default:
+		return nil, invalidf("parentesi chiusa senza quella aperta nella ricerca")
+	}
+}
+
+// --- FTS5 ---
+
+// fts restituisce la ricerca nella sintassi di FTS5 MATCH. Ogni termine
+// viene rimesso tra virgolette, così nulla di quello che scrive l'utente
+// può diventare un errore di sintassi di SQLite.
+func (q searchQuery) fts() string { return q.root.fts() }
+
+func (t searchTerm) fts() string {
+	s := `"` + strings.Join(t.words, " ") + `"`
+	if t.prefix {
+		s += " *"
+	}
+	return s
+}
+
+func (o searchOp) fts() string {
+	return "(" + o.left.fts() + " " + o.op + " " + o.right.fts() + ")"
+}
+
+// --- ricerca in Go ---
+
+// searchDoc sono i campi di un todo su cui si cerca, divisi in parole.
+type searchDoc struct {
+	title, description []searchToken
+}
+
+func newSearchDoc(t Todo) searchDoc {
+	return searchDoc{title: tokenize(t.Title), description: tokenize(t.Description)}
+}
+
+// find restituisce la posizione della prima parola di ogni occorrenza del
+// termine. Come in FTS5 una frase non può stare a cavallo di due campi.
+func (t searchTerm) find(tokens []searchToken) []int {
+	var found []int
+	for i := 0; i+len(t.words) <= len(tokens); i++ {
+		ok := true
+		for j, w := range t.words {
+			tok := tokens[i+j].word
+			last := j == len(t.words)-1
+			if tok != w && !(last && t.prefix && strings.HasPrefix(tok, w)) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			found = append(found, i)
+		}
+	}
+	return found
+}
+
+func (t searchTerm) match(d searchDoc) bool {
+	return len(t.find(d.title)) > 0 || len(t.find(d.description)) > 0
+}
+
+func (o searchOp) match(d searchDoc) bool {
+	switch o.op {
+	case "AND":
+		return o.left.match(d) && o.right.match(d)
+	case "OR":
+		return o.left.match(d) || o.right.match(d)
+	default:
+		return o.left.match(d) && !o.right.match(d)
+	}
+}
+
+func (t searchTerm) positive() []searchTerm { return []searchTerm{t} }
+
+func (o searchOp) positive() []searchTerm {
+	if o.op == "NOT" {
+		return o.left.positive()
+	}
+	return append(o.left.positive(), o.right.positive()...)
+}
+
+// titleWeight è quanto conta una parola trovata nel titolo rispetto a una
+// trovata nella descrizione, sia qui che nel bm25 dello store SQL.
+const titleWeight = 10
+
+// score è la pertinenza calcolata dai backend senza FTS5: le occorrenze
+// dei termini, con quelle nel titolo che pesano di più. Non è il bm25 di
+// SQLite, ma ordina i risultati in modo simile.
+func (q searchQuery) score(d searchDoc) float64 {
+	var score float64
+	for _, t := range q.root.positive() {
+		score += float64(titleWeight*len(t.find(d.title)) + len(t.find(d.description)))
+	}
+	return score
+}
+
+// searchTodos filtra, ordina e pagina todos in Go; lo usano i backend
+// senza indice full-text.
+func (q searchQuery) searchTodos(todos []Todo, opts SearchOptions) SearchPage {
+	results := []SearchResult{}
+	for _, t := range todos {
+		d := newSearchDoc(t)
+		if q.root.match(d) {
+			results = append(results, SearchResult{Todo: t, Score: q.score(d)})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Todo.ID < results[j].Todo.ID
+	})
+
+	page := SearchPage{Total: len(results)}
+	results = results[min(opts.Offset, len(results)):]
+	if opts.Limit > 0 && len(results) > opts.Limit {
+		results, page.HasMore = results[:opts.Limit], true
+	}
+	for i := range results {
+		results[i].Highlights = q.highlight(results[i].Todo)
+	}
+	page.Results = results
+	return page
+}
+
+// --- evidenziazione ---
+
+// Marcatori delle parole trovate negli Highlights.
+const (
+	markOpen  = "<mark>"
+	markClose = "</mark>"
+	// snippetWords è la lunghezza massima, in parole, dell'estratto della
+	// descrizione.
+	snippetWords = 16
+	ellipsis     = "…"
+)
+
+// highlight prepara gli Highlights del todo. I backend li calcolano tutti
+// allo stesso modo, anche quello con FTS5, così le risposte non cambiano
+// da un backend all'altro e il testo è sempre escapato correttamente.
+func (q searchQuery) highlight(t Todo) Highlights {
+	d := newSearchDoc(t)
+	terms := q.root.positive()
+	h := Highlights{Title: markText(t.Title, d.title, 0, len(d.title), terms)}
+
+	first := -1
+	for _, term := range terms {
+		if found := term.find(d.description); len(found) > 0 && (first < 0 || found[0] < first) {
+			first = found[0]
+		}
+	}
+	if first < 0 {
+		return h
+	}
+	// l'estratto parte un paio di parole prima della prima trovata
+	from := max(0, min(first-2, len(d.description)-snippetWords))
+	to := min(len(d.description), from+snippetWords)
+	h.Description = markText(t.Description, d.description, from, to, terms)
+	return h
+}
+
+// markText restituisce il testo delle parole tokens[from:to], escapato per
+// l'HTML, con i termini trovati tra markOpen e markClose. Se l'estratto non
+// comprende tutto il testo, dove è stato tagliato compare "…".
+func markText(text string, tokens []searchToken, from, to int, terms []searchTerm) string {
+	if len(tokens) == 0 {
+		return html.EscapeString(text)
+	}
+	// marked[i] dice se la parola i fa parte di un termine trovato
+	marked := make([]bool, len(tokens))
+	for _, term := range terms {
+		for _, i := range term.find(tokens) {
+			for j := range term.words {
+				marked[i+j] = true
+			}
+		}
+	}
+
+	start, end := 0, len(text)
+	var b strings.Builder
+	if from > 0 {
+		start = tokens[from].start
+		b.WriteString(ellipsis)
+	}
+	if to < len(tokens) {
+		end = tokens[to-1].end
+	}
+	pos := start
+	for i := from; i < to; i++ {
+		if !marked[i] || (i > from && marked[i-1]) {
+			continue
+		}
+		// una sequenza di parole evidenziate diventa un solo <mark>
+		last := i
+		for last+1 < to && marked[last+1] {
+			last++
+		}
+		b.WriteString(html.EscapeString(text[pos:tokens[i].start]))
+		b.WriteString(markOpen + html.EscapeString(text[tokens[i].start:tokens[last].end]) + markClose)
+		pos = tokens[last].end
+	}
+	b.WriteString(html.EscapeString(text[pos:end]))
+	if end < len(text) {
+		b.WriteString(ellipsis)
+	}
+	return b.String()
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Status è lo stato di avanzamento di un todo.
+type Status string
+
+const (
+	StatusPending    Status = "pending"
+	StatusInProgress Status = "in_progress"
+	StatusDone       Status = "done"
+	StatusArchived   Status = "archived"
+)
+
+// Statuses elenca tutti gli stati validi, nell'ordine in cui li mostriamo.
+var Statuses = []Status{StatusPending, StatusInProgress, StatusDone, StatusArchived}
+
+// Valid dice se s è uno degli stati previsti.
+func (s Status) Valid() bool {
+	for _, v := range Statuses {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseStatus converte una stringa ricevuta dal client in uno Status,
+// rifiutando qualsiasi valore non previsto.
+func ParseStatus(s string) (Status, error) {
+	status := Status(s)
+	if !status.Valid() {
+		return "", invalidf("stato %q non valido (valori ammessi: pending, in_progress, done, archived)", s)
+	}
+	return status, nil
+}
+
+// LegacyStatus interpreta i vecchi valori liberi del campo "completed".
+// Deve restare allineata alla CASE della migrazione 0002_status.
+func LegacyStatus(completed string) Status {
+	switch strings.ToLower(strings.TrimSpace(completed)) {
+	case "completed", "complete", "done", "true", "yes", "1", "completato", "fatto":
+		return StatusDone
+	case "in progress", "in_progress", "doing", "in corso":
+		return StatusInProgress
+	case "archived", "archiviato":
+		return StatusArchived
+	default:
+		return StatusPending
+	}
+}
+
+// now è la sorgente del tempo per tutti i backend; i test possono sostituirla.
+var now = func() time.Time { return time.Now().UTC() }
+
+// setStatus aggiorna lo stato del todo mantenendo coerenti Completed e
+// CompletedAt: il timestamp viene impostato quando il todo diventa "done",
+// conservato se viene archiviato e azzerato se torna da fare.
+// Un cambio di stato rende obsoleto l'eventuale valore legacy.
+func (t *Todo) setStatus(status Status, at time.Time) {
+	if status != t.Status {
+		t.legacyCompleted = ""
+	}
+	switch {
+	case status == StatusDone && t.Status != StatusDone:
+		t.CompletedAt = &at
+	case status == StatusPending || status == StatusInProgress:
+		t.CompletedAt = nil
+	}
+	t.Status = status
+	t.Completed = status == StatusDone
+}
+
+// Le date sono salvate in SQLite come testo UTC a larghezza fissa:
+// così il confronto tra stringhe coincide con quello cronologico.
+const dbTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+func formatDBTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(dbTimeFormat)
+}
+
+func parseDBTime(s *string) (*time.Time, error) {
+	if s == nil {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, *s)
+	if err != nil {
+		return nil, fmt.Errorf("data non valida nel database %q: %w", *s, err)
+	}
+	return &t, nil
+}
+
+// Le scadenze invece tengono il fuso del client: RFC 3339 ne indica solo
+// la differenza da UTC, che salviamo a parte in secondi (due_offset).
+// Serve a restituire la data come è stata scritta e a calcolare le
+// ricorrenze nei giorni del client.
+
+// withOffset restituisce t in un fuso fisso con lo stesso offset, senza
+// nome: dal solo offset non si può sapere di che fuso si tratti.
+func withOffset(t time.Time) time.Time {
+	_, offset := t.Zone()
+	return t.In(offsetZone(offset))
+}
+
+// offsetZone è il fuso fisso offset secondi a est di UTC; time.UTC per 0,
+// così le date in UTC restano uguali a quelle di time.Time.UTC.
+func offsetZone(offset int) *time.Location {
+	if offset == 0 {
+		return time.UTC
+	}
+	return time.FixedZone("", offset)
+}
+
+// dueOffset è l'offset da salvare in due_offset.
+func dueOffset(t *time.Time) int {
+	if t == nil {
+		return 0
+	}
+	_, offset := t.Zone()
+	return offset
+}
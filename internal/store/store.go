@@ -1,342 +1,652 @@
 package store
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
 	// Import "blank" per il driver. L'underscore dice a Go di eseguire
 	// solo la funzione di init() del pacchetto, che lo registra.
 	"database/sql"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
 // definiamo la struct Todo, lo facciamo qui perchè è strettamente
 // legata allo store.
 type Todo struct {
-	ID        int    `json:"id"`
-	Title     string `json:"title"`
-	Completed string `json:"completed"`
+	ID          int      `json:"id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Status      Status   `json:"status"`
+	Priority    Priority `json:"priority"`
+	// DueAt è la scadenza, con l'offset da UTC indicato dal client; nil se
+	// il todo non scade.
+	DueAt *time.Time `json:"due_at,omitempty"`
+	// Recurrence è la regola di ricorrenza (un sottoinsieme di RRULE, vedi
+	// il pacchetto rrule) in forma canonica; vuota se il todo non si ripete.
+	Recurrence string `json:"recurrence,omitempty"`
+	// NextOccurrenceID è l'occorrenza creata completando questo todo.
+	NextOccurrenceID *int `json:"next_occurrence_id,omitempty"`
+	// Completed è ricavato da Status: vale true solo per i todo "done".
+	Completed   bool       `json:"completed"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	// Version parte da 1 e aumenta a ogni modifica; serve per gli ETag e
+	// per il controllo di concorrenza ottimistico.
+	Version int `json:"version"`
+	// ListID è la lista che contiene il todo; nil se non è in nessuna lista.
+	ListID *int `json:"list_id"`
+	// ParentID è il todo di cui questo è una sottoattività; nil per i todo
+	// di primo livello.
+	ParentID *int `json:"parent_id"`
+	// Progress è la percentuale di sottoattività dirette completate,
+	// calcolata a ogni lettura; nil se il todo non ha sottoattività.
+	Progress *int `json:"progress,omitempty"`
+	// Tags sono le etichette del todo, in ordine alfabetico; si modificano
+	// con AddTag e RemoveTag.
+	Tags []string `json:"tags"`
+	// DeletedAt è il momento in cui il todo è finito nel cestino; nil per
+	// tutti i todo tranne quelli restituiti da GetTrash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// OwnerID è l'utente a cui appartiene il todo. Non viene esposto: un
+	// client vede comunque solo i propri todo.
+	OwnerID int `json:"-"`
+
+	// legacyCompleted conserva il vecchio valore testuale di "completed"
+	// finché lo stato non viene cambiato; non viene mai esposto ai client.
+	legacyCompleted string
 }
 
-/*Store gestisce l'accesso ai dati dei Todo*/
-/*
-type Store struct {
-	mu       sync.RWMutex //RWMutex è più performante per letture multiple
-	todos    map[int]Todo // mappa per accesso veloce tramite ID
-	nextID   int
-	filePath string
+// MaxDescriptionLength è la lunghezza massima della descrizione, in caratteri.
+const MaxDescriptionLength = 10000
+
+// validate controlla i campi modificabili ricevuti da Create e Update.
+func (t Todo) validate() error {
+	if t.Title == "" {
+		return invalidf("il titolo non può essere vuoto")
+	}
+	if utf8.RuneCountInString(t.Description) > MaxDescriptionLength {
+		return invalidf("la descrizione può avere al massimo %d caratteri", MaxDescriptionLength)
+	}
+	if !t.Status.Valid() {
+		return invalidf("stato %q non valido", t.Status)
+	}
+	if t.Priority != "" && !t.Priority.Valid() {
+		return invalidf("priorità %q non valida", t.Priority)
+	}
+	if err := t.validateRecurrence(); err != nil {
+		return err
+	}
+	if t.ListID != nil && *t.ListID < 1 {
+		return invalidf("lista %d non valida", *t.ListID)
+	}
+	if t.ParentID != nil && *t.ParentID < 1 {
+		return invalidf("todo genitore %d non valido", *t.ParentID)
+	}
+	return nil
 }
-*/
-//versione per db sqlite
-type Store struct {
-	db *sql.DB
+
+// apply copia su t i campi modificabili di input, ricalcolando quelli
+// derivati e incrementando la versione.
+func (t *Todo) apply(input Todo, at time.Time) {
+	t.Title = input.Title
+	t.Description = input.Description
+	t.setStatus(input.Status, at)
+	t.setSchedule(input.Priority, input.DueAt, input.Recurrence)
+	t.ListID = input.ListID
+	t.ParentID = input.ParentID
+	t.Version++
 }
 
-// crea e inizializza una nuova istanza dello store.
-/*
-func New(filePath string) (*Store, error) {
-	s := &Store{
-		todos:    make(map[int]Todo),
-		nextID:   1,
-		filePath: filePath,
+// newTodo prepara un todo appena creato a partire dall'input di Create:
+// stato indicato (vuoto = "pending"), versione 1 e nessun tag. ID e
+// proprietario li mette il backend. Un todo che nasce "done" o "archived"
+// tiene il CompletedAt dell'input, se c'è: serve all'import per non
+// perdere quando era stato completato.
+func (input Todo) newTodo() (Todo, error) {
+	t := Todo{
+		Title:       input.Title,
+		Description: input.Description,
+		Status:      StatusPending,
+		Version:     1,
+		ListID:      input.ListID,
+		ParentID:    input.ParentID,
+		Tags:        []string{},
+	}
+	if input.Status != "" {
+		t.setStatus(input.Status, now())
+	}
+	if input.CompletedAt != nil && (t.Status == StatusDone || t.Status == StatusArchived) {
+		t.CompletedAt = ptr(input.CompletedAt.UTC())
+	}
+	t.setSchedule(input.Priority, input.DueAt, input.Recurrence)
+	if err := t.validate(); err != nil {
+		return Todo{}, err
 	}
-	return s, s.load()
+	return t, nil
 }
-*/
 
-// New crea una nuova istanza dello Store e inizializza il database.
+// setSchedule imposta priorità (vuota = PriorityNormal), scadenza, di cui
+// resta solo l'offset del fuso (vedi withOffset), e ricorrenza.
+func (t *Todo) setSchedule(priority Priority, dueAt *time.Time, recurrence string) {
+	if priority == "" {
+		priority = PriorityNormal
+	}
+	t.Priority = priority
+	t.DueAt = nil
+	if dueAt != nil {
+		t.DueAt = ptr(withOffset(*dueAt))
+	}
+	t.Recurrence = canonicalRule(recurrence)
+}
+
+// ErrVersionConflict indica che il todo è stato modificato da qualcun altro
+// dopo che il client ne ha letto la versione. È un caso particolare di
+// ErrConflict: errors.Is(err, ErrConflict) vale anche per lui.
+var ErrVersionConflict = fmt.Errorf("%w: il todo è stato modificato nel frattempo", ErrConflict)
+
+// checkVersion confronta la versione attesa dal client con quella attuale;
+// expected == 0 significa "nessun controllo".
+func checkVersion(expected, current int) error {
+	if expected != 0 && expected != current {
+		return fmt.Errorf("%w (versione attesa %d, attuale %d)", ErrVersionConflict, expected, current)
+	}
+	return nil
+}
+
+// TodoRepository è il contratto che ogni backend di persistenza deve
+// rispettare. Gli handler dipendono solo da questa interfaccia, così
+// possiamo scegliere all'avvio tra SQLite, file JSON o memoria.
+//
+// Gli errori sono sempre riconoscibili con errors.Is, qualunque sia il
+// backend: ErrNotFound se il todo non esiste, ErrInvalid per dati o opzioni
+// non validi, ErrConflict se l'operazione contrasta con lo stato attuale,
+// ErrUnavailable se l'archivio non risponde (database bloccato, disco
+// pieno...). Update e Delete accettano una versione attesa (0 = qualsiasi)
+// e restituiscono ErrVersionConflict se non coincide.
+//
+// Ogni metodo (tranne Close) lavora sui todo dell'utente impostato nel
+// contesto con WithOwner: i todo degli altri utenti risultano inesistenti.
+type TodoRepository interface {
+	GetAll(ctx context.Context, opts ListOptions) (TodoPage, error)
+	GetByID(ctx context.Context, ID int) (Todo, error)
+	// Create crea un todo con titolo, descrizione, stato (vuoto =
+	// "pending"), priorità, scadenza, ricorrenza, lista e genitore; gli
+	// altri campi sono decisi dallo store, tranne CompletedAt che un todo
+	// creato "done" o "archived" può portarsi dietro. Creare un todo già
+	// "done" non genera l'occorrenza successiva.
+	// Una lista o un genitore inesistenti danno ErrInvalid.
+	Create(ctx context.Context, todo Todo) (Todo, error)
+	// Update sostituisce i campi modificabili del todo con ID todo.ID
+	// (titolo, descrizione, stato, priorità, scadenza, ricorrenza, lista e
+	// genitore:
+	// cambiare ListID sposta il todo di lista, cambiare ParentID lo sposta
+	// sotto un altro todo, ma non sotto una sua sottoattività);
+	// i campi derivati (Completed, CompletedAt) sono calcolati dallo store.
+	// todo.Version è la versione attesa. Completare un todo ricorrente crea,
+	// nella stessa operazione, l'occorrenza successiva (NextOccurrenceID).
+	Update(ctx context.Context, todo Todo) (Todo, error)
+	// Delete sposta il todo nel cestino (vedi TrashRepository): da lì si
+	// può ripristinare finché non viene eliminato definitivamente.
+	Delete(ctx context.Context, ID int, version int) error
+	// Close rilascia le risorse del backend; dopo Close lo store non va
+	// più usato.
+	Close() error
+}
+
+// Verifichiamo a tempo di compilazione che Store implementi l'interfaccia.
+var _ TodoRepository = (*Store)(nil)
+
+// Store è l'implementazione di TodoRepository basata su SQLite.
+type Store struct {
+	db *sql.DB
+	// tx è la transazione aperta da WithTx: se c'è, tutti i metodi lavorano
+	// dentro di essa.
+	tx *sql.Tx
+	// fts dice se c'è l'indice della ricerca full-text (vedi search.go).
+	fts bool
+}
+
+// New crea una nuova istanza dello Store e porta lo schema del database
+// all'ultima versione applicando le migrazioni mancanti.
 func New(dbPath string) (*Store, error) {
-	// Apriamo la connessione al database. Se il file non esiste, viene creato.
-	db, err := sql.Open("sqlite3", dbPath)
+	db, err := OpenDB(dbPath)
 	if err != nil {
-		return nil, fmt.Errorf("errore nell'aprire il db: %w", err)
+		return nil, err
 	}
 
-	// Ping verifica che la connessione sia effettivamente valida.
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("errore nel ping del db: %w", err)
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	applied, err := migrator.Up(context.Background())
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, mig := range applied {
+		log.Printf("migrazione applicata: %04d_%s", mig.Version, mig.Name)
 	}
 
-	// Creiamo la tabella se non esiste.
-	if err := createTable(db); err != nil {
+	fts, err := ensureSearchIndex(context.Background(), db)
+	if err != nil {
+		db.Close()
 		return nil, err
 	}
+	if !fts {
+		log.Printf("ATTENZIONE: %v; GET /todos/search risponderà 501", ErrSearchUnavailable)
+	}
 
-	return &Store{db: db}, nil
+	return &Store{db: db, fts: fts}, nil
 }
 
-// createTable esegue la query per creare la nostra tabella 'todos'.
-func createTable(db *sql.DB) error {
-	// Usiamo TEXT per i campi stringa e INTEGER PRIMARY KEY AUTOINCREMENT
-	// per un ID che si auto-incrementa.
-	query := `
-	CREATE TABLE IF NOT EXISTS todos (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		title TEXT NOT NULL,
-		completed TEXT NOT NULL
-	);`
-
-	_, err := db.Exec(query)
-	if err != nil {
-		return fmt.Errorf("errore nella creazione della tabella: %w", err)
+// Close chiude il database. Va chiamata dopo aver smesso di servire
+// richieste, perché le operazioni successive fallirebbero.
+func (s *Store) Close() error {
+	if s.tx != nil {
+		return errors.New("lo store di una transazione non va chiuso")
 	}
-	return nil
+	return s.db.Close()
 }
 
-/*
-// carica i dati sul file json
-func (s *Store) load() error {
-	// RLock permette a più lettori di accedere contemporaneamente
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// sqliteOptions sono le opzioni di go-sqlite3 con cui apriamo il
+// database, salvo che il percorso non ne indichi altre (es.
+// "todos.db?_busy_timeout=0"):
+//
+//   - _journal_mode=WAL: le letture non aspettano chi sta scrivendo.
+//   - _busy_timeout=5000: chi trova il database occupato aspetta fino a 5
+//     secondi prima di arrendersi con ErrUnavailable.
+var sqliteOptions = [][2]string{
+	{"_journal_mode", "WAL"},
+	{"_busy_timeout", "5000"},
+}
 
-	data, err := os.ReadFile(s.filePath)
+// OpenDB apre il database SQLite senza toccare lo schema; lo usa anche
+// il comando "migrate" per gestire le migrazioni a mano.
+func OpenDB(dbPath string) (*sql.DB, error) {
+	file, query, _ := strings.Cut(dbPath, "?")
+	params, err := url.ParseQuery(query)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil //il file non esiste e va bene, partiamo da zero
+		return nil, fmt.Errorf("opzioni del db non valide in %q: %w", dbPath, err)
+	}
+	for _, opt := range sqliteOptions {
+		if !params.Has(opt[0]) {
+			params.Set(opt[0], opt[1])
 		}
-		return err //altro errore di lettura
+	}
+	// Questa invece non si cambia: ogni transazione prende subito il lock
+	// in scrittura (BEGIN IMMEDIATE). I metodi dello store leggono e poi
+	// scrivono nella stessa transazione (vedi begin): con il BEGIN normale
+	// due transazioni così si bloccano a vicenda al momento di scrivere e
+	// SQLite ne fa fallire una subito, con "database is locked", senza
+	// aspettare. Così invece si mettono in fila.
+	params.Set("_txlock", "immediate")
+
+	// Un file JSON del vecchio store verrebbe rifiutato da SQLite con un
+	// criptico "file is not a database": meglio dire subito cosa fare.
+	if isJSON, err := IsJSONFile(file); err != nil {
+		return nil, fmt.Errorf("errore nel leggere il db: %w", err)
+	} else if isJSON {
+		return nil, fmt.Errorf("%s: %w", file, ErrJSONFile)
 	}
 
-	var todos []Todo
-	if err := json.Unmarshal(data, &todos); err != nil {
-		return err
+	// Apriamo la connessione al database. Se il file non esiste, viene creato.
+	db, err := sql.Open("sqlite3", file+"?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("errore nell'aprire il db: %w", err)
 	}
 
-	//popoliamo la mappa e troviamo il nextID corretto
-	for _, t := range todos {
-		s.todos[t.ID] = t
-		if t.ID >= s.nextID {
-			s.nextID = t.ID + 1
-		}
+	// Ping verifica che la connessione sia effettivamente valida.
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("errore nel ping del db: %w", err)
 	}
-	return nil
+	return db, nil
+}
 
+// querier è la parte comune di *sql.DB e *sql.Tx: le funzioni che la
+// accettano funzionano sia dentro che fuori da una transazione.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
-*/
 
-/*
-// scirve lo stato corrente dello store su file
-func (s *Store) save() error {
-	s.mu.RLock() // basta un lock di lettura per creare la slice temporanea
-	defer s.mu.RUnlock()
+// colonne lette da scanTodo, sempre in questo ordine.
+const todoColumns = "id, owner_id, list_id, parent_id, title, description, status, priority, due_at, due_offset, recurrence, next_occurrence_id, " +
+	"completed_at, deleted_at, version, legacy_completed, " +
+	tagsColumn + ", " + subtasksColumns
 
-	return s.saveInternal() //chiama la versione interna per evitare deadlock
+// scanner è implementata sia da *sql.Row che da *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
 }
 
-// saveInternal fa il lavoro sporco, ma PRESUPPONE che un lock
-// sia già stato acquisito dal chiamante.
-func (s *Store) saveInternal() error {
-	todos := make([]Todo, 0, len(s.todos))
-	for _, t := range s.todos {
-		todos = append(todos, t)
+// scanTodo mappa le colonne di todoColumns nei campi della struct.
+func scanTodo(row scanner) (Todo, error) {
+	var t Todo
+	var dueAt, completedAt, deletedAt, legacy, tags *string
+	var offset, subtasks, subtasksDone int
+	if err := row.Scan(&t.ID, &t.OwnerID, &t.ListID, &t.ParentID, &t.Title, &t.Description, &t.Status, &t.Priority, &dueAt, &offset,
+		&t.Recurrence, &t.NextOccurrenceID, &completedAt, &deletedAt, &t.Version, &legacy, &tags, &subtasks, &subtasksDone); err != nil {
+		return Todo{}, err
 	}
-
-	data, err := json.MarshalIndent(todos, "", "  ")
-	if err != nil {
-		return err
+	var err error
+	if t.DueAt, err = parseDBTime(dueAt); err != nil {
+		return Todo{}, err
+	}
+	if t.DueAt != nil {
+		t.DueAt = ptr(t.DueAt.In(offsetZone(offset)))
 	}
+	if t.CompletedAt, err = parseDBTime(completedAt); err != nil {
+		return Todo{}, err
+	}
+	if t.DeletedAt, err = parseDBTime(deletedAt); err != nil {
+		return Todo{}, err
+	}
+	if legacy != nil {
+		t.legacyCompleted = *legacy
+	}
+	t.Completed = t.Status == StatusDone
+	t.Tags = splitTags(tags)
+	t.Progress = progress(subtasksDone, subtasks)
+	return t, nil
+}
 
-	return os.WriteFile(s.filePath, data, 0644)
+// nullString salva le stringhe vuote come NULL.
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
 }
-*/
 
-// restituisce una slice di tutti i todo
-/*
-func (s *Store) GetAll() []Todo {
-	s.mu.RLock() //Lock in lettura, più goroutine possono leggere contemporaneamente
-	defer s.mu.RUnlock()
+// GetAll restituisce i todo che soddisfano opts, già ordinati e paginati,
+// insieme al numero totale di risultati.
+func (s *Store) GetAll(ctx context.Context, opts ListOptions) (TodoPage, error) {
+	if err := opts.Validate(); err != nil {
+		return TodoPage{}, err
+	}
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return TodoPage{}, err
+	}
 
-	//crea una slice con la dimensione esatta della mappa
-	allTodos := make([]Todo, 0, len(s.todos))
+	where, args := opts.sqlWhere(owner)
 
-	//itera sulla mappa e aggiunge ogni todo alla slice
-	for _, todo := range s.todos {
-		allTodos = append(allTodos, todo)
+	// Prima contiamo tutti i risultati che soddisfano i filtri...
+	var page TodoPage
+	if err := s.conn().QueryRowContext(ctx, "SELECT COUNT(*) FROM todos"+where, args...).Scan(&page.Total); err != nil {
+		return TodoPage{}, dbError("errore nel conteggio dei todo", err)
+	}
 
+	// ...poi leggiamo solo la pagina richiesta.
+	if opts.AfterID > 0 {
+		if opts.Desc {
+			where += " AND id < ?"
+		} else {
+			where += " AND id > ?"
+		}
+		args = append(args, opts.AfterID)
 	}
-	return allTodos
-}
-*/
-func (s *Store) GetAll() ([]Todo, error) {
-	query := "SELECT * FROM todos"
-	rows, err := s.db.Query(query)
+	query := "SELECT " + todoColumns + " FROM todos" + where + opts.sqlOrderBy()
+	if opts.Limit > 0 {
+		// chiediamo una riga in più per sapere se esiste una pagina successiva
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, opts.Limit+1, opts.Offset)
+	} else if opts.Offset > 0 {
+		query += " LIMIT -1 OFFSET ?"
+		args = append(args, opts.Offset)
+	}
+
+	rows, err := s.conn().QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("errore nella query get all: %w", err)
+		return TodoPage{}, dbError("errore nella query get all", err)
 	}
 	defer rows.Close() //fondamentale per rilasciare la connessione al database
 
-	// Creiamo una slice per contenere i risultati.
-	var todos []Todo
+	// Creiamo una slice (vuota, non nil) per contenere i risultati:
+	// così in JSON otteniamo [] e non null.
+	page.Todos = []Todo{}
 
 	// Iteriamo su tutte le righe restituite.
 	for rows.Next() {
-		var t Todo
-		// Scan mappa le colonne della riga corrente nei campi della nostra struct.
-		if err := rows.Scan(&t.ID, &t.Title, &t.Completed); err != nil {
-			// Se una riga dà errore, logghiamo e continuiamo, o restituiamo l'errore.
-			return nil, fmt.Errorf("errore nello scan di una riga: %w", err)
+		t, err := scanTodo(rows)
+		if err != nil {
+			return TodoPage{}, dbError("errore nello scan di una riga", err)
 		}
-		todos = append(todos, t)
+		page.Todos = append(page.Todos, t)
 	}
 
 	// Controlliamo se ci sono stati errori durante l'iterazione.
 	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("errore durante l'iterazione delle righe: %w", err)
+		return TodoPage{}, dbError("errore durante l'iterazione delle righe", err)
 	}
 
-	return todos, nil
+	if opts.Limit > 0 && len(page.Todos) > opts.Limit {
+		page.Todos = page.Todos[:opts.Limit]
+		page.HasMore = true
+	}
+	return page, nil
 }
 
-/*
-func (s *Store) GetByID(ID int) (Todo, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	result, ok := s.todos[ID]
-	//fmt.Println(result)
-
-	return result, ok
-
+func (s *Store) GetByID(ctx context.Context, ID int) (Todo, error) {
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return Todo{}, err
+	}
+	return getTodo(ctx, s.conn(), owner, ID)
 }
-*/
 
-func (s *Store) GetByID(ID int) (Todo, error) {
-	query := "SELECT * FROM todos WHERE id=?"
+// getTodo legge un todo di owner: quelli degli altri utenti e quelli nel
+// cestino non li trova.
+func getTodo(ctx context.Context, q querier, owner, ID int) (Todo, error) {
+	query := "SELECT " + todoColumns + " FROM todos WHERE id=? AND owner_id=? AND deleted_at IS NULL"
 
-	var newEle Todo
-	err := s.db.QueryRow(query, ID).Scan(&newEle)
+	newEle, err := scanTodo(q.QueryRowContext(ctx, query, ID, owner))
 	if err != nil {
-		return Todo{}, fmt.Errorf("errore nel ritornare l'elemento cercato: %w", err)
+		return Todo{}, dbError(fmt.Sprintf("todo %d", ID), err)
 	}
 
 	return newEle, nil
 
 }
 
-/*
-func (s *Store) Create(title string) Todo {
-	// Usiamo un Lock() completo perché stiamo per modificare i dati (nextID e la mappa).
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	//creiamo la nuova struct todo
-	newTodo := Todo{
-		ID:        s.nextID,
-		Title:     title,
-		Completed: "not completed",
+// queryTodos esegue una query che seleziona todoColumns e legge tutte le
+// righe; per GetAll, che pagina, c'è il codice dedicato.
+func queryTodos(ctx context.Context, q querier, query string, args ...any) ([]Todo, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, dbError("errore nella lettura dei todo", err)
 	}
+	defer rows.Close()
 
-	// aggiungiamo il nuovo elemento alla mappa in memoria
-	s.todos[newTodo.ID] = newTodo
-	s.nextID++
-
-	// Salviamo lo stato aggiornato su file.
-	// In un'app reale, potremmo voler gestire l'errore di salvataggio
-	// in modo più granulare, ma per ora lo ignoriamo per semplicità.
-	// La gestione errori robusta è un ottimo argomento per una fase successiva!
-	s.saveInternal()
-
-	return newTodo
+	todos := []Todo{}
+	for rows.Next() {
+		t, err := scanTodo(rows)
+		if err != nil {
+			return nil, dbError("errore nello scan di una riga", err)
+		}
+		todos = append(todos, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError("errore durante l'iterazione delle righe", err)
+	}
+	return todos, nil
 }
-*/
 
 /*metodo create con sql*/
-func (s *Store) Create(title string) (Todo, error) {
-	initialStatus := "not completed"
-
-	// returning id ci ritorna l'id appena generato
-	query := "INSERT INTO todos (title, completed) VALUES (?,?) RETURNING id"
-
-	var newID int
-	/* usiamo QueryRow che è perfetta quando come ritorno ci aspettiamo una sola riga */
-	err := s.db.QueryRow(query, title, initialStatus).Scan(&newID)
+func (s *Store) Create(ctx context.Context, input Todo) (Todo, error) {
+	newTodo, err := input.newTodo()
+	if err != nil {
+		return Todo{}, err
+	}
+	owner, err := ownerID(ctx)
 	if err != nil {
-		return Todo{}, fmt.Errorf("errore nell'inserimento del todo: %w", err)
+		return Todo{}, err
+	}
 
+	// la lista va controllata nella stessa transazione dell'inserimento,
+	// altrimenti potrebbe sparire nel frattempo
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return Todo{}, dbError("errore nell'apertura della transazione", err)
 	}
+	defer tx.Rollback()
 
-	newTodo := Todo{
-		ID:        newID,
-		Title:     title,
-		Completed: initialStatus,
+	if err := checkList(ctx, tx, owner, input.ListID); err != nil {
+		return Todo{}, err
+	}
+	if err := checkParent(ctx, tx, owner, 0, input.ParentID); err != nil {
+		return Todo{}, err
 	}
 
+	newTodo.OwnerID = owner
+	if err := insertTodo(ctx, tx, &newTodo); err != nil {
+		return Todo{}, err
+	}
+	if err := recordEvent(ctx, tx, EventCreated, nil, &newTodo); err != nil {
+		return Todo{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Todo{}, dbError("errore nel commit dell'inserimento", err)
+	}
 	return newTodo, nil
 }
 
-/*
-func (s *Store) Update(ID int, title string, completed string) (Todo, bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// insertTodo inserisce t, già validato e con il proprietario, e ne imposta
+// l'ID; i trigger di 0010_subtasks aggiornano la versione del genitore.
+func insertTodo(ctx context.Context, q querier, t *Todo) error {
+	// returning id ci ritorna l'id appena generato
+	query := `INSERT INTO todos (owner_id, list_id, parent_id, title, description, status, priority, due_at, due_offset, recurrence, completed_at)
+		VALUES (?,?,?,?,?,?,?,?,?,?,?) RETURNING id`
 
-	eleTodo, ok := s.todos[ID]
-	if !ok {
-		return Todo{}, false
+	/* usiamo QueryRow che è perfetta quando come ritorno ci aspettiamo una sola riga */
+	err := q.QueryRowContext(ctx, query, t.OwnerID, t.ListID, t.ParentID, t.Title, t.Description,
+		t.Status, t.Priority, formatDBTime(t.DueAt), dueOffset(t.DueAt), t.Recurrence, formatDBTime(t.CompletedAt)).Scan(&t.ID)
+	if err != nil {
+		return dbError("errore nell'inserimento del todo", err)
 	}
-	newTitle := title
-	newCompleted := completed
-	if title == "" {
-		newTitle = eleTodo.Title
+	return nil
+}
+
+// Update legge il todo e lo riscrive nella stessa transazione, così il
+// calcolo di completed_at si basa sempre sullo stato precedente reale; la
+// transazione parte già in scrittura (vedi begin), quindi due Update
+// insieme si mettono in fila.
+func (s *Store) Update(ctx context.Context, input Todo) (Todo, error) {
+	if err := input.validate(); err != nil {
+		return Todo{}, err
 	}
-	if completed == "" {
-		newCompleted = eleTodo.Completed
+	ID := input.ID
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return Todo{}, err
 	}
 
-	newTodo := Todo{
-		ID:        eleTodo.ID,
-		Title:     newTitle,
-		Completed: newCompleted,
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return Todo{}, dbError("errore nell'apertura della transazione", err)
 	}
+	defer tx.Rollback() // non fa nulla dopo il Commit
 
-	s.todos[ID] = newTodo
-	s.saveInternal()
-	return newTodo, ok
+	todo, err := getTodo(ctx, tx, owner, ID)
+	if err != nil {
+		return Todo{}, err
+	}
+	if err := checkVersion(input.Version, todo.Version); err != nil {
+		return Todo{}, err
+	}
+	if err := checkList(ctx, tx, owner, input.ListID); err != nil {
+		return Todo{}, err
+	}
+	// il genitore si controlla solo se cambia: quello attuale potrebbe
+	// essere nel cestino, e il todo deve restare modificabile
+	if !sameID(todo.ParentID, input.ParentID) {
+		if err := checkParent(ctx, tx, owner, ID, input.ParentID); err != nil {
+			return Todo{}, err
+		}
+	}
+	before := todo
+	todo.apply(input, now())
 
-}
-*/
+	if next, ok := todo.nextOccurrence(before.Status == StatusDone); ok {
+		if err := insertTodo(ctx, tx, &next); err != nil {
+			return Todo{}, err
+		}
+		query := "INSERT INTO todo_tags (todo_id, tag_id) SELECT ?, tag_id FROM todo_tags WHERE todo_id = ?"
+		if _, err := tx.ExecContext(ctx, query, next.ID, ID); err != nil {
+			return Todo{}, dbError("errore nella copia dei tag della ricorrenza", err)
+		}
+		if err := recordEvent(ctx, tx, EventCreated, nil, &next); err != nil {
+			return Todo{}, err
+		}
+		todo.NextOccurrenceID = &next.ID
+	}
 
-func (s *Store) Update(ID int, title string, completed string) (Todo, error) {
-	query := "UPDATE todos SET title = ?, completed = ? WHERE id = ?"
-	_, err := s.db.Exec(query, title, completed, ID)
+	// i trigger di 0010_subtasks aggiornano la versione dei genitori coinvolti
+	query := `UPDATE todos SET list_id = ?, parent_id = ?, title = ?, description = ?, status = ?, priority = ?, due_at = ?,
+		due_offset = ?, recurrence = ?, next_occurrence_id = ?, completed_at = ?, version = ?, legacy_completed = ? WHERE id = ? AND owner_id = ?`
+	_, err = tx.ExecContext(ctx, query, todo.ListID, todo.ParentID, todo.Title, todo.Description, todo.Status, todo.Priority,
+		formatDBTime(todo.DueAt), dueOffset(todo.DueAt), todo.Recurrence, todo.NextOccurrenceID,
+		formatDBTime(todo.CompletedAt), todo.Version, nullString(todo.legacyCompleted), ID, owner)
 	if err != nil {
-		return Todo{}, fmt.Errorf("errore nell'update dell'elemento: %w", err)
+		return Todo{}, dbError("errore nell'update dell'elemento", err)
+	}
+	if err := recordEvent(ctx, tx, EventUpdated, &before, &todo); err != nil {
+		return Todo{}, err
 	}
 
-	return s.GetByID(ID)
+	if err := tx.Commit(); err != nil {
+		return Todo{}, dbError("errore nel commit dell'update", err)
+	}
+	return todo, nil
 
 }
 
-/*
-func (s *Store) Delete(ID int) bool {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	_, exist := s.todos[ID]
-
-	if exist {
-		delete(s.todos, ID)
-		s.saveInternal()
-		return true
-	} else {
-		return false
+// Delete sposta il todo nel cestino. Lettura, controllo della versione,
+// modifica ed evento stanno nella stessa transazione, così non ci sono
+// finestre di race.
+func (s *Store) Delete(ctx context.Context, ID int, version int) error {
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return err
 	}
-}
-*/
 
-func (s *Store) Delete(ID int) error {
-	query := "DELETE FROM todos WHERE id = ?"
-	result, err := s.db.Exec(query, ID)
+	tx, err := s.begin(ctx)
 	if err != nil {
-		return fmt.Errorf("errore nella cancellazione: %w", err)
-
+		return dbError("errore nell'apertura della transazione", err)
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := result.RowsAffected()
+	todo, err := getTodo(ctx, tx, owner, ID)
 	if err != nil {
-		return fmt.Errorf("errore nel recuperare le righe modificate dopo la cancellazione: %w", err)
+		return err
 	}
-
-	if rowsAffected == 0 {
-		// Nessuna riga cancellata significa ID non trovato.
-		return sql.ErrNoRows
+	if err := checkVersion(version, todo.Version); err != nil {
+		return err
+	}
+	trashed := todo
+	trashed.DeletedAt = ptr(now())
+	trashed.Version++
+
+	// il trigger todos_parent_trash aggiorna la versione del genitore
+	query := "UPDATE todos SET deleted_at = ?, version = ? WHERE id = ? AND owner_id = ?"
+	if _, err := tx.ExecContext(ctx, query, formatDBTime(trashed.DeletedAt), trashed.Version, ID, owner); err != nil {
+		return dbError("errore nella cancellazione", err)
+	}
+	if err := recordEvent(ctx, tx, EventDeleted, &todo, &trashed); err != nil {
+		return err
 	}
 
-	return nil // Successo! Non c'è nulla da restituire.
+	if err := tx.Commit(); err != nil {
+		return dbError("errore nel commit della cancellazione", err)
+	}
+	return nil
 }
@@ -1,93 +1,1662 @@
 package store
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
 	// La nostra libreria di assertion
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-// setupTestStore è una funzione helper che crea uno store pulito per ogni test.
-// Restituisce lo store e una funzione di "teardown" per pulire dopo il test.
-
-func setupTestStore(t *testing.T) (*Store, func()) {
-	// usiamo un file di test temporaneo per non sporcare il nostro todos.json
-	testFile := "test_todos.json"
-
-	store, err := New(testFile)
-	// require è come assert, ma usa t.Fatal se il check fallisce.
-	// Se non riusciamo a creare lo store, non ha senso continuare il test.
-	require.NoError(t, err, "La creazione dello store non dovrebbe fallire")
+// backends elenca i costruttori di tutti gli store: ogni test di contratto
+// viene eseguito su ciascuno di essi, così garantiamo lo stesso comportamento.
+var backends = map[string]func(t *testing.T) TodoRepository{
+	"sqlite": func(t *testing.T) TodoRepository {
+		// usiamo una cartella temporanea per non sporcare il nostro todos.json;
+		// viene rimossa automaticamente alla fine del test.
+		s, err := New(filepath.Join(t.TempDir(), "test_todos.db"))
+		// require è come assert, ma usa t.Fatal se il check fallisce.
+		// Se non riusciamo a creare lo store, non ha senso continuare il test.
+		require.NoError(t, err, "La creazione dello store non dovrebbe fallire")
+		t.Cleanup(func() { assert.NoError(t, s.Close()) })
+		return s
+	},
+	"json": func(t *testing.T) TodoRepository {
+		s, err := NewJSONStore(filepath.Join(t.TempDir(), "test_todos.json"))
+		require.NoError(t, err, "La creazione dello store non dovrebbe fallire")
+		return s
+	},
+	"memory": func(t *testing.T) TodoRepository {
+		return NewMemoryStore()
+	},
+}
 
-	// La funzione di teardown viene restituita e chiamata alla fine del test
-	// usando 'defer'.
-	teardown := func() {
-		os.Remove(testFile)
+// Test per il ciclo di vita completo di un Todo, su ogni backend.
+func TestTodoLifecycle(t *testing.T) {
+	for name, newStore := range backends {
+		t.Run(name, func(t *testing.T) {
+			testTodoLifecycle(t, newStore(t))
+		})
 	}
-
-	return store, teardown
 }
 
-// Test per il ciclo di vita completo di un Todo.
-func TestTodoLifecycle(t *testing.T) {
-	store, teardown := setupTestStore(t)
-	defer teardown() // Assicura che la pulizia venga eseguita alla fine del test.
+func testTodoLifecycle(t *testing.T, store TodoRepository) {
+	ctx := WithOwner(context.Background(), DefaultUserID)
 
 	// usiamo t.Run per raggruppare i sottotest
 	t.Run("1. Create Todo", func(t *testing.T) {
 		// Azione
-		created := store.Create("Test di creazione")
+		created, err := store.Create(ctx, Todo{Title: "Test di creazione"})
 
 		// verifica assertion
+		require.NoError(t, err)
 		assert.Equal(t, 1, created.ID, "L'ID del primo Todo dovrebbe essere 1")
 		assert.Equal(t, "Test di creazione", created.Title, "Il titolo non corrisponde")
-		assert.Equal(t, "not completed", created.Completed, "Un nuovo Todo non dovrebbe essere completato")
+		assert.Equal(t, StatusPending, created.Status)
+		assert.False(t, created.Completed, "Un nuovo Todo non dovrebbe essere completato")
+		assert.Nil(t, created.CompletedAt)
+		assert.Equal(t, 1, created.Version)
+	})
+
+	t.Run("1b. Create with an empty title", func(t *testing.T) {
+		_, err := store.Create(ctx, Todo{Title: ""})
+		assert.ErrorIs(t, err, ErrInvalid)
 	})
 
 	t.Run("2. Get Todo By ID", func(t *testing.T) {
 		// Azione
-		todo, found := store.GetByID(1)
+		todo, err := store.GetByID(ctx, 1)
 
 		// Verifica
-		assert.True(t, found, "Il todo con ID 1 dovrebbe essere trovato")
+		require.NoError(t, err, "Il todo con ID 1 dovrebbe essere trovato")
 		assert.Equal(t, 1, todo.ID)
 		assert.Equal(t, "Test di creazione", todo.Title)
 	})
 
 	t.Run("3. Get a non-existent Todo", func(t *testing.T) {
 		// Azione
-		_, found := store.GetByID(999)
+		_, err := store.GetByID(ctx, 999)
 
 		// Verifica
-		assert.False(t, found, "Un todo con ID 999 non dovrebbe esistere")
+		assert.ErrorIs(t, err, ErrNotFound, "Un todo con ID 999 non dovrebbe esistere")
 	})
 
 	t.Run("4. Update Todo", func(t *testing.T) {
 		//Azione
-		updated, found := store.Update(1, "Titolo aggiornato", "completed")
+		updated, err := store.Update(ctx, Todo{ID: 1, Title: "Titolo aggiornato", Status: StatusDone})
 
 		//verifica
-		assert.True(t, found)
+		require.NoError(t, err)
 		assert.Equal(t, "Titolo aggiornato", updated.Title)
-		assert.Equal(t, "completed", updated.Completed)
+		assert.Equal(t, StatusDone, updated.Status)
+		assert.True(t, updated.Completed)
+		require.NotNil(t, updated.CompletedAt, "completed_at va impostato automaticamente")
 
 		// contro verifica: rileggiamo il dato per essere sicuri
-		reRead, _ := store.GetByID(1)
+		reRead, _ := store.GetByID(ctx, 1)
 		assert.Equal(t, "Titolo aggiornato", reRead.Title)
+		require.NotNil(t, reRead.CompletedAt)
+		assert.True(t, updated.CompletedAt.Equal(*reRead.CompletedAt))
+	})
+
+	t.Run("4b. Status transitions", func(t *testing.T) {
+		done, _ := store.GetByID(ctx, 1)
+
+		// archiviare un todo completato ne conserva la data di completamento
+		archived, err := store.Update(ctx, Todo{ID: 1, Title: done.Title, Status: StatusArchived})
+		require.NoError(t, err)
+		assert.False(t, archived.Completed)
+		require.NotNil(t, archived.CompletedAt)
+		assert.True(t, done.CompletedAt.Equal(*archived.CompletedAt))
 
+		// riaprirlo la azzera
+		reopened, err := store.Update(ctx, Todo{ID: 1, Title: done.Title, Status: StatusInProgress})
+		require.NoError(t, err)
+		assert.Nil(t, reopened.CompletedAt)
+
+		_, err = store.Update(ctx, Todo{ID: 1, Title: done.Title, Status: Status("banana")})
+		assert.ErrorIs(t, err, ErrInvalid, "uno stato non previsto va rifiutato")
 	})
 
-	t.Run("5. Delete Todo", func(t *testing.T) {
+	t.Run("4c. Versions", func(t *testing.T) {
+		current, err := store.GetByID(ctx, 1)
+		require.NoError(t, err)
+		assert.Equal(t, 4, current.Version, "ogni Update incrementa la versione")
+
+		// aggiornare a partire da una versione vecchia deve fallire
+		_, err = store.Update(ctx, Todo{ID: 1, Title: "stale", Status: StatusPending, Version: 2})
+		assert.ErrorIs(t, err, ErrVersionConflict)
+		assert.ErrorIs(t, err, ErrConflict, "un conflitto di versione è un ErrConflict")
+
+		updated, err := store.Update(ctx, Todo{ID: 1, Title: current.Title, Status: StatusPending, Version: 4})
+		require.NoError(t, err)
+		assert.Equal(t, 5, updated.Version)
+
+		assert.ErrorIs(t, store.Delete(ctx, 1, 4), ErrVersionConflict)
+		assert.ErrorIs(t, store.Delete(ctx, 999, 4), ErrNotFound)
+	})
+
+	t.Run("5. Get All", func(t *testing.T) {
+		_, err := store.Create(ctx, Todo{Title: "Secondo todo"})
+		require.NoError(t, err)
+
+		page, err := store.GetAll(ctx, ListOptions{})
+		require.NoError(t, err)
+		all := page.Todos
+		require.Len(t, all, 2)
+		assert.Equal(t, 1, all[0].ID, "I todo devono essere ordinati per ID")
+		assert.Equal(t, 2, all[1].ID)
+	})
+
+	t.Run("6. Delete Todo", func(t *testing.T) {
 		//Azione
-		found := store.Delete(1)
+		err := store.Delete(ctx, 1, 0)
 
 		//verifica
-		assert.True(t, found, "Il Delete dovrebbe avere successo per un id esistente")
+		assert.NoError(t, err, "Il Delete dovrebbe avere successo per un id esistente")
 
 		// contro verifica
-		_, foundAfterDelete := store.GetByID(1)
-		assert.False(t, foundAfterDelete, "Il todo non dovrebbe più esistere dopo la cancellazione")
+		_, err = store.GetByID(ctx, 1)
+		assert.ErrorIs(t, err, ErrNotFound, "Il todo non dovrebbe più esistere dopo la cancellazione")
+
+		assert.ErrorIs(t, store.Delete(ctx, 1, 0), ErrNotFound, "Cancellare due volte deve fallire")
+	})
+
+	t.Run("7. Create with a status", func(t *testing.T) {
+		at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("", 3600))
+		done, err := store.Create(ctx, Todo{Title: "Già fatto", Status: StatusDone, CompletedAt: &at})
+		require.NoError(t, err)
+		assert.True(t, done.Completed)
+		assert.Equal(t, 1, done.Version)
+		got, err := store.GetByID(ctx, done.ID)
+		require.NoError(t, err)
+		require.NotNil(t, got.CompletedAt)
+		assert.Equal(t, at.UTC(), *got.CompletedAt, "la data di completamento ricevuta resta quella")
+
+		doneNow, err := store.Create(ctx, Todo{Title: "Fatto ora", Status: StatusDone})
+		require.NoError(t, err)
+		assert.NotNil(t, doneNow.CompletedAt)
+
+		pending, err := store.Create(ctx, Todo{Title: "Da fare", Status: StatusInProgress, CompletedAt: &at})
+		require.NoError(t, err)
+		assert.Nil(t, pending.CompletedAt, "senza completamento la data viene ignorata")
+
+		_, err = store.Create(ctx, Todo{Title: "Boh", Status: "boh"})
+		assert.ErrorIs(t, err, ErrInvalid)
+	})
+}
+
+// Il file JSON deve sopravvivere a un riavvio dello store.
+func TestJSONStorePersistence(t *testing.T) {
+	ctx := WithOwner(context.Background(), DefaultUserID)
+	path := filepath.Join(t.TempDir(), "todos.json")
+
+	s, err := NewJSONStore(path)
+	require.NoError(t, err)
+	_, err = s.Create(ctx, Todo{Title: "Prendere il pane"})
+	require.NoError(t, err)
+	carla, err := s.CreateUser(ctx, "carla", "hash-di-carla")
+	require.NoError(t, err)
+	second, err := s.Create(ctx, Todo{Title: "Mangiare"})
+	require.NoError(t, err)
+	require.NoError(t, s.Delete(ctx, 1, 0))
+
+	reopened, err := NewJSONStore(path)
+	require.NoError(t, err)
+
+	page, err := reopened.GetAll(ctx, ListOptions{})
+	require.NoError(t, err)
+	all := page.Todos
+	require.Len(t, all, 1)
+	assert.Equal(t, second, all[0])
+
+	// il prossimo ID deve proseguire da quello più alto salvato
+	third, err := reopened.Create(ctx, Todo{Title: "Lavare i piatti"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, third.ID)
+
+	// anche gli utenti sopravvivono al riavvio
+	found, err := reopened.GetUserByUsername(ctx, "carla")
+	require.NoError(t, err)
+	assert.Equal(t, carla.ID, found.ID)
+	assert.True(t, carla.CreatedAt.Equal(found.CreatedAt))
+	assert.Equal(t, "hash-di-carla", found.PasswordHash, "l'hash va salvato anche se l'API non lo espone")
+
+	// e anche il registro delle modifiche, compreso il todo nel cestino
+	events, err := reopened.GetHistory(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, EventDeleted, events[1].Action)
+	trash, err := reopened.GetTrash(ctx)
+	require.NoError(t, err)
+	assert.Len(t, trash, 1)
+}
+
+// Un file scritto dal vecchio store, con "completed" testuale, deve essere
+// letto senza perdere il valore originale.
+func TestJSONStoreLegacyFormat(t *testing.T) {
+	ctx := WithOwner(context.Background(), DefaultUserID)
+	path := filepath.Join(t.TempDir(), "todos.json")
+	legacy := `[
+  {"id": 4, "title": "Mangiare", "completed": "not completed"},
+  {"id": 3, "title": "Prendere il pane", "completed": "completed"},
+  {"id": 1, "title": "Strano", "completed": "banana"}
+]`
+	require.NoError(t, os.WriteFile(path, []byte(legacy), 0644))
+
+	s, err := NewJSONStore(path)
+	require.NoError(t, err)
+
+	page, err := s.GetAll(ctx, ListOptions{})
+	require.NoError(t, err)
+	all := page.Todos
+	require.Len(t, all, 3)
+	assert.Equal(t, StatusPending, all[0].Status)
+	assert.Equal(t, "banana", all[0].legacyCompleted)
+	assert.Equal(t, StatusDone, all[1].Status)
+	assert.True(t, all[1].Completed)
+	assert.Equal(t, StatusPending, all[2].Status)
+
+	created, err := s.Create(ctx, Todo{Title: "Nuovo"})
+	require.NoError(t, err)
+	assert.Equal(t, 5, created.ID)
+}
+
+// I tag con spazi dei file scritti prima che fossero vietati diventano
+// trattini, come fa la migrazione 0015 per SQLite.
+func TestJSONStoreTagSpaces(t *testing.T) {
+	ctx := WithOwner(context.Background(), DefaultUserID)
+	path := filepath.Join(t.TempDir(), "todos.json")
+	file := `[{"id": 1, "title": "Pane", "status": "pending", "tags": ["casa mia", "Casa-mia", "lavoro"]}]`
+	require.NoError(t, os.WriteFile(path, []byte(file), 0644))
+
+	s, err := NewJSONStore(path)
+	require.NoError(t, err)
+	todo, err := s.GetByID(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"casa-mia", "lavoro"}, todo.Tags)
+}
+
+// import-legacy: il file JSON finisce nel database con gli stessi ID, e i
+// nuovi record proseguono da quelli importati.
+func TestImportJSON(t *testing.T) {
+	ctx := WithOwner(context.Background(), DefaultUserID)
+	dir := t.TempDir()
+
+	t.Run("formato legacy", func(t *testing.T) {
+		path := filepath.Join(dir, "todos.json")
+		legacy := `
+  [{"id": 4, "title": "Mangiare", "completed": "not completed"},
+   {"id": 7, "title": "Prendere il pane", "completed": "completed"}]`
+		require.NoError(t, os.WriteFile(path, []byte(legacy), 0644))
+
+		isJSON, err := IsJSONFile(path)
+		require.NoError(t, err)
+		assert.True(t, isJSON)
+		_, err = New(path)
+		assert.ErrorIs(t, err, ErrJSONFile, "SQLite non deve provare ad aprire il file JSON")
+
+		s, err := New(filepath.Join(dir, "legacy.db"))
+		require.NoError(t, err)
+		defer s.Close()
+		stats, err := s.ImportJSON(ctx, path)
+		require.NoError(t, err)
+		assert.Equal(t, LegacyImport{Users: 1, Todos: 2}, stats)
+
+		pane, err := s.GetByID(ctx, 7)
+		require.NoError(t, err)
+		assert.Equal(t, StatusDone, pane.Status)
+		assert.Equal(t, "completed", pane.legacyCompleted)
+		created, err := s.Create(ctx, Todo{Title: "Nuovo"})
+		require.NoError(t, err)
+		assert.Equal(t, 8, created.ID)
+
+		_, err = s.ImportJSON(ctx, path)
+		assert.ErrorIs(t, err, ErrConflict, "su un database con dei dati l'import va rifiutato")
+	})
+
+	t.Run("formato completo", func(t *testing.T) {
+		path := filepath.Join(dir, "full.json")
+		src, err := NewJSONStore(path)
+		require.NoError(t, err)
+		carla, err := src.CreateUser(ctx, "carla", "hash-di-carla")
+		require.NoError(t, err)
+		carlaCtx := WithOwner(context.Background(), carla.ID)
+		list, err := src.CreateList(carlaCtx, "Casa")
+		require.NoError(t, err)
+		parent, err := src.Create(carlaCtx, Todo{Title: "Spesa", ListID: &list.ID})
+		require.NoError(t, err)
+		_, err = src.AddTag(carlaCtx, parent.ID, "casa")
+		require.NoError(t, err)
+		child, err := src.Create(carlaCtx, Todo{Title: "Latte", ParentID: &parent.ID})
+		require.NoError(t, err)
+		parent, err = src.GetByID(carlaCtx, parent.ID)
+		require.NoError(t, err)
+		history, err := src.GetHistory(carlaCtx, parent.ID)
+		require.NoError(t, err)
+
+		s, err := New(filepath.Join(dir, "full.db"))
+		require.NoError(t, err)
+		defer s.Close()
+		stats, err := s.ImportJSON(ctx, path)
+		require.NoError(t, err)
+		assert.Equal(t, LegacyImport{Users: 2, Lists: 1, Todos: 2, Events: len(src.events)}, stats)
+
+		got, err := s.GetByID(carlaCtx, parent.ID)
+		require.NoError(t, err)
+		assert.Equal(t, parent, got, "versione compresa, nonostante il trigger sulle sottoattività")
+		got, err = s.GetByID(carlaCtx, child.ID)
+		require.NoError(t, err)
+		assert.Equal(t, child, got)
+		gotHistory, err := s.GetHistory(carlaCtx, parent.ID)
+		require.NoError(t, err)
+		assert.Equal(t, history, gotHistory)
+		user, err := s.GetUserByUsername(ctx, "carla")
+		require.NoError(t, err)
+		assert.Equal(t, "hash-di-carla", user.PasswordHash)
+
+		// le sequenze ripartono dopo gli ID importati
+		bruno, err := s.CreateUser(ctx, "bruno", "")
+		require.NoError(t, err)
+		assert.Equal(t, carla.ID+1, bruno.ID)
+		other, err := s.CreateList(carlaCtx, "Lavoro")
+		require.NoError(t, err)
+		assert.Equal(t, list.ID+1, other.ID)
+		todo, err := s.Create(carlaCtx, Todo{Title: "Dopo"})
+		require.NoError(t, err)
+		assert.Equal(t, child.ID+1, todo.ID)
+	})
+}
+
+// Filtri, ordinamento e paginazione devono dare gli stessi risultati su ogni backend.
+func TestGetAllOptions(t *testing.T) {
+	for name, newStore := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := WithOwner(context.Background(), DefaultUserID)
+			store := newStore(t)
+
+			// 1 "Comprare il pane" done, 2 "lavare l'auto", 3 "Comprare 50% sconto" in_progress,
+			// 4 "bere" done, 5 "Annaffiare" pending
+			titles := []string{"Comprare il pane", "lavare l'auto", "Comprare 50% sconto", "bere", "Annaffiare"}
+			for _, title := range titles {
+				_, err := store.Create(ctx, Todo{Title: title})
+				require.NoError(t, err)
+			}
+			_, err := store.Update(ctx, Todo{ID: 1, Title: titles[0], Status: StatusDone})
+			require.NoError(t, err)
+			_, err = store.Update(ctx, Todo{ID: 3, Title: titles[2], Status: StatusInProgress})
+			require.NoError(t, err)
+			_, err = store.Update(ctx, Todo{ID: 4, Title: titles[3], Status: StatusDone})
+			require.NoError(t, err)
+
+			ids := func(opts ListOptions) ([]int, TodoPage) {
+				page, err := store.GetAll(ctx, opts)
+				require.NoError(t, err)
+				out := []int{}
+				for _, todo := range page.Todos {
+					out = append(out, todo.ID)
+				}
+				return out, page
+			}
+
+			got, page := ids(ListOptions{})
+			assert.Equal(t, []int{1, 2, 3, 4, 5}, got)
+			assert.Equal(t, 5, page.Total)
+			assert.False(t, page.HasMore)
+
+			got, page = ids(ListOptions{Statuses: []Status{StatusDone}})
+			assert.Equal(t, []int{1, 4}, got)
+			assert.Equal(t, 2, page.Total)
+
+			got, _ = ids(ListOptions{Search: "comprare"})
+			assert.Equal(t, []int{1, 3}, got, "la ricerca non distingue maiuscole e minuscole")
+
+			got, _ = ids(ListOptions{Search: "50%"})
+			assert.Equal(t, []int{3}, got, "i caratteri jolly vanno presi alla lettera")
+
+			got, _ = ids(ListOptions{Sort: SortByTitle})
+			assert.Equal(t, []int{5, 4, 3, 1, 2}, got)
+
+			got, _ = ids(ListOptions{Sort: SortByStatus, Desc: true})
+			assert.Equal(t, []int{4, 1, 3, 5, 2}, got)
+
+			got, _ = ids(ListOptions{Sort: SortByCompletedAt})
+			assert.Equal(t, []int{2, 3, 5, 1, 4}, got, "i todo senza completed_at vengono prima")
+
+			got, page = ids(ListOptions{Limit: 2, Offset: 2})
+			assert.Equal(t, []int{3, 4}, got)
+			assert.Equal(t, 5, page.Total)
+			assert.True(t, page.HasMore)
+
+			got, page = ids(ListOptions{Limit: 2, AfterID: 3})
+			assert.Equal(t, []int{4, 5}, got)
+			assert.False(t, page.HasMore)
+
+			got, page = ids(ListOptions{Limit: 2, AfterID: 4, Desc: true})
+			assert.Equal(t, []int{3, 2}, got)
+			assert.True(t, page.HasMore)
+
+			_, err = store.GetAll(ctx, ListOptions{AfterID: 2, Sort: SortByTitle})
+			assert.ErrorIs(t, err, ErrInvalid, "il cursore richiede l'ordinamento per id")
+			_, err = store.GetAll(ctx, ListOptions{Sort: "banana"})
+			assert.ErrorIs(t, err, ErrInvalid)
+		})
+	}
+}
+
+// Un database bloccato da un'altra connessione deve dare ErrUnavailable,
+// non un errore generico indistinguibile da un todo mancante.
+// TestGetAllNonASCII verifica che ricerca e ordinamento per titolo diano
+// lo stesso risultato su tutti i backend anche con le lettere accentate:
+// come LIKE e NOCASE di SQLite, solo le lettere ASCII ignorano le maiuscole.
+func TestGetAllNonASCII(t *testing.T) {
+	for name, newStore := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := WithOwner(context.Background(), DefaultUserID)
+			s := newStore(t)
+			for _, title := range []string{"È ora di cena", "è tardi", "CAFFÈ"} {
+				_, err := s.Create(ctx, Todo{Title: title})
+				require.NoError(t, err)
+			}
+			ids := func(opts ListOptions) []int {
+				page, err := s.GetAll(ctx, opts)
+				require.NoError(t, err)
+				out := []int{}
+				for _, todo := range page.Todos {
+					out = append(out, todo.ID)
+				}
+				return out
+			}
+
+			assert.Equal(t, []int{2}, ids(ListOptions{Search: "è"}))
+			assert.Equal(t, []int{1, 3}, ids(ListOptions{Search: "È"}))
+			assert.Equal(t, []int{3}, ids(ListOptions{Search: "caff"}))
+			assert.Empty(t, ids(ListOptions{Search: "caffè"}))
+			assert.Equal(t, []int{3, 1, 2}, ids(ListOptions{Sort: SortByTitle}))
+		})
+	}
+}
+
+func TestSQLiteUnavailable(t *testing.T) {
+	ctx := WithOwner(context.Background(), DefaultUserID)
+	path := filepath.Join(t.TempDir(), "locked.db")
+
+	// _busy_timeout=0: senza attese, il lock viene segnalato subito
+	s, err := New(path + "?_busy_timeout=0")
+	require.NoError(t, err)
+	defer s.Close()
+	_, err = s.Create(ctx, Todo{Title: "Prima del lock"})
+	require.NoError(t, err)
+
+	locker, err := OpenDB(path)
+	require.NoError(t, err)
+	defer locker.Close()
+	conn, err := locker.Conn(ctx)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.ExecContext(ctx, "BEGIN EXCLUSIVE")
+	require.NoError(t, err)
+	defer conn.ExecContext(ctx, "ROLLBACK")
+
+	_, err = s.Create(ctx, Todo{Title: "Durante il lock"})
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.NotErrorIs(t, err, ErrNotFound)
+}
+
+// Più richieste che leggono e poi modificano dei todo in parallelo si
+// mettono in fila: al massimo trovano un conflitto di versione, mai il
+// database bloccato (ErrUnavailable).
+func TestSQLiteConcurrentUpdates(t *testing.T) {
+	ctx := WithOwner(context.Background(), DefaultUserID)
+	s, err := New(filepath.Join(t.TempDir(), "concurrent.db"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	const todos, workers, rounds = 10, 20, 20
+	for i := range todos {
+		_, err := s.Create(ctx, Todo{Title: fmt.Sprintf("Todo %d", i+1)})
+		require.NoError(t, err)
+	}
+
+	// ogni todo è conteso da due worker, così ci sono anche dei conflitti
+	errs := make(chan error, workers*rounds)
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ID := w%todos + 1
+			for r := range rounds {
+				td, err := s.GetByID(ctx, ID)
+				if err != nil {
+					errs <- err
+					continue
+				}
+				_, err = s.Update(ctx, Todo{ID: ID, Title: fmt.Sprintf("Todo %d, giro %d", ID, r), Status: StatusPending, Version: td.Version})
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	updated := 0
+	for err := range errs {
+		if err == nil {
+			updated++
+			continue
+		}
+		assert.ErrorIs(t, err, ErrVersionConflict)
+		assert.NotErrorIs(t, err, ErrUnavailable)
+	}
+	assert.Positive(t, updated)
+}
+
+// Anche gli altri metodi che modificano i dati leggono e poi scrivono:
+// in parallelo, su todo diversi, devono riuscire tutti. Il percorso chiede
+// il BEGIN normale, ma OpenDB usa comunque BEGIN IMMEDIATE.
+func TestSQLiteConcurrentWrites(t *testing.T) {
+	ctx := WithOwner(context.Background(), DefaultUserID)
+	s, err := New(filepath.Join(t.TempDir(), "writes.db") + "?_txlock=deferred")
+	require.NoError(t, err)
+	defer s.Close()
+
+	const workers, rounds = 10, 10
+	errs := make(chan error, workers*rounds*7)
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			td, err := s.Create(ctx, Todo{Title: fmt.Sprintf("Todo %d", w)})
+			if err != nil {
+				errs <- err
+				return
+			}
+			for r := range rounds {
+				errs <- s.Delete(ctx, td.ID, 0)
+				_, err := s.Restore(ctx, td.ID)
+				errs <- err
+				_, err = s.AddTag(ctx, td.ID, "casa")
+				errs <- err
+				_, err = s.RemoveTag(ctx, td.ID, "casa")
+				errs <- err
+				l, err := s.CreateList(ctx, fmt.Sprintf("Lista %d-%d", w, r))
+				errs <- err
+				if err == nil {
+					_, err = s.RenameList(ctx, l.ID, fmt.Sprintf("Lista %d-%d bis", w, r))
+					errs <- err
+					errs <- s.DeleteList(ctx, l.ID, true)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+}
+
+// Le transazioni di WithTx (i batch) leggono e poi scrivono come i singoli
+// metodi: in parallelo devono eseguirsi una dopo l'altra, tutte intere.
+func TestSQLiteConcurrentTx(t *testing.T) {
+	ctx := WithOwner(context.Background(), DefaultUserID)
+	s, err := New(filepath.Join(t.TempDir(), "batches.db"))
+	require.NoError(t, err)
+	defer s.Close()
+	counter, err := s.Create(ctx, Todo{Title: "0"})
+	require.NoError(t, err)
+
+	// ogni batch legge il contatore nel titolo e lo incrementa: se due
+	// batch si sovrapponessero, uno degli incrementi andrebbe perso
+	const batches = 20
+	errs := make(chan error, batches)
+	var wg sync.WaitGroup
+	for range batches {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.WithTx(ctx, func(tx Repository) error {
+				td, err := tx.GetByID(ctx, counter.ID)
+				if err != nil {
+					return err
+				}
+				var n int
+				fmt.Sscan(td.Title, &n)
+				_, err = tx.Update(ctx, Todo{ID: td.ID, Title: fmt.Sprint(n + 1), Status: StatusPending, Version: td.Version})
+				return err
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	td, err := s.GetByID(ctx, counter.ID)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprint(batches), td.Title)
+}
+
+// Se il file JSON non si può scrivere l'errore è ErrUnavailable e lo
+// stato in memoria resta quello di prima.
+func TestJSONStoreUnavailable(t *testing.T) {
+	ctx := WithOwner(context.Background(), DefaultUserID)
+	dir := t.TempDir()
+
+	s, err := NewJSONStore(filepath.Join(dir, "todos.json"))
+	require.NoError(t, err)
+	// togliendo la cartella il file temporaneo non può essere creato
+	require.NoError(t, os.RemoveAll(dir))
+
+	_, err = s.Create(ctx, Todo{Title: "Non salvabile"})
+	assert.ErrorIs(t, err, ErrUnavailable)
+	_, err = s.GetByID(ctx, 1)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+// Ogni utente vede e modifica solo i propri todo; quelli degli altri
+// risultano inesistenti.
+func TestOwnership(t *testing.T) {
+	for name, newStore := range backends {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t).(Repository)
+			bg := context.Background()
+
+			alice, err := s.CreateUser(bg, "alice", "")
+			require.NoError(t, err)
+			bob, err := s.CreateUser(bg, "bob", "")
+			require.NoError(t, err)
+			assert.NotEqual(t, alice.ID, bob.ID)
+
+			_, err = s.CreateUser(bg, "ALICE", "")
+			assert.ErrorIs(t, err, ErrConflict, "gli username non distinguono le maiuscole")
+			_, err = s.CreateUser(bg, "con spazi", "")
+			assert.ErrorIs(t, err, ErrInvalid)
+
+			found, err := s.GetUserByUsername(bg, "Bob")
+			require.NoError(t, err)
+			assert.Equal(t, bob.ID, found.ID)
+			_, err = s.GetUser(bg, 999)
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			require.NoError(t, s.SetPassword(bg, bob.ID, "nuovo-hash"))
+			found, err = s.GetUser(bg, bob.ID)
+			require.NoError(t, err)
+			assert.Equal(t, "nuovo-hash", found.PasswordHash)
+			assert.ErrorIs(t, s.SetPassword(bg, 999, "x"), ErrNotFound)
+
+			asAlice := WithOwner(bg, alice.ID)
+			asBob := WithOwner(bg, bob.ID)
+
+			todo, err := s.Create(asAlice, Todo{Title: "Segreto di Alice"})
+			require.NoError(t, err)
+			_, err = s.Create(asBob, Todo{Title: "Lista di Bob"})
+			require.NoError(t, err)
+
+			page, err := s.GetAll(asBob, ListOptions{})
+			require.NoError(t, err)
+			require.Len(t, page.Todos, 1)
+			assert.Equal(t, "Lista di Bob", page.Todos[0].Title)
+			assert.Equal(t, 1, page.Total)
+
+			_, err = s.GetByID(asBob, todo.ID)
+			assert.ErrorIs(t, err, ErrNotFound)
+			_, err = s.Update(asBob, Todo{ID: todo.ID, Title: "Rubato", Status: StatusDone})
+			assert.ErrorIs(t, err, ErrNotFound)
+			assert.ErrorIs(t, s.Delete(asBob, todo.ID, 0), ErrNotFound)
+
+			// il todo di Alice è rimasto com'era
+			got, err := s.GetByID(asAlice, todo.ID)
+			require.NoError(t, err)
+			assert.Equal(t, todo, got)
+
+			// senza utente nel contesto non si fa nulla
+			_, err = s.GetAll(bg, ListOptions{})
+			assert.ErrorIs(t, err, ErrNoOwner)
+			_, err = s.Create(bg, Todo{Title: "Di nessuno"})
+			assert.ErrorIs(t, err, ErrNoOwner)
+		})
+	}
+}
+
+// Le API key appartengono a un utente; la ricerca per hash invece non
+// dipende dal contesto, perché serve proprio a scoprire l'utente.
+func TestAPIKeys(t *testing.T) {
+	for name, newStore := range backends {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t).(Repository)
+			bg := context.Background()
+			other, err := s.CreateUser(bg, "altro", "")
+			require.NoError(t, err)
+			ctx := WithOwner(bg, DefaultUserID)
+
+			key, err := s.CreateAPIKey(ctx, APIKey{Name: "CI", Prefix: "tdk_abcd", KeyHash: "hash-1", Scope: ScopeReadOnly})
+			require.NoError(t, err)
+			assert.Equal(t, DefaultUserID, key.OwnerID)
+			assert.Nil(t, key.LastUsedAt)
+
+			_, err = s.CreateAPIKey(ctx, APIKey{Name: "doppia", Prefix: "tdk_abcd", KeyHash: "hash-1", Scope: ScopeReadOnly})
+			assert.ErrorIs(t, err, ErrConflict)
+			_, err = s.CreateAPIKey(ctx, APIKey{Name: "x", Prefix: "tdk_x", KeyHash: "hash-2", Scope: "admin"})
+			assert.ErrorIs(t, err, ErrInvalid)
+
+			found, err := s.GetAPIKeyByHash(bg, "hash-1")
+			require.NoError(t, err)
+			assert.Equal(t, key.ID, found.ID)
+			assert.Equal(t, DefaultUserID, found.OwnerID)
+			_, err = s.GetAPIKeyByHash(bg, "nessuna")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			used := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+			require.NoError(t, s.TouchAPIKey(bg, key.ID, used))
+
+			keys, err := s.ListAPIKeys(ctx)
+			require.NoError(t, err)
+			require.Len(t, keys, 1)
+			require.NotNil(t, keys[0].LastUsedAt)
+			assert.True(t, used.Equal(*keys[0].LastUsedAt))
+
+			// un altro utente non vede e non revoca le chiavi altrui
+			asOther := WithOwner(bg, other.ID)
+			keys, err = s.ListAPIKeys(asOther)
+			require.NoError(t, err)
+			assert.Empty(t, keys)
+			_, err = s.RevokeAPIKey(asOther, key.ID)
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			revoked, err := s.RevokeAPIKey(ctx, key.ID)
+			require.NoError(t, err)
+			require.True(t, revoked.Revoked())
+			again, err := s.RevokeAPIKey(ctx, key.ID)
+			require.NoError(t, err, "revocare due volte non è un errore")
+			assert.True(t, revoked.RevokedAt.Equal(*again.RevokedAt), "la data di revoca non cambia")
+
+			found, err = s.GetAPIKeyByHash(bg, "hash-1")
+			require.NoError(t, err)
+			assert.True(t, found.Revoked())
+		})
+	}
+}
+
+func TestLists(t *testing.T) {
+	for name, newStore := range backends {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t).(Repository)
+			bg := context.Background()
+			ctx := WithOwner(bg, DefaultUserID)
+
+			work, err := s.CreateList(ctx, "  Lavoro ")
+			require.NoError(t, err)
+			assert.Equal(t, "Lavoro", work.Name, "gli spazi ai lati vanno tolti")
+			home, err := s.CreateList(ctx, "Casa")
+			require.NoError(t, err)
+
+			_, err = s.CreateList(ctx, "LAVORO")
+			assert.ErrorIs(t, err, ErrConflict, "i nomi non distinguono le maiuscole")
+			_, err = s.CreateList(ctx, " ")
+			assert.ErrorIs(t, err, ErrInvalid)
+			_, err = s.RenameList(ctx, home.ID, "lavoro")
+			assert.ErrorIs(t, err, ErrConflict)
+
+			report, err := s.Create(ctx, Todo{Title: "Relazione", ListID: &work.ID})
+			require.NoError(t, err)
+			require.NotNil(t, report.ListID)
+			assert.Equal(t, work.ID, *report.ListID)
+			_, err = s.Create(ctx, Todo{Title: "Senza lista"})
+			require.NoError(t, err)
+			_, err = s.Create(ctx, Todo{Title: "Lista inventata", ListID: ptr(999)})
+			assert.ErrorIs(t, err, ErrInvalid)
+
+			page, err := s.GetAll(ctx, ListOptions{ListID: work.ID})
+			require.NoError(t, err)
+			require.Len(t, page.Todos, 1)
+			assert.Equal(t, report.ID, page.Todos[0].ID)
+
+			got, err := s.GetList(ctx, work.ID)
+			require.NoError(t, err)
+			assert.Equal(t, 1, got.TodoCount)
+
+			// spostare un todo è un normale Update della lista
+			moved, err := s.Update(ctx, Todo{ID: report.ID, Title: report.Title, Status: report.Status, ListID: &home.ID})
+			require.NoError(t, err)
+			assert.Equal(t, home.ID, *moved.ListID)
+			_, err = s.Update(ctx, Todo{ID: report.ID, Title: report.Title, Status: report.Status, ListID: ptr(999)})
+			assert.ErrorIs(t, err, ErrInvalid)
+
+			lists, err := s.GetLists(ctx)
+			require.NoError(t, err)
+			require.Len(t, lists, 2)
+			assert.Equal(t, 0, lists[0].TodoCount)
+			assert.Equal(t, 1, lists[1].TodoCount)
+
+			// un altro utente non vede le liste e non può usarle
+			other, err := s.CreateUser(bg, "altro", "")
+			require.NoError(t, err)
+			asOther := WithOwner(bg, other.ID)
+			_, err = s.GetList(asOther, home.ID)
+			assert.ErrorIs(t, err, ErrNotFound)
+			_, err = s.Create(asOther, Todo{Title: "Intruso", ListID: &home.ID})
+			assert.ErrorIs(t, err, ErrInvalid)
+			_, err = s.CreateList(asOther, "Casa")
+			assert.NoError(t, err, "lo stesso nome va bene per utenti diversi")
+
+			// una lista piena si cancella solo a cascata
+			assert.ErrorIs(t, s.DeleteList(ctx, home.ID, false), ErrListNotEmpty)
+			require.NoError(t, s.DeleteList(ctx, work.ID, false), "una lista vuota si cancella sempre")
+			require.NoError(t, s.DeleteList(ctx, home.ID, true))
+			_, err = s.GetByID(ctx, report.ID)
+			assert.ErrorIs(t, err, ErrNotFound, "il todo è nel cestino")
+			assert.ErrorIs(t, s.DeleteList(ctx, home.ID, true), ErrNotFound)
+
+			page, err = s.GetAll(ctx, ListOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, 1, page.Total, "il todo senza lista resta")
+		})
+	}
+}
+
+func TestTags(t *testing.T) {
+	for name, newStore := range backends {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t).(Repository)
+			ctx := WithOwner(context.Background(), DefaultUserID)
+
+			bread, err := s.Create(ctx, Todo{Title: "Pane"})
+			require.NoError(t, err)
+			assert.Equal(t, []string{}, bread.Tags)
+			report, err := s.Create(ctx, Todo{Title: "Relazione"})
+			require.NoError(t, err)
+			call, err := s.Create(ctx, Todo{Title: "Telefonare"})
+			require.NoError(t, err)
+
+			bread, err = s.AddTag(ctx, bread.ID, " Casa ")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"casa"}, bread.Tags, "i tag sono normalizzati")
+			assert.Equal(t, 2, bread.Version, "cambiare i tag cambia la versione")
+			bread, err = s.AddTag(ctx, bread.ID, "urgente")
+			require.NoError(t, err)
+			again, err := s.AddTag(ctx, bread.ID, "CASA")
+			require.NoError(t, err)
+			assert.Equal(t, bread, again, "un tag già presente non cambia nulla")
+
+			_, err = s.AddTag(ctx, report.ID, "lavoro")
+			require.NoError(t, err)
+			_, err = s.AddTag(ctx, call.ID, "urgente")
+			require.NoError(t, err)
+			_, err = s.AddTag(ctx, call.ID, "a,b")
+			assert.ErrorIs(t, err, ErrInvalid)
+			_, err = s.AddTag(ctx, call.ID, "casa mia")
+			assert.ErrorIs(t, err, ErrInvalid, "nel Markdown un tag con spazi diventerebbe due parole")
+			_, err = s.AddTag(ctx, 999, "casa")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			got, err := s.GetByID(ctx, bread.ID)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"casa", "urgente"}, got.Tags)
+
+			titles := func(opts ListOptions) []string {
+				page, err := s.GetAll(ctx, opts)
+				require.NoError(t, err)
+				var out []string
+				for _, td := range page.Todos {
+					out = append(out, td.Title)
+				}
+				return out
+			}
+			assert.Equal(t, []string{"Pane", "Relazione"}, titles(ListOptions{Tags: []string{"casa", "lavoro"}}))
+			assert.Equal(t, []string{"Pane"}, titles(ListOptions{Tags: []string{"casa", "urgente"}, AllTags: true}))
+			assert.Equal(t, []string{"Pane"}, titles(ListOptions{Tags: []string{"casa", "casa"}, AllTags: true}), "i doppioni non contano")
+			assert.Empty(t, titles(ListOptions{Tags: []string{"nessuno"}}))
+
+			tags, err := s.GetTags(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, []Tag{{"casa", 1}, {"lavoro", 1}, {"urgente", 2}}, tags)
+
+			// togliere l'ultimo uso di un tag lo fa sparire dall'elenco
+			report, err = s.RemoveTag(ctx, report.ID, "lavoro")
+			require.NoError(t, err)
+			assert.Equal(t, []string{}, report.Tags)
+			_, err = s.RemoveTag(ctx, report.ID, "lavoro")
+			assert.NoError(t, err, "togliere un tag assente non è un errore")
+			require.NoError(t, s.Delete(ctx, call.ID, 0))
+
+			tags, err = s.GetTags(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, []Tag{{"casa", 1}, {"urgente", 1}}, tags)
+
+			// i tag degli altri utenti non si vedono
+			other, err := s.CreateUser(context.Background(), "altro", "")
+			require.NoError(t, err)
+			tags, err = s.GetTags(WithOwner(context.Background(), other.ID))
+			require.NoError(t, err)
+			assert.Empty(t, tags)
+		})
+	}
+}
+
+func TestScheduling(t *testing.T) {
+	for name, newStore := range backends {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t)
+			ctx := WithOwner(context.Background(), DefaultUserID)
+			rome := time.FixedZone("CEST", 2*60*60)
+			past := time.Date(2000, 1, 1, 9, 0, 0, 0, rome)
+			soon := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+			later := soon.Add(24 * time.Hour)
+
+			create := func(title string, p Priority, due *time.Time) Todo {
+				td, err := s.Create(ctx, Todo{Title: title, Priority: p, DueAt: due, Description: "note di " + title})
+				require.NoError(t, err)
+				return td
+			}
+			late := create("Scaduto", PriorityLow, &past)
+			lateDone := create("Scaduto ma fatto", PriorityUrgent, &past)
+			_, err := s.Update(ctx, Todo{ID: lateDone.ID, Title: lateDone.Title, Status: StatusDone, Priority: PriorityUrgent, DueAt: &past})
+			require.NoError(t, err)
+			create("Urgente dopo", PriorityUrgent, &later)
+			create("Urgente presto", PriorityUrgent, &soon)
+			create("Urgente senza data", PriorityUrgent, nil)
+			plain := create("Normale", "", nil)
+
+			assert.Equal(t, PriorityNormal, plain.Priority, "la priorità di default è normal")
+			got, err := s.GetByID(ctx, late.ID)
+			require.NoError(t, err)
+			assert.True(t, past.Equal(*got.DueAt))
+			for _, td := range []Todo{late, got} {
+				assert.Equal(t, "2000-01-01T09:00:00+02:00", td.DueAt.Format(time.RFC3339), "le scadenze tengono l'offset del client")
+			}
+			assert.Equal(t, "note di Scaduto", got.Description)
+
+			_, err = s.Create(ctx, Todo{Title: "x", Priority: "altissima"})
+			assert.ErrorIs(t, err, ErrInvalid)
+
+			titles := func(opts ListOptions) []string {
+				page, err := s.GetAll(ctx, opts)
+				require.NoError(t, err)
+				var out []string
+				for _, td := range page.Todos {
+					out = append(out, td.Title)
+				}
+				return out
+			}
+			yes, no := true, false
+			assert.Equal(t, []string{"Scaduto"}, titles(ListOptions{Overdue: &yes}))
+			assert.Len(t, titles(ListOptions{Overdue: &no}), 5)
+			assert.Equal(t, []string{"Urgente presto"}, titles(ListOptions{DueAfter: &soon, DueBefore: &later}))
+			assert.Equal(t, []string{"Scaduto", "Scaduto ma fatto"}, titles(ListOptions{DueBefore: ptr(time.Now())}))
+
+			// a parità di priorità conta la scadenza, anche se il todo è già fatto
+			assert.Equal(t, []string{
+				"Scaduto ma fatto", "Urgente presto", "Urgente dopo", "Urgente senza data",
+				"Normale", "Scaduto",
+			}, titles(ListOptions{Sort: SortByPriority}))
+			assert.Equal(t, []string{
+				"Scaduto", "Normale",
+				"Urgente dopo", "Urgente presto", "Scaduto ma fatto", "Urgente senza data",
+			}, titles(ListOptions{Sort: SortByPriority, Desc: true}), "i todo senza scadenza restano in fondo al loro gruppo")
+
+			page, err := s.GetAll(ctx, ListOptions{Sort: SortByDueAt})
+			require.NoError(t, err)
+			assert.Nil(t, page.Todos[len(page.Todos)-1].DueAt)
+		})
+	}
+}
+
+func TestSubtasks(t *testing.T) {
+	for name, newStore := range backends {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t).(Repository)
+			ctx := WithOwner(context.Background(), DefaultUserID)
+
+			create := func(title string, parentID *int) Todo {
+				td, err := s.Create(ctx, Todo{Title: title, ParentID: parentID})
+				require.NoError(t, err)
+				return td
+			}
+			get := func(ID int) Todo {
+				td, err := s.GetByID(ctx, ID)
+				require.NoError(t, err)
+				return td
+			}
+			trip := create("Viaggio", nil)
+			assert.Nil(t, trip.Progress, "senza sottoattività non c'è avanzamento")
+			tickets := create("Biglietti", &trip.ID)
+			hotel := create("Albergo", &trip.ID)
+			prices := create("Confrontare i prezzi", &tickets.ID)
+
+			trip = get(trip.ID)
+			assert.Equal(t, 0, *trip.Progress)
+			assert.Equal(t, 3, trip.Version, "ogni nuova sottoattività cambia la versione del genitore")
+
+			_, err := s.Create(ctx, Todo{Title: "orfano", ParentID: ptr(999)})
+			assert.ErrorIs(t, err, ErrInvalid)
+			other, err := s.CreateUser(context.Background(), "altro", "")
+			require.NoError(t, err)
+			_, err = s.Create(WithOwner(context.Background(), other.ID), Todo{Title: "intruso", ParentID: &trip.ID})
+			assert.ErrorIs(t, err, ErrInvalid, "il genitore di un altro utente non esiste")
+
+			t.Run("avanzamento", func(t *testing.T) {
+				_, err := s.Update(ctx, Todo{ID: hotel.ID, Title: hotel.Title, Status: StatusDone, ParentID: &trip.ID})
+				require.NoError(t, err)
+				trip = get(trip.ID)
+				assert.Equal(t, 50, *trip.Progress)
+				assert.Equal(t, 4, trip.Version)
+
+				// un cambio che non riguarda stato o genitore non tocca il genitore
+				_, err = s.Update(ctx, Todo{ID: tickets.ID, Title: "Biglietti del treno", Status: StatusArchived, ParentID: &trip.ID})
+				require.NoError(t, err)
+				trip = get(trip.ID)
+				assert.Equal(t, 100, *trip.Progress, "le sottoattività archiviate non contano")
+			})
+
+			t.Run("cicli", func(t *testing.T) {
+				_, err := s.Update(ctx, Todo{ID: trip.ID, Title: trip.Title, Status: StatusPending, ParentID: &prices.ID})
+				assert.ErrorIs(t, err, ErrInvalid)
+				_, err = s.Update(ctx, Todo{ID: hotel.ID, Title: hotel.Title, Status: StatusDone, ParentID: &hotel.ID})
+				assert.ErrorIs(t, err, ErrInvalid)
+
+				// spostare una sottoattività sotto un ramo diverso va bene
+				moved, err := s.Update(ctx, Todo{ID: prices.ID, Title: prices.Title, Status: StatusPending, ParentID: &hotel.ID})
+				require.NoError(t, err)
+				assert.Equal(t, hotel.ID, *moved.ParentID)
+				_, err = s.Update(ctx, Todo{ID: prices.ID, Title: prices.Title, Status: StatusPending, ParentID: &tickets.ID})
+				require.NoError(t, err)
+			})
+
+			t.Run("figli e albero", func(t *testing.T) {
+				page, err := s.GetAll(ctx, ListOptions{ParentID: trip.ID})
+				require.NoError(t, err)
+				require.Len(t, page.Todos, 2)
+				assert.Equal(t, []int{tickets.ID, hotel.ID}, []int{page.Todos[0].ID, page.Todos[1].ID})
+
+				forest, err := s.GetTree(ctx, 0)
+				require.NoError(t, err)
+				require.Len(t, forest, 1)
+				assert.Equal(t, "Viaggio", forest[0].Title)
+				require.Len(t, forest[0].Children, 2)
+				require.Len(t, forest[0].Children[0].Children, 1)
+				assert.Equal(t, "Confrontare i prezzi", forest[0].Children[0].Children[0].Title)
+				assert.Empty(t, forest[0].Children[1].Children)
+
+				sub, err := s.GetTree(ctx, tickets.ID)
+				require.NoError(t, err)
+				require.Len(t, sub, 1)
+				assert.Equal(t, tickets.ID, sub[0].ID)
+				assert.Len(t, sub[0].Children, 1)
+
+				_, err = s.GetTree(ctx, 999)
+				assert.ErrorIs(t, err, ErrNotFound)
+			})
+
+			t.Run("cancellare un genitore", func(t *testing.T) {
+				before := get(prices.ID)
+				require.NoError(t, s.Delete(ctx, tickets.ID, 0))
+				assert.Equal(t, trip.Version+1, get(trip.ID).Version)
+
+				// finché il genitore è nel cestino la sottoattività resta sua,
+				// ma nell'albero compare al primo livello
+				assert.Equal(t, tickets.ID, *get(prices.ID).ParentID)
+				forest, err := s.GetTree(ctx, 0)
+				require.NoError(t, err)
+				assert.Len(t, forest, 2)
+				_, err = s.Update(ctx, Todo{ID: prices.ID, Title: "Prezzi", Status: StatusPending, ParentID: &tickets.ID})
+				require.NoError(t, err, "il todo resta modificabile")
+				_, err = s.Update(ctx, Todo{ID: hotel.ID, Title: hotel.Title, Status: StatusDone, ParentID: &tickets.ID})
+				assert.ErrorIs(t, err, ErrInvalid, "ma non gli si possono dare nuove sottoattività")
+
+				_, err = s.Purge(context.Background(), time.Now().Add(time.Minute))
+				require.NoError(t, err)
+				orphan := get(prices.ID)
+				assert.Nil(t, orphan.ParentID, "eliminato il genitore, le sottoattività salgono al primo livello")
+				assert.Equal(t, before.Version+2, orphan.Version)
+			})
+		})
+	}
+}
+
+func TestRecurrence(t *testing.T) {
+	for name, newStore := range backends {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t).(Repository)
+			ctx := WithOwner(context.Background(), DefaultUserID)
+			get := func(ID int) Todo {
+				td, err := s.GetByID(ctx, ID)
+				require.NoError(t, err)
+				return td
+			}
+			complete := func(td Todo) Todo {
+				done, err := s.Update(ctx, Todo{ID: td.ID, Title: td.Title, Status: StatusDone, Priority: td.Priority,
+					DueAt: td.DueAt, Recurrence: td.Recurrence, ParentID: td.ParentID})
+				require.NoError(t, err)
+				return done
+			}
+
+			// lunedì 6 gennaio 2025
+			due := time.Date(2025, 1, 6, 9, 0, 0, 0, time.UTC)
+			parent, err := s.Create(ctx, Todo{Title: "Casa"})
+			require.NoError(t, err)
+			weekly, err := s.Create(ctx, Todo{Title: "Buttare la carta", Priority: PriorityHigh, DueAt: &due,
+				Recurrence: "rrule:freq=weekly;count=2", ParentID: &parent.ID})
+			require.NoError(t, err)
+			assert.Equal(t, "FREQ=WEEKLY;COUNT=2", weekly.Recurrence, "la regola è salvata in forma canonica")
+			_, err = s.AddTag(ctx, weekly.ID, "casa")
+			require.NoError(t, err)
+			weekly = get(weekly.ID)
+			parentVersion := get(parent.ID).Version
+
+			done := complete(weekly)
+			require.NotNil(t, done.NextOccurrenceID)
+			assert.Equal(t, done.NextOccurrenceID, get(weekly.ID).NextOccurrenceID)
+
+			next := get(*done.NextOccurrenceID)
+			assert.Equal(t, StatusPending, next.Status)
+			assert.Equal(t, "Buttare la carta", next.Title)
+			assert.Equal(t, PriorityHigh, next.Priority)
+			assert.True(t, due.AddDate(0, 0, 7).Equal(*next.DueAt))
+			assert.Equal(t, "FREQ=WEEKLY;COUNT=1", next.Recurrence)
+			assert.Equal(t, []string{"casa"}, next.Tags)
+			assert.Equal(t, parent.ID, *next.ParentID)
+			assert.Nil(t, next.NextOccurrenceID)
+			assert.Equal(t, parentVersion+2, get(parent.ID).Version, "cambiano sia lo stato del figlio che i figli")
+
+			t.Run("una sola occorrenza successiva", func(t *testing.T) {
+				reopened, err := s.Update(ctx, Todo{ID: done.ID, Title: done.Title, Status: StatusPending, DueAt: done.DueAt,
+					Recurrence: done.Recurrence, ParentID: done.ParentID})
+				require.NoError(t, err)
+				again := complete(reopened)
+				assert.Equal(t, next.ID, *again.NextOccurrenceID)
+				page, err := s.GetAll(ctx, ListOptions{})
+				require.NoError(t, err)
+				assert.Len(t, page.Todos, 3)
+			})
+
+			t.Run("fine della serie", func(t *testing.T) {
+				last := complete(next)
+				assert.Nil(t, last.NextOccurrenceID, "COUNT=1 è l'ultima occorrenza")
+			})
+
+			t.Run("cancellare l'occorrenza successiva", func(t *testing.T) {
+				before := get(weekly.ID)
+				require.NoError(t, s.Delete(ctx, next.ID, 0))
+				_, err := s.Purge(context.Background(), time.Now().Add(time.Minute))
+				require.NoError(t, err)
+				after := get(weekly.ID)
+				assert.Nil(t, after.NextOccurrenceID)
+				assert.Equal(t, before.Version+1, after.Version)
+			})
+
+			t.Run("giorni nel fuso della scadenza", func(t *testing.T) {
+				// lunedì alle 00:30 con +02:00: in UTC è ancora domenica
+				monday := time.Date(2025, 6, 2, 0, 30, 0, 0, time.FixedZone("", 2*60*60))
+				td, err := s.Create(ctx, Todo{Title: "Riunione", DueAt: &monday, Recurrence: "FREQ=WEEKLY;BYDAY=MO"})
+				require.NoError(t, err)
+				done := complete(td)
+				require.NotNil(t, done.NextOccurrenceID)
+				assert.Equal(t, "2025-06-09T00:30:00+02:00", get(*done.NextOccurrenceID).DueAt.Format(time.RFC3339))
+			})
+
+			t.Run("regole non valide", func(t *testing.T) {
+				_, err := s.Create(ctx, Todo{Title: "x", DueAt: &due, Recurrence: "FREQ=YEARLY"})
+				assert.ErrorIs(t, err, ErrInvalid)
+				_, err = s.Create(ctx, Todo{Title: "x", Recurrence: "FREQ=DAILY"})
+				assert.ErrorIs(t, err, ErrInvalid, "serve una scadenza")
+			})
+		})
+	}
+}
+
+func TestTrash(t *testing.T) {
+	for name, newStore := range backends {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t).(Repository)
+			ctx := WithOwner(context.Background(), DefaultUserID)
+
+			list, err := s.CreateList(ctx, "Casa")
+			require.NoError(t, err)
+			parent, err := s.Create(ctx, Todo{Title: "Trasloco"})
+			require.NoError(t, err)
+			boxes, err := s.Create(ctx, Todo{Title: "Scatoloni", ListID: &list.ID, ParentID: &parent.ID})
+			require.NoError(t, err)
+			_, err = s.AddTag(ctx, boxes.ID, "casa")
+			require.NoError(t, err)
+			boxes, err = s.GetByID(ctx, boxes.ID)
+			require.NoError(t, err)
+			parent, err = s.GetByID(ctx, parent.ID)
+			require.NoError(t, err)
+			parentVersion := parent.Version
+
+			assert.ErrorIs(t, s.Delete(ctx, boxes.ID, boxes.Version+1), ErrVersionConflict)
+			require.NoError(t, s.Delete(ctx, boxes.ID, boxes.Version))
+
+			_, err = s.GetByID(ctx, boxes.ID)
+			assert.ErrorIs(t, err, ErrNotFound, "i todo nel cestino non si leggono")
+			_, err = s.Update(ctx, Todo{ID: boxes.ID, Title: "x", Status: StatusPending})
+			assert.ErrorIs(t, err, ErrNotFound)
+			assert.ErrorIs(t, s.Delete(ctx, boxes.ID, 0), ErrNotFound)
+			page, err := s.GetAll(ctx, ListOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, 1, page.Total)
+			l, err := s.GetList(ctx, list.ID)
+			require.NoError(t, err)
+			assert.Zero(t, l.TodoCount)
+			tags, err := s.GetTags(ctx)
+			require.NoError(t, err)
+			assert.Empty(t, tags)
+			parent, err = s.GetByID(ctx, parent.ID)
+			require.NoError(t, err)
+			assert.Nil(t, parent.Progress)
+			assert.Equal(t, parentVersion+1, parent.Version)
+
+			trash, err := s.GetTrash(ctx)
+			require.NoError(t, err)
+			require.Len(t, trash, 1)
+			assert.Equal(t, boxes.ID, trash[0].ID)
+			require.NotNil(t, trash[0].DeletedAt)
+			assert.WithinDuration(t, time.Now(), *trash[0].DeletedAt, time.Minute)
+
+			other, err := s.CreateUser(context.Background(), "altro", "")
+			require.NoError(t, err)
+			otherCtx := WithOwner(context.Background(), other.ID)
+			_, err = s.Restore(otherCtx, boxes.ID)
+			assert.ErrorIs(t, err, ErrNotFound)
+			otherTrash, err := s.GetTrash(otherCtx)
+			require.NoError(t, err)
+			assert.Empty(t, otherTrash)
+
+			t.Run("ripristino", func(t *testing.T) {
+				restored, err := s.Restore(ctx, boxes.ID)
+				require.NoError(t, err)
+				assert.Nil(t, restored.DeletedAt)
+				assert.Equal(t, boxes.Version+2, restored.Version)
+				assert.Equal(t, []string{"casa"}, restored.Tags)
+				assert.Equal(t, list.ID, *restored.ListID)
+				assert.Equal(t, parent.ID, *restored.ParentID)
+
+				_, err = s.Restore(ctx, boxes.ID)
+				assert.ErrorIs(t, err, ErrNotFound, "il todo non è più nel cestino")
+				_, err = s.Restore(ctx, 999)
+				assert.ErrorIs(t, err, ErrNotFound)
+			})
+
+			t.Run("lista cancellata", func(t *testing.T) {
+				require.NoError(t, s.Delete(ctx, boxes.ID, 0))
+				require.NoError(t, s.DeleteList(ctx, list.ID, false), "la lista ha solo todo nel cestino")
+				restored, err := s.Restore(ctx, boxes.ID)
+				require.NoError(t, err)
+				assert.Nil(t, restored.ListID)
+			})
+
+			t.Run("lista cancellata a cascata", func(t *testing.T) {
+				shop, err := s.CreateList(ctx, "Spesa")
+				require.NoError(t, err)
+				milk, err := s.Create(ctx, Todo{Title: "Latte", ListID: &shop.ID})
+				require.NoError(t, err)
+				bread, err := s.Create(ctx, Todo{Title: "Pane", ListID: &shop.ID, ParentID: &milk.ID})
+				require.NoError(t, err)
+
+				require.NoError(t, s.DeleteList(ctx, shop.ID, true))
+				trash, err := s.GetTrash(ctx)
+				require.NoError(t, err)
+				require.Len(t, trash, 2, "i todo della lista finiscono nel cestino")
+				events, err := s.GetHistory(ctx, bread.ID)
+				require.NoError(t, err)
+				assert.Equal(t, EventDeleted, events[len(events)-1].Action)
+
+				for _, td := range []Todo{milk, bread} {
+					restored, err := s.Restore(ctx, td.ID)
+					require.NoError(t, err)
+					assert.Equal(t, td.Title, restored.Title)
+					assert.Nil(t, restored.ListID)
+				}
+				restored, err := s.GetByID(ctx, bread.ID)
+				require.NoError(t, err)
+				assert.Equal(t, milk.ID, *restored.ParentID)
+			})
+
+			t.Run("eliminazione definitiva", func(t *testing.T) {
+				require.NoError(t, s.Delete(ctx, parent.ID, 0))
+				n, err := s.Purge(context.Background(), time.Now().Add(-time.Hour))
+				require.NoError(t, err)
+				assert.Zero(t, n, "il todo non è ancora abbastanza vecchio")
+
+				n, err = s.Purge(context.Background(), time.Now().Add(time.Minute))
+				require.NoError(t, err)
+				assert.Equal(t, 1, n)
+				trash, err := s.GetTrash(ctx)
+				require.NoError(t, err)
+				assert.Empty(t, trash)
+				_, err = s.Restore(ctx, parent.ID)
+				assert.ErrorIs(t, err, ErrNotFound)
+				child, err := s.GetByID(ctx, boxes.ID)
+				require.NoError(t, err)
+				assert.Nil(t, child.ParentID)
+			})
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	for name, newStore := range backends {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t).(Repository)
+			ctx := WithRequestID(WithOwner(context.Background(), DefaultUserID), "req-1")
+
+			todo, err := s.Create(ctx, Todo{Title: "Bollette"})
+			require.NoError(t, err)
+			_, err = s.Update(ctx, Todo{ID: todo.ID, Title: "Bollette", Status: StatusDone, Version: 1})
+			require.NoError(t, err)
+			_, err = s.Update(ctx, Todo{ID: todo.ID, Title: "non registrato", Status: StatusPending, Version: 1})
+			require.ErrorIs(t, err, ErrVersionConflict)
+			_, err = s.AddTag(ctx, todo.ID, "casa")
+			require.NoError(t, err)
+			require.NoError(t, s.Delete(ctx, todo.ID, 0))
+			_, err = s.Restore(ctx, todo.ID)
+			require.NoError(t, err)
+
+			events, err := s.GetHistory(ctx, todo.ID)
+			require.NoError(t, err)
+			var actions []EventAction
+			for _, e := range events {
+				actions = append(actions, e.Action)
+				assert.Equal(t, todo.ID, e.TodoID)
+				assert.Equal(t, DefaultUserID, *e.ActorID)
+				assert.Equal(t, "req-1", e.RequestID)
+			}
+			assert.Equal(t, []EventAction{EventCreated, EventUpdated, EventUpdated, EventDeleted, EventRestored}, actions)
+
+			created, closed, tagged, deleted := events[0], events[1], events[2], events[3]
+			assert.Nil(t, created.Before)
+			assert.Equal(t, "Bollette", mustJSONField(t, created.After, "title"))
+			assert.Empty(t, created.Changed)
+			// chi ha chiuso il todo, e quando
+			assert.Equal(t, []string{"completed", "completed_at", "status"}, closed.Changed)
+			assert.Equal(t, "pending", mustJSONField(t, closed.Before, "status"))
+			assert.Equal(t, "done", mustJSONField(t, closed.After, "status"))
+			assert.WithinDuration(t, time.Now(), closed.CreatedAt, time.Minute)
+			assert.Equal(t, []string{"tags"}, tagged.Changed)
+			assert.Equal(t, []string{"deleted_at"}, deleted.Changed)
+			assert.Less(t, created.ID, closed.ID)
+
+			t.Run("eliminazione definitiva", func(t *testing.T) {
+				require.NoError(t, s.Delete(ctx, todo.ID, 0))
+				_, err := s.Purge(context.Background(), time.Now().Add(time.Minute))
+				require.NoError(t, err)
+
+				events, err := s.GetHistory(ctx, todo.ID)
+				require.NoError(t, err, "la storia resta anche dopo l'eliminazione")
+				last := events[len(events)-1]
+				assert.Equal(t, EventPurged, last.Action)
+				assert.Nil(t, last.ActorID, "lo svuotamento del cestino lo fa il server")
+				assert.Nil(t, last.After)
+			})
+
+			t.Run("todo sconosciuti", func(t *testing.T) {
+				_, err := s.GetHistory(ctx, 999)
+				assert.ErrorIs(t, err, ErrNotFound)
+				other, err := s.CreateUser(context.Background(), "altro", "")
+				require.NoError(t, err)
+				_, err = s.GetHistory(WithOwner(context.Background(), other.ID), todo.ID)
+				assert.ErrorIs(t, err, ErrNotFound)
+			})
+
+			t.Run("registro di tutti gli utenti", func(t *testing.T) {
+				other, err := s.CreateUser(context.Background(), "bruno", "")
+				require.NoError(t, err)
+				_, err = s.Create(WithOwner(context.Background(), other.ID), Todo{Title: "Di bruno"})
+				require.NoError(t, err)
+
+				last, err := s.LastEventID(context.Background())
+				require.NoError(t, err)
+				assert.Equal(t, 8, last) // i 7 del todo, poi quello di bruno
+
+				events, err := s.EventsAfter(context.Background(), 4, 2)
+				require.NoError(t, err)
+				require.Len(t, events, 2)
+				assert.Equal(t, []int{5, 6}, []int{events[0].ID, events[1].ID})
+				assert.Equal(t, []string{"deleted_at"}, events[0].Changed)
+
+				events, err = s.EventsAfter(context.Background(), 7, 10)
+				require.NoError(t, err)
+				require.Len(t, events, 1)
+				assert.Equal(t, other.ID, events[0].OwnerID)
+
+				events, err = s.EventsAfter(context.Background(), last, 10)
+				require.NoError(t, err)
+				assert.Empty(t, events)
+			})
+
+			if sqlStore, ok := s.(*Store); ok {
+				_, err := sqlStore.db.Exec("DELETE FROM todo_events")
+				assert.Error(t, err, "il registro è in sola aggiunta")
+				_, err = sqlStore.db.Exec("UPDATE todo_events SET actor_id = NULL")
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+// mustJSONField legge un campo stringa da un documento JSON.
+func mustJSONField(t *testing.T, doc json.RawMessage, name string) string {
+	t.Helper()
+	var fields map[string]any
+	require.NoError(t, json.Unmarshal(doc, &fields))
+	s, _ := fields[name].(string)
+	return s
+}
+
+func TestWithTx(t *testing.T) {
+	for name, newStore := range backends {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t).(Repository)
+			ctx := WithOwner(context.Background(), DefaultUserID)
+			errStop := errors.New("basta così")
+
+			count := func() int {
+				t.Helper()
+				page, err := s.GetAll(ctx, ListOptions{})
+				require.NoError(t, err)
+				return page.Total
+			}
+
+			t.Run("conferma", func(t *testing.T) {
+				var created Todo
+				err := s.WithTx(ctx, func(tx Repository) error {
+					var err error
+					if created, err = tx.Create(ctx, Todo{Title: "Latte"}); err != nil {
+						return err
+					}
+					_, err = tx.AddTag(ctx, created.ID, "spesa")
+					return err
+				})
+				require.NoError(t, err)
+
+				got, err := s.GetByID(ctx, created.ID)
+				require.NoError(t, err)
+				assert.Equal(t, []string{"spesa"}, got.Tags)
+				events, err := s.GetHistory(ctx, created.ID)
+				require.NoError(t, err)
+				assert.Len(t, events, 2)
+			})
+
+			t.Run("annullamento", func(t *testing.T) {
+				before := count()
+				var created Todo
+				err := s.WithTx(ctx, func(tx Repository) error {
+					var err error
+					if created, err = tx.Create(ctx, Todo{Title: "Pane"}); err != nil {
+						return err
+					}
+					return errStop
+				})
+				assert.ErrorIs(t, err, errStop)
+				assert.Equal(t, before, count())
+				_, err = s.GetHistory(ctx, created.ID)
+				assert.ErrorIs(t, err, ErrNotFound, "neanche l'evento resta")
+			})
+
+			t.Run("operazione fallita dentro la transazione", func(t *testing.T) {
+				before := count()
+				err := s.WithTx(ctx, func(tx Repository) error {
+					_, err := tx.Create(ctx, Todo{Title: "Uova", ListID: ptr(999)})
+					require.ErrorIs(t, err, ErrInvalid)
+					// la transazione resta utilizzabile
+					_, err = tx.Create(ctx, Todo{Title: "Uova"})
+					return err
+				})
+				require.NoError(t, err)
+				assert.Equal(t, before+1, count())
+			})
+
+			t.Run("transazioni annidate", func(t *testing.T) {
+				before := count()
+				err := s.WithTx(ctx, func(tx Repository) error {
+					if _, err := tx.Create(ctx, Todo{Title: "Fuori"}); err != nil {
+						return err
+					}
+					err := tx.WithTx(ctx, func(inner Repository) error {
+						if _, err := inner.Create(ctx, Todo{Title: "Dentro"}); err != nil {
+							return err
+						}
+						return errStop
+					})
+					assert.ErrorIs(t, err, errStop)
+					return nil
+				})
+				require.NoError(t, err)
+				assert.Equal(t, before+1, count(), "resta solo il todo creato fuori")
+			})
+		})
+	}
+}
+
+// La ricerca full-text deve trovare, ordinare ed evidenziare allo stesso
+// modo su ogni backend (anche SQLite senza FTS5).
+func TestSearch(t *testing.T) {
+	for name, newStore := range backends {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t).(Repository)
+			ctx := WithOwner(context.Background(), DefaultUserID)
+			if sqlStore, ok := s.(*Store); ok && !sqlStore.fts {
+				_, err := s.Search(ctx, SearchOptions{Query: "pane"})
+				assert.ErrorIs(t, err, ErrSearchUnavailable, "senza FTS5 niente ricerca")
+				_, err = sqlStore.RebuildSearchIndex(ctx)
+				assert.ErrorIs(t, err, ErrSearchUnavailable)
+				t.Skip("FTS5 non compilato: per provare la ricerca SQLite usare make test (-tags sqlite_fts5)")
+			}
+
+			// 1 e 2 contengono "pane", il 5 è nel cestino
+			for _, todo := range []Todo{
+				{Title: "Comprare il pane", Description: "al forno sotto casa"},
+				{Title: "Latte fresco", Description: "e anche il pane, se c'è"},
+				{Title: "Pagare la bolletta", Description: "luce e gas"},
+				{Title: "Città: prenotare <cena>"},
+				{Title: "Pane vecchio"},
+			} {
+				_, err := s.Create(ctx, todo)
+				require.NoError(t, err)
+			}
+			require.NoError(t, s.Delete(ctx, 5, 0))
+			other, err := s.CreateUser(ctx, "bruno", "")
+			require.NoError(t, err)
+			_, err = s.Create(WithOwner(context.Background(), other.ID), Todo{Title: "Il pane di Bruno"})
+			require.NoError(t, err)
+
+			search := func(query string) []int {
+				t.Helper()
+				page, err := s.Search(ctx, SearchOptions{Query: query})
+				require.NoError(t, err, query)
+				ids := []int{}
+				for _, res := range page.Results {
+					ids = append(ids, res.Todo.ID)
+				}
+				return ids
+			}
+
+			assert.Equal(t, []int{1, 2}, search("PANE"), "prima chi ha la parola nel titolo")
+			assert.Equal(t, []int{1, 2}, search("pan*"))
+			assert.Equal(t, []int{2}, search(`"latte fresco"`))
+			assert.Empty(t, search(`"fresco latte"`))
+			assert.Equal(t, []int{1}, search("pane NOT latte"))
+			assert.ElementsMatch(t, []int{2, 3}, search("bolletta OR latte"))
+			assert.Equal(t, []int{2}, search("(bolletta OR latte) AND pane"))
+			assert.Equal(t, []int{4}, search("citta"), "gli accenti non contano")
+
+			page, err := s.Search(ctx, SearchOptions{Query: "pane", Limit: 1})
+			require.NoError(t, err)
+			assert.Equal(t, 2, page.Total)
+			assert.True(t, page.HasMore)
+			require.Len(t, page.Results, 1)
+			first := page.Results[0]
+			assert.Equal(t, Highlights{Title: "Comprare il <mark>pane</mark>"}, first.Highlights)
+			page, err = s.Search(ctx, SearchOptions{Query: "pane", Limit: 1, Offset: 1})
+			require.NoError(t, err)
+			assert.False(t, page.HasMore)
+			require.Len(t, page.Results, 1)
+			second := page.Results[0]
+			assert.Equal(t, "Latte fresco", second.Highlights.Title)
+			assert.Equal(t, "e anche il <mark>pane</mark>, se c&#39;è", second.Highlights.Description)
+			assert.Greater(t, first.Score, second.Score)
+
+			page, err = s.Search(ctx, SearchOptions{Query: "città"})
+			require.NoError(t, err)
+			require.Len(t, page.Results, 1)
+			assert.Equal(t, "<mark>Città</mark>: prenotare &lt;cena&gt;", page.Results[0].Highlights.Title)
+
+			// l'indice segue le modifiche e il cestino
+			_, err = s.Update(ctx, Todo{ID: 3, Title: "Pagare il gas", Status: StatusPending})
+			require.NoError(t, err)
+			assert.Empty(t, search("bolletta"))
+			assert.Equal(t, []int{3}, search("gas"))
+			require.NoError(t, s.Delete(ctx, 1, 0))
+			assert.Equal(t, []int{2}, search("pane"))
+			_, err = s.Restore(ctx, 5)
+			require.NoError(t, err)
+			assert.Equal(t, []int{5, 2}, search("pane"))
+
+			for _, query := range []string{"", "  !! ", `"pane`, "pane AND", "OR pane", "(pane", "pane)"} {
+				_, err := s.Search(ctx, SearchOptions{Query: query})
+				assert.ErrorIs(t, err, ErrInvalid, "%q", query)
+			}
+
+			if sqlStore, ok := s.(*Store); ok {
+				n, err := sqlStore.RebuildSearchIndex(ctx)
+				require.NoError(t, err)
+				assert.Equal(t, 6, n)
+				assert.Equal(t, []int{5, 2}, search("pane"))
+			}
+		})
+	}
+}
+
+func TestSearchQuery(t *testing.T) {
+	q, err := parseSearchQuery(`(pane OR latte*) "e-mail" NOT x`)
+	require.NoError(t, err)
+	assert.Equal(t, `(("pane" OR "latte" *) AND ("e mail" NOT "x"))`, q.fts(), "NOT lega più di AND, che lega più di OR")
+
+	q, err = parseSearchQuery(`"O'Brien" and`)
+	require.NoError(t, err)
+	assert.Equal(t, `("o brien" AND "and")`, q.fts(), "l'input non può rompere la sintassi di FTS5")
 
+	// l'estratto della descrizione parte poco prima della prima parola trovata
+	q, err = parseSearchQuery("pane")
+	require.NoError(t, err)
+	h := q.highlight(Todo{
+		Title:       "Spesa",
+		Description: "uno due tre quattro cinque sei sette otto nove pane undici dodici tredici quattordici quindici sedici diciassette diciotto diciannove venti ventuno ventidue ventitré",
 	})
+	assert.Equal(t, "Spesa", h.Title)
+	assert.Equal(t, "…otto nove <mark>pane</mark> undici dodici tredici quattordici quindici sedici diciassette diciotto diciannove venti ventuno ventidue ventitré", h.Description)
+	h = q.highlight(Todo{Title: "Spesa", Description: "uno due tre " + strings.Repeat("pane ", 20)})
+	assert.True(t, strings.HasSuffix(h.Description, "</mark>…"), h.Description)
 }
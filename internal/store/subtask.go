@@ -0,0 +1,251 @@
+package store
+
+import (
+	"context"
+	"fmt"
+)
+
+// TodoNode è un todo con le sue sottoattività, per la vista ad albero.
+type TodoNode struct {
+	Todo
+	Children []TodoNode `json:"children"`
+}
+
+// SubtaskRepository legge la gerarchia dei todo dell'utente nel contesto.
+// Le sottoattività si creano e si spostano con Create e Update, impostando
+// ParentID, e si elencano con GetAll e ListOptions.ParentID.
+type SubtaskRepository interface {
+	// GetTree restituisce l'albero che parte dal todo rootID (un solo
+	// nodo) oppure, con rootID 0, tutti i todo di primo livello con le
+	// loro sottoattività. A ogni livello i todo sono ordinati per ID.
+	GetTree(ctx context.Context, rootID int) ([]TodoNode, error)
+}
+
+// progress calcola la percentuale (arrotondata per difetto) di
+// sottoattività fatte. Quelle archiviate non contano: un todo con solo
+// sottoattività archiviate è come se non ne avesse.
+func progress(done, total int) *int {
+	if total == 0 {
+		return nil
+	}
+	return ptr(done * 100 / total)
+}
+
+// missingParent è l'errore di un todo assegnato a un genitore inesistente,
+// come missingList per le liste.
+func missingParent(ID int) error {
+	return invalidf("il todo genitore %d non esiste", ID)
+}
+
+// parentCycle è l'errore di un todo spostato sotto se stesso o sotto una
+// sua sottoattività.
+func parentCycle(ID, parentID int) error {
+	return invalidf("il todo %d non può diventare sottoattività del todo %d: si formerebbe un ciclo", ID, parentID)
+}
+
+// buildTree costruisce l'albero a partire da tutti i todo dell'utente,
+// già ordinati per ID.
+func buildTree(todos []Todo, rootID int) ([]TodoNode, error) {
+	children := make(map[int][]Todo)
+	exists := make(map[int]bool, len(todos))
+	for _, t := range todos {
+		exists[t.ID] = true
+	}
+	var roots []Todo
+	for _, t := range todos {
+		switch {
+		case rootID != 0 && t.ID == rootID:
+			roots = append(roots, t)
+		case t.ParentID != nil && exists[*t.ParentID]:
+			children[*t.ParentID] = append(children[*t.ParentID], t)
+		case rootID == 0:
+			roots = append(roots, t)
+		}
+	}
+	if rootID != 0 && len(roots) == 0 {
+		return nil, notFound(rootID)
+	}
+
+	var build func(t Todo) TodoNode
+	build = func(t Todo) TodoNode {
+		node := TodoNode{Todo: t, Children: []TodoNode{}}
+		for _, c := range children[t.ID] {
+			node.Children = append(node.Children, build(c))
+		}
+		return node
+	}
+	nodes := make([]TodoNode, 0, len(roots))
+	for _, t := range roots {
+		nodes = append(nodes, build(t))
+	}
+	return nodes, nil
+}
+
+// --- implementazione SQL ---
+
+// subtasksColumns sono le colonne di todoColumns con il numero di
+// sottoattività (archiviate e nel cestino escluse) e quante di queste sono
+// fatte.
+const subtasksColumns = "(SELECT COUNT(*) FROM todos c WHERE c.parent_id = todos.id AND c.deleted_at IS NULL AND c.status <> 'archived'), " +
+	"(SELECT COUNT(*) FROM todos c WHERE c.parent_id = todos.id AND c.deleted_at IS NULL AND c.status = 'done')"
+
+// checkParent verifica che il todo ID (0 se è nuovo) possa diventare
+// sottoattività di parentID (nil = nessun genitore): il genitore deve
+// esistere e non deve essere il todo stesso né una sua sottoattività.
+func checkParent(ctx context.Context, q querier, owner, ID int, parentID *int) error {
+	if parentID == nil {
+		return nil
+	}
+	if *parentID == ID {
+		return parentCycle(ID, *parentID)
+	}
+	// risaliamo dal nuovo genitore fino alla radice: se lungo la strada
+	// c'è il todo, si formerebbe un ciclo. UNION (e non UNION ALL) ferma
+	// la ricorsione anche su dati già rovinati.
+	query := `WITH RECURSIVE ancestors (id, parent_id) AS (
+		SELECT id, parent_id FROM todos WHERE id = ? AND owner_id = ? AND deleted_at IS NULL
+		UNION
+		SELECT todos.id, todos.parent_id FROM todos JOIN ancestors ON todos.id = ancestors.parent_id
+	) SELECT COUNT(*), COALESCE(SUM(id = ?), 0) FROM ancestors`
+	var found, cycle int
+	if err := q.QueryRowContext(ctx, query, *parentID, owner, ID).Scan(&found, &cycle); err != nil {
+		return dbError(fmt.Sprintf("todo genitore %d", *parentID), err)
+	}
+	if found == 0 {
+		return missingParent(*parentID)
+	}
+	if cycle > 0 {
+		return parentCycle(ID, *parentID)
+	}
+	return nil
+}
+
+func (s *Store) GetTree(ctx context.Context, rootID int) ([]TodoNode, error) {
+	page, err := s.GetAll(ctx, ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return buildTree(page.Todos, rootID)
+}
+
+// --- implementazione in memoria ---
+
+func (s *MemoryStore) GetTree(ctx context.Context, rootID int) ([]TodoNode, error) {
+	page, err := s.GetAll(ctx, ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return buildTree(page.Todos, rootID)
+}
+
+// checkParent fa gli stessi controlli della versione SQL. PRESUPPONE il
+// lock già acquisito.
+func (s *MemoryStore) checkParent(owner, ID int, parentID *int) error {
+	if parentID == nil {
+		return nil
+	}
+	if *parentID == ID {
+		return parentCycle(ID, *parentID)
+	}
+	if _, err := s.get(owner, *parentID); err != nil {
+		return missingParent(*parentID)
+	}
+	// al massimo len(s.todos) passi, anche se i dati contenessero un ciclo
+	next := parentID
+	for range len(s.todos) {
+		if next == nil {
+			break
+		}
+		if *next == ID {
+			return parentCycle(ID, *parentID)
+		}
+		next = s.todos[*next].ParentID
+	}
+	return nil
+}
+
+// withProgress calcola Progress per i todo indicati con una sola passata
+// su tutti i todo. PRESUPPONE il lock già acquisito.
+func (s *MemoryStore) withProgress(todos []Todo) {
+	type counts struct{ done, total int }
+	byParent := make(map[int]counts)
+	for _, t := range s.todos {
+		if t.ParentID == nil || t.Status == StatusArchived || t.DeletedAt != nil {
+			continue
+		}
+		c := byParent[*t.ParentID]
+		c.total++
+		if t.Status == StatusDone {
+			c.done++
+		}
+		byParent[*t.ParentID] = c
+	}
+	for i := range todos {
+		c := byParent[todos[i].ID]
+		todos[i].Progress = progress(c.done, c.total)
+	}
+}
+
+// touchParents aumenta la versione dei todo indicati (nil e doppioni sono
+// ignorati), come i trigger di 0010_subtasks. I valori precedenti finiscono
+// in prev, per restore. PRESUPPONE il lock già acquisito.
+func (s *MemoryStore) touchParents(prev map[int]Todo, IDs ...*int) {
+	seen := make(map[int]bool)
+	for _, ID := range IDs {
+		if ID == nil || seen[*ID] {
+			continue
+		}
+		seen[*ID] = true
+		t, ok := s.todos[*ID]
+		if !ok {
+			continue
+		}
+		if _, saved := prev[t.ID]; !saved {
+			prev[t.ID] = t
+		}
+		t.Version++
+		s.todos[t.ID] = t
+	}
+}
+
+// unlink toglie i riferimenti al todo ID appena cancellato: le sue
+// sottoattività passano al primo livello, come con il trigger
+// todos_parent_delete, e l'occorrenza precedente perde NextOccurrenceID,
+// come con todos_next_delete. PRESUPPONE il lock già acquisito.
+func (s *MemoryStore) unlink(prev map[int]Todo, ID int) {
+	for _, t := range s.todos {
+		child := t.ParentID != nil && *t.ParentID == ID
+		previous := t.NextOccurrenceID != nil && *t.NextOccurrenceID == ID
+		if !child && !previous {
+			continue
+		}
+		if _, saved := prev[t.ID]; !saved {
+			prev[t.ID] = t
+		}
+		if child {
+			t.ParentID = nil
+			t.Version++
+		}
+		if previous {
+			t.NextOccurrenceID = nil
+			t.Version++
+		}
+		s.todos[t.ID] = t
+	}
+}
+
+// restore rimette i todo salvati da touchParents e unlink, se il
+// salvataggio fallisce. PRESUPPONE il lock già acquisito.
+func (s *MemoryStore) restore(prev map[int]Todo) {
+	for ID, t := range prev {
+		s.todos[ID] = t
+	}
+}
+
+// sameID confronta due riferimenti facoltativi (ParentID, ListID).
+func sameID(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
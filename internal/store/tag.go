@@ -0,0 +1,294 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxTagsPerTodo limita i tag di un singolo todo.
+const maxTagsPerTodo = 20
+
+// Tag è un'etichetta con il numero di todo dell'utente che la usano.
+// Un tag esiste finché almeno un todo lo usa.
+type Tag struct {
+	Name      string `json:"name"`
+	TodoCount int    `json:"todo_count"`
+}
+
+// TagRepository gestisce i tag dei todo dell'utente nel contesto. I nomi
+// passati ai metodi vengono normalizzati con NormalizeTag.
+type TagRepository interface {
+	// GetTags restituisce i tag usati dall'utente, in ordine alfabetico.
+	GetTags(ctx context.Context) ([]Tag, error)
+	// AddTag aggiunge il tag al todo e restituisce il todo aggiornato; se
+	// il todo ha già quel tag non cambia nulla.
+	AddTag(ctx context.Context, todoID int, tag string) (Todo, error)
+	// RemoveTag toglie il tag dal todo; se il todo non lo ha non è un errore.
+	RemoveTag(ctx context.Context, todoID int, tag string) (Todo, error)
+}
+
+// NormalizeTag porta il nome di un tag nella forma in cui viene salvato
+// (senza spazi ai lati e in minuscolo) e lo valida. Le virgole non sono
+// ammesse perché separano i tag nei filtri (?tag=casa,lavoro), gli spazi
+// perché nell'export Markdown un tag è una parola che inizia con #.
+func NormalizeTag(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return "", invalidf("il nome del tag non può essere vuoto")
+	}
+	if utf8.RuneCountInString(name) > 50 {
+		return "", invalidf("il nome del tag può avere al massimo 50 caratteri")
+	}
+	if strings.ContainsFunc(name, func(r rune) bool { return r == ',' || r == ' ' || unicode.IsControl(r) }) {
+		return "", invalidf("il nome del tag non può contenere virgole, spazi né caratteri di controllo")
+	}
+	return name, nil
+}
+
+// withTag restituisce una copia dei tag con name aggiunto, in ordine. Le
+// slice dei todo non vanno mai modificate sul posto: sono condivise con
+// le copie già restituite ai chiamanti.
+func withTag(tags []string, name string) []string {
+	tags = append(slices.Clone(tags), name)
+	slices.Sort(tags)
+	return tags
+}
+
+// withoutTag restituisce una copia dei tag senza name.
+func withoutTag(tags []string, name string) []string {
+	return slices.DeleteFunc(slices.Clone(tags), func(t string) bool { return t == name })
+}
+
+// --- implementazione SQL ---
+
+// tagsColumn è la colonna di todoColumns con i tag del todo separati da
+// virgole (che nei nomi non possono comparire).
+const tagsColumn = "(SELECT group_concat(tags.name, ',') FROM todo_tags JOIN tags ON tags.id = todo_tags.tag_id WHERE todo_tags.todo_id = todos.id)"
+
+// splitTags converte il risultato di tagsColumn in una slice ordinata.
+func splitTags(s *string) []string {
+	if s == nil || *s == "" {
+		return []string{}
+	}
+	tags := strings.Split(*s, ",")
+	slices.Sort(tags)
+	return tags
+}
+
+func (s *Store) GetTags(ctx context.Context) ([]Tag, error) {
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := `SELECT tags.name, COUNT(*) FROM tags JOIN todo_tags ON todo_tags.tag_id = tags.id
+		JOIN todos ON todos.id = todo_tags.todo_id AND todos.deleted_at IS NULL
+		WHERE tags.owner_id = ? GROUP BY tags.id ORDER BY tags.name`
+	rows, err := s.conn().QueryContext(ctx, query, owner)
+	if err != nil {
+		return nil, dbError("errore nella lettura dei tag", err)
+	}
+	defer rows.Close()
+
+	tags := []Tag{}
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.Name, &t.TodoCount); err != nil {
+			return nil, dbError("errore nello scan di un tag", err)
+		}
+		tags = append(tags, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError("errore durante l'iterazione dei tag", err)
+	}
+	return tags, nil
+}
+
+// AddTag lavora in una transazione: il tag, il collegamento e la nuova
+// versione del todo vengono salvati insieme.
+func (s *Store) AddTag(ctx context.Context, todoID int, tag string) (Todo, error) {
+	tag, err := NormalizeTag(tag)
+	if err != nil {
+		return Todo{}, err
+	}
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return Todo{}, err
+	}
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return Todo{}, dbError("errore nell'apertura della transazione", err)
+	}
+	defer tx.Rollback()
+
+	todo, err := getTodo(ctx, tx, owner, todoID)
+	if err != nil {
+		return Todo{}, err
+	}
+	if slices.Contains(todo.Tags, tag) {
+		return todo, nil
+	}
+	if len(todo.Tags) >= maxTagsPerTodo {
+		return Todo{}, invalidf("un todo può avere al massimo %d tag", maxTagsPerTodo)
+	}
+
+	// il DO UPDATE (che non cambia nulla) serve a far funzionare RETURNING
+	// anche quando il tag esiste già
+	var tagID int
+	err = tx.QueryRowContext(ctx, `INSERT INTO tags (owner_id, name) VALUES (?, ?)
+		ON CONFLICT (owner_id, name) DO UPDATE SET name = excluded.name RETURNING id`, owner, tag).Scan(&tagID)
+	if err != nil {
+		return Todo{}, dbError(fmt.Sprintf("errore nel salvataggio del tag %q", tag), err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO todo_tags (todo_id, tag_id) VALUES (?, ?)", todoID, tagID); err != nil {
+		return Todo{}, dbError(fmt.Sprintf("errore nell'aggiunta del tag %q", tag), err)
+	}
+	return bumpVersion(ctx, tx, todo, withTag(todo.Tags, tag))
+}
+
+func (s *Store) RemoveTag(ctx context.Context, todoID int, tag string) (Todo, error) {
+	tag, err := NormalizeTag(tag)
+	if err != nil {
+		return Todo{}, err
+	}
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return Todo{}, err
+	}
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return Todo{}, dbError("errore nell'apertura della transazione", err)
+	}
+	defer tx.Rollback()
+
+	todo, err := getTodo(ctx, tx, owner, todoID)
+	if err != nil {
+		return Todo{}, err
+	}
+	if !slices.Contains(todo.Tags, tag) {
+		return todo, nil
+	}
+
+	// se era l'ultimo todo con questo tag, il trigger todo_tags_prune
+	// cancella anche il tag
+	query := "DELETE FROM todo_tags WHERE todo_id = ? AND tag_id = (SELECT id FROM tags WHERE owner_id = ? AND name = ?)"
+	if _, err := tx.ExecContext(ctx, query, todoID, owner, tag); err != nil {
+		return Todo{}, dbError(fmt.Sprintf("errore nella rimozione del tag %q", tag), err)
+	}
+	return bumpVersion(ctx, tx, todo, withoutTag(todo.Tags, tag))
+}
+
+// bumpVersion incrementa la versione del todo dopo un cambio dei tag,
+// registra la modifica e conclude la transazione: anche l'ETag deve cambiare.
+func bumpVersion(ctx context.Context, tx txn, todo Todo, tags []string) (Todo, error) {
+	if _, err := tx.ExecContext(ctx, "UPDATE todos SET version = version + 1 WHERE id = ? AND owner_id = ?", todo.ID, todo.OwnerID); err != nil {
+		return Todo{}, dbError("errore nell'aggiornamento della versione", err)
+	}
+	updated := todo
+	updated.Tags = tags
+	updated.Version++
+	if err := recordEvent(ctx, tx, EventUpdated, &todo, &updated); err != nil {
+		return Todo{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Todo{}, dbError("errore nel commit dei tag", err)
+	}
+	return updated, nil
+}
+
+// --- implementazione in memoria ---
+
+func (s *MemoryStore) GetTags(ctx context.Context) ([]Tag, error) {
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, t := range s.todos {
+		if t.OwnerID != owner || t.DeletedAt != nil {
+			continue
+		}
+		for _, name := range t.Tags {
+			counts[name]++
+		}
+	}
+	tags := make([]Tag, 0, len(counts))
+	for name, n := range counts {
+		tags = append(tags, Tag{Name: name, TodoCount: n})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	return tags, nil
+}
+
+func (s *MemoryStore) AddTag(ctx context.Context, todoID int, tag string) (Todo, error) {
+	tag, err := NormalizeTag(tag)
+	if err != nil {
+		return Todo{}, err
+	}
+	return s.updateTags(ctx, todoID, func(tags []string) ([]string, error) {
+		if slices.Contains(tags, tag) {
+			return tags, nil
+		}
+		if len(tags) >= maxTagsPerTodo {
+			return nil, invalidf("un todo può avere al massimo %d tag", maxTagsPerTodo)
+		}
+		return withTag(tags, tag), nil
+	})
+}
+
+func (s *MemoryStore) RemoveTag(ctx context.Context, todoID int, tag string) (Todo, error) {
+	tag, err := NormalizeTag(tag)
+	if err != nil {
+		return Todo{}, err
+	}
+	return s.updateTags(ctx, todoID, func(tags []string) ([]string, error) {
+		return withoutTag(tags, tag), nil
+	})
+}
+
+// updateTags applica change ai tag del todo; se cambiano davvero aumenta
+// la versione e salva, come fa lo store SQL.
+func (s *MemoryStore) updateTags(ctx context.Context, todoID int, change func([]string) ([]string, error)) (Todo, error) {
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return Todo{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, err := s.get(owner, todoID)
+	if err != nil {
+		return Todo{}, err
+	}
+	tags, err := change(old.Tags)
+	if err != nil {
+		return Todo{}, err
+	}
+	if slices.Equal(tags, old.Tags) {
+		return old, nil
+	}
+
+	todo := old
+	todo.Tags = tags
+	todo.Version++
+	s.todos[todoID] = todo
+	events := len(s.events)
+	s.record(ctx, EventUpdated, &old, &todo)
+	if err := s.save(); err != nil {
+		s.todos[todoID] = old
+		s.rollbackEvents(events)
+		return Todo{}, err
+	}
+	return todo, nil
+}
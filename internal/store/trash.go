@@ -0,0 +1,213 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// TrashRepository gestisce il cestino: Delete non cancella i todo ma li
+// sposta qui, da dove si possono ripristinare finché Purge non li elimina
+// definitivamente. I todo nel cestino sono esclusi da tutte le altre
+// letture (GetAll, GetByID, i conteggi di liste e tag, l'avanzamento...).
+type TrashRepository interface {
+	// GetTrash restituisce i todo dell'utente nel cestino, dal più
+	// recente, con DeletedAt impostato.
+	GetTrash(ctx context.Context) ([]Todo, error)
+	// Restore riporta fuori dal cestino il todo ID; se il todo non è nel
+	// cestino restituisce ErrNotFound. Le sottoattività non sono toccate
+	// da Delete, quindi non c'è niente da ripristinare insieme al todo.
+	Restore(ctx context.Context, ID int) (Todo, error)
+	// Purge elimina definitivamente i todo di tutti gli utenti finiti nel
+	// cestino prima di before e restituisce quanti sono. Non guarda
+	// l'utente nel contesto: lo usa il job di pulizia in background.
+	Purge(ctx context.Context, before time.Time) (int, error)
+}
+
+// --- implementazione SQL ---
+
+func (s *Store) GetTrash(ctx context.Context) ([]Todo, error) {
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := "SELECT " + todoColumns + " FROM todos WHERE owner_id = ? AND deleted_at IS NOT NULL ORDER BY deleted_at DESC, id DESC"
+	return queryTodos(ctx, s.conn(), query, owner)
+}
+
+// Restore legge, aggiorna e rilegge nella stessa transazione; il trigger
+// todos_parent_trash aggiorna la versione del genitore.
+func (s *Store) Restore(ctx context.Context, ID int) (Todo, error) {
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return Todo{}, err
+	}
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return Todo{}, dbError("errore nell'apertura della transazione", err)
+	}
+	defer tx.Rollback()
+
+	query := "SELECT " + todoColumns + " FROM todos WHERE id = ? AND owner_id = ? AND deleted_at IS NOT NULL"
+	trashed, err := scanTodo(tx.QueryRowContext(ctx, query, ID, owner))
+	if err != nil {
+		return Todo{}, dbError(fmt.Sprintf("todo %d", ID), err)
+	}
+	query = "UPDATE todos SET deleted_at = NULL, version = version + 1 WHERE id = ? AND owner_id = ?"
+	if _, err := tx.ExecContext(ctx, query, ID, owner); err != nil {
+		return Todo{}, dbError("errore nel ripristino del todo", err)
+	}
+	todo, err := getTodo(ctx, tx, owner, ID)
+	if err != nil {
+		return Todo{}, err
+	}
+	if err := recordEvent(ctx, tx, EventRestored, &trashed, &todo); err != nil {
+		return Todo{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Todo{}, dbError("errore nel commit del ripristino", err)
+	}
+	return todo, nil
+}
+
+// Purge registra l'eliminazione di ogni todo e lascia ai trigger la
+// pulizia di tag, sottoattività e ricorrenze che puntavano ai todo eliminati.
+func (s *Store) Purge(ctx context.Context, before time.Time) (int, error) {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return 0, dbError("errore nell'apertura della transazione", err)
+	}
+	defer tx.Rollback()
+
+	query := "SELECT " + todoColumns + " FROM todos WHERE deleted_at IS NOT NULL AND deleted_at < ?"
+	expired, err := queryTodos(ctx, tx, query, formatDBTime(&before))
+	if err != nil {
+		return 0, err
+	}
+	if err := purgeTodos(ctx, tx, expired); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, dbError("errore nel commit dello svuotamento del cestino", err)
+	}
+	return len(expired), nil
+}
+
+// purgeTodos elimina definitivamente i todo, registrandolo per ciascuno.
+func purgeTodos(ctx context.Context, q querier, todos []Todo) error {
+	for _, t := range todos {
+		if err := recordEvent(ctx, q, EventPurged, &t, nil); err != nil {
+			return err
+		}
+		if _, err := q.ExecContext(ctx, "DELETE FROM todos WHERE id = ?", t.ID); err != nil {
+			return dbError(fmt.Sprintf("errore nell'eliminazione del todo %d", t.ID), err)
+		}
+	}
+	return nil
+}
+
+// --- implementazione in memoria ---
+
+func (s *MemoryStore) GetTrash(ctx context.Context) ([]Todo, error) {
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	todos := []Todo{}
+	for _, t := range s.todos {
+		if t.OwnerID == owner && t.DeletedAt != nil {
+			todos = append(todos, t)
+		}
+	}
+	s.withProgress(todos)
+	sort.Slice(todos, func(i, j int) bool {
+		a, b := todos[i], todos[j]
+		if !a.DeletedAt.Equal(*b.DeletedAt) {
+			return a.DeletedAt.After(*b.DeletedAt)
+		}
+		return a.ID > b.ID
+	})
+	return todos, nil
+}
+
+func (s *MemoryStore) Restore(ctx context.Context, ID int) (Todo, error) {
+	owner, err := ownerID(ctx)
+	if err != nil {
+		return Todo{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.todos[ID]
+	if !ok || old.OwnerID != owner || old.DeletedAt == nil {
+		return Todo{}, notFound(ID)
+	}
+	restored := old
+	restored.DeletedAt = nil
+	restored.Version++
+	s.todos[ID] = restored
+	prev := make(map[int]Todo)
+	s.touchParents(prev, restored.ParentID)
+	events := len(s.events)
+	s.record(ctx, EventRestored, &old, &restored)
+	if err := s.save(); err != nil {
+		s.todos[ID] = old
+		s.restore(prev)
+		s.rollbackEvents(events)
+		return Todo{}, err
+	}
+	return s.get(owner, ID)
+}
+
+func (s *MemoryStore) Purge(ctx context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired []Todo
+	for _, t := range s.todos {
+		if t.DeletedAt != nil && t.DeletedAt.Before(before) {
+			expired = append(expired, t)
+		}
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+	prev := make(map[int]Todo)
+	events := len(s.events)
+	s.purge(ctx, prev, expired)
+
+	if err := s.save(); err != nil {
+		for _, t := range expired {
+			s.todos[t.ID] = t
+		}
+		s.restore(prev)
+		s.rollbackEvents(events)
+		return 0, err
+	}
+	return len(expired), nil
+}
+
+// purge elimina definitivamente i todo registrandolo, come purgeTodos; i
+// todo modificati di riflesso finiscono in prev, per restore. PRESUPPONE
+// il lock già acquisito.
+func (s *MemoryStore) purge(ctx context.Context, prev map[int]Todo, todos []Todo) {
+	// in ordine di ID, così anche il registro ha un ordine prevedibile
+	sort.Slice(todos, func(i, j int) bool { return todos[i].ID < todos[j].ID })
+	for _, t := range todos {
+		s.record(ctx, EventPurged, &t, nil)
+		delete(s.todos, t.ID)
+	}
+	for _, t := range todos {
+		s.touchParents(prev, t.ParentID)
+		s.unlink(prev, t.ID)
+	}
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"maps"
+	"slices"
+)
+
+// Transactor raggruppa più operazioni in un'unica transazione.
+type Transactor interface {
+	// WithTx esegue fn passandole un Repository legato alla transazione:
+	// se fn restituisce un errore nessuna delle modifiche fatte tramite tx
+	// resta, altrimenti restano tutte insieme. Ogni metodo di tx resta a
+	// sua volta atomico: se fallisce non lascia modifiche a metà, e fn può
+	// decidere di ignorare l'errore e andare avanti.
+	//
+	// Dentro fn va usato solo tx, e solo finché fn non ritorna: lo store di
+	// partenza può restare bloccato fino alla fine della transazione.
+	WithTx(ctx context.Context, fn func(tx Repository) error) error
+}
+
+// --- implementazione SQL ---
+
+// txn è una transazione usata da un singolo metodo dello Store: una vera
+// transazione di database/sql oppure, dentro WithTx, un savepoint di
+// quella esterna.
+type txn interface {
+	querier
+	Commit() error
+	Rollback() error
+}
+
+// conn è dove vanno le query fuori da una transazione del metodo.
+func (s *Store) conn() querier {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+// begin apre la transazione di un metodo. Dentro WithTx apre un savepoint,
+// così un'operazione fallita si annulla da sola senza far fallire quelle
+// fatte prima.
+//
+// Ogni metodo che modifica i dati passa di qui e di solito legge prima di
+// scrivere (versione, proprietario, stato precedente): per questo OpenDB
+// apre il database con _txlock=immediate e la transazione ha il lock in
+// scrittura fin dall'inizio. Chi trova un'altra scrittura in corso aspetta
+// il suo turno (_busy_timeout) invece di fallire a metà.
+func (s *Store) begin(ctx context.Context) (txn, error) {
+	if s.tx == nil {
+		return s.db.BeginTx(ctx, nil)
+	}
+	if _, err := s.tx.ExecContext(ctx, "SAVEPOINT store_op"); err != nil {
+		return nil, err
+	}
+	return &savepoint{Tx: s.tx, ctx: ctx}, nil
+}
+
+// savepoint è un txn annidato. SQLite ammette savepoint con lo stesso nome
+// uno dentro l'altro: RELEASE e ROLLBACK TO agiscono sul più recente.
+type savepoint struct {
+	*sql.Tx
+	ctx  context.Context
+	done bool
+}
+
+func (sp *savepoint) Commit() error {
+	if sp.done {
+		return sql.ErrTxDone
+	}
+	sp.done = true
+	_, err := sp.ExecContext(sp.ctx, "RELEASE store_op")
+	return err
+}
+
+// Rollback, come quello di sql.Tx, non fa nulla dopo Commit.
+func (sp *savepoint) Rollback() error {
+	if sp.done {
+		return sql.ErrTxDone
+	}
+	sp.done = true
+	if _, err := sp.ExecContext(sp.ctx, "ROLLBACK TO store_op"); err != nil {
+		return err
+	}
+	_, err := sp.ExecContext(sp.ctx, "RELEASE store_op")
+	return err
+}
+
+// WithTx parte in scrittura come ogni altra transazione (vedi begin): due
+// batch in parallelo si eseguono uno dopo l'altro invece di far fallire
+// uno dei due con "database is locked".
+func (s *Store) WithTx(ctx context.Context, fn func(tx Repository) error) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return dbError("errore nell'apertura della transazione", err)
+	}
+	defer tx.Rollback()
+
+	// dentro un'altra WithTx si continua sulla stessa transazione
+	inner := &Store{db: s.db, tx: s.tx, fts: s.fts}
+	if inner.tx == nil {
+		inner.tx = tx.(*sql.Tx)
+	}
+	if err := fn(inner); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return dbError("errore nel commit della transazione", err)
+	}
+	return nil
+}
+
+// --- implementazione in memoria ---
+
+// WithTx lavora su una copia dei dati, che sostituisce quelli veri solo se
+// fn riesce; per tutta la durata tiene il lock, come farebbe SQLite con
+// una transazione in scrittura.
+func (s *MemoryStore) WithTx(ctx context.Context, fn func(tx Repository) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &MemoryStore{memoryData: s.memoryData.clone()}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	prev := s.memoryData
+	s.memoryData = tx.memoryData
+	if err := s.save(); err != nil {
+		s.memoryData = prev
+		return err
+	}
+	return nil
+}
+
+// clone copia mappe e registro. I valori si possono condividere: i metodi
+// sostituiscono sempre un todo intero invece di modificarlo sul posto.
+func (d memoryData) clone() memoryData {
+	d.todos = maps.Clone(d.todos)
+	d.users = maps.Clone(d.users)
+	d.apiKeys = maps.Clone(d.apiKeys)
+	d.lists = maps.Clone(d.lists)
+	d.events = slices.Clone(d.events)
+	return d
+}
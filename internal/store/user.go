@@ -0,0 +1,174 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultUserID è l'utente creato dalla migrazione 0004_users: possiede i
+// todo che esistevano prima degli account.
+const (
+	DefaultUserID   = 1
+	DefaultUsername = "default"
+)
+
+// User è un account. Ogni todo appartiene a un solo utente e nessun
+// utente può vedere o modificare i todo degli altri.
+type User struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+	// PasswordHash è l'hash calcolato da auth.HashPassword; vuoto se
+	// l'utente non ha una password. Non esce mai dall'API.
+	PasswordHash string `json:"-"`
+}
+
+// UserRepository gestisce gli account. Come per i todo, un utente
+// inesistente dà ErrNotFound e uno username già usato ErrConflict.
+type UserRepository interface {
+	// CreateUser crea un utente; lo store salva passwordHash così com'è,
+	// senza sapere come è stato calcolato.
+	CreateUser(ctx context.Context, username, passwordHash string) (User, error)
+	// SetPassword sostituisce l'hash della password dell'utente ID.
+	SetPassword(ctx context.Context, ID int, passwordHash string) error
+	GetUser(ctx context.Context, ID int) (User, error)
+	// GetUserByUsername non distingue maiuscole e minuscole.
+	GetUserByUsername(ctx context.Context, username string) (User, error)
+}
+
+// Repository riunisce tutto ciò che offre un backend: è quello che
+// restituisce Open.
+type Repository interface {
+	TodoRepository
+	UserRepository
+	APIKeyRepository
+	ListRepository
+	TagRepository
+	SubtaskRepository
+	TrashRepository
+	EventRepository
+	EventFeed
+	SearchRepository
+	Transactor
+}
+
+var (
+	_ Repository = (*Store)(nil)
+	_ Repository = (*MemoryStore)(nil)
+	_ Repository = (*JSONStore)(nil)
+)
+
+// validateUsername ammette lettere, cifre, '.', '_' e '-', fino a 64 caratteri.
+func validateUsername(username string) error {
+	if username == "" {
+		return invalidf("lo username non può essere vuoto")
+	}
+	if len(username) > 64 {
+		return invalidf("lo username può avere al massimo 64 caratteri")
+	}
+	for _, r := range username {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("._-", r)) {
+			return invalidf("lo username può contenere solo lettere, cifre, '.', '_' e '-'")
+		}
+	}
+	return nil
+}
+
+// --- proprietario nel contesto ---
+
+type ownerKey struct{}
+
+// WithOwner restituisce un contesto in cui le operazioni sui todo sono
+// fatte per conto dell'utente userID. Lo imposta il middleware di
+// autenticazione; senza, ogni metodo di TodoRepository fallisce con
+// ErrNoOwner.
+func WithOwner(ctx context.Context, userID int) context.Context {
+	return context.WithValue(ctx, ownerKey{}, userID)
+}
+
+// OwnerFromContext restituisce l'utente impostato con WithOwner.
+func OwnerFromContext(ctx context.Context) (int, bool) {
+	id, ok := ctx.Value(ownerKey{}).(int)
+	return id, ok && id > 0
+}
+
+// ErrNoOwner indica un errore di programmazione: un'operazione sui todo
+// senza un utente nel contesto. Non rientra in nessuna categoria, così
+// diventa un 500 invece di mostrare i dati di qualcuno per sbaglio.
+var ErrNoOwner = errors.New("nessun utente nel contesto")
+
+func ownerID(ctx context.Context) (int, error) {
+	id, ok := OwnerFromContext(ctx)
+	if !ok {
+		return 0, ErrNoOwner
+	}
+	return id, nil
+}
+
+// --- implementazione SQL ---
+
+const userColumns = "id, username, created_at, password_hash"
+
+func scanUser(row scanner) (User, error) {
+	var u User
+	var createdAt string
+	var hash *string
+	if err := row.Scan(&u.ID, &u.Username, &createdAt, &hash); err != nil {
+		return User{}, err
+	}
+	if hash != nil {
+		u.PasswordHash = *hash
+	}
+	t, err := parseDBTime(&createdAt)
+	if err != nil {
+		return User{}, err
+	}
+	u.CreatedAt = *t
+	return u, nil
+}
+
+func (s *Store) CreateUser(ctx context.Context, username, passwordHash string) (User, error) {
+	if err := validateUsername(username); err != nil {
+		return User{}, err
+	}
+	u := User{Username: username, CreatedAt: now(), PasswordHash: passwordHash}
+	err := s.conn().QueryRowContext(ctx, "INSERT INTO users (username, created_at, password_hash) VALUES (?, ?, ?) RETURNING id",
+		username, formatDBTime(&u.CreatedAt), nullString(passwordHash)).Scan(&u.ID)
+	if err != nil {
+		return User{}, dbError(fmt.Sprintf("errore nella creazione dell'utente %q", username), err)
+	}
+	return u, nil
+}
+
+func (s *Store) GetUser(ctx context.Context, ID int) (User, error) {
+	u, err := scanUser(s.conn().QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = ?", ID))
+	if err != nil {
+		return User{}, dbError(fmt.Sprintf("utente %d", ID), err)
+	}
+	return u, nil
+}
+
+func (s *Store) GetUserByUsername(ctx context.Context, username string) (User, error) {
+	// la colonna è COLLATE NOCASE, quindi il confronto ignora le maiuscole
+	u, err := scanUser(s.conn().QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE username = ?", username))
+	if err != nil {
+		return User{}, dbError(fmt.Sprintf("utente %q", username), err)
+	}
+	return u, nil
+}
+
+func (s *Store) SetPassword(ctx context.Context, ID int, passwordHash string) error {
+	result, err := s.conn().ExecContext(ctx, "UPDATE users SET password_hash = ? WHERE id = ?", nullString(passwordHash), ID)
+	if err != nil {
+		return dbError(fmt.Sprintf("errore nell'aggiornamento della password dell'utente %d", ID), err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return dbError("errore nel recuperare le righe modificate", err)
+	} else if n == 0 {
+		return fmt.Errorf("utente %d: %w", ID, ErrNotFound)
+	}
+	return nil
+}
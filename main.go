@@ -2,51 +2,254 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"flag"
 	"log"
+	"net"
 	"net/http"
-	"time"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 
 	// I nostri package interni
+	"todolist-api-v2/internal/auth"
+	"todolist-api-v2/internal/config"
 	"todolist-api-v2/internal/http/handler"
 	"todolist-api-v2/internal/store"
 )
 
 func main() {
-	// Inizializza lo store, che caricherà i dati da "todos.json".
-	todoStore, err := store.New("todos.json")
+	// La configurazione arriva da flag, variabili d'ambiente e da un file
+	// opzionale (vedi il package config), ad esempio:
+	//   go run . -store memory -addr :9090
+	//   TODO_DB_PATH=prova.db go run .
+	//   go run . -config todos.config.json
+	cfg, args, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Configurazione non valida:\n%v", err)
+	}
+
+	// Eventuali sottocomandi, ad esempio:
+	//   go run . -db todos.db migrate status
+	//   go run . config print
+	//   go run . import-legacy todos.json
+	//   make run ARGS="search rebuild"   (la ricerca richiede -tags sqlite_fts5)
+	if len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			err = runMigrate(os.Stdout, cfg.DBPath, args[1:])
+		case "config":
+			err = runConfig(os.Stdout, cfg, args[1:])
+		case "user":
+			err = runUser(os.Stdin, os.Stdout, cfg, args[1:])
+		case "import-legacy":
+			err = runImportLegacy(os.Stdout, cfg, args[1:])
+		case "search":
+			err = runSearch(os.Stdout, cfg, args[1:])
+		default:
+			log.Fatalf("comando sconosciuto %q", args[0])
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Inizializza lo store scelto.
+	if cfg.Store == store.DriverSQLite {
+		warnLegacyJSON(cfg.DBPath)
+	}
+	todoStore, err := store.Open(cfg.Store, cfg.DBPath)
 	if err != nil {
 		log.Fatalf("Errore nell'inizializzare lo store: %v", err)
 	}
 
-	// Inizializza l'handler, passandogli lo store.
+	// La chiave dei token: senza configurazione ne usiamo una casuale,
+	// quindi a ogni riavvio tutti gli utenti devono rifare il login.
+	secret := []byte(cfg.AuthSecret)
+	if len(secret) == 0 {
+		log.Printf("ATTENZIONE: auth_secret non configurato, uso una chiave casuale valida solo fino al riavvio")
+		secret = make([]byte, auth.MinSecretLength)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalf("Errore nella generazione della chiave: %v", err)
+		}
+	}
+	tokens, err := auth.NewTokens(secret, cfg.AccessTokenTTL, cfg.RefreshTokenTTL)
+	if err != nil {
+		log.Fatalf("Errore nella configurazione dei token: %v", err)
+	}
+
+	// Inizializza gli handler, passandogli lo store.
 	todoHandler := handler.NewTodoHandler(todoStore)
+	authHandler := handler.NewAuthHandler(todoStore, tokens)
+	apiKeyHandler := handler.NewAPIKeyHandler(todoStore)
+	listHandler := handler.NewListHandler(todoStore, todoHandler)
+	tagHandler := handler.NewTagHandler(todoStore)
+	treeHandler := handler.NewTreeHandler(todoStore)
+	trashHandler := handler.NewTrashHandler(todoStore)
+	historyHandler := handler.NewHistoryHandler(todoStore)
+	batchHandler := handler.NewBatchHandler(todoStore)
+	transferHandler := handler.NewTransferHandler(todoStore)
+	searchHandler := handler.NewSearchHandler(todoStore)
+	eventBroker := handler.NewEventBroker(todoStore, handler.EventPollInterval, handler.EventBufferSize)
+	eventsHandler := handler.NewEventsHandler(eventBroker)
 
 	// Inizializza il router Chi.
 	r := chi.NewRouter()
 
 	// Aggiunge dei Middleware standard di Chi.
-	r.Use(middleware.RequestID)                 // Aggiunge un ID univoco a ogni richiesta.
-	r.Use(middleware.RealIP)                    // Usa l'IP reale del client.
-	r.Use(middleware.Logger)                    // Logga ogni richiesta in modo strutturato.
-	r.Use(middleware.Recoverer)                 // Recupera da panic e risponde con un 500.
-	r.Use(middleware.Timeout(60 * time.Second)) // Timeout per le richieste.
+	r.Use(middleware.RequestID) // Aggiunge un ID univoco a ogni richiesta.
+	r.Use(middleware.RealIP)    // Usa l'IP reale del client.
+	r.Use(middleware.Logger)    // Logga ogni richiesta in modo strutturato.
+	r.Use(middleware.Recoverer) // Recupera da panic e risponde con un 500.
+
+	// Anche gli errori di routing rispondono in formato problem+json.
+	r.NotFound(handler.NotFound)
+	r.MethodNotAllowed(handler.MethodNotAllowed)
 
 	// Definiamo le nostre rotte (le API).
-	r.Route("/todos", func(r chi.Router) {
-		r.Get("/", todoHandler.GetAll)  // GET /todos
-		r.Post("/", todoHandler.Create) // POST /todos
-
-		// Sotto-router per percorsi con un ID.
-		r.Route("/{todoID}", func(r chi.Router) {
-			r.Get("/", todoHandler.GetByID)   // GET /todos/123
-			r.Put("/", todoHandler.Update)    // PUT /todos/123
-			r.Delete("/", todoHandler.Delete) // DELETE /todos/123
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(cfg.RequestTimeout)) // Timeout per le richieste.
+		r.Route("/auth", func(r chi.Router) {
+			r.Post("/signup", authHandler.Signup)   // POST /auth/signup
+			r.Post("/login", authHandler.Login)     // POST /auth/login
+			r.Post("/refresh", authHandler.Refresh) // POST /auth/refresh
+			r.With(handler.RequireUser(tokens)).Get("/me", authHandler.Me)
+
+			// Le API key si gestiscono solo con il token: una chiave non può
+			// crearne altre.
+			r.Route("/api-keys", func(r chi.Router) {
+				r.Use(handler.RequireUser(tokens))
+				r.Get("/", apiKeyHandler.List)             // GET /auth/api-keys
+				r.Post("/", apiKeyHandler.Create)          // POST /auth/api-keys
+				r.Delete("/{keyID}", apiKeyHandler.Revoke) // DELETE /auth/api-keys/1
+			})
 		})
+
+		r.Route("/todos", func(r chi.Router) {
+			// Tutte le rotte dei todo richiedono un utente autenticato, con
+			// un token oppure con una API key.
+			r.Use(handler.RequireUserOrAPIKey(tokens, todoStore))
+
+			r.Get("/", todoHandler.GetAll)            // GET /todos
+			r.Post("/", todoHandler.Create)           // POST /todos
+			r.Get("/tree", treeHandler.GetAll)        // GET /todos/tree (tutti i todo, annidati)
+			r.Post("/batch", batchHandler.Run)        // POST /todos/batch (più operazioni in una transazione)
+			r.Get("/export", transferHandler.Export)  // GET /todos/export?format=csv|json|md
+			r.Post("/import", transferHandler.Import) // POST /todos/import?format=...&dry_run=true
+			r.Get("/search", searchHandler.Search)    // GET /todos/search?q=pane OR latte
+
+			// Sotto-router per percorsi con un ID.
+			r.Route("/{todoID}", func(r chi.Router) {
+				r.Get("/", todoHandler.GetByID)   // GET /todos/123
+				r.Put("/", todoHandler.Update)    // PUT /todos/123 (sostituzione completa)
+				r.Patch("/", todoHandler.Patch)   // PATCH /todos/123 (merge patch o json patch)
+				r.Delete("/", todoHandler.Delete) // DELETE /todos/123 (sposta nel cestino)
+
+				r.Post("/restore", trashHandler.Restore) // POST /todos/123/restore (esce dal cestino)
+
+				// Le sottoattività si creano con POST /todos indicando "parent_id".
+				r.Get("/children", todoHandler.Children) // GET /todos/123/children
+				r.Get("/tree", treeHandler.GetByID)      // GET /todos/123/tree
+
+				r.Get("/history", historyHandler.GetByID) // GET /todos/123/history (chi ha cambiato cosa)
+
+				r.Put("/tags/{tag}", tagHandler.Add)       // PUT /todos/123/tags/casa
+				r.Delete("/tags/{tag}", tagHandler.Remove) // DELETE /todos/123/tags/casa
+			})
+		})
+
+		r.Route("/lists", func(r chi.Router) {
+			r.Use(handler.RequireUserOrAPIKey(tokens, todoStore))
+
+			r.Get("/", listHandler.GetAll)  // GET /lists
+			r.Post("/", listHandler.Create) // POST /lists
+
+			r.Route("/{listID}", func(r chi.Router) {
+				r.Get("/", listHandler.GetByID)   // GET /lists/3
+				r.Put("/", listHandler.Update)    // PUT /lists/3 (rinomina)
+				r.Delete("/", listHandler.Delete) // DELETE /lists/3?cascade=true
+
+				// I todo della lista; per spostare un todo in un'altra lista
+				// basta cambiarne "list_id" con PUT o PATCH /todos/{todoID}.
+				r.Get("/todos", listHandler.GetTodos)    // GET /lists/3/todos
+				r.Post("/todos", listHandler.CreateTodo) // POST /lists/3/todos
+			})
+		})
+
+		// GET /tags: i tag usati, con il numero di todo per ciascuno.
+		r.With(handler.RequireUserOrAPIKey(tokens, todoStore)).Get("/tags", tagHandler.GetAll)
+		// GET /trash: i todo cancellati, che si possono ancora ripristinare.
+		r.With(handler.RequireUserOrAPIKey(tokens, todoStore)).Get("/trash", trashHandler.GetAll)
 	})
 
-	log.Println("Server in ascolto su http://localhost:8080")
-	http.ListenAndServe(":8080", r)
+	// GET /todos/events: lo stream delle modifiche (Server-Sent Events)
+	// resta aperto finché il client non se ne va, quindi sta fuori dal
+	// gruppo con il timeout delle richieste.
+	r.With(handler.RequireUserOrAPIKey(tokens, todoStore)).Get("/todos/events", eventsHandler.Stream)
+
+	srv := &http.Server{
+		Addr:         cfg.ListenAddr,
+		Handler:      r,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+
+	// Apriamo la porta prima di partire: se è occupata usciamo subito con
+	// un codice di errore, così chi ci ha lanciato se ne accorge.
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		todoStore.Close()
+		log.Fatalf("Impossibile ascoltare su %s: %v", srv.Addr, err)
+	}
+
+	// ctx viene annullato al primo SIGINT (Ctrl+C) o SIGTERM (quello dei
+	// deploy). Dopo il primo segnale ripristiniamo il comportamento di
+	// default, così un secondo Ctrl+C termina subito il processo.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+
+	// In background svuotiamo il cestino dai todo più vecchi di trash_retention.
+	purgeDone := make(chan struct{})
+	go func() {
+		defer close(purgeDone)
+		purgeTrash(ctx, todoStore, cfg.TrashRetention, cfg.PurgeInterval)
+	}()
+
+	// E seguiamo il registro delle modifiche per GET /todos/events. Al
+	// segnale il broker chiude gli stream aperti, altrimenti lo spegnimento
+	// aspetterebbe che ogni client si scolleghi da sé.
+	eventsDone := make(chan struct{})
+	go func() {
+		defer close(eventsDone)
+		eventBroker.Run(ctx)
+	}()
+
+	log.Printf("Server in ascolto su %s (store: %s)", ln.Addr(), cfg.Store)
+	serveErr := serve(ctx, srv, ln, cfg.ShutdownTimeout)
+
+	// Lo store si chiude solo quando nessuna richiesta, e nemmeno i job
+	// in background, lo sta più usando. stop() serve se il server si è
+	// fermato per un errore e non per un segnale.
+	stop()
+	<-purgeDone
+	<-eventsDone
+	if err := todoStore.Close(); err != nil {
+		log.Printf("Errore nella chiusura dello store: %v", err)
+	}
+	if serveErr != nil {
+		log.Printf("%v", serveErr)
+		os.Exit(1)
+	}
+	log.Printf("Server spento correttamente")
 }
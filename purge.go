@@ -0,0 +1,35 @@
+// File: purge.go
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"todolist-api-v2/internal/store"
+)
+
+// purgeTrash elimina definitivamente, ogni interval, i todo rimasti nel
+// cestino più di retention, finché ctx non viene annullato. Il primo
+// controllo avviene subito: un server riavviato spesso altrimenti non
+// svuoterebbe mai il cestino.
+func purgeTrash(ctx context.Context, trash store.TrashRepository, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := trash.Purge(ctx, time.Now().Add(-retention))
+		switch {
+		case err != nil && ctx.Err() == nil:
+			// riproviamo al prossimo giro
+			log.Printf("Errore nello svuotamento del cestino: %v", err)
+		case n > 0:
+			log.Printf("Cestino: eliminati definitivamente %d todo", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"todolist-api-v2/internal/store"
+)
+
+func TestPurgeTrash(t *testing.T) {
+	s := store.NewMemoryStore()
+	ctx := store.WithOwner(context.Background(), store.DefaultUserID)
+	old, err := s.Create(ctx, store.Todo{Title: "Vecchio"})
+	require.NoError(t, err)
+	recent, err := s.Create(ctx, store.Todo{Title: "Recente"})
+	require.NoError(t, err)
+	require.NoError(t, s.Delete(ctx, old.ID, 0))
+
+	jobCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		purgeTrash(jobCtx, s, 0, 10*time.Millisecond)
+	}()
+
+	// il primo giro è immediato, i successivi arrivano con l'intervallo
+	trashed := func() int {
+		trash, err := s.GetTrash(ctx)
+		require.NoError(t, err)
+		return len(trash)
+	}
+	require.Eventually(t, func() bool { return trashed() == 0 }, time.Second, 5*time.Millisecond)
+	require.NoError(t, s.Delete(ctx, recent.ID, 0))
+	require.Eventually(t, func() bool { return trashed() == 0 }, time.Second, 5*time.Millisecond)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("il job non si è fermato dopo l'annullamento del contesto")
+	}
+	_, err = s.Restore(ctx, recent.ID)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
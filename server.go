@@ -0,0 +1,46 @@
+// File: server.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// serve accetta richieste su ln finché ctx non viene annullato (di solito
+// da SIGINT o SIGTERM). A quel punto smette di accettare connessioni e
+// aspetta al massimo shutdownTimeout che le richieste in corso finiscano.
+//
+// Restituisce nil solo dopo uno spegnimento ordinato; se il server si
+// ferma per un errore, o le richieste non finiscono in tempo, l'errore
+// viene restituito al chiamante.
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, shutdownTimeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		// Serve restituisce sempre un errore: ErrServerClosed dopo Shutdown.
+		errCh <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("il server si è fermato: %w", err)
+	case <-ctx.Done():
+	}
+
+	log.Printf("Spegnimento in corso: attendo le richieste attive (al massimo %s)", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// tempo scaduto: chiudiamo a forza le connessioni rimaste
+		srv.Close()
+		return fmt.Errorf("spegnimento non completato: %w", err)
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
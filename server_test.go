@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer avvia serve su una porta libera con un handler che resta
+// bloccato finché release non viene chiuso.
+func startServer(t *testing.T, shutdownTimeout time.Duration) (url string, started, release chan struct{}, cancel func(), done chan error) {
+	started = make(chan struct{}, 1)
+	release = make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		io.WriteString(w, "finito")
+	})}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done = make(chan error, 1)
+	go func() { done <- serve(ctx, srv, ln, shutdownTimeout) }()
+	return "http://" + ln.Addr().String(), started, release, cancel, done
+}
+
+// Una richiesta in corso al momento del segnale deve essere completata.
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	url, started, release, cancel, done := startServer(t, 5*time.Second)
+
+	type result struct {
+		body string
+		err  error
+	}
+	resCh := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			resCh <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		resCh <- result{string(body), err}
+	}()
+
+	<-started
+	cancel() // come se fosse arrivato SIGTERM
+
+	// il server non accetta più nuove connessioni...
+	require.Eventually(t, func() bool {
+		_, err := net.DialTimeout("tcp", url[len("http://"):], 100*time.Millisecond)
+		return err != nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// ...ma completa quella in corso.
+	close(release)
+	res := <-resCh
+	require.NoError(t, res.err)
+	assert.Equal(t, "finito", res.body)
+	assert.NoError(t, <-done)
+}
+
+// Se le richieste non finiscono entro il timeout serve restituisce un errore.
+func TestServeShutdownTimeout(t *testing.T) {
+	url, started, release, cancel, done := startServer(t, 50*time.Millisecond)
+	defer close(release)
+
+	go http.Get(url)
+	<-started
+	cancel()
+
+	assert.ErrorIs(t, <-done, context.DeadlineExceeded)
+}